@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/coindesk"
+	pkgcoindesk "github.com/alex-rufo/exchange/pkg/coindesk"
+	"github.com/spf13/cobra"
+)
+
+// backfillDateLayout is the layout of the --from and --to flags.
+const backfillDateLayout = "2006-01-02"
+
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Backfill the repository with the historical rates of a provider",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if repositoryKind == repositoryMemory {
+			return errors.New("the memory repository loses the backfilled rates on exit, select another one with --repository")
+		}
+
+		fetcher, err := newHistoryFetcher(backfillProvider)
+		if err != nil {
+			return err
+		}
+		from, err := time.Parse(backfillDateLayout, backfillFrom)
+		if err != nil {
+			return fmt.Errorf("invalid --from date %q, must be YYYY-MM-DD", backfillFrom)
+		}
+		to := time.Now().UTC().Truncate(24 * time.Hour)
+		if backfillTo != "" {
+			if to, err = time.Parse(backfillDateLayout, backfillTo); err != nil {
+				return fmt.Errorf("invalid --to date %q, must be YYYY-MM-DD", backfillTo)
+			}
+		}
+		if to.Before(from) {
+			return fmt.Errorf("--to %s is before --from %s", to.Format(backfillDateLayout), from.Format(backfillDateLayout))
+		}
+
+		retention, err := repositoryRetention()
+		if err != nil {
+			return err
+		}
+		oldest := time.Now().Add(-retention)
+		if err := checkBackfillRetention(from, to, oldest); err != nil {
+			return err
+		}
+
+		repository, closeRepository, err := newRepository(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer closeRepository()
+
+		rates, err := fetcher.FetchHistory(cmd.Context(), backfillPair, from, to)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the history of %s from %s: %v", backfillPair, backfillProvider, err)
+		}
+
+		var inserted, expired int
+		for _, rate := range rates {
+			if !rate.At.After(oldest) {
+				expired++
+				continue
+			}
+			ok, err := insertHistorical(cmd.Context(), repository, rate)
+			if err != nil {
+				return fmt.Errorf("failed to insert the rate of %s at %s: %v", rate.Pair(), rate.At, err)
+			}
+			if ok {
+				inserted++
+			}
+		}
+
+		if err := compactRetentionTiers(cmd.Context(), repository); err != nil {
+			return err
+		}
+
+		log.Printf("Backfilled %d of the %d rates of %s fetched from %s, %d were already persisted and %d are older than the retention", inserted, len(rates), backfillPair, backfillProvider, len(rates)-inserted-expired, expired)
+		return nil
+	},
+}
+
+// checkBackfillRetention fails when the closes of every day between both dates are older than the oldest rate kept
+// by the repository, as none of them would be backfilled, and warns when only the ones of the first days are.
+func checkBackfillRetention(from, to, oldest time.Time) error {
+	// the close of a day is at the midnight that ends it
+	if !to.Add(24 * time.Hour).After(oldest) {
+		return fmt.Errorf("the closes until %s are older than the retention of the repository, which keeps the rates since %s, increase it with --ttl or --retention-tiers",
+			to.Format(backfillDateLayout), oldest.UTC().Format(time.RFC3339))
+	}
+	if !from.Add(24 * time.Hour).After(oldest) {
+		log.Printf("WARNING: the closes before %s are older than the retention of the repository and will not be backfilled, increase it with --ttl or --retention-tiers",
+			oldest.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// historyFetcher is implemented by the providers able to fetch the rates of the past.
+type historyFetcher interface {
+	FetchHistory(ctx context.Context, pair string, from, to time.Time) ([]exchange.RateUpdated, error)
+}
+
+// newHistoryFetcher creates the history fetcher of the provider.
+func newHistoryFetcher(provider string) (historyFetcher, error) {
+	switch provider {
+	case coindesk.Source:
+		return coindesk.NewHistoryFetcher(pkgcoindesk.NewClient(coindeskBaseURL, coindeskTimeout)), nil
+	default:
+		return nil, fmt.Errorf("unsupported provider %q, the history is only available from: %s", provider, coindesk.Source)
+	}
+}
+
+// historicalInserter is implemented by repositories that keep the rates of the past apart from the new ones.
+type historicalInserter interface {
+	InsertHistorical(ctx context.Context, rate exchange.RateUpdated) (bool, error)
+}
+
+// insertHistorical inserts a rate of the past unless the repository already has it, so the same range can be
+// backfilled again. It returns whether the rate was inserted.
+func insertHistorical(ctx context.Context, repository historyRepository, rate exchange.RateUpdated) (bool, error) {
+	if inserter, ok := repository.(historicalInserter); ok {
+		return inserter.InsertHistorical(ctx, rate)
+	}
+	return exchange.InsertMissing(ctx, repository, rate)
+}
+
+var (
+	backfillProvider string
+	backfillPair     string
+	backfillFrom     string
+	backfillTo       string
+)
+
+func init() {
+	rootCmd.AddCommand(backfillCmd)
+	backfillCmd.Flags().StringVarP(&backfillProvider, "provider", "", coindesk.Source, "Provider the historical rates are fetched from (defaults to coindesk)")
+	backfillCmd.Flags().StringVarP(&backfillPair, "pair", "", "USD-BTC", "Pair whose historical rates are backfilled (defaults to USD-BTC)")
+	backfillCmd.Flags().StringVarP(&backfillFrom, "from", "", "", "First day backfilled, as YYYY-MM-DD")
+	backfillCmd.Flags().StringVarP(&backfillTo, "to", "", "", "Last day backfilled, as YYYY-MM-DD, the one in progress has no close yet (defaults to today)")
+	backfillCmd.MarkFlagRequired("from")
+	addRepositoryFlags(backfillCmd.Flags())
+	backfillCmd.Flags().StringVarP(&coindeskBaseURL, "coindesk-base-url", "", "https://api.coindesk.com/", "CoinDesk base URL (defaults to https://api.coindesk.com/)")
+	backfillCmd.Flags().DurationVarP(&coindeskTimeout, "coindesk-timeout", "", time.Second, "CoinDesk timeout (defaults to 1s)")
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBackfillRetention(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC) }
+	oldest := day(10).Add(time.Hour)
+
+	tests := []struct {
+		name          string
+		from, to      time.Time
+		expectedError string
+	}{
+		{name: "within the retention", from: day(10), to: day(12)},
+		{name: "partially older than the retention", from: day(1), to: day(12)},
+		{name: "last close older than the retention", from: day(1), to: day(9), expectedError: "the closes until 2026-03-09 are older than the retention"},
+		{name: "older than the retention", from: day(1), to: day(5), expectedError: "increase it with --ttl or --retention-tiers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBackfillRetention(tt.from, tt.to, oldest)
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/export"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the history of the rates from the repository",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if repositoryKind == repositoryMemory {
+			return errors.New("the memory repository has no history to export, select another one with --repository")
+		}
+
+		query := exchange.Query{Pair: exportPair}
+		var err error
+		if exportSince != "" {
+			if query.Since, err = time.Parse(time.RFC3339Nano, exportSince); err != nil {
+				return fmt.Errorf("invalid --since time %q, must be RFC 3339", exportSince)
+			}
+		}
+		if exportUntil != "" {
+			if query.Until, err = time.Parse(time.RFC3339Nano, exportUntil); err != nil {
+				return fmt.Errorf("invalid --until time %q, must be RFC 3339", exportUntil)
+			}
+		}
+
+		output := os.Stdout
+		if exportOut != "" && exportOut != "-" {
+			if output, err = os.Create(exportOut); err != nil {
+				return fmt.Errorf("failed to create %s: %v", exportOut, err)
+			}
+			defer output.Close()
+		}
+		buffered := bufio.NewWriter(output)
+
+		writer, err := export.NewWriter(exportFormat, buffered)
+		if err != nil {
+			return err
+		}
+
+		repository, closeRepository, err := newRepository(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer closeRepository()
+
+		written, err := export.Export(cmd.Context(), repository, query, export.DefaultPageSize, writer)
+		if err != nil {
+			return fmt.Errorf("failed to export the rates: %v", err)
+		}
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("failed to complete the %s output: %v", exportFormat, err)
+		}
+		if err := buffered.Flush(); err != nil {
+			return fmt.Errorf("failed to write the output: %v", err)
+		}
+		if output != os.Stdout {
+			if err := output.Close(); err != nil {
+				return fmt.Errorf("failed to close %s: %v", exportOut, err)
+			}
+		}
+
+		log.Printf("Exported %d rates", written)
+		return nil
+	},
+}
+
+var (
+	exportPair   string
+	exportSince  string
+	exportUntil  string
+	exportFormat string
+	exportOut    string
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVarP(&exportPair, "pair", "", "", "Pair whose rates are exported, e.g. USD-BTC (defaults to all of them)")
+	exportCmd.Flags().StringVarP(&exportSince, "since", "", "", "Only export the rates newer than the time, as RFC 3339")
+	exportCmd.Flags().StringVarP(&exportUntil, "until", "", "", "Only export the rates not newer than the time, as RFC 3339")
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "", export.FormatCSV, "Format of the output: csv, jsonl or parquet (defaults to csv)")
+	exportCmd.Flags().StringVarP(&exportOut, "out", "o", "", "File where the rates are exported (defaults to the standard output)")
+	addRepositoryFlags(exportCmd.Flags())
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/alex-rufo/exchange/cmd/server"
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/remote"
+	"github.com/spf13/cobra"
+	"gopkg.in/tomb.v2"
+)
+
+var gatewayCmd = &cobra.Command{
+	Use:   "gateway",
+	Short: "Run a WebSocket gateway, streaming the updates of the shared topic",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if topicKind == topicChannel {
+			return errors.New("the gateway streams the updates published by other instances, select a shared topic with --topic")
+		}
+		if err := validateHistoryURL(gatewayHistoryURL, port); err != nil {
+			return err
+		}
+
+		t, ctx := tomb.WithContext(cmd.Context())
+
+		topic, closeTopic, err := newTopic(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer closeTopic()
+		broadcaster, err := newBroadcaster(ctx, topic)
+		if err != nil {
+			return err
+		}
+
+		// The history is served by the instances persisting the updates.
+		repository := remote.NewRepository(gatewayHistoryURL, gatewayHistoryTimeout)
+		var options []server.Option
+		if updatesLog, ok := topic.(exchange.Log); ok {
+			options = append(options, server.WithLog(updatesLog))
+		}
+		server := server.NewServer(broadcaster, repository, options...)
+
+		// Listen for exchange rate updates and propage them to the WebSocket subscriptions.
+		t.Go(func() error {
+			broadcaster.ListenAndServer()
+			return nil
+		})
+
+		// Start the HTTP server
+		t.Go(func() error {
+			return server.Start(port)
+		})
+
+		// Block until tomb is dying, happens either because context is cancelled or a routine experienced an error.
+		<-t.Dying()
+
+		server.Close()
+		broadcaster.Close()
+
+		err = t.Wait()
+		if err := topic.Close(); err != nil {
+			log.Printf("Failed to close the topic: %v", err)
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("Gateway closed with error: %s", err)
+			return err
+		}
+		return nil
+	},
+}
+
+var (
+	gatewayHistoryURL     string
+	gatewayHistoryTimeout time.Duration
+)
+
+// validateHistoryURL checks that the history is served by another instance, as the gateway has no repository
+// and requesting its own API would recurse into itself.
+func validateHistoryURL(historyURL string, port int) error {
+	u, err := url.Parse(historyURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --history-url %q, must be the base URL of the server persisting the rates", historyURL)
+	}
+
+	urlPort := u.Port()
+	if urlPort == "" {
+		urlPort = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	if urlPort != strconv.Itoa(port) {
+		return nil
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && (ip.IsLoopback() || ip.IsUnspecified())) {
+		return fmt.Errorf("--history-url %q points to the gateway itself, it must be the server persisting the rates", historyURL)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(gatewayCmd)
+	gatewayCmd.Flags().IntVarP(&port, "port", "p", 8080, "HTTP server port (defaults to 8080)")
+	gatewayCmd.Flags().StringVarP(&gatewayHistoryURL, "history-url", "", "", "Base URL of the server whose REST API serves the persisted rates, e.g. http://exchange-api:8080 (required)")
+	gatewayCmd.Flags().DurationVarP(&gatewayHistoryTimeout, "history-timeout", "", 5*time.Second, "Timeout of the requests to the history API (defaults to 5s)")
+	gatewayCmd.Flags().IntVarP(&subscriptionBufferSize, "subscripition-buffer-size", "b", 5, "Subscription buffer size to give some time to the subscription to handle the rate updates (defaults to 5)")
+	gatewayCmd.Flags().IntVarP(&broadcasterShards, "broadcaster-shards", "", 0, "Number of goroutines delivering the updates to the subscriptions, each one serving a share of them (defaults to the number of CPUs)")
+	addTopicFlags(gatewayCmd.Flags(), "topic")
+	gatewayCmd.MarkFlagRequired("history-url")
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateHistoryURL(t *testing.T) {
+	tests := []struct {
+		name          string
+		historyURL    string
+		port          int
+		expectedError string
+	}{
+		{name: "another host", historyURL: "http://exchange-api:8080", port: 8080},
+		{name: "another port of the same host", historyURL: "http://localhost:8081", port: 8080},
+		{name: "default port", historyURL: "https://exchange.example.com", port: 8080},
+		{name: "empty", historyURL: "", port: 8080, expectedError: "invalid --history-url"},
+		{name: "without scheme", historyURL: "exchange-api:8080", port: 8080, expectedError: "invalid --history-url"},
+		{name: "localhost", historyURL: "http://localhost:8080", port: 8080, expectedError: "points to the gateway itself"},
+		{name: "loopback address", historyURL: "http://127.0.0.1:8080/", port: 8080, expectedError: "points to the gateway itself"},
+		{name: "IPv6 loopback address", historyURL: "http://[::1]:8080", port: 8080, expectedError: "points to the gateway itself"},
+		{name: "default port of the gateway", historyURL: "http://localhost", port: 80, expectedError: "points to the gateway itself"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHistoryURL(tt.historyURL, tt.port)
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
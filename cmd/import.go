@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/export"
+	"github.com/alex-rufo/exchange/internal/exchange/importer"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import rates from a file into the repository",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if repositoryKind == repositoryMemory {
+			return errors.New("the memory repository loses the imported rates on exit, select another one with --repository")
+		}
+
+		var input io.Reader = os.Stdin
+		if importFile != "-" {
+			file, err := os.Open(importFile)
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %v", importFile, err)
+			}
+			defer file.Close()
+			input = file
+		}
+		reader, err := importer.NewReader(importFormat, input)
+		if err != nil {
+			return err
+		}
+
+		retention, err := repositoryRetention()
+		if err != nil {
+			return err
+		}
+		repository, closeRepository, err := newRepository(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer closeRepository()
+
+		var insert importer.InsertFunc = func(ctx context.Context, rate exchange.RateUpdated) (bool, error) {
+			return insertHistorical(ctx, repository, rate)
+		}
+		if importDryRun {
+			insert = func(ctx context.Context, rate exchange.RateUpdated) (bool, error) {
+				contained, err := exchange.Contains(ctx, repository, rate)
+				return !contained, err
+			}
+		}
+		var expired int
+		insert = skipExpired(insert, time.Now().Add(-retention), &expired)
+		reject := func(line int, reason error) {
+			log.Printf("Rejected line %d: %v", line, reason)
+		}
+
+		stats, err := importer.Import(cmd.Context(), reader, insert, reject)
+		if err != nil {
+			return err
+		}
+
+		if importDryRun {
+			log.Printf("Dry run, %d rates would be imported, %d are duplicated in the file, %d are already persisted, %d are older than the retention and %d lines are rejected",
+				stats.Imported, stats.Duplicated, stats.Skipped-expired, expired, stats.Rejected)
+			return nil
+		}
+		if err := compactRetentionTiers(cmd.Context(), repository); err != nil {
+			return err
+		}
+		log.Printf("Imported %d rates, %d are duplicated in the file, %d are already persisted, %d are older than the retention and %d lines are rejected",
+			stats.Imported, stats.Duplicated, stats.Skipped-expired, expired, stats.Rejected)
+		return nil
+	},
+}
+
+// skipExpired skips the rates older than the oldest one kept by the repository, which would never return them,
+// counting them in expired. The rest are inserted with insert.
+func skipExpired(insert importer.InsertFunc, oldest time.Time, expired *int) importer.InsertFunc {
+	return func(ctx context.Context, rate exchange.RateUpdated) (bool, error) {
+		if !rate.At.After(oldest) {
+			*expired++
+			return false, nil
+		}
+		return insert(ctx, rate)
+	}
+}
+
+var (
+	importFile   string
+	importFormat string
+	importDryRun bool
+)
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVarP(&importFile, "file", "f", "", "File the rates are imported from, - for the standard input")
+	importCmd.Flags().StringVarP(&importFormat, "format", "", export.FormatCSV, "Format of the file: csv or jsonl (defaults to csv)")
+	importCmd.Flags().BoolVarP(&importDryRun, "dry-run", "", false, "Validate the file and report what would be imported without writing anything")
+	importCmd.MarkFlagRequired("file")
+	addRepositoryFlags(importCmd.Flags())
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipExpired(t *testing.T) {
+	oldest := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	var inserted []exchange.RateUpdated
+	insert := func(ctx context.Context, rate exchange.RateUpdated) (bool, error) {
+		inserted = append(inserted, rate)
+		return true, nil
+	}
+
+	var expired int
+	insert = skipExpired(insert, oldest, &expired)
+
+	// the rates at or before the oldest one kept are skipped
+	recent := exchange.RateUpdated{From: "USD", To: "BTC", At: oldest.Add(time.Second), Rate: "50000.00"}
+	for _, rate := range []exchange.RateUpdated{
+		{From: "USD", To: "BTC", At: oldest.Add(-time.Hour), Rate: "49000.00"},
+		{From: "USD", To: "BTC", At: oldest, Rate: "49500.00"},
+		recent,
+	} {
+		ok, err := insert(context.Background(), rate)
+		require.NoError(t, err)
+		assert.Equal(t, rate == recent, ok)
+	}
+	assert.Equal(t, 2, expired)
+	assert.Equal(t, []exchange.RateUpdated{recent}, inserted)
+}
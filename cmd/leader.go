@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/leader"
+	"github.com/alex-rufo/exchange/internal/exchange/redis"
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/spf13/pflag"
+)
+
+const (
+	leaderElectionNone  = "none"
+	leaderElectionFile  = "file"
+	leaderElectionRedis = "redis"
+)
+
+var (
+	leaderElection  string
+	leaderLockFile  string
+	leaderLeaseName string
+	leaderLeaseTTL  time.Duration
+)
+
+// addLeaderFlags adds the flags used by newElector.
+func addLeaderFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&leaderElection, "leader-election", "", leaderElectionNone, "Leader election among the replicas, only the leader runs the providers: none, file or redis (defaults to none)")
+	flags.StringVarP(&leaderLockFile, "leader-lock-file", "", "exchange.lock", "File locked by the leader of the replicas running on the same host (defaults to exchange.lock)")
+	flags.StringVarP(&leaderLeaseName, "leader-lease-name", "", "exchange:leader", "Redis key of the lease held by the leader (defaults to exchange:leader)")
+	flags.DurationVarP(&leaderLeaseTTL, "leader-lease-ttl", "", leader.DefaultTTL, "Time the lease is held without being renewed, before another replica takes over a lost leader (defaults to 10s)")
+}
+
+// newElector creates the elector selected with the --leader-election flag, nil when every replica runs the
+// providers. The returned function releases its resources.
+func newElector(ctx context.Context) (leader.Elector, func(), error) {
+	switch leaderElection {
+	case leaderElectionNone:
+		return nil, func() {}, nil
+	case leaderElectionFile:
+		return leader.NewFileLock(leaderLockFile, leader.DefaultRetryInterval), func() {}, nil
+	case leaderElectionRedis:
+		options, err := goredis.ParseURL(redisURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --redis-url: %v", err)
+		}
+		client := goredis.NewClient(options)
+		if err := client.Ping(ctx).Err(); err != nil {
+			client.Close()
+			return nil, nil, fmt.Errorf("failed to connect to Redis: %v", err)
+		}
+		closeClient := func() {
+			if err := client.Close(); err != nil {
+				log.Printf("Failed to close the Redis client: %v", err)
+			}
+		}
+		hostname, _ := os.Hostname()
+		elector := leader.NewLeaseElector(redis.NewLeaseStore(client), leader.LeaseConfig{
+			Name:   leaderLeaseName,
+			Holder: fmt.Sprintf("%s-%s", hostname, uuid.NewString()),
+			TTL:    leaderLeaseTTL,
+		})
+		return elector, closeClient, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported leader election %q, must be one of: %s, %s, %s", leaderElection, leaderElectionNone, leaderElectionFile, leaderElectionRedis)
+	}
+}
+
+// validateLeaderElection checks the followers receive the updates fetched by the leader.
+func validateLeaderElection() error {
+	if leaderElection != leaderElectionNone && topicKind == topicChannel {
+		return errors.New("the followers never receive the updates fetched by the leader through the channel topic, select a shared one")
+	}
+	return nil
+}
+
+// runProvider fetches the rates of the provider every --interval until ctx is done. With an elector, the
+// rates are only fetched while the replica is the leader, the fetcher being created again on every term.
+func runProvider(ctx context.Context, elector leader.Elector, provider string, updates chan<- exchange.RateUpdated) error {
+	if _, err := newPeriodicFetcher(provider); err != nil {
+		return err
+	}
+
+	lead := func(ctx context.Context) {
+		// the provider is validated above
+		fetcher, _ := newPeriodicFetcher(provider)
+		go fetcher.Run(ctx, updates)
+
+		<-ctx.Done()
+		fetcher.Close()
+	}
+	if elector == nil {
+		lead(ctx)
+		return nil
+	}
+
+	log.Printf("Campaigning to run the %s provider", provider)
+	leader.Run(ctx, elector, lead)
+	return nil
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/coindesk"
+	pkgcoindesk "github.com/alex-rufo/exchange/pkg/coindesk"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var providerCmd = &cobra.Command{
+	Use:   "provider",
+	Short: "Run a provider, publishing the rates it fetches to a shared topic",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if topicKind == topicChannel {
+			return errors.New("the channel topic does not leave the process, select a shared one with --publish-to")
+		}
+		fetcher, err := newFetcher(providerName)
+		if err != nil {
+			return err
+		}
+
+		topic, closeTopic, err := newTopic(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer closeTopic()
+		defer func() {
+			if err := topic.Close(); err != nil {
+				log.Printf("Failed to close the topic: %v", err)
+			}
+		}()
+
+		if providerOnce {
+			return publishOnce(cmd.Context(), fetcher, topic)
+		}
+
+		// Only the leader of the replicas of the provider publishes the rates it fetches.
+		elector, closeElector, err := newElector(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer closeElector()
+
+		updates := make(chan exchange.RateUpdated)
+		published := make(chan struct{})
+		go func() {
+			defer close(published)
+			exchange.PublishUpdates(cmd.Context(), topic, updates)
+		}()
+
+		log.Printf("Publishing the rates fetched from %s every %s to the %s topic", providerName, fetchInterval, topicKind)
+		err = runProvider(cmd.Context(), elector, providerName, updates)
+		close(updates)
+		<-published
+		return err
+	},
+}
+
+// publishOnce publishes the rates fetched once from the provider, failing if any of them could not be published.
+func publishOnce(ctx context.Context, fetcher ratesFetcher, topic exchange.Topic) error {
+	rates, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the rates from %s: %v", providerName, err)
+	}
+	for _, rate := range rates {
+		if err := topic.Publish(ctx, rate); err != nil {
+			return fmt.Errorf("failed to publish the rate of %s: %v", rate.Pair(), err)
+		}
+	}
+
+	log.Printf("Published %d rates fetched from %s to the %s topic", len(rates), providerName, topicKind)
+	return nil
+}
+
+// ratesFetcher fetches the latest rates of a provider.
+type ratesFetcher interface {
+	Fetch(ctx context.Context) ([]exchange.RateUpdated, error)
+}
+
+// periodicRatesFetcher fetches the latest rates of a provider every interval, until it is closed.
+type periodicRatesFetcher interface {
+	Run(ctx context.Context, output chan<- exchange.RateUpdated)
+	Close()
+}
+
+// newFetcher creates the fetcher of the provider.
+func newFetcher(provider string) (ratesFetcher, error) {
+	switch provider {
+	case coindesk.Source:
+		return coindesk.NewFetcher(pkgcoindesk.NewClient(coindeskBaseURL, coindeskTimeout), toCurrencies), nil
+	default:
+		return nil, fmt.Errorf("unsupported provider %q, must be one of: %s", provider, coindesk.Source)
+	}
+}
+
+// newPeriodicFetcher creates the fetcher of the provider run every --interval.
+func newPeriodicFetcher(provider string) (periodicRatesFetcher, error) {
+	switch provider {
+	case coindesk.Source:
+		return coindesk.NewPeriodicallyFetcher(pkgcoindesk.NewClient(coindeskBaseURL, coindeskTimeout), toCurrencies, fetchInterval), nil
+	default:
+		return nil, fmt.Errorf("unsupported provider %q, must be one of: %s", provider, coindesk.Source)
+	}
+}
+
+var (
+	providerName    string
+	providerOnce    bool
+	toCurrencies    []string
+	fetchInterval   time.Duration
+	coindeskBaseURL string
+	coindeskTimeout time.Duration
+)
+
+// addProviderFlags adds the flags used to create the fetchers of the providers.
+func addProviderFlags(flags *pflag.FlagSet) {
+	flags.StringSliceVarP(&toCurrencies, "currencies", "c", []string{"USD"}, "List of currencies to which we want the BTC exchange rate to (defaults to USD)")
+	flags.DurationVarP(&fetchInterval, "interval", "i", 5*time.Second, "Interval in which the rates are going to be refreshed (defaults to 5s)")
+	flags.StringVarP(&coindeskBaseURL, "coindesk-base-url", "", "https://api.coindesk.com/", "CoinDesk base URL (defaults to https://api.coindesk.com/)")
+	flags.DurationVarP(&coindeskTimeout, "coindesk-timeout", "", time.Second, "CoinDesk timeout (defaults to 1s)")
+}
+
+func init() {
+	rootCmd.AddCommand(providerCmd)
+	providerCmd.Flags().StringVarP(&providerName, "name", "", coindesk.Source, "Provider the rates are fetched from (defaults to coindesk)")
+	providerCmd.Flags().BoolVarP(&providerOnce, "once", "", false, "Fetch and publish the rates once and exit, e.g. to run the provider as a CronJob")
+	addProviderFlags(providerCmd.Flags())
+	addTopicFlags(providerCmd.Flags(), "publish-to")
+	addLeaderFlags(providerCmd.Flags())
+	providerCmd.MarkFlagRequired("publish-to")
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alex-rufo/exchange/cmd/server"
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/bolt"
+	"github.com/alex-rufo/exchange/internal/exchange/filelog"
+	"github.com/alex-rufo/exchange/internal/exchange/retention"
+	"github.com/alex-rufo/exchange/internal/exchange/sqlite"
+	"github.com/spf13/pflag"
+)
+
+const (
+	repositoryMemory  = "memory"
+	repositorySQLite  = "sqlite"
+	repositoryFileLog = "filelog"
+	repositoryBolt    = "bolt"
+)
+
+var (
+	repositoryTTL           time.Duration
+	repositoryKind          string
+	repositoryDSN           string
+	repositorySegmentSize   int64
+	repositorySegmentMaxAge time.Duration
+	repositoryMaxRates      int
+	retentionTiers          []string
+)
+
+// defaultMaxRatesPerPair bounds the memory of the in-memory repository, e.g. a day of rates fetched every second.
+const defaultMaxRatesPerPair = 86400
+
+// addRepositoryFlags adds the flags used by newRepository, shared by the commands using the repository.
+func addRepositoryFlags(flags *pflag.FlagSet) {
+	flags.DurationVarP(&repositoryTTL, "ttl", "", 24*time.Hour, "Time until data will be evicted from the repository (defaults to 1 hour)")
+	flags.StringVarP(&repositoryKind, "repository", "", repositoryMemory, "Repository where the rates are persisted: memory, sqlite, filelog or bolt (defaults to memory)")
+	flags.StringVarP(&repositoryDSN, "repository-dsn", "", "exchange.db", "Data source of the repository, the database file or the log directory (defaults to exchange.db)")
+	flags.Int64VarP(&repositorySegmentSize, "repository-segment-size", "", filelog.DefaultMaxSegmentSize, "Size in bytes after which the file log starts a new segment (defaults to 64MiB)")
+	flags.DurationVarP(&repositorySegmentMaxAge, "repository-segment-max-age", "", filelog.DefaultMaxSegmentAge, "Time after which the file log starts a new segment (defaults to 1h)")
+	flags.IntVarP(&repositoryMaxRates, "repository-max-rates", "", defaultMaxRatesPerPair, "Rates of every pair kept by the memory repository and each of its retention tiers, the oldest ones being evicted first, 0 for no limit (defaults to 86400)")
+	flags.StringSliceVarP(&retentionTiers, "retention-tiers", "", nil, "Tiers keeping the rates older than --ttl downsampled, as resolution:retention, e.g. 1m:720h,1h:17520h (defaults to none)")
+}
+
+// historyRepository is the repository where the rates are persisted and later served from.
+type historyRepository interface {
+	exchange.Repository
+	server.Repository
+}
+
+// newRepository creates the repository selected with the --repository flag, keeping the older rates
+// downsampled in the --retention-tiers. The returned function releases its resources, and must be
+// called once the repository is no longer used.
+func newRepository(ctx context.Context) (historyRepository, func(), error) {
+	if repositoryMaxRates < 0 {
+		return nil, nil, fmt.Errorf("invalid --repository-max-rates %d, must not be negative", repositoryMaxRates)
+	}
+	tiers, err := parseRetentionTiers(retentionTiers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, closeRaw, err := openRepository(ctx, repositoryDSN, repositoryTTL)
+	if err != nil || len(tiers) == 0 {
+		return raw, closeRaw, err
+	}
+
+	closers := []func(){closeRaw}
+	closeAll := func() {
+		for _, closeRepository := range closers {
+			closeRepository()
+		}
+	}
+
+	tiers = append([]retention.Tier{{Retention: repositoryTTL, Store: raw}}, tiers...)
+	for i := 1; i < len(tiers); i++ {
+		tier := &tiers[i]
+		store, closeStore, err := openRepository(ctx, tierDSN(repositoryDSN, tier.Resolution), tier.Retention)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		tier.Store = store
+		closers = append(closers, closeStore)
+	}
+
+	repository, err := retention.NewRepository(tiers)
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	return repository, closeAll, nil
+}
+
+// openRepository opens the backend selected with the --repository flag.
+func openRepository(ctx context.Context, dsn string, ttl time.Duration) (historyRepository, func(), error) {
+	switch repositoryKind {
+	case repositoryMemory:
+		return exchange.NewInMemoryRepository(ttl, repositoryMaxRates), func() {}, nil
+	case repositorySQLite:
+		repository, err := sqlite.Open(ctx, dsn, ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+		return repository, closer(repository), nil
+	case repositoryFileLog:
+		repository, err := filelog.Open(filelog.Config{
+			Dir:            dsn,
+			TTL:            ttl,
+			MaxSegmentSize: repositorySegmentSize,
+			MaxSegmentAge:  repositorySegmentMaxAge,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return repository, closer(repository), nil
+	case repositoryBolt:
+		repository, err := bolt.Open(dsn, ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+		return repository, closer(repository), nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported repository %q, must be one of: %s, %s, %s, %s", repositoryKind, repositoryMemory, repositorySQLite, repositoryFileLog, repositoryBolt)
+	}
+}
+
+// compactRetentionTiers downsamples the rates inserted by the commands into the coarser retention tiers right away,
+// instead of waiting for the server to do it.
+func compactRetentionTiers(ctx context.Context, repository historyRepository) error {
+	if repository, ok := repository.(*retention.Repository); ok {
+		return repository.Compact(ctx)
+	}
+	return nil
+}
+
+// repositoryRetention returns how long the repository selected with the flags keeps the rates,
+// the retention of its coarsest tier or the --ttl when it has none.
+func repositoryRetention() (time.Duration, error) {
+	tiers, err := parseRetentionTiers(retentionTiers)
+	if err != nil {
+		return 0, err
+	}
+
+	retention := repositoryTTL
+	for _, tier := range tiers {
+		retention = max(retention, tier.Retention)
+	}
+	return retention, nil
+}
+
+// parseRetentionTiers parses the tiers in the resolution:retention format, e.g. 1m:720h.
+func parseRetentionTiers(values []string) ([]retention.Tier, error) {
+	var tiers []retention.Tier
+	for _, value := range values {
+		resolution, period, ok := strings.Cut(value, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention tier %q, must be resolution:retention", value)
+		}
+
+		var (
+			tier retention.Tier
+			err  error
+		)
+		if tier.Resolution, err = time.ParseDuration(resolution); err != nil || tier.Resolution <= 0 {
+			return nil, fmt.Errorf("invalid resolution of retention tier %q", value)
+		}
+		if tier.Retention, err = time.ParseDuration(period); err != nil || tier.Retention <= 0 {
+			return nil, fmt.Errorf("invalid retention of retention tier %q", value)
+		}
+		tiers = append(tiers, tier)
+	}
+
+	return tiers, nil
+}
+
+// tierDSN returns the data source of the tier, adding its resolution to the one of the raw rates,
+// e.g. exchange-1m.db for exchange.db.
+func tierDSN(dsn string, resolution time.Duration) string {
+	// drop the zero units of the duration, e.g. 1h instead of 1h0m0s
+	label := resolution.String()
+	if strings.HasSuffix(label, "m0s") {
+		label = strings.TrimSuffix(label, "0s")
+	}
+	if strings.HasSuffix(label, "h0m") {
+		label = strings.TrimSuffix(label, "0m")
+	}
+
+	extension := filepath.Ext(dsn)
+	return strings.TrimSuffix(dsn, extension) + "-" + label + extension
+}
+
+func closer(c io.Closer) func() {
+	return func() {
+		if err := c.Close(); err != nil {
+			log.Printf("Failed to close the repository: %v", err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/retention"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetentionTiers(t *testing.T) {
+	tests := []struct {
+		name          string
+		values        []string
+		expected      []retention.Tier
+		expectedError string
+	}{
+		{name: "none"},
+		{
+			name:   "several tiers",
+			values: []string{"1m:720h", "1h:17520h"},
+			expected: []retention.Tier{
+				{Resolution: time.Minute, Retention: 720 * time.Hour},
+				{Resolution: time.Hour, Retention: 17520 * time.Hour},
+			},
+		},
+		{name: "missing retention", values: []string{"1m"}, expectedError: "must be resolution:retention"},
+		{name: "invalid resolution", values: []string{"minute:720h"}, expectedError: "invalid resolution"},
+		{name: "zero resolution", values: []string{"0s:720h"}, expectedError: "invalid resolution"},
+		{name: "invalid retention", values: []string{"1m:month"}, expectedError: "invalid retention"},
+		{name: "negative retention", values: []string{"1m:-720h"}, expectedError: "invalid retention"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiers, err := parseRetentionTiers(tt.values)
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, tiers)
+		})
+	}
+}
+
+func TestTierDSN(t *testing.T) {
+	tests := []struct {
+		dsn        string
+		resolution time.Duration
+		expected   string
+	}{
+		{dsn: "exchange.db", resolution: time.Minute, expected: "exchange-1m.db"},
+		{dsn: "exchange.db", resolution: time.Hour, expected: "exchange-1h.db"},
+		{dsn: "exchange.db", resolution: 90 * time.Second, expected: "exchange-1m30s.db"},
+		{dsn: "/var/lib/exchange/rates", resolution: 24 * time.Hour, expected: "/var/lib/exchange/rates-24h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tierDSN(tt.dsn, tt.resolution))
+		})
+	}
+}
+
+func TestNewRepository(t *testing.T) {
+	// the flags are package variables, restored after every test
+	setRepositoryFlags := func(t *testing.T, kind, dsn string, tiers []string) {
+		kindFlag, dsnFlag, ttlFlag, tiersFlag, maxRatesFlag := repositoryKind, repositoryDSN, repositoryTTL, retentionTiers, repositoryMaxRates
+		t.Cleanup(func() {
+			repositoryKind, repositoryDSN, repositoryTTL, retentionTiers, repositoryMaxRates = kindFlag, dsnFlag, ttlFlag, tiersFlag, maxRatesFlag
+		})
+		repositoryKind, repositoryDSN, repositoryTTL, retentionTiers, repositoryMaxRates = kind, dsn, time.Hour, tiers, defaultMaxRatesPerPair
+	}
+
+	t.Run("without tiers", func(t *testing.T) {
+		dir := t.TempDir()
+		setRepositoryFlags(t, repositorySQLite, filepath.Join(dir, "exchange.db"), nil)
+
+		repository, closeRepository, err := newRepository(context.Background())
+		require.NoError(t, err)
+		defer closeRepository()
+
+		_, ok := repository.(*retention.Repository)
+		assert.False(t, ok)
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		assert.Equal(t, "exchange.db", files[0].Name())
+	})
+
+	t.Run("in memory", func(t *testing.T) {
+		setRepositoryFlags(t, repositoryMemory, "exchange.db", nil)
+
+		repository, closeRepository, err := newRepository(context.Background())
+		require.NoError(t, err)
+		defer closeRepository()
+		assert.IsType(t, &exchange.InMemoryRepository{}, repository)
+	})
+
+	t.Run("in memory keeps at most the max rates of every pair", func(t *testing.T) {
+		setRepositoryFlags(t, repositoryMemory, "exchange.db", nil)
+		repositoryMaxRates = 2
+
+		repository, closeRepository, err := newRepository(context.Background())
+		require.NoError(t, err)
+		defer closeRepository()
+
+		now := time.Now().UTC()
+		for i := 3; i > 0; i-- {
+			rate := exchange.RateUpdated{From: "USD", To: "BTC", At: now.Add(-time.Duration(i) * time.Minute), Rate: "50000.00"}
+			require.NoError(t, repository.Insert(context.Background(), rate))
+		}
+		rates, err := repository.ListSince(context.Background(), time.Time{})
+		require.NoError(t, err)
+		assert.Len(t, rates, 2)
+	})
+
+	t.Run("negative max rates", func(t *testing.T) {
+		setRepositoryFlags(t, repositoryMemory, "exchange.db", nil)
+		repositoryMaxRates = -1
+
+		_, _, err := newRepository(context.Background())
+		assert.ErrorContains(t, err, "invalid --repository-max-rates")
+	})
+
+	t.Run("with tiers", func(t *testing.T) {
+		dir := t.TempDir()
+		setRepositoryFlags(t, repositorySQLite, filepath.Join(dir, "exchange.db"), []string{"1m:720h", "1h:17520h"})
+
+		repository, closeRepository, err := newRepository(context.Background())
+		require.NoError(t, err)
+		defer closeRepository()
+
+		assert.IsType(t, &retention.Repository{}, repository)
+		for _, name := range []string{"exchange.db", "exchange-1m.db", "exchange-1h.db"} {
+			assert.FileExists(t, filepath.Join(dir, name))
+		}
+
+		rate := exchange.RateUpdated{From: "USD", To: "BTC", At: time.Now().UTC().Truncate(time.Second), Rate: "50000.00"}
+		require.NoError(t, repository.Insert(context.Background(), rate))
+		rates, err := repository.ListSince(context.Background(), time.Time{})
+		require.NoError(t, err)
+		assert.Equal(t, []exchange.RateUpdated{rate}, rates)
+	})
+
+	t.Run("invalid tiers", func(t *testing.T) {
+		dir := t.TempDir()
+		setRepositoryFlags(t, repositorySQLite, filepath.Join(dir, "exchange.db"), []string{"1m"})
+
+		_, _, err := newRepository(context.Background())
+		assert.ErrorContains(t, err, "invalid retention tier")
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("unsupported repository", func(t *testing.T) {
+		setRepositoryFlags(t, "postgres", "exchange.db", nil)
+
+		_, _, err := newRepository(context.Background())
+		assert.ErrorContains(t, err, `unsupported repository "postgres"`)
+	})
+}
+
+func TestRepositoryRetention(t *testing.T) {
+	ttlFlag, tiersFlag := repositoryTTL, retentionTiers
+	t.Cleanup(func() { repositoryTTL, retentionTiers = ttlFlag, tiersFlag })
+
+	tests := []struct {
+		name          string
+		tiers         []string
+		expected      time.Duration
+		expectedError string
+	}{
+		{name: "without tiers", expected: 24 * time.Hour},
+		{name: "coarsest tier", tiers: []string{"1m:720h", "1h:17520h"}, expected: 17520 * time.Hour},
+		{name: "tiers shorter than the ttl", tiers: []string{"1m:1h"}, expected: 24 * time.Hour},
+		{name: "invalid tiers", tiers: []string{"1m"}, expectedError: "invalid retention tier"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repositoryTTL, retentionTiers = 24*time.Hour, tt.tiers
+
+			retention, err := repositoryRetention()
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, retention)
+		})
+	}
+}
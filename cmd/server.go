@@ -3,13 +3,21 @@ package cmd
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/alex-rufo/exchange/cmd/server"
 	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/alert"
+	"github.com/alex-rufo/exchange/internal/exchange/analytics"
 	"github.com/alex-rufo/exchange/internal/exchange/coindesk"
-	pkgcoindesk "github.com/alex-rufo/exchange/pkg/coindesk"
+	"github.com/alex-rufo/exchange/internal/exchange/retention"
+	"github.com/alex-rufo/exchange/internal/exchange/staleness"
+	"github.com/alex-rufo/exchange/internal/exchange/stats"
+	"github.com/alex-rufo/exchange/internal/exchange/webhook"
+	"github.com/alex-rufo/exchange/pkg/backoff"
+	pkgwebhook "github.com/alex-rufo/exchange/pkg/webhook"
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 	"gopkg.in/tomb.v2"
@@ -19,37 +27,206 @@ var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Run server",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		updatesChannel := make(chan exchange.RateUpdated)
-		coindeskClient := pkgcoindesk.NewClient(coindeskBaseURL, coindeskTimeout)
-		coindeskFetcher := coindesk.NewPeriodicallyFetcher(coindeskClient, toCurrencies, fetchInterval)
-		repository := exchange.NewInMemoryRepository(int(repositoryTTL / fetchInterval))
-		broadcaster := exchange.NewBroadcaster(updatesChannel, subscriptionBufferSize)
-		server := server.NewServer(broadcaster, repository)
+		if noProviders && topicKind == topicChannel {
+			return errors.New("without providers the channel topic never receives any update, select a shared one with --topic")
+		}
+		if err := validateLeaderElection(); err != nil {
+			return err
+		}
+		elector, closeElector, err := newElector(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer closeElector()
+
+		// The context is done once the tomb is dying, either because the command context is cancelled or a routine
+		// experienced an error.
+		t, ctx := tomb.WithContext(cmd.Context())
 
-		t, _ := tomb.WithContext(cmd.Context())
+		updatesChannel := make(chan exchange.RateUpdated)
+		topic, closeTopic, err := newTopic(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer closeTopic()
+		broadcaster, err := newBroadcaster(ctx, topic)
+		if err != nil {
+			return err
+		}
+		repository, closeRepository, err := newRepository(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer closeRepository()
+		analyzer := analytics.NewAnalyzer(analytics.Config{
+			TWAPWindows:      twapWindows,
+			SMAPeriod:        smaPeriod,
+			EMAPeriod:        emaPeriod,
+			VolatilityPeriod: volatilityPeriod,
+		})
+		tracker := stats.NewTracker(stats.DefaultWindow, subscriptionBufferSize)
+		alertStore, err := alert.NewFileStore(alertsFile)
+		if err != nil {
+			return err
+		}
+		webhookClient := pkgwebhook.NewClient(webhookTimeout, backoff.Default, webhookMaxAttempts)
+		alertEngine := alert.NewEngine(alertStore, webhookClient)
+		webhookStore, err := webhook.NewFileStore(webhooksFile)
+		if err != nil {
+			return err
+		}
+		webhookDispatcher := webhook.NewDispatcher(webhookStore, webhookClient, webhook.Config{
+			BatchSize:      webhookBatchSize,
+			Linger:         webhookBatchLinger,
+			QueueSize:      webhookQueueSize,
+			MaxDeadLetters: webhookMaxDeadLetters,
+		})
+		thresholds := make(map[string]time.Duration, len(stalenessThresholds))
+		for key, value := range stalenessThresholds {
+			threshold, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid staleness threshold for %s: %v", key, err)
+			}
+			thresholds[key] = threshold
+		}
+		freshnessMonitor := staleness.NewMonitor(staleness.Config{
+			Threshold:              stalenessThreshold,
+			Thresholds:             thresholds,
+			SubscriptionBufferSize: subscriptionBufferSize,
+		})
+		persister, err := exchange.NewPersister(repository, exchange.PersisterConfig{
+			BatchSize:     persisterBatchSize,
+			Linger:        persisterLinger,
+			QueueSize:     persisterQueueSize,
+			MaxAttempts:   persisterMaxAttempts,
+			Backoff:       backoff.Default,
+			SpillDir:      persisterSpillDir,
+			MaxSpilled:    persisterMaxSpilled,
+			DrainInterval: persisterDrainInterval,
+			FlushTimeout:  persisterFlushTimeout,
+		})
+		if err != nil {
+			return err
+		}
+		options := []server.Option{
+			server.WithIndicators(analyzer),
+			server.WithStats(tracker),
+			server.WithAlerts(alertEngine),
+			server.WithWebhooks(webhookDispatcher),
+			server.WithFreshness(freshnessMonitor),
+			server.WithPersister(persister),
+		}
+		updatesLog, isLog := topic.(exchange.Log)
+		if isLog {
+			options = append(options, server.WithLog(updatesLog))
+		}
+		server := server.NewServer(broadcaster, repository, options...)
 
 		// We are going to persist all the updates so they can be fetched later on.
 		// In order to do so, we are going to create a new subscription that,
 		// instead of sending the update into a WS, will persists them into a repository.
+		// When the topic is a log, the persister reads it on its own, resuming from the offset committed by its group.
 		t.Go(func() error {
+			if isLog {
+				return persister.PersistLog(ctx, updatesLog, persisterGroup)
+			}
 			updates, err := broadcaster.Subscribe(uuid.NewString())
 			if err != nil {
 				return err
 			}
-			persister := exchange.NewPersister(repository)
 			persister.PersistUpdates(cmd.Context(), updates)
 			return nil
 		})
 
-		// Listen for exchange rate updates and propage them to the multiple subscriptions.
+		// Periodically remove the expired rates from the repositories that do not evict them on insert.
+		if evicter, ok := repository.(exchange.Evicter); ok {
+			t.Go(func() error {
+				exchange.EvictPeriodically(cmd.Context(), evicter, repositoryEvictInterval)
+				return nil
+			})
+		}
+
+		// Downsample the older rates into the retention tiers.
+		if tiered, ok := repository.(*retention.Repository); ok {
+			t.Go(func() error {
+				tiered.CompactPeriodically(cmd.Context(), retentionCompactionInterval)
+				return nil
+			})
+		}
+
+		// Keep the indicators of every pair up to date using its own subscription.
 		t.Go(func() error {
-			broadcaster.ListenAndServer()
+			updates, err := broadcaster.Subscribe(uuid.NewString())
+			if err != nil {
+				return err
+			}
+			analyzer.AnalyzeUpdates(cmd.Context(), updates)
 			return nil
 		})
 
-		// CoinDesk provider fetcher
+		// Keep the rolling statistics of every pair up to date, starting from the ones already persisted.
+		// The subscription starts once warmed up, so its buffer does not fill up in the meantime.
 		t.Go(func() error {
-			coindeskFetcher.Run(cmd.Context(), updatesChannel)
+			if err := tracker.Warmup(cmd.Context(), repository); err != nil {
+				log.Printf("Failed to warm up the statistics: %v", err)
+			}
+			updates, err := broadcaster.Subscribe(uuid.NewString())
+			if err != nil {
+				return err
+			}
+			tracker.TrackUpdates(cmd.Context(), updates)
+			return nil
+		})
+
+		// Evaluate the price alerts against every update.
+		t.Go(func() error {
+			updates, err := broadcaster.Subscribe(uuid.NewString())
+			if err != nil {
+				return err
+			}
+			alertEngine.EvaluateUpdates(cmd.Context(), updates)
+			return nil
+		})
+
+		// Deliver the updates to the registered webhooks in batches.
+		t.Go(func() error {
+			updates, err := broadcaster.Subscribe(uuid.NewString())
+			if err != nil {
+				return err
+			}
+			webhookDispatcher.DispatchUpdates(cmd.Context(), updates)
+			return nil
+		})
+
+		// Detect the pairs their provider stopped updating.
+		t.Go(func() error {
+			updates, err := broadcaster.Subscribe(uuid.NewString())
+			if err != nil {
+				return err
+			}
+			freshnessMonitor.MonitorUpdates(cmd.Context(), updates, stalenessCheckInterval)
+			return nil
+		})
+
+		// Publish the updates fetched by the providers to the topic, shared by every broadcaster.
+		// Without providers, the updates are only consumed from the topic, published by the provider command.
+		// With leader election, only the leader runs the providers while the followers relay the updates it publishes.
+		if !noProviders {
+			t.Go(func() error {
+				exchange.PublishUpdates(cmd.Context(), topic, updatesChannel)
+				return nil
+			})
+
+			// CoinDesk provider fetcher
+			t.Go(func() error {
+				defer close(updatesChannel)
+				return runProvider(ctx, elector, coindesk.Source, updatesChannel)
+			})
+		}
+
+		// Listen for exchange rate updates and propage them to the multiple subscriptions.
+		t.Go(func() error {
+			broadcaster.ListenAndServer()
 			return nil
 		})
 
@@ -64,11 +241,14 @@ var serverCmd = &cobra.Command{
 
 		server.Close()
 		broadcaster.Close()
-		coindeskFetcher.Close()
-		close(updatesChannel)
+		freshnessMonitor.Close()
 
-		// Wait until all the goroutines have finished
-		if err := t.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+		// Wait until all the goroutines have finished, the persister commits its offset to the topic before returning.
+		err = t.Wait()
+		if err := topic.Close(); err != nil {
+			log.Printf("Failed to close the topic: %v", err)
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
 			log.Printf("Server closed with error: %s", err)
 			return err
 		}
@@ -77,22 +257,72 @@ var serverCmd = &cobra.Command{
 }
 
 var (
-	port                   int
-	toCurrencies           []string
-	fetchInterval          time.Duration
-	repositoryTTL          time.Duration
-	subscriptionBufferSize int
-	coindeskBaseURL        string
-	coindeskTimeout        time.Duration
+	port                        int
+	noProviders                 bool
+	repositoryEvictInterval     time.Duration
+	retentionCompactionInterval time.Duration
+	persisterBatchSize          int
+	persisterLinger             time.Duration
+	persisterQueueSize          int
+	persisterMaxAttempts        int
+	persisterSpillDir           string
+	persisterMaxSpilled         int
+	persisterDrainInterval      time.Duration
+	persisterFlushTimeout       time.Duration
+	persisterGroup              string
+	subscriptionBufferSize      int
+	broadcasterShards           int
+	twapWindows                 []time.Duration
+	smaPeriod                   int
+	emaPeriod                   int
+	volatilityPeriod            int
+	alertsFile                  string
+	webhookTimeout              time.Duration
+	webhookMaxAttempts          int
+	webhooksFile                string
+	webhookBatchSize            int
+	webhookBatchLinger          time.Duration
+	webhookQueueSize            int
+	webhookMaxDeadLetters       int
+	stalenessThreshold          time.Duration
+	stalenessThresholds         map[string]string
+	stalenessCheckInterval      time.Duration
 )
 
 func init() {
 	rootCmd.AddCommand(serverCmd)
 	serverCmd.Flags().IntVarP(&port, "port", "p", 8080, "HTTP server port (defaults to 8080)")
-	serverCmd.Flags().StringSliceVarP(&toCurrencies, "currencies", "c", []string{"USD"}, "List of currencies to which we want the BTC exchange rate to (defaults to USD)")
-	serverCmd.Flags().DurationVarP(&fetchInterval, "interval", "i", 5*time.Second, "Interval in which the rates are going to be refreshed (defaults to 5s)")
-	serverCmd.Flags().DurationVarP(&repositoryTTL, "ttl", "", 24*time.Hour, "Time until data will be evicted from the repository (defaults to 1 hour)")
+	addProviderFlags(serverCmd.Flags())
+	serverCmd.Flags().BoolVarP(&noProviders, "no-providers", "", false, "Do not run the providers, only consume the updates published to the shared topic by the provider command")
+	addRepositoryFlags(serverCmd.Flags())
+	addTopicFlags(serverCmd.Flags(), "topic")
+	addLeaderFlags(serverCmd.Flags())
+	serverCmd.Flags().DurationVarP(&repositoryEvictInterval, "repository-evict-interval", "", time.Minute, "Interval in which the expired rates are removed from the repository (defaults to 1m)")
+	serverCmd.Flags().DurationVarP(&retentionCompactionInterval, "retention-compaction-interval", "", time.Minute, "Interval in which the rates are downsampled into the retention tiers (defaults to 1m)")
+	serverCmd.Flags().IntVarP(&persisterBatchSize, "persister-batch-size", "", 100, "Maximum number of rates inserted into the repository at once (defaults to 100)")
+	serverCmd.Flags().DurationVarP(&persisterLinger, "persister-linger", "", time.Second, "Time a batch waits for more rates before being inserted into the repository (defaults to 1s)")
+	serverCmd.Flags().IntVarP(&persisterQueueSize, "persister-queue-size", "", 10, "Number of batches waiting to be inserted while the failed ones are retried (defaults to 10)")
+	serverCmd.Flags().IntVarP(&persisterMaxAttempts, "persister-max-attempts", "", 3, "Maximum number of attempts to insert a batch before spilling it (defaults to 3)")
+	serverCmd.Flags().StringVarP(&persisterSpillDir, "persister-spill-dir", "", "", "Directory where the batches that could not be inserted are buffered, so they survive restarts (defaults to none, keeping them in memory)")
+	serverCmd.Flags().IntVarP(&persisterMaxSpilled, "persister-max-spilled", "", exchange.DefaultMaxSpilled, "Maximum number of rates buffered in memory without --persister-spill-dir, dropping the batches over it (defaults to 100000)")
+	serverCmd.Flags().DurationVarP(&persisterDrainInterval, "persister-drain-interval", "", 5*time.Second, "Interval in which inserting the spilled batches is retried (defaults to 5s)")
+	serverCmd.Flags().DurationVarP(&persisterFlushTimeout, "persister-flush-timeout", "", exchange.DefaultFlushTimeout, "Time the pending batches are still inserted for on shutdown before spilling them (defaults to 10s)")
+	serverCmd.Flags().StringVarP(&persisterGroup, "persister-group", "", "exchange-persister", "Consumer group committing the offset persisted from the kafka topic, so it resumes from it on restart (defaults to exchange-persister)")
 	serverCmd.Flags().IntVarP(&subscriptionBufferSize, "subscripition-buffer-size", "b", 5, "Subscription buffer size to give some time to the subscription to handle the rate updates (defaults to 5)")
-	serverCmd.Flags().StringVarP(&coindeskBaseURL, "coindesk-base-url", "", "https://api.coindesk.com/", "CoinDesk base URL (defaults to https://api.coindesk.com/)")
-	serverCmd.Flags().DurationVarP(&coindeskTimeout, "coindesk-timeout", "", time.Second, "CoinDesk timeout (defaults to 1s)")
+	serverCmd.Flags().IntVarP(&broadcasterShards, "broadcaster-shards", "", 0, "Number of goroutines delivering the updates to the subscriptions, each one serving a share of them (defaults to the number of CPUs)")
+	serverCmd.Flags().DurationSliceVarP(&twapWindows, "twap-windows", "", []time.Duration{time.Minute, 5 * time.Minute, time.Hour}, "Rolling windows used to compute the TWAP of every pair (defaults to 1m,5m,1h)")
+	serverCmd.Flags().IntVarP(&smaPeriod, "sma-period", "", 20, "Number of updates used to compute the simple moving average (defaults to 20)")
+	serverCmd.Flags().IntVarP(&emaPeriod, "ema-period", "", 20, "Number of updates used to compute the exponential moving average (defaults to 20)")
+	serverCmd.Flags().IntVarP(&volatilityPeriod, "volatility-period", "", 20, "Number of log returns used to compute the realized volatility (defaults to 20)")
+	serverCmd.Flags().StringVarP(&alertsFile, "alerts-file", "", "alerts.json", "File where the price alerts are persisted, empty to keep them in memory (defaults to alerts.json)")
+	serverCmd.Flags().DurationVarP(&webhookTimeout, "webhook-timeout", "", 5*time.Second, "Timeout of every webhook delivery attempt (defaults to 5s)")
+	serverCmd.Flags().IntVarP(&webhookMaxAttempts, "webhook-max-attempts", "", 5, "Maximum number of attempts to deliver a webhook (defaults to 5)")
+	serverCmd.Flags().StringVarP(&webhooksFile, "webhooks-file", "", "webhooks.json", "File where the webhook subscriptions and their dead letters are persisted, empty to keep them in memory (defaults to webhooks.json)")
+	serverCmd.Flags().IntVarP(&webhookBatchSize, "webhook-batch-size", "", 50, "Maximum number of rates delivered to a webhook subscription in a single request (defaults to 50)")
+	serverCmd.Flags().DurationVarP(&webhookBatchLinger, "webhook-batch-linger", "", time.Second, "Time a batch waits for more rates before being delivered to a webhook subscription (defaults to 1s)")
+	serverCmd.Flags().DurationVarP(&stalenessThreshold, "staleness-threshold", "", 5*time.Minute, "Maximum age of the latest rate of a pair before it is considered stale (defaults to 5m)")
+	serverCmd.Flags().StringToStringVarP(&stalenessThresholds, "staleness-thresholds", "", nil, "Staleness thresholds per provider or provider and pair, e.g. coindesk=2m,coindesk/USD-BTC=1m")
+	serverCmd.Flags().DurationVarP(&stalenessCheckInterval, "staleness-check-interval", "", 5*time.Second, "Interval in which the freshness of every pair is checked (defaults to 5s)")
+	serverCmd.Flags().IntVarP(&webhookQueueSize, "webhook-queue-size", "", 1000, "Number of rates every webhook subscription can have pending for delivery (defaults to 1000)")
+	serverCmd.Flags().IntVarP(&webhookMaxDeadLetters, "webhook-max-dead-letters", "", 100, "Number of dead letters kept per webhook subscription, the oldest ones being removed (defaults to 100)")
 }
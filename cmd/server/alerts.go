@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/alex-rufo/exchange/internal/exchange/alert"
+)
+
+type AlertManager interface {
+	Create(a alert.Alert) (alert.Alert, error)
+	List() []alert.Alert
+	Get(id string) (alert.Alert, error)
+	Delete(id string) error
+}
+
+// WithAlerts exposes the REST API to manage the price alerts.
+func WithAlerts(alerts AlertManager) Option {
+	return func(s *Server) {
+		s.alerts = alerts
+	}
+}
+
+func (s *Server) handleCreateAlert(w http.ResponseWriter, r *http.Request) {
+	var a alert.Alert
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	created, err := s.alerts.Create(a)
+	if err != nil {
+		if errors.Is(err, alert.ErrInvalid) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("Failed to create alert: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to create alert")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) handleListAlerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.alerts.List())
+}
+
+func (s *Server) handleGetAlert(w http.ResponseWriter, r *http.Request) {
+	a, err := s.alerts.Get(r.PathValue("id"))
+	if err != nil {
+		writeAlertError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (s *Server) handleDeleteAlert(w http.ResponseWriter, r *http.Request) {
+	if err := s.alerts.Delete(r.PathValue("id")); err != nil {
+		writeAlertError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAlertError(w http.ResponseWriter, err error) {
+	if errors.Is(err, alert.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	log.Printf("Failed to handle alert request: %v", err)
+	writeError(w, http.StatusInternalServerError, "failed to handle alert request")
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange/alert"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_Alerts(t *testing.T) {
+	createdAt := time.Unix(1000, 0).UTC()
+	created := alert.Alert{
+		ID:         "alert-id",
+		Pair:       "USD-BTC",
+		Condition:  alert.ConditionAbove,
+		Threshold:  70000,
+		WebhookURL: "https://example.com/hook",
+		Secret:     "secret",
+		CreatedAt:  createdAt,
+	}
+	listed := created
+	listed.Secret = ""
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		body           string
+		setup          func(m *MockAlertManager)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:   "create alert",
+			method: http.MethodPost,
+			url:    "/v1/alerts",
+			body:   `{"pair":"USD-BTC","condition":"above","threshold":70000,"webhookUrl":"https://example.com/hook"}`,
+			setup: func(m *MockAlertManager) {
+				m.On("Create", alert.Alert{Pair: "USD-BTC", Condition: alert.ConditionAbove, Threshold: 70000, WebhookURL: "https://example.com/hook"}).Return(created, nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id":"alert-id","pair":"USD-BTC","condition":"above","threshold":70000,"webhookUrl":"https://example.com/hook","secret":"secret","createdAt":"1970-01-01T00:16:40Z"}`,
+		},
+		{
+			name:           "create alert with invalid JSON",
+			method:         http.MethodPost,
+			url:            "/v1/alerts",
+			body:           `{`,
+			setup:          func(m *MockAlertManager) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid JSON body"}`,
+		},
+		{
+			name:   "create invalid alert",
+			method: http.MethodPost,
+			url:    "/v1/alerts",
+			body:   `{"condition":"above"}`,
+			setup: func(m *MockAlertManager) {
+				m.On("Create", mock.Anything).Return(alert.Alert{}, errors.Join(alert.ErrInvalid, errors.New("pair is required")))
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid alert\npair is required"}`,
+		},
+		{
+			name:   "create alert store failure",
+			method: http.MethodPost,
+			url:    "/v1/alerts",
+			body:   `{"pair":"USD-BTC"}`,
+			setup: func(m *MockAlertManager) {
+				m.On("Create", mock.Anything).Return(alert.Alert{}, errors.New("disk full"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"failed to create alert"}`,
+		},
+		{
+			name:   "list alerts",
+			method: http.MethodGet,
+			url:    "/v1/alerts",
+			setup: func(m *MockAlertManager) {
+				m.On("List").Return([]alert.Alert{listed})
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"id":"alert-id","pair":"USD-BTC","condition":"above","threshold":70000,"webhookUrl":"https://example.com/hook","createdAt":"1970-01-01T00:16:40Z"}]`,
+		},
+		{
+			name:   "get alert",
+			method: http.MethodGet,
+			url:    "/v1/alerts/alert-id",
+			setup: func(m *MockAlertManager) {
+				m.On("Get", "alert-id").Return(listed, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":"alert-id","pair":"USD-BTC","condition":"above","threshold":70000,"webhookUrl":"https://example.com/hook","createdAt":"1970-01-01T00:16:40Z"}`,
+		},
+		{
+			name:   "get unknown alert",
+			method: http.MethodGet,
+			url:    "/v1/alerts/unknown",
+			setup: func(m *MockAlertManager) {
+				m.On("Get", "unknown").Return(alert.Alert{}, alert.ErrNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":"alert not found"}`,
+		},
+		{
+			name:   "delete alert",
+			method: http.MethodDelete,
+			url:    "/v1/alerts/alert-id",
+			setup: func(m *MockAlertManager) {
+				m.On("Delete", "alert-id").Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:   "delete unknown alert",
+			method: http.MethodDelete,
+			url:    "/v1/alerts/unknown",
+			setup: func(m *MockAlertManager) {
+				m.On("Delete", "unknown").Return(alert.ErrNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":"alert not found"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alerts := &MockAlertManager{}
+			tt.setup(alerts)
+			server := NewServer(&MockSubscriber{}, &MockRepository{}, WithAlerts(alerts))
+
+			recorder := httptest.NewRecorder()
+			server.routes().ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.expectedStatus, recorder.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, recorder.Body.String())
+			}
+			alerts.AssertExpectations(t)
+		})
+	}
+}
+
+// MockAlertManager implements the AlertManager interface for testing
+type MockAlertManager struct {
+	mock.Mock
+}
+
+func (m *MockAlertManager) Create(a alert.Alert) (alert.Alert, error) {
+	args := m.Called(a)
+	return args.Get(0).(alert.Alert), args.Error(1)
+}
+
+func (m *MockAlertManager) List() []alert.Alert {
+	args := m.Called()
+	return args.Get(0).([]alert.Alert)
+}
+
+func (m *MockAlertManager) Get(id string) (alert.Alert, error) {
+	args := m.Called(id)
+	return args.Get(0).(alert.Alert), args.Error(1)
+}
+
+func (m *MockAlertManager) Delete(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
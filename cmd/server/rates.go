@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+)
+
+const (
+	// DefaultLookback is how old the rate returned for a time can be when no lookback is requested.
+	DefaultLookback = 24 * time.Hour
+	// MaxRateLookups is the maximum number of times that can be looked up in a single request.
+	MaxRateLookups = 1000
+	// MaxRatesPageSize is the maximum number of persisted rates listed in a single request, and the default one.
+	MaxRatesPageSize = 1000
+)
+
+// ratesAt is the payload of the as-of rates endpoint.
+type ratesAt struct {
+	Pair  string   `json:"pair"`
+	Rates []rateAt `json:"rates"`
+}
+
+// rateAt is the rate of the pair at a time, nil when there is no rate within the lookback.
+type rateAt struct {
+	At   time.Time             `json:"at"`
+	Rate *exchange.RateUpdated `json:"rate"`
+}
+
+// handleRatesAt returns the last known rate of the pair at or before every requested time, e.g.
+// /v1/rates/at?pair=EUR-BTC&at=2026-03-01T12:00:00Z,2026-03-02T12:00:00Z&lookback=1h
+func (s *Server) handleRatesAt(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	pair := query.Get("pair")
+	if pair == "" {
+		writeError(w, http.StatusBadRequest, "pair is required")
+		return
+	}
+
+	lookback := DefaultLookback
+	if value := query.Get("lookback"); value != "" {
+		var err error
+		if lookback, err = time.ParseDuration(value); err != nil || lookback <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid lookback %q, must be a positive duration", value))
+			return
+		}
+	}
+
+	var times []time.Time
+	for _, values := range query["at"] {
+		for _, value := range strings.Split(values, ",") {
+			at, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(value))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid at %q, must be an RFC 3339 time", value))
+				return
+			}
+			times = append(times, at)
+		}
+	}
+	if len(times) == 0 {
+		writeError(w, http.StatusBadRequest, "at is required")
+		return
+	}
+	if len(times) > MaxRateLookups {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d times can be looked up at once", MaxRateLookups))
+		return
+	}
+
+	result := ratesAt{Pair: pair, Rates: make([]rateAt, len(times))}
+	for i, at := range times {
+		result.Rates[i].At = at
+
+		rate, found, err := s.repository.RateAt(r.Context(), pair, at, lookback)
+		if err != nil {
+			log.Printf("Failed to get the rate of %s at %s: %v", pair, at, err)
+			writeError(w, http.StatusInternalServerError, "failed to get rates")
+			return
+		}
+		if found {
+			result.Rates[i].Rate = &rate
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// ratesSince is the payload of the history endpoint.
+type ratesSince struct {
+	Rates []exchange.RateUpdated `json:"rates"`
+	// NextOffset is the offset of the next page, nil on the last one.
+	NextOffset *int `json:"nextOffset,omitempty"`
+}
+
+// handleRatesSince returns a page of the persisted rates newer than the since time, sorted by time, e.g.
+// /v1/rates?since=2026-03-01T12:00:00Z&limit=100&offset=200
+func (s *Server) handleRatesSince(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	value := query.Get("since")
+	if value == "" {
+		writeError(w, http.StatusBadRequest, "since is required")
+		return
+	}
+	since, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid since %q, must be an RFC 3339 time", value))
+		return
+	}
+
+	limit := MaxRatesPageSize
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > MaxRatesPageSize {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit %q, must be between 1 and %d", value, MaxRatesPageSize))
+			return
+		}
+	}
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid offset %q, must be a non-negative integer", value))
+			return
+		}
+	}
+
+	// one more rate tells whether there is a next page
+	rates, err := s.repository.List(r.Context(), exchange.Query{Since: since, Offset: offset, Limit: limit + 1})
+	if err != nil {
+		log.Printf("Failed to list the rates since %s: %v", since, err)
+		writeError(w, http.StatusInternalServerError, "failed to get rates")
+		return
+	}
+
+	result := ratesSince{Rates: rates}
+	if len(rates) > limit {
+		result.Rates = rates[:limit]
+		next := offset + limit
+		result.NextOffset = &next
+	}
+	if result.Rates == nil {
+		result.Rates = []exchange.RateUpdated{}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_handleRatesAt(t *testing.T) {
+	first := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	second := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	rate := exchange.RateUpdated{From: "EUR", To: "BTC", At: first.Add(-time.Minute), Rate: "78,000.12", Source: "coindesk"}
+
+	tests := []struct {
+		name           string
+		query          string
+		setup          func(repository *MockRepository)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:  "single time",
+			query: "pair=EUR-BTC&at=2026-03-01T12:00:00Z",
+			setup: func(repository *MockRepository) {
+				repository.On("RateAt", mock.Anything, "EUR-BTC", first, DefaultLookback).Return(rate, true, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"pair":"EUR-BTC","rates":[{"at":"2026-03-01T12:00:00Z","rate":{"from":"EUR","to":"BTC","at":"2026-03-01T11:59:00Z","rate":"78,000.12","source":"coindesk"}}]}`,
+		},
+		{
+			name:  "several times with lookback",
+			query: "pair=EUR-BTC&at=2026-03-01T12:00:00Z,2026-03-02T12:00:00Z&lookback=1h",
+			setup: func(repository *MockRepository) {
+				repository.On("RateAt", mock.Anything, "EUR-BTC", first, time.Hour).Return(rate, true, nil)
+				repository.On("RateAt", mock.Anything, "EUR-BTC", second, time.Hour).Return(exchange.RateUpdated{}, false, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"pair":"EUR-BTC","rates":[{"at":"2026-03-01T12:00:00Z","rate":{"from":"EUR","to":"BTC","at":"2026-03-01T11:59:00Z","rate":"78,000.12","source":"coindesk"}},{"at":"2026-03-02T12:00:00Z","rate":null}]}`,
+		},
+		{
+			name:  "repeated at parameter",
+			query: "pair=EUR-BTC&at=2026-03-02T12:00:00Z&at=2026-03-01T12:00:00Z",
+			setup: func(repository *MockRepository) {
+				repository.On("RateAt", mock.Anything, "EUR-BTC", first, DefaultLookback).Return(rate, true, nil)
+				repository.On("RateAt", mock.Anything, "EUR-BTC", second, DefaultLookback).Return(rate, true, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"pair":"EUR-BTC","rates":[{"at":"2026-03-02T12:00:00Z","rate":{"from":"EUR","to":"BTC","at":"2026-03-01T11:59:00Z","rate":"78,000.12","source":"coindesk"}},{"at":"2026-03-01T12:00:00Z","rate":{"from":"EUR","to":"BTC","at":"2026-03-01T11:59:00Z","rate":"78,000.12","source":"coindesk"}}]}`,
+		},
+		{
+			name:           "missing pair",
+			query:          "at=2026-03-01T12:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"pair is required"}`,
+		},
+		{
+			name:           "missing at",
+			query:          "pair=EUR-BTC",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"at is required"}`,
+		},
+		{
+			name:           "invalid at",
+			query:          "pair=EUR-BTC&at=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid at \"yesterday\", must be an RFC 3339 time"}`,
+		},
+		{
+			name:           "invalid lookback",
+			query:          "pair=EUR-BTC&at=2026-03-01T12:00:00Z&lookback=-1h",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid lookback \"-1h\", must be a positive duration"}`,
+		},
+		{
+			name:           "too many times",
+			query:          "pair=EUR-BTC&at=" + strings.TrimSuffix(strings.Repeat("2026-03-01T12:00:00Z,", MaxRateLookups+1), ","),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"at most 1000 times can be looked up at once"}`,
+		},
+		{
+			name:  "repository error",
+			query: "pair=EUR-BTC&at=2026-03-01T12:00:00Z",
+			setup: func(repository *MockRepository) {
+				repository.On("RateAt", mock.Anything, "EUR-BTC", first, DefaultLookback).Return(exchange.RateUpdated{}, false, errors.New("database is closed"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"failed to get rates"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &MockRepository{}
+			if tt.setup != nil {
+				tt.setup(repository)
+			}
+			server := NewServer(&MockSubscriber{}, repository)
+
+			recorder := httptest.NewRecorder()
+			server.routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/rates/at?"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatus, recorder.Code)
+			assert.JSONEq(t, tt.expectedBody, recorder.Body.String())
+			repository.AssertExpectations(t)
+		})
+	}
+}
+
+func TestServer_handleRatesSince(t *testing.T) {
+	since := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	rate := exchange.RateUpdated{From: "EUR", To: "BTC", At: since.Add(time.Minute), Rate: "78,000.12", Source: "coindesk"}
+	other := exchange.RateUpdated{From: "USD", To: "BTC", At: since.Add(time.Minute), Rate: "84,000.12", Source: "coindesk"}
+
+	tests := []struct {
+		name           string
+		query          string
+		setup          func(repository *MockRepository)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:  "rates since",
+			query: "since=2026-03-01T12:00:00Z",
+			setup: func(repository *MockRepository) {
+				repository.On("List", mock.Anything, exchange.Query{Since: since, Limit: MaxRatesPageSize + 1}).Return([]exchange.RateUpdated{rate}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"rates":[{"from":"EUR","to":"BTC","at":"2026-03-01T12:01:00Z","rate":"78,000.12","source":"coindesk"}]}`,
+		},
+		{
+			name:  "page with a next one",
+			query: "since=2026-03-01T12:00:00Z&limit=1&offset=2",
+			setup: func(repository *MockRepository) {
+				repository.On("List", mock.Anything, exchange.Query{Since: since, Offset: 2, Limit: 2}).Return([]exchange.RateUpdated{rate, other}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"rates":[{"from":"EUR","to":"BTC","at":"2026-03-01T12:01:00Z","rate":"78,000.12","source":"coindesk"}],"nextOffset":3}`,
+		},
+		{
+			name:  "no rates",
+			query: "since=2026-03-01T12:00:00Z",
+			setup: func(repository *MockRepository) {
+				repository.On("List", mock.Anything, exchange.Query{Since: since, Limit: MaxRatesPageSize + 1}).Return([]exchange.RateUpdated(nil), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"rates":[]}`,
+		},
+		{
+			name:           "missing since",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"since is required"}`,
+		},
+		{
+			name:           "invalid since",
+			query:          "since=1744280237",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid since \"1744280237\", must be an RFC 3339 time"}`,
+		},
+		{
+			name:           "limit too big",
+			query:          "since=2026-03-01T12:00:00Z&limit=1001",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid limit \"1001\", must be between 1 and 1000"}`,
+		},
+		{
+			name:           "negative offset",
+			query:          "since=2026-03-01T12:00:00Z&offset=-1",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid offset \"-1\", must be a non-negative integer"}`,
+		},
+		{
+			name:  "repository error",
+			query: "since=2026-03-01T12:00:00Z",
+			setup: func(repository *MockRepository) {
+				repository.On("List", mock.Anything, exchange.Query{Since: since, Limit: MaxRatesPageSize + 1}).Return([]exchange.RateUpdated(nil), errors.New("unavailable"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"failed to get rates"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &MockRepository{}
+			if tt.setup != nil {
+				tt.setup(repository)
+			}
+			server := NewServer(&MockSubscriber{}, repository)
+
+			recorder := httptest.NewRecorder()
+			server.routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/rates?"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatus, recorder.Code)
+			assert.JSONEq(t, tt.expectedBody, recorder.Body.String())
+			repository.AssertExpectations(t)
+		})
+	}
+}
@@ -3,26 +3,70 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/analytics"
+	"github.com/alex-rufo/exchange/internal/exchange/codec"
+	"github.com/alex-rufo/exchange/internal/exchange/staleness"
+	"github.com/alex-rufo/exchange/internal/exchange/stats"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// Subscriber delivers the same updates to every subscription, so the messages sent to the WebSocket
+// clients are encoded once for all of them.
 type Subscriber interface {
-	Subscribe(id string) (<-chan exchange.RateUpdated, error)
+	SubscribeUpdates(id string) (<-chan *exchange.Update, error)
 	Unsubscribe(id string)
 }
 
 type Repository interface {
 	ListSince(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error)
+	List(ctx context.Context, query exchange.Query) ([]exchange.RateUpdated, error)
+	RateAt(ctx context.Context, pair string, at time.Time, lookback time.Duration) (exchange.RateUpdated, bool, error)
 }
 
+type IndicatorsProvider interface {
+	Indicators(pair string) (analytics.Indicators, bool)
+	AllIndicators() []analytics.Indicators
+}
+
+type StatsProvider interface {
+	Subscribe(id string) (<-chan stats.Stats, error)
+	Unsubscribe(id string)
+	Latest() []stats.Latest
+}
+
+type FreshnessMonitor interface {
+	Subscribe(id string) (<-chan staleness.Event, error)
+	Unsubscribe(id string)
+	IsStale(source, pair string) bool
+	Freshness() []staleness.Freshness
+}
+
+type PersisterStatsProvider interface {
+	Stats() exchange.PersisterStats
+}
+
+type LogReader interface {
+	Read(ctx context.Context, offset int64) (<-chan exchange.Record, error)
+}
+
+// Channels a WebSocket client can subscribe to.
+const (
+	ChannelRates  = "rates"
+	ChannelStats  = "stats"
+	ChannelStatus = "status"
+)
+
 var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 	CheckOrigin: func(r *http.Request) bool {
@@ -35,26 +79,111 @@ type Server struct {
 	server     *http.Server
 	subscriber Subscriber
 	repository Repository
+	indicators IndicatorsProvider
+	stats      StatsProvider
+	alerts     AlertManager
+	webhooks   WebhookManager
+	freshness  FreshnessMonitor
+	persister  PersisterStatsProvider
+	updatesLog LogReader
+}
+
+// Option allows enabling optional features of the server.
+type Option func(*Server)
+
+// WithIndicators exposes the indicators over REST and allows WebSocket clients to
+// receive them alongside every rate update.
+func WithIndicators(indicators IndicatorsProvider) Option {
+	return func(s *Server) {
+		s.indicators = indicators
+	}
+}
+
+// WithStats exposes the latest rate and statistics of every pair over REST and
+// enables the stats channel on the WebSocket.
+func WithStats(stats StatsProvider) Option {
+	return func(s *Server) {
+		s.stats = stats
+	}
 }
 
-func NewServer(subscriber Subscriber, repository Repository) *Server {
-	return &Server{
+// WithFreshness marks the rates of stale pairs, enables the status channel on the
+// WebSocket and reports the freshness of every pair on the health endpoint.
+func WithFreshness(freshness FreshnessMonitor) Option {
+	return func(s *Server) {
+		s.freshness = freshness
+	}
+}
+
+// WithPersister reports the state of the persister on the health endpoint.
+func WithPersister(persister PersisterStatsProvider) Option {
+	return func(s *Server) {
+		s.persister = persister
+	}
+}
+
+// WithLog adds the token of its offset in the log to every rate sent to the WebSocket clients, so they resume
+// from the last rate they received when reconnecting. The subscriber must deliver the updates read from the log,
+// which is only read on behalf of a client to replay the rates it missed.
+func WithLog(updatesLog LogReader) Option {
+	return func(s *Server) {
+		s.updatesLog = updatesLog
+	}
+}
+
+func NewServer(subscriber Subscriber, repository Repository, options ...Option) *Server {
+	s := &Server{
 		subscriber: subscriber,
 		repository: repository,
 	}
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
 }
 
 func (s *Server) Start(port int) error {
 	log.Printf("Server running on port %d\n", port)
 
 	s.server = &http.Server{
-		Addr: fmt.Sprintf(":%d", port),
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: s.routes(),
 	}
 
-	http.HandleFunc("/rates", s.handleRateUpdates)
 	return s.server.ListenAndServe()
 }
 
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rates", s.handleRateUpdates)
+	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("GET /v1/rates", s.handleRatesSince)
+	mux.HandleFunc("GET /v1/rates/at", s.handleRatesAt)
+	if s.indicators != nil {
+		mux.HandleFunc("GET /v1/indicators", s.handleIndicators)
+	}
+	if s.stats != nil {
+		mux.HandleFunc("GET /v1/rates/latest", s.handleLatestRates)
+	}
+	if s.alerts != nil {
+		mux.HandleFunc("POST /v1/alerts", s.handleCreateAlert)
+		mux.HandleFunc("GET /v1/alerts", s.handleListAlerts)
+		mux.HandleFunc("GET /v1/alerts/{id}", s.handleGetAlert)
+		mux.HandleFunc("DELETE /v1/alerts/{id}", s.handleDeleteAlert)
+	}
+	if s.webhooks != nil {
+		mux.HandleFunc("POST /v1/webhooks", s.handleCreateWebhook)
+		mux.HandleFunc("GET /v1/webhooks", s.handleListWebhooks)
+		mux.HandleFunc("GET /v1/webhooks/{id}", s.handleGetWebhook)
+		mux.HandleFunc("DELETE /v1/webhooks/{id}", s.handleDeleteWebhook)
+		mux.HandleFunc("GET /v1/webhooks/{id}/dead-letters", s.handleListDeadLetters)
+		mux.HandleFunc("POST /v1/webhooks/{id}/dead-letters/replay", s.handleReplayDeadLetters)
+	}
+
+	return mux
+}
+
 func (s *Server) Close() {
 	if s.server == nil {
 		return
@@ -66,8 +195,24 @@ func (s *Server) Close() {
 }
 
 func (s *Server) handleRateUpdates(w http.ResponseWriter, r *http.Request) {
+	encoding, responseHeader := negotiateEncoding(r)
+	channels, err := s.parseChannels(r.URL.Query().Get("channels"), encoding)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if encoding.Binary() && r.URL.Query().Get("indicators") == "true" {
+		http.Error(w, "indicators are only supported with the json encoding", http.StatusBadRequest)
+		return
+	}
+	offset, err := s.parseResumeToken(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Upgrade HTTP request to a WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
@@ -76,7 +221,10 @@ func (s *Server) handleRateUpdates(w http.ResponseWriter, r *http.Request) {
 
 	log.Println("WebSocket client connected")
 
-	if param := r.URL.Query().Get("since"); param != "" {
+	// Indicators are opt-in as they make every message considerably bigger.
+	withIndicators := s.indicators != nil && r.URL.Query().Get("indicators") == "true"
+
+	if param := r.URL.Query().Get("since"); param != "" && channels[ChannelRates] {
 		i, err := strconv.ParseInt(param, 10, 64)
 		if err != nil {
 			log.Printf("Invalid since param: %v", err)
@@ -91,29 +239,127 @@ func (s *Server) handleRateUpdates(w http.ResponseWriter, r *http.Request) {
 		}
 
 		for _, rate := range rates {
-			if err := s.writeToWS(conn, rate); err != nil {
+			// Indicators and freshness are only known for the latest rates, so they are not added to historical data.
+			if err := s.writeRateToWS(conn, encoding, rateMessage{Channel: ChannelRates, RateUpdated: rate}); err != nil {
 				log.Printf("Failed to send rate udpate to the websocket: %v", err)
 			}
 		}
 	}
 
+	var events <-chan staleness.Event
+	if channels[ChannelStatus] {
+		eventsSubscriptionID := uuid.NewString()
+		events, err = s.freshness.Subscribe(eventsSubscriptionID)
+		if err != nil {
+			log.Printf("Status subscription failed: %v", err)
+			return
+		}
+		defer s.freshness.Unsubscribe(eventsSubscriptionID)
+	}
+
+	// The statistics are sent once the tracker has added the rate to them.
+	var statsUpdates <-chan stats.Stats
+	if channels[ChannelStats] {
+		statsSubscriptionID := uuid.NewString()
+		statsUpdates, err = s.stats.Subscribe(statsSubscriptionID)
+		if err != nil {
+			log.Printf("Stats subscription failed: %v", err)
+			return
+		}
+		defer s.stats.Unsubscribe(statsSubscriptionID)
+	}
+
 	subscriptionID := uuid.NewString()
-	rates, err := s.subscriber.Subscribe(subscriptionID)
+	updates, err := s.subscriber.SubscribeUpdates(subscriptionID)
 	if err != nil {
 		log.Printf("Subscription failed: %v", err)
 		return
 	}
 	defer s.subscriber.Unsubscribe(subscriptionID)
 
+	// Clients resuming from a token are sent the rates following it from the log, until the subscription
+	// delivers the next one, so they are handed over to it without missing or repeating any rate.
+	var records <-chan exchange.Record
+	replayCtx, stopReplay := context.WithCancel(r.Context())
+	defer stopReplay()
+	if offset != exchange.OffsetNewest {
+		records, err = s.updatesLog.Read(replayCtx, offset)
+		if err != nil {
+			log.Printf("Failed to read the log from offset %d: %v", offset, err)
+			return
+		}
+	}
+
 	for {
+		var (
+			message rateMessage
+			// frame is the message shared by every client, encoded once for all of them
+			frame []byte
+		)
 		select {
-		case rate, ok := <-rates:
+		case update, ok := <-updates:
+			if !ok {
+				// Updates channel was closed, we won't receive any more updates
+				return
+			}
+			if records != nil {
+				if update.Offset != offset {
+					// sent from the log already, or to be sent from it once it catches up
+					continue
+				}
+				records = nil
+				stopReplay()
+			}
+			if withIndicators {
+				message = s.liveRateMessage(update.Rate, withIndicators)
+				message.Token = s.updateToken(update)
+			} else {
+				message.RateUpdated = update.Rate
+				if frame, err = s.rateFrame(update, encoding); err != nil {
+					log.Printf("Failed to encode the rate update: %v", err)
+					return
+				}
+			}
+		case record, ok := <-records:
+			if !ok {
+				// The log was closed, we won't receive any more updates
+				return
+			}
+			if record.Offset < offset {
+				continue
+			}
+			// Indicators and freshness are only known for the latest rates, so they are not added to the replayed ones.
+			message = rateMessage{Channel: ChannelRates, RateUpdated: record.Rate, Token: resumeToken(record.Offset)}
+			offset = record.Offset + 1
+		case event, ok := <-events:
 			if !ok {
-				// Rates channel was closed, we won't receive any more updates
 				return
 			}
 
-			if err := s.writeToWS(conn, rate); err != nil {
+			if err := s.writeToWS(conn, statusMessage{Channel: ChannelStatus, Event: event}); err != nil {
+				log.Printf("Failed to send status update to the websocket: %v", err)
+				return
+			}
+			continue
+		case pairStats, ok := <-statsUpdates:
+			if !ok {
+				return
+			}
+
+			if err := s.writeToWS(conn, statsMessage{Channel: ChannelStats, Stats: pairStats}); err != nil {
+				log.Printf("Failed to send stats update to the websocket: %v", err)
+				return
+			}
+			continue
+		}
+
+		if channels[ChannelRates] {
+			if frame != nil {
+				err = conn.WriteMessage(messageType(encoding), frame)
+			} else {
+				err = s.writeRateToWS(conn, encoding, message)
+			}
+			if err != nil {
 				// We failed to write to the WS, let's stop the subscription.
 				// TODO: we should be more careful as not all the errors mean disconnection but I wanted to keep it simple for now.
 				log.Printf("Failed to send rate udpate to the websocket: %v", err)
@@ -124,11 +370,237 @@ func (s *Server) handleRateUpdates(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (s *Server) writeToWS(conn *websocket.Conn, rate exchange.RateUpdated) error {
-	payload, err := json.Marshal(rate)
+// parseResumeToken returns the offset of the log the rates are read from, the one following the rate of the
+// resume token when the client sent one. Otherwise, only the new rates are read.
+func (s *Server) parseResumeToken(query url.Values) (int64, error) {
+	token := query.Get("resume")
+	if token == "" {
+		return exchange.OffsetNewest, nil
+	}
+	if s.updatesLog == nil {
+		return 0, errors.New("resume tokens are only supported when the updates are kept in a log")
+	}
+	if query.Get("since") != "" {
+		return 0, errors.New("since and resume cannot be used together")
+	}
+
+	offset, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid resume token %q", token)
+	}
+	return offset + 1, nil
+}
+
+// resumeToken returns the token of the rate at the offset, an opaque string for the clients.
+func resumeToken(offset int64) string {
+	return strconv.FormatInt(offset, 10)
+}
+
+// updateToken returns the resume token of the update, none when the updates are not kept in a log.
+func (s *Server) updateToken(update *exchange.Update) string {
+	if s.updatesLog == nil || update.Offset == exchange.NoOffset {
+		return ""
+	}
+	return resumeToken(update.Offset)
+}
+
+// negotiateEncoding returns the codec of the first WebSocket subprotocol requested by the client that is
+// supported, along with the header accepting it. Clients not requesting any supported one get JSON.
+func negotiateEncoding(r *http.Request) (codec.Codec, http.Header) {
+	for _, protocol := range websocket.Subprotocols(r) {
+		if encoding, ok := codec.Lookup(protocol); ok {
+			return encoding, http.Header{"Sec-Websocket-Protocol": {protocol}}
+		}
+	}
+	return codec.JSON, nil
+}
+
+// parseChannels returns the set of channels requested by the client, which defaults to
+// rates, and status when freshness is being monitored. The binary encodings only carry rates.
+func (s *Server) parseChannels(param string, encoding codec.Codec) (map[string]bool, error) {
+	if param == "" {
+		return map[string]bool{ChannelRates: true, ChannelStatus: s.freshness != nil && !encoding.Binary()}, nil
+	}
+
+	channels := make(map[string]bool)
+	for _, channel := range strings.Split(param, ",") {
+		switch {
+		case channel != ChannelRates && encoding.Binary():
+			return nil, fmt.Errorf("the %s channel is only supported with the json encoding", channel)
+		case channel == ChannelRates:
+		case channel == ChannelStats && s.stats != nil:
+		case channel == ChannelStatus && s.freshness != nil:
+		default:
+			return nil, fmt.Errorf("unsupported channel %q", channel)
+		}
+		channels[channel] = true
+	}
+
+	return channels, nil
+}
+
+// rateMessage is the payload sent through the WebSocket for every rate update.
+type rateMessage struct {
+	Channel string `json:"channel"`
+	exchange.RateUpdated
+	// Stale flags rates of pairs that their provider stopped updating.
+	Stale      bool                  `json:"stale,omitempty"`
+	Indicators *analytics.Indicators `json:"indicators,omitempty"`
+	// Token resumes the stream after this rate when sent back as the resume parameter.
+	Token string `json:"token,omitempty"`
+}
+
+// statsMessage is the payload sent through the WebSocket stats channel.
+type statsMessage struct {
+	Channel string `json:"channel"`
+	stats.Stats
+}
+
+// statusMessage is the payload sent through the WebSocket status channel.
+type statusMessage struct {
+	Channel string `json:"channel"`
+	staleness.Event
+}
+
+func (s *Server) liveRateMessage(rate exchange.RateUpdated, withIndicators bool) rateMessage {
+	message := rateMessage{Channel: ChannelRates, RateUpdated: rate}
+	if s.freshness != nil {
+		message.Stale = s.freshness.IsStale(rate.Source, rate.Pair())
+	}
+	if withIndicators {
+		// The analyzer consumes the updates on its own subscription, so the indicators
+		// might not include this very same rate yet if it is running behind.
+		if indicators, ok := s.indicators.Indicators(rate.Pair()); ok {
+			message.Indicators = &indicators
+		}
+	}
+
+	return message
+}
+
+// codecRate returns the message in the schema shared by every encoding, which has no indicators.
+func (m rateMessage) codecRate() codec.Rate {
+	rate := codec.NewRate(m.Channel, m.RateUpdated)
+	rate.Stale = m.Stale
+	rate.Token = m.Token
+	return rate
+}
+
+// rateFrame returns the live rate message of the update without indicators in the encoding, the same for
+// every client, so it is only encoded by the first one. The bytes are written as they are, as prepared
+// messages only pay off with compression, which is not negotiated.
+func (s *Server) rateFrame(update *exchange.Update, encoding codec.Codec) ([]byte, error) {
+	frame, err := update.Payload(encoding.Name(), func(rate exchange.RateUpdated) (any, error) {
+		message := s.liveRateMessage(rate, false)
+		message.Token = s.updateToken(update)
+		return encoding.Marshal(message.codecRate())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return frame.([]byte), nil
+}
+
+// writeRateToWS writes the rate message in the encoding, the indicators only being sent with JSON.
+func (s *Server) writeRateToWS(conn *websocket.Conn, encoding codec.Codec, message rateMessage) error {
+	if message.Indicators != nil {
+		return s.writeToWS(conn, message)
+	}
+
+	payload, err := encoding.Marshal(message.codecRate())
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(messageType(encoding), payload)
+}
+
+// messageType returns the type of the WebSocket messages of the encoding.
+func messageType(encoding codec.Codec) int {
+	if encoding.Binary() {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+func (s *Server) writeToWS(conn *websocket.Conn, message any) error {
+	payload, err := json.Marshal(message)
 	if err != nil {
 		return err
 	}
 
 	return conn.WriteMessage(websocket.TextMessage, payload)
 }
+
+// health is the payload of the health endpoint. The service is degraded, but still
+// able to serve requests, when any of the pairs is stale or the rates can not be persisted.
+type health struct {
+	Status    string                   `json:"status"`
+	Freshness []staleness.Freshness    `json:"freshness,omitempty"`
+	Persister *exchange.PersisterStats `json:"persister,omitempty"`
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	result := health{Status: "ok"}
+	if s.freshness != nil {
+		result.Freshness = s.freshness.Freshness()
+		for _, freshness := range result.Freshness {
+			if freshness.Stale {
+				result.Status = "degraded"
+			}
+		}
+	}
+	if s.persister != nil {
+		stats := s.persister.Stats()
+		result.Persister = &stats
+		if stats.Spilled > 0 {
+			result.Status = "degraded"
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleLatestRates(w http.ResponseWriter, r *http.Request) {
+	latest := s.stats.Latest()
+
+	if pair := r.URL.Query().Get("pair"); pair != "" {
+		for _, l := range latest {
+			if l.Pair() == pair {
+				writeJSON(w, http.StatusOK, l)
+				return
+			}
+		}
+
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no rates found for pair %s", pair))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, latest)
+}
+
+func (s *Server) handleIndicators(w http.ResponseWriter, r *http.Request) {
+	pair := r.URL.Query().Get("pair")
+	if pair == "" {
+		writeJSON(w, http.StatusOK, s.indicators.AllIndicators())
+		return
+	}
+
+	indicators, ok := s.indicators.Indicators(pair)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no indicators found for pair %s", pair))
+		return
+	}
+	writeJSON(w, http.StatusOK, indicators)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write the HTTP response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
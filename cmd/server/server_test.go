@@ -1,18 +1,26 @@
 package server
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/analytics"
+	"github.com/alex-rufo/exchange/internal/exchange/codec"
+	"github.com/alex-rufo/exchange/internal/exchange/staleness"
+	"github.com/alex-rufo/exchange/internal/exchange/stats"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServer_Start(t *testing.T) {
@@ -95,13 +103,13 @@ func TestServer_handleRateUpdates_WithSubscription(t *testing.T) {
 	server := NewServer(subscriber, repository)
 
 	// Create a channel for rate updates
-	rateChan := make(chan exchange.RateUpdated, 1)
+	rateChan := make(chan *exchange.Update, 1)
 	expectedRate := exchange.RateUpdated{From: "USD", To: "BTC", At: time.Now(), Rate: "50000.00"}
-	rateChan <- expectedRate
+	rateChan <- &exchange.Update{Rate: expectedRate}
 	close(rateChan)
 
 	// Mock subscription
-	subscriber.On("Subscribe", mock.Anything).Return(rateChan, nil)
+	subscriber.On("SubscribeUpdates", mock.Anything).Return(rateChan, nil)
 	subscriber.On("Unsubscribe", mock.Anything).Return()
 
 	// Create a test server
@@ -136,7 +144,7 @@ func TestServer_handleRateUpdates_SubscriptionError(t *testing.T) {
 	server := NewServer(subscriber, repository)
 
 	// Mock subscription error
-	subscriber.On("Subscribe", mock.Anything).Return(nil, assert.AnError)
+	subscriber.On("SubscribeUpdates", mock.Anything).Return(nil, assert.AnError)
 
 	// Create a test server
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -187,17 +195,561 @@ func TestServer_handleRateUpdates_HistoricalDataError(t *testing.T) {
 	repository.AssertExpectations(t)
 }
 
+func TestServer_handleRateUpdates_WithIndicators(t *testing.T) {
+	subscriber := &MockSubscriber{}
+	repository := &MockRepository{}
+	indicators := &MockIndicatorsProvider{}
+	server := NewServer(subscriber, repository, WithIndicators(indicators))
+
+	rateChan := make(chan *exchange.Update, 1)
+	rateChan <- &exchange.Update{Rate: exchange.RateUpdated{From: "USD", To: "BTC", At: time.Now(), Rate: "50000.00"}}
+	close(rateChan)
+
+	subscriber.On("SubscribeUpdates", mock.Anything).Return(rateChan, nil)
+	subscriber.On("Unsubscribe", mock.Anything).Return()
+	indicators.On("Indicators", "USD-BTC").Return(analytics.Indicators{Pair: "USD-BTC", Rate: 50000, EMA: 49000}, true)
+
+	ts := httptest.NewServer(http.HandlerFunc(server.handleRateUpdates))
+	defer ts.Close()
+
+	wsURL := fmt.Sprintf("ws%s/rates?indicators=true", ts.URL[4:])
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	var received rateMessage
+	require.NoError(t, json.Unmarshal(message, &received))
+	assert.Equal(t, "USD", received.From)
+	assert.Equal(t, "50000.00", received.Rate)
+	require.NotNil(t, received.Indicators)
+	assert.Equal(t, 49000.0, received.Indicators.EMA)
+
+	indicators.AssertExpectations(t)
+}
+
+func TestServer_handleIndicators(t *testing.T) {
+	indicators := &MockIndicatorsProvider{}
+	server := NewServer(&MockSubscriber{}, &MockRepository{}, WithIndicators(indicators))
+
+	all := []analytics.Indicators{{Pair: "EUR-BTC", Rate: 45000}, {Pair: "USD-BTC", Rate: 50000}}
+	indicators.On("AllIndicators").Return(all)
+	indicators.On("Indicators", "USD-BTC").Return(all[1], true)
+	indicators.On("Indicators", "GBP-BTC").Return(analytics.Indicators{}, false)
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedBody   any
+	}{
+		{name: "all pairs", url: "/v1/indicators", expectedStatus: http.StatusOK, expectedBody: all},
+		{name: "single pair", url: "/v1/indicators?pair=USD-BTC", expectedStatus: http.StatusOK, expectedBody: all[1]},
+		{
+			name:           "unknown pair",
+			url:            "/v1/indicators?pair=GBP-BTC",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"error": "no indicators found for pair GBP-BTC"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.handleIndicators(recorder, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			expected, err := json.Marshal(tt.expectedBody)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, recorder.Code)
+			assert.JSONEq(t, string(expected), recorder.Body.String())
+		})
+	}
+}
+
+func TestServer_handleRateUpdates_WithStatsChannel(t *testing.T) {
+	subscriber := &MockSubscriber{}
+	repository := &MockRepository{}
+	statsProvider := &MockStatsProvider{}
+	server := NewServer(subscriber, repository, WithStats(statsProvider))
+
+	rateChan := make(chan *exchange.Update, 1)
+	rateChan <- &exchange.Update{Rate: exchange.RateUpdated{From: "USD", To: "BTC", At: time.Now(), Rate: "50000.00"}}
+	statsChan := make(chan stats.Stats, 1)
+
+	subscriber.On("SubscribeUpdates", mock.Anything).Return(rateChan, nil)
+	subscriber.On("Unsubscribe", mock.Anything).Return()
+	statsProvider.On("Subscribe", mock.Anything).Return(statsChan, nil)
+	unsubscribed := make(chan struct{})
+	statsProvider.On("Unsubscribe", mock.Anything).Run(func(mock.Arguments) { close(unsubscribed) }).Return()
+
+	ts := httptest.NewServer(http.HandlerFunc(server.handleRateUpdates))
+	defer ts.Close()
+
+	wsURL := fmt.Sprintf("ws%s/rates?channels=rates,stats", ts.URL[4:])
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	var rate rateMessage
+	require.NoError(t, json.Unmarshal(message, &rate))
+	assert.Equal(t, ChannelRates, rate.Channel)
+	assert.Equal(t, "50000.00", rate.Rate)
+
+	// The stats are sent once the tracker has added the rate to them.
+	statsChan <- stats.Stats{Pair: "USD-BTC", High: 51000, Low: 49000}
+	_, message, err = conn.ReadMessage()
+	require.NoError(t, err)
+
+	var received statsMessage
+	require.NoError(t, json.Unmarshal(message, &received))
+	assert.Equal(t, ChannelStats, received.Channel)
+	assert.Equal(t, "USD-BTC", received.Pair)
+	assert.Equal(t, 51000.0, received.High)
+	assert.Equal(t, 49000.0, received.Low)
+
+	// and the subscription is removed once the client is gone
+	close(rateChan)
+	select {
+	case <-unsubscribed:
+	case <-time.After(time.Second):
+		assert.Fail(t, "stats subscription not removed")
+	}
+}
+
+func TestServer_handleRateUpdates_SharedFrame(t *testing.T) {
+	subscriber := &MockSubscriber{}
+	freshness := &MockFreshnessMonitor{}
+	server := NewServer(subscriber, &MockRepository{}, WithFreshness(freshness))
+
+	// both clients receive the same update
+	update := &exchange.Update{Rate: exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 0).UTC(), Rate: "50000.00", Source: "coindesk"}}
+	first, second := make(chan *exchange.Update, 1), make(chan *exchange.Update, 1)
+	first <- update
+	second <- update
+	subscriber.On("SubscribeUpdates", mock.Anything).Return(first, nil).Once()
+	subscriber.On("SubscribeUpdates", mock.Anything).Return(second, nil).Once()
+	subscriber.On("Unsubscribe", mock.Anything).Return()
+	freshness.On("IsStale", "coindesk", "USD-BTC").Return(true)
+
+	ts := httptest.NewServer(http.HandlerFunc(server.handleRateUpdates))
+	defer ts.Close()
+
+	var messages []string
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws%s/rates?channels=rates", ts.URL[4:]), nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, message, err := conn.ReadMessage()
+		require.NoError(t, err)
+		messages = append(messages, string(message))
+	}
+
+	// the message is encoded once, for the first client
+	expected := `{"channel":"rates","from":"USD","to":"BTC","at":"1970-01-01T00:16:40Z","rate":"50000.00","source":"coindesk","stale":true}`
+	assert.JSONEq(t, expected, messages[0])
+	assert.Equal(t, messages[0], messages[1])
+	freshness.AssertNumberOfCalls(t, "IsStale", 1)
+}
+
+func TestServer_handleRateUpdates_Encodings(t *testing.T) {
+	rate := exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 5).UTC(), Rate: "50000.00", Source: "coindesk"}
+
+	for _, encoding := range codec.Codecs {
+		t.Run(encoding.Name(), func(t *testing.T) {
+			subscriber := &MockSubscriber{}
+			repository := &MockRepository{}
+			freshness := &MockFreshnessMonitor{}
+			server := NewServer(subscriber, repository, WithFreshness(freshness))
+
+			updates := make(chan *exchange.Update, 1)
+			updates <- &exchange.Update{Rate: rate}
+			close(updates)
+			subscriber.On("SubscribeUpdates", mock.Anything).Return(updates, nil)
+			subscriber.On("Unsubscribe", mock.Anything).Return()
+			repository.On("ListSince", mock.Anything, mock.Anything).Return([]exchange.RateUpdated{rate}, nil)
+			freshness.On("IsStale", "coindesk", "USD-BTC").Return(true)
+			if !encoding.Binary() {
+				// the status channel is only included by default with json
+				freshness.On("Subscribe", mock.Anything).Return(make(chan staleness.Event), nil)
+				freshness.On("Unsubscribe", mock.Anything).Return()
+			}
+
+			ts := httptest.NewServer(http.HandlerFunc(server.handleRateUpdates))
+			defer ts.Close()
+
+			dialer := websocket.Dialer{Subprotocols: []string{"xml", encoding.Name()}}
+			conn, _, err := dialer.Dial(fmt.Sprintf("ws%s/rates?since=1", ts.URL[4:]), nil)
+			require.NoError(t, err)
+			defer conn.Close()
+			assert.Equal(t, encoding.Name(), conn.Subprotocol())
+
+			// both the historical and the live rates are sent in the negotiated encoding
+			historical := codec.NewRate(ChannelRates, rate)
+			live := historical
+			live.Stale = true
+			for _, expected := range []codec.Rate{historical, live} {
+				messageType, message, err := conn.ReadMessage()
+				require.NoError(t, err)
+				assert.Equal(t, encoding.Binary(), messageType == websocket.BinaryMessage)
+
+				var received codec.Rate
+				require.NoError(t, encoding.Unmarshal(message, &received))
+				assert.Equal(t, expected, received)
+			}
+		})
+	}
+}
+
+func TestServer_handleRateUpdates_DefaultEncoding(t *testing.T) {
+	subscriber := &MockSubscriber{}
+	server := NewServer(subscriber, &MockRepository{})
+	updates := make(chan *exchange.Update, 1)
+	updates <- &exchange.Update{Rate: exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 0).UTC(), Rate: "50000.00"}}
+	close(updates)
+	subscriber.On("SubscribeUpdates", mock.Anything).Return(updates, nil)
+	subscriber.On("Unsubscribe", mock.Anything).Return()
+
+	ts := httptest.NewServer(http.HandlerFunc(server.handleRateUpdates))
+	defer ts.Close()
+
+	// clients not requesting any supported encoding get json
+	dialer := websocket.Dialer{Subprotocols: []string{"xml"}}
+	conn, _, err := dialer.Dial(fmt.Sprintf("ws%s/rates", ts.URL[4:]), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Empty(t, conn.Subprotocol())
+
+	messageType, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, websocket.TextMessage, messageType)
+	assert.JSONEq(t, `{"channel":"rates","from":"USD","to":"BTC","at":"1970-01-01T00:16:40Z","rate":"50000.00"}`, string(message))
+}
+
+func TestServer_handleRateUpdates_BinaryEncodingUnsupported(t *testing.T) {
+	server := NewServer(&MockSubscriber{}, &MockRepository{}, WithIndicators(&MockIndicatorsProvider{}), WithStats(&MockStatsProvider{}))
+
+	tests := map[string]struct {
+		url           string
+		expectedError string
+	}{
+		"indicators": {url: "/rates?indicators=true", expectedError: "indicators are only supported with the json encoding"},
+		"stats":      {url: "/rates?channels=rates,stats", expectedError: "the stats channel is only supported with the json encoding"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			request.Header.Set("Sec-WebSocket-Protocol", "msgpack")
+			recorder := httptest.NewRecorder()
+			server.handleRateUpdates(recorder, request)
+
+			assert.Equal(t, http.StatusBadRequest, recorder.Code)
+			assert.Contains(t, recorder.Body.String(), tt.expectedError)
+		})
+	}
+}
+
+func TestServer_handleRateUpdates_UnsupportedChannel(t *testing.T) {
+	// The stats channel is only available when the server has a stats provider.
+	server := NewServer(&MockSubscriber{}, &MockRepository{})
+
+	recorder := httptest.NewRecorder()
+	server.handleRateUpdates(recorder, httptest.NewRequest(http.MethodGet, "/rates?channels=stats", nil))
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `unsupported channel "stats"`)
+}
+
+func TestServer_handleRateUpdates_ResumeToken(t *testing.T) {
+	ctx := context.Background()
+	updatesLog := exchange.NewMemoryLog()
+	defer updatesLog.Close()
+
+	rates := make([]exchange.RateUpdated, 5)
+	for i := range rates {
+		rates[i] = exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(int64(1000+i), 0).UTC(), Rate: "50000.00"}
+	}
+	for _, rate := range rates[:3] {
+		require.NoError(t, updatesLog.Publish(ctx, rate))
+	}
+
+	// the log is read once, by the broadcaster, the clients only reading it to replay the rates they missed
+	records, err := updatesLog.Read(ctx, exchange.OffsetNewest)
+	require.NoError(t, err)
+	broadcaster := exchange.NewLogBroadcaster(records, 5, 1)
+	go broadcaster.ListenAndServer()
+	defer broadcaster.Close()
+	server := NewServer(broadcaster, &MockRepository{}, WithLog(updatesLog))
+
+	ts := httptest.NewServer(http.HandlerFunc(server.handleRateUpdates))
+	defer ts.Close()
+
+	// the rates following the one of the token are sent, and then the new ones, each one with its own token
+	resumed, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws%s/rates?resume=0", ts.URL[4:]), nil)
+	require.NoError(t, err)
+	defer resumed.Close()
+	for i := 1; i < 3; i++ {
+		assertRateMessage(t, resumed, rates[i], fmt.Sprint(i))
+	}
+
+	// the clients without a token only get the new ones
+	live, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws%s/rates", ts.URL[4:]), nil)
+	require.NoError(t, err)
+	defer live.Close()
+
+	for i := 3; i < 5; i++ {
+		require.NoError(t, updatesLog.Publish(ctx, rates[i]))
+		assertRateMessage(t, resumed, rates[i], fmt.Sprint(i))
+		assertRateMessage(t, live, rates[i], fmt.Sprint(i))
+	}
+}
+
+func TestServer_handleRateUpdates_ResumeTokenHandover(t *testing.T) {
+	ctx := context.Background()
+	updatesLog := exchange.NewMemoryLog()
+	defer updatesLog.Close()
+	rates := make([]exchange.RateUpdated, 3)
+	for i := range rates {
+		rates[i] = exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(int64(1000+i), 0).UTC(), Rate: "50000.00"}
+		require.NoError(t, updatesLog.Publish(ctx, rates[i]))
+	}
+
+	// the subscription delivers the rates at offset 1, already replayed, and 2, handing the client over to it
+	subscriber := &MockSubscriber{}
+	updates := make(chan *exchange.Update, 2)
+	updates <- &exchange.Update{Rate: rates[1], Offset: 1}
+	updates <- &exchange.Update{Rate: rates[2], Offset: 2}
+	subscriber.On("SubscribeUpdates", mock.Anything).Return(updates, nil)
+	subscriber.On("Unsubscribe", mock.Anything).Return()
+	server := NewServer(subscriber, &MockRepository{}, WithLog(updatesLog))
+
+	ts := httptest.NewServer(http.HandlerFunc(server.handleRateUpdates))
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws%s/rates?resume=0", ts.URL[4:]), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	for i := 1; i < 3; i++ {
+		assertRateMessage(t, conn, rates[i], fmt.Sprint(i))
+	}
+
+	// no rate is sent twice
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, _, err = conn.ReadMessage()
+	var netErr net.Error
+	assert.ErrorAs(t, err, &netErr)
+}
+
+func TestServer_handleRateUpdates_InvalidResumeToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []Option
+		url      string
+		expected string
+	}{
+		{name: "without log", url: "/rates?resume=1", expected: "resume tokens are only supported when the updates are kept in a log"},
+		{name: "not an offset", options: []Option{WithLog(exchange.NewMemoryLog())}, url: "/rates?resume=abc", expected: `invalid resume token "abc"`},
+		{name: "along with since", options: []Option{WithLog(exchange.NewMemoryLog())}, url: "/rates?resume=1&since=1000", expected: "since and resume cannot be used together"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(&MockSubscriber{}, &MockRepository{}, tt.options...)
+
+			recorder := httptest.NewRecorder()
+			server.handleRateUpdates(recorder, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			assert.Equal(t, http.StatusBadRequest, recorder.Code)
+			assert.Contains(t, recorder.Body.String(), tt.expected)
+		})
+	}
+}
+
+func assertRateMessage(t *testing.T, conn *websocket.Conn, rate exchange.RateUpdated, token string) {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	var received rateMessage
+	require.NoError(t, json.Unmarshal(message, &received))
+	assert.Equal(t, rateMessage{Channel: ChannelRates, RateUpdated: rate, Token: token}, received)
+}
+
+func TestServer_handleLatestRates(t *testing.T) {
+	statsProvider := &MockStatsProvider{}
+	server := NewServer(&MockSubscriber{}, &MockRepository{}, WithStats(statsProvider))
+
+	at := time.Unix(1000, 0).UTC()
+	latest := []stats.Latest{
+		{
+			RateUpdated: exchange.RateUpdated{From: "EUR", To: "BTC", At: at, Rate: "45000.00"},
+			Stats:       stats.Stats{Pair: "EUR-BTC", At: at, Open: 44000, High: 45000, Low: 44000, Last: 45000, Change: 1000},
+		},
+		{
+			RateUpdated: exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: "50000.00"},
+			Stats:       stats.Stats{Pair: "USD-BTC", At: at, Open: 50000, High: 50000, Low: 50000, Last: 50000},
+		},
+	}
+	statsProvider.On("Latest").Return(latest)
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedBody   any
+	}{
+		{name: "all pairs", url: "/v1/rates/latest", expectedStatus: http.StatusOK, expectedBody: latest},
+		{name: "single pair", url: "/v1/rates/latest?pair=USD-BTC", expectedStatus: http.StatusOK, expectedBody: latest[1]},
+		{
+			name:           "unknown pair",
+			url:            "/v1/rates/latest?pair=GBP-BTC",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"error": "no rates found for pair GBP-BTC"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.handleLatestRates(recorder, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			expected, err := json.Marshal(tt.expectedBody)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, recorder.Code)
+			assert.JSONEq(t, string(expected), recorder.Body.String())
+		})
+	}
+}
+
+func TestServer_handleRateUpdates_WithFreshness(t *testing.T) {
+	subscriber := &MockSubscriber{}
+	freshness := &MockFreshnessMonitor{}
+	server := NewServer(subscriber, &MockRepository{}, WithFreshness(freshness))
+
+	rateChan := make(chan *exchange.Update)
+	eventsChan := make(chan staleness.Event)
+	subscriber.On("SubscribeUpdates", mock.Anything).Return(rateChan, nil)
+	subscriber.On("Unsubscribe", mock.Anything).Return()
+	freshness.On("Subscribe", mock.Anything).Return(eventsChan, nil)
+	freshness.On("Unsubscribe", mock.Anything).Return()
+	freshness.On("IsStale", "coindesk", "USD-BTC").Return(true)
+
+	ts := httptest.NewServer(http.HandlerFunc(server.handleRateUpdates))
+	defer ts.Close()
+
+	// The status channel is included by default.
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws%s/rates", ts.URL[4:]), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	lastAt := time.Unix(1000, 0).UTC()
+	eventsChan <- staleness.Event{Status: staleness.StatusStale, Source: "coindesk", Pair: "USD-BTC", LastAt: lastAt, ThresholdSeconds: 60}
+
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"channel":"status","status":"stale","source":"coindesk","pair":"USD-BTC","lastAt":"1970-01-01T00:16:40Z","thresholdSeconds":60,"at":"0001-01-01T00:00:00Z"}`, string(message))
+
+	// Rates of stale pairs are flagged.
+	rateChan <- &exchange.Update{Rate: exchange.RateUpdated{From: "USD", To: "BTC", At: lastAt, Rate: "50000.00", Source: "coindesk"}}
+
+	_, message, err = conn.ReadMessage()
+	require.NoError(t, err)
+
+	var received rateMessage
+	require.NoError(t, json.Unmarshal(message, &received))
+	assert.True(t, received.Stale)
+
+	// Once the connection is closed, every subscription was removed.
+	close(rateChan)
+	_, _, err = conn.ReadMessage()
+	assert.Error(t, err)
+	freshness.AssertExpectations(t)
+}
+
+func TestServer_handleHealth(t *testing.T) {
+	lastAt := time.Unix(1000, 0).UTC()
+
+	tests := []struct {
+		name         string
+		freshness    []staleness.Freshness
+		expectedBody string
+	}{
+		{
+			name:         "all pairs fresh",
+			freshness:    []staleness.Freshness{{Source: "coindesk", Pair: "USD-BTC", LastAt: lastAt, AgeSeconds: 5, ThresholdSeconds: 60}},
+			expectedBody: `{"status":"ok","freshness":[{"source":"coindesk","pair":"USD-BTC","lastAt":"1970-01-01T00:16:40Z","ageSeconds":5,"thresholdSeconds":60,"stale":false}]}`,
+		},
+		{
+			name:         "stale pair",
+			freshness:    []staleness.Freshness{{Source: "coindesk", Pair: "USD-BTC", LastAt: lastAt, AgeSeconds: 120, ThresholdSeconds: 60, Stale: true}},
+			expectedBody: `{"status":"degraded","freshness":[{"source":"coindesk","pair":"USD-BTC","lastAt":"1970-01-01T00:16:40Z","ageSeconds":120,"thresholdSeconds":60,"stale":true}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			freshness := &MockFreshnessMonitor{}
+			freshness.On("Freshness").Return(tt.freshness)
+			server := NewServer(&MockSubscriber{}, &MockRepository{}, WithFreshness(freshness))
+
+			recorder := httptest.NewRecorder()
+			server.routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.JSONEq(t, tt.expectedBody, recorder.Body.String())
+		})
+	}
+
+	t.Run("without freshness monitor", func(t *testing.T) {
+		server := NewServer(&MockSubscriber{}, &MockRepository{})
+
+		recorder := httptest.NewRecorder()
+		server.routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"status":"ok"}`, recorder.Body.String())
+	})
+
+	t.Run("with persister", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			stats        exchange.PersisterStats
+			expectedBody string
+		}{
+			"persisting": {stats: exchange.PersisterStats{Queued: 2, Persisted: 10}, expectedBody: `{"status":"ok","persister":{"queued":2,"spilled":0,"persisted":10,"failures":0,"dropped":0}}`},
+			"spilling":   {stats: exchange.PersisterStats{Spilled: 5, Persisted: 10, Failures: 3}, expectedBody: `{"status":"degraded","persister":{"queued":0,"spilled":5,"persisted":10,"failures":3,"dropped":0}}`},
+		} {
+			t.Run(name, func(t *testing.T) {
+				persister := &MockPersister{}
+				persister.On("Stats").Return(tc.stats)
+				server := NewServer(&MockSubscriber{}, &MockRepository{}, WithPersister(persister))
+
+				recorder := httptest.NewRecorder()
+				server.routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+				assert.Equal(t, http.StatusOK, recorder.Code)
+				assert.JSONEq(t, tc.expectedBody, recorder.Body.String())
+			})
+		}
+	})
+}
+
 // MockSubscriber implements the Subscriber interface for testing
 type MockSubscriber struct {
 	mock.Mock
 }
 
-func (m *MockSubscriber) Subscribe(id string) (<-chan exchange.RateUpdated, error) {
+func (m *MockSubscriber) SubscribeUpdates(id string) (<-chan *exchange.Update, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).(chan exchange.RateUpdated), args.Error(1)
+	return args.Get(0).(chan *exchange.Update), args.Error(1)
 }
 
 func (m *MockSubscriber) Unsubscribe(id string) {
@@ -216,3 +768,191 @@ func (m *MockRepository) ListSince(ctx context.Context, since time.Time) ([]exch
 	}
 	return args.Get(0).([]exchange.RateUpdated), args.Error(1)
 }
+
+func (m *MockRepository) List(ctx context.Context, query exchange.Query) ([]exchange.RateUpdated, error) {
+	args := m.Called(ctx, query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]exchange.RateUpdated), args.Error(1)
+}
+
+func (m *MockRepository) RateAt(ctx context.Context, pair string, at time.Time, lookback time.Duration) (exchange.RateUpdated, bool, error) {
+	args := m.Called(ctx, pair, at, lookback)
+	return args.Get(0).(exchange.RateUpdated), args.Bool(1), args.Error(2)
+}
+
+// MockIndicatorsProvider implements the IndicatorsProvider interface for testing
+type MockIndicatorsProvider struct {
+	mock.Mock
+}
+
+func (m *MockIndicatorsProvider) Indicators(pair string) (analytics.Indicators, bool) {
+	args := m.Called(pair)
+	return args.Get(0).(analytics.Indicators), args.Bool(1)
+}
+
+func (m *MockIndicatorsProvider) AllIndicators() []analytics.Indicators {
+	args := m.Called()
+	return args.Get(0).([]analytics.Indicators)
+}
+
+// MockStatsProvider implements the StatsProvider interface for testing
+type MockStatsProvider struct {
+	mock.Mock
+}
+
+func (m *MockStatsProvider) Subscribe(id string) (<-chan stats.Stats, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(chan stats.Stats), args.Error(1)
+}
+
+func (m *MockStatsProvider) Unsubscribe(id string) {
+	m.Called(id)
+}
+
+func (m *MockStatsProvider) Latest() []stats.Latest {
+	args := m.Called()
+	return args.Get(0).([]stats.Latest)
+}
+
+// MockFreshnessMonitor implements the FreshnessMonitor interface for testing
+type MockFreshnessMonitor struct {
+	mock.Mock
+}
+
+func (m *MockFreshnessMonitor) Subscribe(id string) (<-chan staleness.Event, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(chan staleness.Event), args.Error(1)
+}
+
+func (m *MockFreshnessMonitor) Unsubscribe(id string) {
+	m.Called(id)
+}
+
+func (m *MockFreshnessMonitor) IsStale(source, pair string) bool {
+	args := m.Called(source, pair)
+	return args.Bool(0)
+}
+
+func (m *MockFreshnessMonitor) Freshness() []staleness.Freshness {
+	args := m.Called()
+	return args.Get(0).([]staleness.Freshness)
+}
+
+// MockPersister implements the PersisterStatsProvider interface for testing
+type MockPersister struct {
+	mock.Mock
+}
+
+func (m *MockPersister) Stats() exchange.PersisterStats {
+	args := m.Called()
+	return args.Get(0).(exchange.PersisterStats)
+}
+
+func BenchmarkServer_writeRates(b *testing.B) {
+	server := NewServer(&MockSubscriber{}, &MockRepository{})
+	rate := exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 0).UTC(), Rate: "50000.00", Source: "coindesk"}
+
+	for _, clients := range []int{100, 1000} {
+		conns := make([]*websocket.Conn, clients)
+		for i := range conns {
+			conns[i] = newDiscardWS(b)
+		}
+
+		// every client encodes the message on its own
+		b.Run(fmt.Sprintf("clients=%d/marshal", clients), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, conn := range conns {
+					if err := server.writeToWS(conn, server.liveRateMessage(rate, false)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+
+		// the message is encoded once and its bytes are written to every client
+		b.Run(fmt.Sprintf("clients=%d/shared", clients), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				update := &exchange.Update{Rate: rate}
+				for _, conn := range conns {
+					frame, err := server.rateFrame(update, codec.JSON)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+
+		// the message is encoded once into a prepared message written to every client
+		b.Run(fmt.Sprintf("clients=%d/prepared", clients), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				update := &exchange.Update{Rate: rate}
+				for _, conn := range conns {
+					prepared, err := update.Payload("prepared", func(rate exchange.RateUpdated) (any, error) {
+						payload, err := json.Marshal(server.liveRateMessage(rate, false))
+						if err != nil {
+							return nil, err
+						}
+						return websocket.NewPreparedMessage(websocket.TextMessage, payload)
+					})
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := conn.WritePreparedMessage(prepared.(*websocket.PreparedMessage)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// newDiscardWS upgrades a connection discarding everything written to it, so only the server is measured.
+func newDiscardWS(b *testing.B) *websocket.Conn {
+	b.Helper()
+	request := httptest.NewRequest(http.MethodGet, "/rates", nil)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	request.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+	conn, err := upgrader.Upgrade(hijackRecorder{ResponseRecorder: httptest.NewRecorder()}, request, nil)
+	require.NoError(b, err)
+	b.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// hijackRecorder hands a discardConn over to the WebSocket upgrader.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn := discardConn{}
+	return conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)), nil
+}
+
+// discardConn is a connection discarding everything written to it, and never reading anything.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Read(p []byte) (int, error)       { return 0, io.EOF }
+func (discardConn) Write(p []byte) (int, error)      { return len(p), nil }
+func (discardConn) Close() error                     { return nil }
+func (discardConn) SetDeadline(time.Time) error      { return nil }
+func (discardConn) SetReadDeadline(time.Time) error  { return nil }
+func (discardConn) SetWriteDeadline(time.Time) error { return nil }
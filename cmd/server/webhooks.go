@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/alex-rufo/exchange/internal/exchange/webhook"
+)
+
+type WebhookManager interface {
+	Create(endpoint webhook.Endpoint) (webhook.Endpoint, error)
+	List() []webhook.Endpoint
+	Get(id string) (webhook.Endpoint, error)
+	Delete(id string) error
+	DeadLetters(endpointID string) ([]webhook.DeadLetter, error)
+	Replay(ctx context.Context, endpointID string) (webhook.ReplayResult, error)
+}
+
+// WithWebhooks exposes the REST API to manage the webhook subscriptions and their dead letters.
+func WithWebhooks(webhooks WebhookManager) Option {
+	return func(s *Server) {
+		s.webhooks = webhooks
+	}
+}
+
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var endpoint webhook.Endpoint
+	if err := json.NewDecoder(r.Body).Decode(&endpoint); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	created, err := s.webhooks.Create(endpoint)
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.webhooks.List())
+}
+
+func (s *Server) handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	endpoint, err := s.webhooks.Get(r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, endpoint)
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := s.webhooks.Delete(r.PathValue("id")); err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	deadLetters, err := s.webhooks.DeadLetters(r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deadLetters)
+}
+
+func (s *Server) handleReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	result, err := s.webhooks.Replay(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeWebhookError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, webhook.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, webhook.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Printf("Failed to handle webhook request: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to handle webhook request")
+	}
+}
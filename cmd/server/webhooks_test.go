@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_Webhooks(t *testing.T) {
+	createdAt := time.Unix(1000, 0).UTC()
+	created := webhook.Endpoint{ID: "hook-id", URL: "https://example.com/hook", Pairs: []string{"USD-BTC"}, Secret: "secret", CreatedAt: createdAt}
+	listed := created
+	listed.Secret = ""
+	deadLetter := webhook.DeadLetter{
+		ID:         "letter-id",
+		EndpointID: "hook-id",
+		Rates:      []exchange.RateUpdated{{From: "USD", To: "BTC", At: createdAt, Rate: "50000.00"}},
+		Error:      "timeout",
+		FailedAt:   createdAt,
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		body           string
+		setup          func(m *MockWebhookManager)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:   "create webhook",
+			method: http.MethodPost,
+			url:    "/v1/webhooks",
+			body:   `{"url":"https://example.com/hook","pairs":["USD-BTC"]}`,
+			setup: func(m *MockWebhookManager) {
+				m.On("Create", webhook.Endpoint{URL: "https://example.com/hook", Pairs: []string{"USD-BTC"}}).Return(created, nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id":"hook-id","url":"https://example.com/hook","pairs":["USD-BTC"],"secret":"secret","createdAt":"1970-01-01T00:16:40Z"}`,
+		},
+		{
+			name:           "create webhook with invalid JSON",
+			method:         http.MethodPost,
+			url:            "/v1/webhooks",
+			body:           `[`,
+			setup:          func(m *MockWebhookManager) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid JSON body"}`,
+		},
+		{
+			name:   "create invalid webhook",
+			method: http.MethodPost,
+			url:    "/v1/webhooks",
+			body:   `{"url":"example.com"}`,
+			setup: func(m *MockWebhookManager) {
+				m.On("Create", mock.Anything).Return(webhook.Endpoint{}, fmt.Errorf("%w: url must be an absolute http(s) URL", webhook.ErrInvalid))
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid webhook: url must be an absolute http(s) URL"}`,
+		},
+		{
+			name:   "list webhooks",
+			method: http.MethodGet,
+			url:    "/v1/webhooks",
+			setup: func(m *MockWebhookManager) {
+				m.On("List").Return([]webhook.Endpoint{listed})
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"id":"hook-id","url":"https://example.com/hook","pairs":["USD-BTC"],"createdAt":"1970-01-01T00:16:40Z"}]`,
+		},
+		{
+			name:   "get unknown webhook",
+			method: http.MethodGet,
+			url:    "/v1/webhooks/unknown",
+			setup: func(m *MockWebhookManager) {
+				m.On("Get", "unknown").Return(webhook.Endpoint{}, webhook.ErrNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":"webhook not found"}`,
+		},
+		{
+			name:   "delete webhook",
+			method: http.MethodDelete,
+			url:    "/v1/webhooks/hook-id",
+			setup: func(m *MockWebhookManager) {
+				m.On("Delete", "hook-id").Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:   "list dead letters",
+			method: http.MethodGet,
+			url:    "/v1/webhooks/hook-id/dead-letters",
+			setup: func(m *MockWebhookManager) {
+				m.On("DeadLetters", "hook-id").Return([]webhook.DeadLetter{deadLetter}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"id":"letter-id","endpointId":"hook-id","rates":[{"from":"USD","to":"BTC","at":"1970-01-01T00:16:40Z","rate":"50000.00"}],"error":"timeout","failedAt":"1970-01-01T00:16:40Z"}]`,
+		},
+		{
+			name:   "replay dead letters",
+			method: http.MethodPost,
+			url:    "/v1/webhooks/hook-id/dead-letters/replay",
+			setup: func(m *MockWebhookManager) {
+				m.On("Replay", mock.Anything, "hook-id").Return(webhook.ReplayResult{Replayed: 2, Failed: 1}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"replayed":2,"failed":1}`,
+		},
+		{
+			name:   "replay dead letters failure",
+			method: http.MethodPost,
+			url:    "/v1/webhooks/hook-id/dead-letters/replay",
+			setup: func(m *MockWebhookManager) {
+				m.On("Replay", mock.Anything, "hook-id").Return(webhook.ReplayResult{}, errors.New("disk full"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"failed to handle webhook request"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhooks := &MockWebhookManager{}
+			tt.setup(webhooks)
+			server := NewServer(&MockSubscriber{}, &MockRepository{}, WithWebhooks(webhooks))
+
+			recorder := httptest.NewRecorder()
+			server.routes().ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.expectedStatus, recorder.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, recorder.Body.String())
+			}
+			webhooks.AssertExpectations(t)
+		})
+	}
+}
+
+// MockWebhookManager implements the WebhookManager interface for testing
+type MockWebhookManager struct {
+	mock.Mock
+}
+
+func (m *MockWebhookManager) Create(endpoint webhook.Endpoint) (webhook.Endpoint, error) {
+	args := m.Called(endpoint)
+	return args.Get(0).(webhook.Endpoint), args.Error(1)
+}
+
+func (m *MockWebhookManager) List() []webhook.Endpoint {
+	args := m.Called()
+	return args.Get(0).([]webhook.Endpoint)
+}
+
+func (m *MockWebhookManager) Get(id string) (webhook.Endpoint, error) {
+	args := m.Called(id)
+	return args.Get(0).(webhook.Endpoint), args.Error(1)
+}
+
+func (m *MockWebhookManager) Delete(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockWebhookManager) DeadLetters(endpointID string) ([]webhook.DeadLetter, error) {
+	args := m.Called(endpointID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]webhook.DeadLetter), args.Error(1)
+}
+
+func (m *MockWebhookManager) Replay(ctx context.Context, endpointID string) (webhook.ReplayResult, error) {
+	args := m.Called(ctx, endpointID)
+	return args.Get(0).(webhook.ReplayResult), args.Error(1)
+}
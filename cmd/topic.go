@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/kafka"
+	"github.com/alex-rufo/exchange/internal/exchange/nats"
+	"github.com/alex-rufo/exchange/internal/exchange/redis"
+	natsgo "github.com/nats-io/nats.go"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/spf13/pflag"
+)
+
+const (
+	topicChannel = "channel"
+	topicRedis   = "redis"
+	topicNATS    = "nats"
+	topicKafka   = "kafka"
+)
+
+var (
+	topicKind        string
+	topicName        string
+	redisURL         string
+	natsURL          string
+	natsStream       string
+	natsStreamMaxAge time.Duration
+	natsDurable      string
+	kafkaBrokers     []string
+	kafkaRetention   time.Duration
+)
+
+// addTopicFlags adds the flags used by newTopic, the topic being selected with the kind flag.
+func addTopicFlags(flags *pflag.FlagSet, kindFlag string) {
+	flags.StringVarP(&topicKind, kindFlag, "", topicChannel, "Topic carrying the updates from the providers to the broadcasters: channel, redis, nats or kafka (defaults to channel)")
+	flags.StringVarP(&topicName, "topic-name", "", redis.DefaultChannel, "Name of the topic, the Redis channel, the prefix of the NATS subjects or the Kafka topic (defaults to rates)")
+	flags.StringVarP(&redisURL, "redis-url", "", "redis://localhost:6379/0", "URL of the Redis server used by the redis topic (defaults to redis://localhost:6379/0)")
+	flags.StringVarP(&natsURL, "nats-url", "", natsgo.DefaultURL, "URL of the NATS server used by the nats topic (defaults to nats://127.0.0.1:4222)")
+	flags.StringVarP(&natsStream, "nats-stream", "", "", "JetStream stream keeping the updates of the nats topic, empty to use core NATS")
+	flags.DurationVarP(&natsStreamMaxAge, "nats-stream-max-age", "", nats.DefaultStreamMaxAge, "Time the JetStream stream keeps the updates (defaults to 24h)")
+	flags.StringVarP(&natsDurable, "nats-durable", "", "", "Durable JetStream consumer, unique per instance, resuming after the last received update on restart")
+	flags.StringSliceVarP(&kafkaBrokers, "kafka-brokers", "", []string{"localhost:9092"}, "Brokers of the Kafka cluster used by the kafka topic (defaults to localhost:9092)")
+	flags.DurationVarP(&kafkaRetention, "kafka-retention", "", kafka.DefaultRetention, "Time the Kafka topic keeps the updates when it is created (defaults to 168h)")
+}
+
+// newTopic creates the topic selected with the --topic flag. The returned function releases its resources,
+// and must be called once the topic is closed.
+func newTopic(ctx context.Context) (exchange.Topic, func(), error) {
+	switch topicKind {
+	case topicChannel:
+		return exchange.NewChanTopic(), func() {}, nil
+	case topicRedis:
+		options, err := goredis.ParseURL(redisURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --redis-url: %v", err)
+		}
+		client := goredis.NewClient(options)
+		if err := client.Ping(ctx).Err(); err != nil {
+			client.Close()
+			return nil, nil, fmt.Errorf("failed to connect to Redis: %v", err)
+		}
+		closeClient := func() {
+			if err := client.Close(); err != nil {
+				log.Printf("Failed to close the Redis client: %v", err)
+			}
+		}
+		return redis.NewTopic(client, topicName), closeClient, nil
+	case topicNATS:
+		conn, err := natsgo.Connect(natsURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to NATS: %v", err)
+		}
+		topic, err := nats.NewTopic(ctx, conn, nats.Config{
+			SubjectPrefix: topicName,
+			Stream:        natsStream,
+			StreamMaxAge:  natsStreamMaxAge,
+			Durable:       natsDurable,
+		})
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		return topic, conn.Close, nil
+	case topicKafka:
+		topic, err := kafka.NewLog(ctx, kafka.Config{
+			Brokers:   kafkaBrokers,
+			Topic:     topicName,
+			Retention: kafkaRetention,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return topic, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported topic %q, must be one of: %s, %s, %s, %s", topicKind, topicChannel, topicRedis, topicNATS, topicKafka)
+	}
+}
+
+// newBroadcaster creates the broadcaster of the updates of the topic, subscribing to it once for all the subscriptions.
+// The updates of a log carry their offsets, so the WebSocket clients get the tokens to resume from them.
+func newBroadcaster(ctx context.Context, topic exchange.Topic) (*exchange.Broadcaster, error) {
+	if updatesLog, ok := topic.(exchange.Log); ok {
+		records, err := updatesLog.Read(ctx, exchange.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		return exchange.NewLogBroadcaster(records, subscriptionBufferSize, broadcasterShards), nil
+	}
+
+	updates, err := topic.Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return exchange.NewShardedBroadcaster(updates, subscriptionBufferSize, broadcasterShards), nil
+}
@@ -0,0 +1,100 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+type Condition string
+
+const (
+	// ConditionAbove triggers when the rate crosses above the threshold.
+	ConditionAbove Condition = "above"
+	// ConditionBelow triggers when the rate crosses below the threshold.
+	ConditionBelow Condition = "below"
+	// ConditionChange triggers when the rate moves more than the given percent within the window.
+	ConditionChange Condition = "change"
+)
+
+// Duration is a time.Duration that is represented as a string (e.g. "10m") in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+type Alert struct {
+	ID         string    `json:"id"`
+	Pair       string    `json:"pair"`
+	Condition  Condition `json:"condition"`
+	Threshold  float64   `json:"threshold,omitempty"`
+	Percent    float64   `json:"percent,omitempty"`
+	Window     Duration  `json:"window,omitempty"`
+	WebhookURL string    `json:"webhookUrl"`
+	// Secret is used to sign the webhooks. It is only returned when the alert is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Validate checks that the alert has everything needed to be evaluated and delivered.
+func (a Alert) Validate() error {
+	var errs []error
+
+	if a.Pair == "" {
+		errs = append(errs, errors.New("pair is required"))
+	}
+
+	switch a.Condition {
+	case ConditionAbove, ConditionBelow:
+		if a.Threshold <= 0 {
+			errs = append(errs, errors.New("threshold must be greater than zero"))
+		}
+	case ConditionChange:
+		if a.Percent <= 0 {
+			errs = append(errs, errors.New("percent must be greater than zero"))
+		}
+		if a.Window <= 0 {
+			errs = append(errs, errors.New("window must be greater than zero"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported condition %q", a.Condition))
+	}
+
+	if u, err := url.Parse(a.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, errors.New("webhookUrl must be an absolute http(s) URL"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Triggered is the payload delivered to the webhook of an alert.
+type Triggered struct {
+	AlertID     string    `json:"alertId"`
+	Pair        string    `json:"pair"`
+	Condition   Condition `json:"condition"`
+	Threshold   float64   `json:"threshold,omitempty"`
+	Percent     float64   `json:"percent,omitempty"`
+	Window      Duration  `json:"window,omitempty"`
+	Rate        string    `json:"rate"`
+	At          time.Time `json:"at"`
+	Reference   float64   `json:"reference"`
+	TriggeredAt time.Time `json:"triggeredAt"`
+}
@@ -0,0 +1,65 @@
+package alert
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlert_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		alert       Alert
+		expectedErr string
+	}{
+		{
+			name:  "valid threshold alert",
+			alert: Alert{Pair: "USD-BTC", Condition: ConditionAbove, Threshold: 70000, WebhookURL: "https://example.com/hook"},
+		},
+		{
+			name:  "valid change alert",
+			alert: Alert{Pair: "USD-BTC", Condition: ConditionChange, Percent: 3, Window: Duration(10 * time.Minute), WebhookURL: "http://example.com/hook"},
+		},
+		{
+			name:        "missing threshold",
+			alert:       Alert{Pair: "USD-BTC", Condition: ConditionBelow, WebhookURL: "https://example.com/hook"},
+			expectedErr: "threshold must be greater than zero",
+		},
+		{
+			name:        "missing percent and window",
+			alert:       Alert{Pair: "USD-BTC", Condition: ConditionChange, WebhookURL: "https://example.com/hook"},
+			expectedErr: "percent must be greater than zero\nwindow must be greater than zero",
+		},
+		{
+			name:        "missing pair and invalid webhook",
+			alert:       Alert{Condition: ConditionAbove, Threshold: 1, WebhookURL: "/hook"},
+			expectedErr: "pair is required\nwebhookUrl must be an absolute http(s) URL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.alert.Validate()
+			if tt.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestDuration_JSON(t *testing.T) {
+	var alert Alert
+	require.NoError(t, json.Unmarshal([]byte(`{"window":"10m"}`), &alert))
+	assert.Equal(t, Duration(10*time.Minute), alert.Window)
+
+	encoded, err := json.Marshal(alert)
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"window":"10m0s"`)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"window":"ten minutes"}`), &alert))
+}
@@ -0,0 +1,226 @@
+package alert
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/google/uuid"
+)
+
+type Store interface {
+	List() []Alert
+	Get(id string) (Alert, error)
+	Save(alert Alert) error
+	Delete(id string) error
+}
+
+type Notifier interface {
+	Send(ctx context.Context, url string, secret string, payload []byte) error
+}
+
+type sample struct {
+	at    time.Time
+	value float64
+}
+
+// Engine evaluates the registered alerts against every rate update and notifies the
+// webhook of the ones that trigger. Deliveries happen in the background, so a slow
+// or failing webhook does not delay the evaluation of the following updates.
+type Engine struct {
+	store    Store
+	notifier Notifier
+
+	mu         sync.Mutex
+	last       map[string]sample   // last rate received per pair
+	windows    map[string][]sample // rates within the window of every change alert
+	deliveries sync.WaitGroup
+}
+
+func NewEngine(store Store, notifier Notifier) *Engine {
+	return &Engine{
+		store:    store,
+		notifier: notifier,
+		last:     make(map[string]sample),
+		windows:  make(map[string][]sample),
+	}
+}
+
+// Create validates and stores a new alert, generating a signing secret if none was provided.
+func (e *Engine) Create(alert Alert) (Alert, error) {
+	if err := alert.Validate(); err != nil {
+		return Alert{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	alert.ID = uuid.NewString()
+	alert.CreatedAt = time.Now().UTC()
+	if alert.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return Alert{}, err
+		}
+		alert.Secret = hex.EncodeToString(secret)
+	}
+
+	if err := e.store.Save(alert); err != nil {
+		return Alert{}, err
+	}
+	return alert, nil
+}
+
+// List returns all the alerts without their secrets.
+func (e *Engine) List() []Alert {
+	alerts := e.store.List()
+	for i := range alerts {
+		alerts[i].Secret = ""
+	}
+	return alerts
+}
+
+// Get returns the alert without its secret.
+func (e *Engine) Get(id string) (Alert, error) {
+	alert, err := e.store.Get(id)
+	if err != nil {
+		return Alert{}, err
+	}
+
+	alert.Secret = ""
+	return alert, nil
+}
+
+func (e *Engine) Delete(id string) error {
+	if err := e.store.Delete(id); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	delete(e.windows, id)
+	e.mu.Unlock()
+
+	return nil
+}
+
+// EvaluateUpdates evaluates all the updates received until the channel is closed, and
+// then waits for the pending deliveries to finish.
+func (e *Engine) EvaluateUpdates(ctx context.Context, updates <-chan exchange.RateUpdated) {
+	defer e.Wait()
+
+	for {
+		select {
+		case rate, ok := <-updates:
+			if !ok {
+				// updates channel was closed, we won't receive any more updates
+				return
+			}
+
+			if err := e.Evaluate(ctx, rate); err != nil {
+				log.Println("Failed to evaluate alerts for the rate", err, rate)
+			}
+		}
+	}
+}
+
+// Evaluate checks the alerts of the rate pair and delivers the ones that trigger.
+// Rates that are not newer than the last one of the pair are ignored.
+func (e *Engine) Evaluate(ctx context.Context, rate exchange.RateUpdated) error {
+	value, err := rate.Value()
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	previous, hasPrevious := e.last[rate.Pair()]
+	if hasPrevious && !rate.At.After(previous.at) {
+		e.mu.Unlock()
+		return nil
+	}
+
+	current := sample{at: rate.At, value: value}
+	e.last[rate.Pair()] = current
+
+	for _, alert := range e.store.List() {
+		if alert.Pair != rate.Pair() {
+			continue
+		}
+
+		reference, ok := e.check(alert, previous, hasPrevious, current)
+		if !ok {
+			continue
+		}
+
+		e.deliver(ctx, alert, Triggered{
+			AlertID:     alert.ID,
+			Pair:        alert.Pair,
+			Condition:   alert.Condition,
+			Threshold:   alert.Threshold,
+			Percent:     alert.Percent,
+			Window:      alert.Window,
+			Rate:        rate.Rate,
+			At:          rate.At,
+			Reference:   reference,
+			TriggeredAt: time.Now().UTC(),
+		})
+	}
+	e.mu.Unlock()
+
+	return nil
+}
+
+// Wait blocks until all the pending deliveries have finished.
+func (e *Engine) Wait() {
+	e.deliveries.Wait()
+}
+
+// check returns whether the alert triggers with the current rate, together with the
+// reference value it was compared against.
+func (e *Engine) check(alert Alert, previous sample, hasPrevious bool, current sample) (float64, bool) {
+	switch alert.Condition {
+	case ConditionAbove:
+		// Crossing requires knowing where the rate was before.
+		return alert.Threshold, hasPrevious && previous.value <= alert.Threshold && current.value > alert.Threshold
+	case ConditionBelow:
+		return alert.Threshold, hasPrevious && previous.value >= alert.Threshold && current.value < alert.Threshold
+	case ConditionChange:
+		window := append(e.windows[alert.ID], current)
+		start := current.at.Add(-time.Duration(alert.Window))
+		for window[0].at.Before(start) {
+			window = window[1:]
+		}
+
+		reference := window[0].value
+		if reference != 0 && math.Abs(current.value-reference)/reference*100 >= alert.Percent {
+			// Start over, so the same move does not trigger the alert again.
+			e.windows[alert.ID] = []sample{current}
+			return reference, true
+		}
+
+		e.windows[alert.ID] = window
+		return reference, false
+	default:
+		return 0, false
+	}
+}
+
+func (e *Engine) deliver(ctx context.Context, alert Alert, triggered Triggered) {
+	payload, err := json.Marshal(triggered)
+	if err != nil {
+		log.Printf("Failed to encode the triggered alert %s: %v", alert.ID, err)
+		return
+	}
+
+	e.deliveries.Add(1)
+	go func() {
+		defer e.deliveries.Done()
+
+		if err := e.notifier.Send(ctx, alert.WebhookURL, alert.Secret, payload); err != nil {
+			log.Printf("Failed to deliver the triggered alert %s: %v", alert.ID, err)
+		}
+	}()
+}
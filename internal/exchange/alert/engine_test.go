@@ -0,0 +1,239 @@
+package alert
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/pkg/webhook/webhooktest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// received returns the triggered alerts sorted by rate time, as deliveries run concurrently.
+func received(t *testing.T, r *webhooktest.Receiver) []Triggered {
+	triggered := webhooktest.Received[Triggered](t, r)
+	sort.Slice(triggered, func(i, j int) bool { return triggered[i].At.Before(triggered[j].At) })
+	return triggered
+}
+
+func newTestEngine(t *testing.T, store Store) *Engine {
+	return NewEngine(store, webhooktest.NewClient(3))
+}
+
+func createAlert(t *testing.T, engine *Engine, r *webhooktest.Receiver, alert Alert) Alert {
+	alert.WebhookURL = r.URL
+	created, err := engine.Create(alert)
+	require.NoError(t, err)
+	r.AddSecret(created.Secret)
+	return created
+}
+
+func evaluate(t *testing.T, engine *Engine, start time.Time, values ...string) {
+	for i, value := range values {
+		rate := exchange.RateUpdated{From: "USD", To: "BTC", At: start.Add(time.Duration(i) * time.Minute), Rate: value}
+		require.NoError(t, engine.Evaluate(context.Background(), rate))
+	}
+	engine.Wait()
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+
+	tests := []struct {
+		name              string
+		alert             Alert
+		values            []string
+		expectedRates     []string
+		expectedReference float64
+	}{
+		{
+			name:              "crosses above",
+			alert:             Alert{Pair: "USD-BTC", Condition: ConditionAbove, Threshold: 70000},
+			values:            []string{"69,000.00", "70,500.00", "71,000.00", "69,500.00", "70,000.00", "70,001.00"},
+			expectedRates:     []string{"70,500.00", "70,001.00"},
+			expectedReference: 70000,
+		},
+		{
+			name:              "first rate never crosses",
+			alert:             Alert{Pair: "USD-BTC", Condition: ConditionAbove, Threshold: 70000},
+			values:            []string{"71,000.00", "72,000.00"},
+			expectedRates:     nil,
+			expectedReference: 70000,
+		},
+		{
+			name:              "crosses below",
+			alert:             Alert{Pair: "USD-BTC", Condition: ConditionBelow, Threshold: 70000},
+			values:            []string{"71,000.00", "69,000.00", "68,000.00", "70,000.00", "69,999.99"},
+			expectedRates:     []string{"69,000.00", "69,999.99"},
+			expectedReference: 70000,
+		},
+		{
+			name:              "moves more than the percent within the window",
+			alert:             Alert{Pair: "USD-BTC", Condition: ConditionChange, Percent: 3, Window: Duration(10 * time.Minute)},
+			values:            []string{"100", "101", "102", "103", "103.5"},
+			expectedRates:     []string{"103"},
+			expectedReference: 100,
+		},
+		{
+			name:              "moves down more than the percent within the window",
+			alert:             Alert{Pair: "USD-BTC", Condition: ConditionChange, Percent: 3, Window: Duration(10 * time.Minute)},
+			values:            []string{"100", "96"},
+			expectedRates:     []string{"96"},
+			expectedReference: 100,
+		},
+		{
+			name:  "moves slower than the window",
+			alert: Alert{Pair: "USD-BTC", Condition: ConditionChange, Percent: 3, Window: Duration(2 * time.Minute)},
+			// Every minute the rate moves 1%, so within 2 minutes it never moves 3%.
+			values:            []string{"100", "101", "102", "103", "104"},
+			expectedRates:     nil,
+			expectedReference: 100,
+		},
+		{
+			name:              "alerts of other pairs are ignored",
+			alert:             Alert{Pair: "EUR-BTC", Condition: ConditionAbove, Threshold: 70000},
+			values:            []string{"69,000.00", "71,000.00"},
+			expectedRates:     nil,
+			expectedReference: 70000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := webhooktest.NewReceiver(t)
+			store, err := NewFileStore("")
+			require.NoError(t, err)
+			engine := newTestEngine(t, store)
+
+			alert := createAlert(t, engine, r, tt.alert)
+			evaluate(t, engine, start, tt.values...)
+
+			triggered := received(t, r)
+			require.Len(t, triggered, len(tt.expectedRates))
+			for i, rate := range tt.expectedRates {
+				assert.Equal(t, alert.ID, triggered[i].AlertID)
+				assert.Equal(t, alert.Pair, triggered[i].Pair)
+				assert.Equal(t, alert.Condition, triggered[i].Condition)
+				assert.Equal(t, rate, triggered[i].Rate)
+				assert.Equal(t, tt.expectedReference, triggered[i].Reference)
+			}
+		})
+	}
+}
+
+func TestEngine_Evaluate_RetriesDelivery(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	r.FailNext(2)
+
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	engine := newTestEngine(t, store)
+
+	createAlert(t, engine, r, Alert{Pair: "USD-BTC", Condition: ConditionAbove, Threshold: 70000})
+	evaluate(t, engine, time.Unix(1000, 0), "69,000.00", "71,000.00")
+
+	assert.Len(t, received(t, r), 1)
+}
+
+func TestEngine_Evaluate_IgnoresStaleRates(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	engine := newTestEngine(t, store)
+
+	createAlert(t, engine, r, Alert{Pair: "USD-BTC", Condition: ConditionAbove, Threshold: 70000})
+
+	at := time.Unix(1000, 0)
+	require.NoError(t, engine.Evaluate(context.Background(), exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: "69000"}))
+	require.NoError(t, engine.Evaluate(context.Background(), exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: "71000"}))
+	engine.Wait()
+
+	assert.Empty(t, received(t, r))
+}
+
+func TestEngine_EvaluateUpdates(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	engine := newTestEngine(t, store)
+
+	createAlert(t, engine, r, Alert{Pair: "USD-BTC", Condition: ConditionBelow, Threshold: 70000})
+
+	updates := make(chan exchange.RateUpdated, 2)
+	updates <- exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 0), Rate: "71000"}
+	updates <- exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1001, 0), Rate: "69000"}
+	close(updates)
+
+	// It returns once the channel is closed and all the deliveries finished.
+	engine.EvaluateUpdates(context.Background(), updates)
+
+	assert.Len(t, received(t, r), 1)
+}
+
+func TestEngine_SurvivesRestarts(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	path := filepath.Join(t.TempDir(), "alerts.json")
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	created := createAlert(t, newTestEngine(t, store), r, Alert{Pair: "USD-BTC", Condition: ConditionAbove, Threshold: 70000})
+
+	// A new engine loading the same file evaluates the alert.
+	store, err = NewFileStore(path)
+	require.NoError(t, err)
+	engine := newTestEngine(t, store)
+
+	alerts := engine.List()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, created.ID, alerts[0].ID)
+	assert.Empty(t, alerts[0].Secret)
+
+	evaluate(t, engine, time.Unix(1000, 0), "69,000.00", "71,000.00")
+	assert.Len(t, received(t, r), 1)
+}
+
+func TestEngine_Create(t *testing.T) {
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	engine := newTestEngine(t, store)
+
+	created, err := engine.Create(Alert{Pair: "USD-BTC", Condition: ConditionAbove, Threshold: 70000, WebhookURL: "http://localhost/hook"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.ID)
+	assert.NotEmpty(t, created.Secret)
+	assert.False(t, created.CreatedAt.IsZero())
+
+	// The secret is only returned on creation.
+	fetched, err := engine.Get(created.ID)
+	require.NoError(t, err)
+	assert.Empty(t, fetched.Secret)
+
+	stored, err := store.Get(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, created.Secret, stored.Secret)
+
+	_, err = engine.Create(Alert{Pair: "USD-BTC", Condition: "sideways", WebhookURL: "http://localhost/hook"})
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.EqualError(t, err, `invalid alert: unsupported condition "sideways"`)
+}
+
+func TestEngine_Delete(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	engine := newTestEngine(t, store)
+
+	alert := createAlert(t, engine, r, Alert{Pair: "USD-BTC", Condition: ConditionAbove, Threshold: 70000})
+	require.NoError(t, engine.Delete(alert.ID))
+	assert.ErrorIs(t, engine.Delete(alert.ID), ErrNotFound)
+
+	_, err = engine.Get(alert.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	evaluate(t, engine, time.Unix(1000, 0), "69,000.00", "71,000.00")
+	assert.Empty(t, received(t, r))
+}
@@ -0,0 +1,123 @@
+package alert
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/alex-rufo/exchange/pkg/jsonfile"
+)
+
+var (
+	ErrNotFound = errors.New("alert not found")
+	ErrInvalid  = errors.New("invalid alert")
+)
+
+// FileStore keeps the alerts in memory and writes all of them into a JSON file on every
+// change, so they survive restarts. Alerts are few and rarely change, which makes
+// rewriting the whole file acceptable. An empty path keeps the alerts in memory only.
+type FileStore struct {
+	path string
+
+	mu     sync.RWMutex
+	alerts map[string]Alert
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:   path,
+		alerts: make(map[string]Alert),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	var alerts []Alert
+	if err := jsonfile.Read(path, &alerts); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, alert := range alerts {
+		s.alerts[alert.ID] = alert
+	}
+
+	return s, nil
+}
+
+// List returns all the alerts sorted by creation time.
+func (s *FileStore) List() []Alert {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.list()
+}
+
+func (s *FileStore) Get(id string) (Alert, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	alert, ok := s.alerts[id]
+	if !ok {
+		return Alert{}, ErrNotFound
+	}
+	return alert, nil
+}
+
+func (s *FileStore) Save(alert Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.alerts[alert.ID]
+	s.alerts[alert.ID] = alert
+	if err := s.flush(); err != nil {
+		// Keep memory and disk consistent.
+		if existed {
+			s.alerts[alert.ID] = previous
+		} else {
+			delete(s.alerts, alert.ID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alert, ok := s.alerts[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(s.alerts, id)
+	if err := s.flush(); err != nil {
+		s.alerts[id] = alert
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStore) list() []Alert {
+	alerts := make([]Alert, 0, len(s.alerts))
+	for _, alert := range s.alerts {
+		alerts = append(alerts, alert)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].CreatedAt.Equal(alerts[j].CreatedAt) {
+			return alerts[i].ID < alerts[j].ID
+		}
+		return alerts[i].CreatedAt.Before(alerts[j].CreatedAt)
+	})
+	return alerts
+}
+
+func (s *FileStore) flush() error {
+	if s.path == "" {
+		return nil
+	}
+	return jsonfile.Write(s.path, s.list())
+}
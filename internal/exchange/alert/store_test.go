@@ -0,0 +1,58 @@
+package alert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	assert.Empty(t, store.List())
+
+	first := Alert{ID: "b", Pair: "USD-BTC", Condition: ConditionAbove, Threshold: 1, CreatedAt: time.Unix(1000, 0).UTC()}
+	second := Alert{ID: "a", Pair: "EUR-BTC", Condition: ConditionBelow, Threshold: 1, CreatedAt: time.Unix(2000, 0).UTC()}
+	require.NoError(t, store.Save(second))
+	require.NoError(t, store.Save(first))
+
+	// Alerts are listed by creation time.
+	assert.Equal(t, []Alert{first, second}, store.List())
+
+	require.NoError(t, store.Delete(first.ID))
+	assert.ErrorIs(t, store.Delete(first.ID), ErrNotFound)
+
+	_, err = store.Get(first.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A new store loads what was persisted.
+	reloaded, err := NewFileStore(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Alert{second}, reloaded.List())
+}
+
+func TestFileStore_WriteError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "alerts")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+
+	store, err := NewFileStore(filepath.Join(dir, "alerts.json"))
+	require.NoError(t, err)
+
+	// Memory is left untouched when the alerts can not be persisted.
+	require.NoError(t, os.RemoveAll(dir))
+	assert.Error(t, store.Save(Alert{ID: "a"}))
+	assert.Empty(t, store.List())
+}
+
+func TestNewFileStore_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o644))
+
+	_, err := NewFileStore(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,174 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+)
+
+type Config struct {
+	// TWAPWindows are the rolling windows the time-weighted average price is computed for.
+	TWAPWindows []time.Duration
+	// SMAPeriod is the number of updates used by the simple moving average.
+	SMAPeriod int
+	// EMAPeriod is the number of updates used to derive the exponential moving average smoothing factor.
+	EMAPeriod int
+	// VolatilityPeriod is the number of log returns used to compute the realized volatility.
+	VolatilityPeriod int
+}
+
+// Indicators is a snapshot of the analytics computed for a pair. Indicators that
+// require a full period of data are omitted until enough updates were received.
+type Indicators struct {
+	Pair       string             `json:"pair"`
+	At         time.Time          `json:"at"`
+	Rate       float64            `json:"rate"`
+	TWAP       map[string]float64 `json:"twap"`
+	SMA        *float64           `json:"sma,omitempty"`
+	EMA        float64            `json:"ema"`
+	Volatility *float64           `json:"volatility,omitempty"`
+}
+
+type series struct {
+	last       point
+	twaps      []*twap
+	sma        *window
+	ema        *ema
+	volatility *window
+}
+
+// Analyzer maintains rolling indicators (TWAP, SMA, EMA and realized volatility) per pair.
+// Every indicator is updated incrementally, so observing a rate is O(1) amortized
+// regardless of the size of the windows.
+type Analyzer struct {
+	config Config
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+func NewAnalyzer(config Config) *Analyzer {
+	return &Analyzer{
+		config: config,
+		series: make(map[string]*series),
+	}
+}
+
+// AnalyzeUpdates feeds the analyzer with all the updates received until the channel is closed.
+func (a *Analyzer) AnalyzeUpdates(ctx context.Context, updates <-chan exchange.RateUpdated) {
+	for {
+		select {
+		case rate, ok := <-updates:
+			if !ok {
+				// updates channel was closed, we won't receive any more updates
+				return
+			}
+
+			if err := a.Observe(rate); err != nil {
+				log.Println("Failed to analyze the rate", err, rate)
+			}
+		}
+	}
+}
+
+// Observe updates the indicators of the rate pair. Rates that are not newer than the
+// last one observed for the same pair are ignored, as providers might send the same
+// rate more than once.
+func (a *Analyzer) Observe(rate exchange.RateUpdated) error {
+	value, err := rate.Value()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.series[rate.Pair()]
+	if !ok {
+		s = a.newSeries()
+		a.series[rate.Pair()] = s
+	} else if !rate.At.After(s.last.at) {
+		return nil
+	}
+
+	if s.last.value > 0 && value > 0 {
+		s.volatility.add(math.Log(value / s.last.value))
+	}
+
+	p := point{at: rate.At, value: value}
+	for _, t := range s.twaps {
+		t.add(p)
+	}
+	s.sma.add(value)
+	s.ema.add(value)
+	s.last = p
+
+	return nil
+}
+
+// Indicators returns the latest indicators of the given pair.
+func (a *Analyzer) Indicators(pair string) (Indicators, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	s, ok := a.series[pair]
+	if !ok {
+		return Indicators{}, false
+	}
+	return s.indicators(pair), true
+}
+
+// AllIndicators returns the latest indicators of every pair observed, sorted by pair.
+func (a *Analyzer) AllIndicators() []Indicators {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := make([]Indicators, 0, len(a.series))
+	for pair, s := range a.series {
+		result = append(result, s.indicators(pair))
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Pair < result[j].Pair })
+	return result
+}
+
+func (a *Analyzer) newSeries() *series {
+	s := &series{
+		sma:        newWindow(max(a.config.SMAPeriod, 1)),
+		ema:        newEMA(max(a.config.EMAPeriod, 1)),
+		volatility: newWindow(max(a.config.VolatilityPeriod, 2)),
+	}
+	for _, w := range a.config.TWAPWindows {
+		s.twaps = append(s.twaps, newTWAP(w))
+	}
+	return s
+}
+
+func (s *series) indicators(pair string) Indicators {
+	result := Indicators{
+		Pair: pair,
+		At:   s.last.at,
+		Rate: s.last.value,
+		TWAP: make(map[string]float64, len(s.twaps)),
+		EMA:  s.ema.value,
+	}
+
+	for _, t := range s.twaps {
+		result.TWAP[t.window.String()] = t.value()
+	}
+	if s.sma.full() {
+		sma := s.sma.mean()
+		result.SMA = &sma
+	}
+	if s.volatility.full() {
+		volatility := s.volatility.stddev()
+		result.Volatility = &volatility
+	}
+
+	return result
+}
@@ -0,0 +1,145 @@
+package analytics
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rates(from, to string, start time.Time, step time.Duration, values ...float64) []exchange.RateUpdated {
+	result := make([]exchange.RateUpdated, 0, len(values))
+	for i, value := range values {
+		result = append(result, exchange.RateUpdated{
+			From: from,
+			To:   to,
+			At:   start.Add(time.Duration(i) * step),
+			Rate: strconv.FormatFloat(value, 'f', -1, 64),
+		})
+	}
+	return result
+}
+
+func TestAnalyzer_Observe(t *testing.T) {
+	analyzer := NewAnalyzer(Config{
+		TWAPWindows:      []time.Duration{10 * time.Second, time.Minute},
+		SMAPeriod:        3,
+		EMAPeriod:        3,
+		VolatilityPeriod: 2,
+	})
+
+	start := time.Unix(1000, 0)
+	for _, rate := range rates("USD", "BTC", start, 5*time.Second, 100, 200, 400) {
+		require.NoError(t, analyzer.Observe(rate))
+	}
+
+	indicators, ok := analyzer.Indicators("USD-BTC")
+	require.True(t, ok)
+	assert.Equal(t, "USD-BTC", indicators.Pair)
+	assert.True(t, start.Add(10*time.Second).Equal(indicators.At))
+	assert.Equal(t, 400.0, indicators.Rate)
+
+	// [0,5) at 100 and [5,10) at 200 for both windows, as there is no older data.
+	assert.InDelta(t, 150.0, indicators.TWAP["10s"], 1e-9)
+	assert.InDelta(t, 150.0, indicators.TWAP["1m0s"], 1e-9)
+
+	require.NotNil(t, indicators.SMA)
+	assert.InDelta(t, 700.0/3, *indicators.SMA, 1e-9)
+
+	// alpha = 0.5: 100 -> 150 -> 275
+	assert.InDelta(t, 275.0, indicators.EMA, 1e-9)
+
+	// Both log returns are ln(2), so there is no dispersion at all.
+	require.NotNil(t, indicators.Volatility)
+	assert.InDelta(t, 0.0, *indicators.Volatility, 1e-9)
+}
+
+func TestAnalyzer_Observe_Warmup(t *testing.T) {
+	analyzer := NewAnalyzer(Config{SMAPeriod: 3, EMAPeriod: 3, VolatilityPeriod: 2})
+
+	for _, rate := range rates("USD", "BTC", time.Unix(1000, 0), time.Second, 100, 110) {
+		require.NoError(t, analyzer.Observe(rate))
+	}
+
+	indicators, ok := analyzer.Indicators("USD-BTC")
+	require.True(t, ok)
+	assert.Nil(t, indicators.SMA)
+	assert.Nil(t, indicators.Volatility)
+	assert.Empty(t, indicators.TWAP)
+}
+
+func TestAnalyzer_Observe_Volatility(t *testing.T) {
+	analyzer := NewAnalyzer(Config{SMAPeriod: 1, EMAPeriod: 1, VolatilityPeriod: 2})
+
+	for _, rate := range rates("USD", "BTC", time.Unix(1000, 0), time.Second, 100, 200, 100) {
+		require.NoError(t, analyzer.Observe(rate))
+	}
+
+	// Log returns are ln(2) and -ln(2), the sample standard deviation is ln(2)*sqrt(2).
+	indicators, ok := analyzer.Indicators("USD-BTC")
+	require.True(t, ok)
+	require.NotNil(t, indicators.Volatility)
+	assert.InDelta(t, math.Ln2*math.Sqrt2, *indicators.Volatility, 1e-9)
+}
+
+func TestAnalyzer_Observe_IgnoresStaleRates(t *testing.T) {
+	analyzer := NewAnalyzer(Config{SMAPeriod: 1, EMAPeriod: 1, VolatilityPeriod: 2})
+	at := time.Unix(1000, 0)
+
+	require.NoError(t, analyzer.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: "100"}))
+	require.NoError(t, analyzer.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: "200"}))
+	require.NoError(t, analyzer.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: at.Add(-time.Second), Rate: "300"}))
+
+	indicators, ok := analyzer.Indicators("USD-BTC")
+	require.True(t, ok)
+	assert.Equal(t, 100.0, indicators.Rate)
+}
+
+func TestAnalyzer_Observe_InvalidRate(t *testing.T) {
+	analyzer := NewAnalyzer(Config{SMAPeriod: 1, EMAPeriod: 1, VolatilityPeriod: 2})
+
+	err := analyzer.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 0), Rate: "invalid"})
+	assert.Error(t, err)
+
+	_, ok := analyzer.Indicators("USD-BTC")
+	assert.False(t, ok)
+}
+
+func TestAnalyzer_AllIndicators(t *testing.T) {
+	analyzer := NewAnalyzer(Config{SMAPeriod: 1, EMAPeriod: 1, VolatilityPeriod: 2})
+	at := time.Unix(1000, 0)
+
+	require.NoError(t, analyzer.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: "50,000.00"}))
+	require.NoError(t, analyzer.Observe(exchange.RateUpdated{From: "EUR", To: "BTC", At: at, Rate: "45,000.00"}))
+
+	indicators := analyzer.AllIndicators()
+	require.Len(t, indicators, 2)
+	assert.Equal(t, "EUR-BTC", indicators[0].Pair)
+	assert.Equal(t, 45000.0, indicators[0].Rate)
+	assert.Equal(t, "USD-BTC", indicators[1].Pair)
+	assert.Equal(t, 50000.0, indicators[1].Rate)
+}
+
+func TestAnalyzer_AnalyzeUpdates(t *testing.T) {
+	analyzer := NewAnalyzer(Config{SMAPeriod: 2, EMAPeriod: 2, VolatilityPeriod: 2})
+
+	updates := make(chan exchange.RateUpdated, 3)
+	for _, rate := range rates("USD", "BTC", time.Unix(1000, 0), time.Second, 100, 200, 300) {
+		updates <- rate
+	}
+	close(updates)
+
+	// It returns once the updates channel is closed.
+	analyzer.AnalyzeUpdates(context.Background(), updates)
+
+	indicators, ok := analyzer.Indicators("USD-BTC")
+	require.True(t, ok)
+	assert.Equal(t, 300.0, indicators.Rate)
+	require.NotNil(t, indicators.SMA)
+	assert.InDelta(t, 250.0, *indicators.SMA, 1e-9)
+}
@@ -0,0 +1,127 @@
+package analytics
+
+import (
+	"math"
+	"time"
+)
+
+type point struct {
+	at    time.Time
+	value float64
+}
+
+// twap keeps the time-weighted average price over a rolling window. Every rate is
+// considered valid until the next one arrives, so the average is the integral of
+// that step function over the window divided by the covered time span.
+type twap struct {
+	window time.Duration
+	points []point
+	area   float64 // integral of the rate between points[0] and the last point
+}
+
+func newTWAP(window time.Duration) *twap {
+	return &twap{window: window}
+}
+
+func (t *twap) add(p point) {
+	if len(t.points) > 0 {
+		last := t.points[len(t.points)-1]
+		t.area += last.value * p.at.Sub(last.at).Seconds()
+	}
+	t.points = append(t.points, p)
+
+	// The first point is kept as long as it is still the active rate at the
+	// beginning of the window, as it contributes to the first slice of it.
+	start := p.at.Add(-t.window)
+	for len(t.points) > 1 && !t.points[1].at.After(start) {
+		t.area -= t.points[0].value * t.points[1].at.Sub(t.points[0].at).Seconds()
+		t.points = t.points[1:]
+	}
+}
+
+func (t *twap) value() float64 {
+	first, last := t.points[0], t.points[len(t.points)-1]
+
+	area := t.area
+	span := last.at.Sub(first.at)
+	if start := last.at.Add(-t.window); first.at.Before(start) {
+		area -= first.value * start.Sub(first.at).Seconds()
+		span = t.window
+	}
+
+	if span <= 0 {
+		return last.value
+	}
+	return area / span.Seconds()
+}
+
+// window is a fixed size ring of the latest values. Mean and variance are kept
+// up to date using Welford's algorithm adapted to sliding windows, which is
+// O(1) per update and numerically stable compared to running sums of squares.
+type window struct {
+	values []float64
+	next   int
+	count  int
+	avg    float64
+	m2     float64 // sum of squared differences from the mean
+}
+
+func newWindow(size int) *window {
+	return &window{values: make([]float64, size)}
+}
+
+func (w *window) add(value float64) {
+	if w.count < len(w.values) {
+		w.count++
+		delta := value - w.avg
+		w.avg += delta / float64(w.count)
+		w.m2 += delta * (value - w.avg)
+	} else {
+		evicted := w.values[w.next]
+		avg := w.avg + (value-evicted)/float64(w.count)
+		w.m2 += (value - evicted) * (value - avg + evicted - w.avg)
+		w.avg = avg
+	}
+
+	w.values[w.next] = value
+	w.next = (w.next + 1) % len(w.values)
+}
+
+func (w *window) full() bool {
+	return w.count == len(w.values)
+}
+
+func (w *window) mean() float64 {
+	return w.avg
+}
+
+// stddev returns the sample standard deviation of the values in the window.
+func (w *window) stddev() float64 {
+	if w.count < 2 || w.m2 <= 0 {
+		// m2 can drop slightly below zero due to rounding errors when values are almost identical.
+		return 0
+	}
+	return math.Sqrt(w.m2 / float64(w.count-1))
+}
+
+// ema is an exponential moving average using the usual 2/(N+1) smoothing factor.
+// It is seeded with the first value it receives.
+type ema struct {
+	alpha  float64
+	value  float64
+	seeded bool
+}
+
+func newEMA(period int) *ema {
+	return &ema{alpha: 2 / float64(period+1)}
+}
+
+func (e *ema) add(value float64) {
+	if !e.seeded {
+		e.value = value
+		e.seeded = true
+		return
+	}
+
+	e.value = e.alpha*value + (1-e.alpha)*e.value
+}
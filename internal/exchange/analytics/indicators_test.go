@@ -0,0 +1,68 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTWAP(t *testing.T) {
+	start := time.Unix(1000, 0)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	twap := newTWAP(10 * time.Second)
+
+	// A single point has no time span, its value is the TWAP.
+	twap.add(point{at: at(0), value: 100})
+	assert.Equal(t, 100.0, twap.value())
+
+	// [0,4) at 100 and [4,10) at 200
+	twap.add(point{at: at(4), value: 200})
+	twap.add(point{at: at(10), value: 300})
+	assert.InDelta(t, 160.0, twap.value(), 1e-9)
+
+	// Window is [4,14): [4,10) at 200 and [10,14) at 300
+	twap.add(point{at: at(14), value: 400})
+	assert.InDelta(t, 240.0, twap.value(), 1e-9)
+	assert.Len(t, twap.points, 3)
+
+	// Window is [7,17): [7,10) at 200, [10,14) at 300 and [14,17) at 400
+	twap.add(point{at: at(17), value: 400})
+	assert.InDelta(t, 300.0, twap.value(), 1e-9)
+}
+
+func TestWindow(t *testing.T) {
+	w := newWindow(3)
+
+	w.add(1)
+	w.add(2)
+	assert.False(t, w.full())
+	assert.InDelta(t, 1.5, w.mean(), 1e-9)
+
+	w.add(3)
+	w.add(4)
+	assert.True(t, w.full())
+	assert.InDelta(t, 3.0, w.mean(), 1e-9)
+	assert.InDelta(t, 1.0, w.stddev(), 1e-9)
+
+	// Identical values must not produce a NaN due to rounding errors.
+	w.add(0.1)
+	w.add(0.1)
+	w.add(0.1)
+	assert.InDelta(t, 0.1, w.mean(), 1e-12)
+	assert.InDelta(t, 0.0, w.stddev(), 1e-12)
+}
+
+func TestEMA(t *testing.T) {
+	e := newEMA(3) // alpha = 0.5
+
+	e.add(10)
+	assert.Equal(t, 10.0, e.value)
+
+	e.add(20)
+	assert.InDelta(t, 15.0, e.value, 1e-9)
+
+	e.add(5)
+	assert.InDelta(t, 10.0, e.value, 1e-9)
+}
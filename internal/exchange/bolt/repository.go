@@ -0,0 +1,319 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	bbolt "go.etcd.io/bbolt"
+)
+
+var (
+	minTime = time.Unix(0, math.MinInt64)
+
+	// ratesBucket holds the rates keyed by (pair, at, seq), so the rates of a pair are stored together sorted by At.
+	ratesBucket = []byte("rates")
+	// timeBucket indexes the keys of ratesBucket by (at, seq), to list the rates of all the pairs sorted by At.
+	timeBucket = []byte("rates_by_time")
+)
+
+// Repository stores the rates in an embedded bbolt key-value database, which keeps its keys sorted,
+// so ranges of rates of a pair are efficiently scanned. Rates older than the TTL are never returned
+// and are removed by EvictExpired.
+type Repository struct {
+	db  *bbolt.DB
+	ttl time.Duration
+	now func() time.Time
+}
+
+// Open opens (creating it if needed) the database of the file.
+func Open(path string, ttl time.Duration) (*Repository, error) {
+	db, err := bbolt.Open(path, 0o644, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bbolt database: %v", err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(ratesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(timeBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %v", err)
+	}
+
+	return &Repository{
+		db:  db,
+		ttl: ttl,
+		now: time.Now,
+	}, nil
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+func (r *Repository) Insert(ctx context.Context, rate exchange.RateUpdated) error {
+	return r.InsertBatch(ctx, []exchange.RateUpdated{rate})
+}
+
+// InsertBatch inserts all the rates in a single transaction.
+func (r *Repository) InsertBatch(_ context.Context, batch []exchange.RateUpdated) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		rates := tx.Bucket(ratesBucket)
+		for _, rate := range batch {
+			value, err := json.Marshal(rate)
+			if err != nil {
+				return err
+			}
+
+			// the sequence keeps the insertion order of the rates with the same At
+			seq, err := rates.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			key := rateKey(rate.Pair(), rate.At, seq)
+			if err := rates.Put(key, value); err != nil {
+				return err
+			}
+			if err := tx.Bucket(timeBucket).Put(timeKey(rate.At, seq), key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert rates: %v", err)
+	}
+
+	return nil
+}
+
+// ListSince returns all the rates with At newer than the passed since time, sorted by At.
+func (r *Repository) ListSince(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error) {
+	return r.List(ctx, exchange.Query{Since: since})
+}
+
+// List returns the rates matching the query, sorted by At in the order of the query. The rates of a pair are scanned
+// directly from their range of keys, the other ones through the time index.
+func (r *Repository) List(_ context.Context, query exchange.Query) ([]exchange.RateUpdated, error) {
+	if expiration := r.now().Add(-r.ttl); query.Since.Before(expiration) {
+		query.Since = expiration
+	}
+
+	// the time keys of the matching rates are in [since, until)
+	since := timeKey(after(query.Since), 0)
+	var until []byte
+	if !query.Until.IsZero() {
+		until = timeKey(after(query.Until), 0)
+	}
+
+	var result []exchange.RateUpdated
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		rates := tx.Bucket(ratesBucket)
+
+		// next returns the key of the time index of the next rate and its value
+		var next func() ([]byte, []byte)
+		if query.Pair != "" {
+			prefix := pairPrefix(query.Pair)
+			step := scan(rates.Cursor(), prefix, since, until, query.Descending)
+			next = func() ([]byte, []byte) {
+				k, v := step()
+				if k == nil {
+					return nil, nil
+				}
+				return k[len(prefix):], v
+			}
+		} else {
+			step := scan(tx.Bucket(timeBucket).Cursor(), nil, since, until, query.Descending)
+			next = func() ([]byte, []byte) {
+				k, v := step()
+				if k == nil {
+					return nil, nil
+				}
+				return k, rates.Get(v)
+			}
+		}
+
+		skipped := 0
+		for key, value := next(); key != nil; key, value = next() {
+			if skipped < query.Offset {
+				skipped++
+				continue
+			}
+
+			rate, err := decode(value)
+			if err != nil {
+				return err
+			}
+			result = append(result, rate)
+			if query.Limit > 0 && len(result) == query.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rates: %v", err)
+	}
+
+	return result, nil
+}
+
+// RateAt returns the last rate of the pair at or before the time, as long as it is not older than the lookback.
+// A zero lookback only limits it by the TTL. False is returned when there is no such rate.
+func (r *Repository) RateAt(_ context.Context, pair string, at time.Time, lookback time.Duration) (exchange.RateUpdated, bool, error) {
+	var (
+		rate  exchange.RateUpdated
+		found bool
+	)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(ratesBucket).Cursor()
+
+		// the rate right before the first key after the time
+		k, v := cursor.Seek(rateKey(pair, after(at), 0))
+		if k == nil {
+			k, v = cursor.Last()
+		} else {
+			k, v = cursor.Prev()
+		}
+		if k == nil || !bytes.HasPrefix(k, pairPrefix(pair)) {
+			return nil
+		}
+
+		var err error
+		if rate, err = decode(v); err != nil {
+			return err
+		}
+		found = rate.At.After(r.now().Add(-r.ttl)) && exchange.IsWithinLookback(rate, at, lookback)
+		return nil
+	})
+	if err != nil {
+		return exchange.RateUpdated{}, false, fmt.Errorf("failed to get rate: %v", err)
+	}
+	if !found {
+		return exchange.RateUpdated{}, false, nil
+	}
+
+	return rate, true, nil
+}
+
+// EvictExpired removes the rates older than the TTL.
+func (r *Repository) EvictExpired(_ context.Context) (int, error) {
+	expiration := timeKey(after(r.now().Add(-r.ttl)), 0)
+
+	var evicted int
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		rates := tx.Bucket(ratesBucket)
+		cursor := tx.Bucket(timeBucket).Cursor()
+		for k, v := cursor.First(); k != nil && bytes.Compare(k, expiration) < 0; k, v = cursor.First() {
+			if err := rates.Delete(v); err != nil {
+				return err
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			evicted++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict expired rates: %v", err)
+	}
+
+	return evicted, nil
+}
+
+// scan returns the keys with the prefix followed by a time key in [since, until), in ascending or
+// descending order. A nil until has no upper bound. It returns a nil key once there are no more.
+func scan(cursor *bbolt.Cursor, prefix, since, until []byte, descending bool) func() ([]byte, []byte) {
+	lower := append(bytes.Clone(prefix), since...)
+	var upper []byte
+	switch {
+	case until != nil:
+		upper = append(bytes.Clone(prefix), until...)
+	case prefix != nil:
+		upper = prefixEnd(prefix)
+	}
+
+	var k, v []byte
+	if descending {
+		// the last key before the upper bound
+		if upper == nil {
+			k, v = cursor.Last()
+		} else if k, v = cursor.Seek(upper); k == nil {
+			k, v = cursor.Last()
+		} else {
+			k, v = cursor.Prev()
+		}
+	} else {
+		k, v = cursor.Seek(lower)
+	}
+
+	return func() ([]byte, []byte) {
+		if k == nil || bytes.Compare(k, lower) < 0 || (upper != nil && bytes.Compare(k, upper) >= 0) {
+			return nil, nil
+		}
+		key, value := k, v
+		if descending {
+			k, v = cursor.Prev()
+		} else {
+			k, v = cursor.Next()
+		}
+		return key, value
+	}
+}
+
+// pairPrefix is the prefix of the keys of the rates of the pair. Pairs never contain a zero byte,
+// so the prefix of a pair never matches the keys of another one.
+func pairPrefix(pair string) []byte {
+	return append([]byte(pair), 0)
+}
+
+func rateKey(pair string, at time.Time, seq uint64) []byte {
+	return append(pairPrefix(pair), timeKey(at, seq)...)
+}
+
+// timeKey encodes the time and the sequence so the keys are sorted by time and then by sequence.
+func timeKey(at time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	if at.Before(minTime) {
+		// UnixNano is undefined before it, none of the stored rates can be that old anyway
+		at = minTime
+	}
+	// flipping the sign bit sorts negative unix times before the positive ones
+	binary.BigEndian.PutUint64(key[0:8], uint64(at.UnixNano())^(1<<63))
+	binary.BigEndian.PutUint64(key[8:16], seq)
+	return key
+}
+
+// prefixEnd returns the smallest key that is greater than all the keys with the prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	end[len(end)-1]++
+	return end
+}
+
+// after returns the earliest time a rate must have to be newer than t.
+func after(t time.Time) time.Time {
+	return t.Add(time.Nanosecond)
+}
+
+func decode(value []byte) (exchange.RateUpdated, error) {
+	var rate exchange.RateUpdated
+	if err := json.Unmarshal(value, &rate); err != nil {
+		return exchange.RateUpdated{}, fmt.Errorf("failed to decode rate: %v", err)
+	}
+	// the time is encoded in its original location, but returned in UTC like the other repositories
+	rate.At = rate.At.UTC()
+	return rate, nil
+}
@@ -0,0 +1,118 @@
+package bolt
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/repotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T, ttl time.Duration) repotest.Repository {
+		return openRepository(t, filepath.Join(t.TempDir(), "exchange.bolt"), ttl)
+	})
+}
+
+func TestRepository_ListPair(t *testing.T) {
+	ctx := context.Background()
+	repository := openRepository(t, filepath.Join(t.TempDir(), "exchange.bolt"), time.Hour)
+
+	now := time.Now().UTC()
+	usd := []exchange.RateUpdated{
+		{From: "USD", To: "BTC", At: now.Add(-3 * time.Minute), Rate: "1"},
+		{From: "USD", To: "BTC", At: now.Add(-time.Minute), Rate: "3"},
+	}
+	// a pair that is a prefix of another one must not list its rates
+	usdt := exchange.RateUpdated{From: "USD", To: "BTCX", At: now.Add(-2 * time.Minute), Rate: "2"}
+	for _, rate := range []exchange.RateUpdated{usd[1], usdt, usd[0]} {
+		require.NoError(t, repository.Insert(ctx, rate))
+	}
+
+	result, err := repository.List(ctx, exchange.Query{Pair: "USD-BTC", Since: now.Add(-time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, usd, result)
+
+	result, err = repository.List(ctx, exchange.Query{Pair: "USD-BTC", Since: usd[0].At})
+	require.NoError(t, err)
+	assert.Equal(t, usd[1:], result)
+
+	result, err = repository.List(ctx, exchange.Query{Pair: "EUR-BTC", Since: now.Add(-time.Hour)})
+	require.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+func TestRepository_TTL(t *testing.T) {
+	ctx := context.Background()
+	repository := openRepository(t, filepath.Join(t.TempDir(), "exchange.bolt"), time.Hour)
+
+	now := time.Now().UTC()
+	expired := exchange.RateUpdated{From: "USD", To: "BTC", At: now.Add(-2 * time.Hour), Rate: "1"}
+	fresh := exchange.RateUpdated{From: "USD", To: "BTC", At: now.Add(-time.Minute), Rate: "2"}
+	require.NoError(t, repository.Insert(ctx, expired))
+	require.NoError(t, repository.Insert(ctx, fresh))
+
+	// expired rates are never returned, even before being evicted
+	result, err := repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []exchange.RateUpdated{fresh}, result)
+
+	evicted, err := repository.EvictExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+
+	evicted, err = repository.EvictExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, evicted)
+
+	repository.ttl = 10 * time.Hour
+	result, err = repository.List(ctx, exchange.Query{Pair: "USD-BTC", Since: time.Time{}})
+	require.NoError(t, err)
+	assert.Equal(t, []exchange.RateUpdated{fresh}, result)
+}
+
+func TestRepository_SurvivesRestarts(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "exchange.bolt")
+
+	rate := exchange.RateUpdated{From: "USD", To: "BTC", At: time.Now().UTC().Add(-time.Minute), Rate: "84,000.1", Source: "coindesk"}
+	repository, err := Open(path, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, repository.Insert(ctx, rate))
+	require.NoError(t, repository.Close())
+
+	repository = openRepository(t, path, time.Hour)
+	result, err := repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []exchange.RateUpdated{rate}, result)
+}
+
+func openRepository(t *testing.T, path string, ttl time.Duration) *Repository {
+	t.Helper()
+
+	repository, err := Open(path, ttl)
+	require.NoError(t, err)
+	t.Cleanup(func() { repository.Close() })
+
+	return repository
+}
+
+func TestRepository_InsertBatch(t *testing.T) {
+	ctx := context.Background()
+	repository := openRepository(t, filepath.Join(t.TempDir(), "exchange.bolt"), time.Hour)
+
+	now := time.Now().UTC()
+	rates := []exchange.RateUpdated{
+		{From: "USD", To: "BTC", At: now.Add(-time.Minute), Rate: "1", Source: "coindesk"},
+		{From: "EUR", To: "BTC", At: now.Add(-time.Minute), Rate: "2", Source: "coindesk"},
+	}
+	require.NoError(t, repository.InsertBatch(ctx, rates))
+
+	result, err := repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, rates, result)
+}
@@ -2,80 +2,262 @@ package exchange
 
 import (
 	"fmt"
+	"hash/fnv"
 	"log"
-	"time"
-
-	"github.com/alex-rufo/exchange/pkg/syncx"
+	"runtime"
+	"sync"
+	"sync/atomic"
 )
 
-type RateUpdated struct {
-	From string    `json:"from"`
-	To   string    `json:"to"`
-	At   time.Time `json:"at"`
-	Rate string    `json:"rate"`
-}
+// shardQueueSize is the number of updates every shard can have pending, so a shard busy with its
+// subscriptions does not hold back the other ones.
+const shardQueueSize = 16
 
+// Broadcaster fans the updates out to its subscriptions. The subscriptions are spread across shards, each one
+// served by its own goroutine, so the updates are delivered to tens of thousands of them in parallel.
 type Broadcaster struct {
-	updates                chan RateUpdated
+	updates <-chan RateUpdated
+	// records are the updates read from a log, along with their offsets, instead of the updates channel
+	records                <-chan Record
 	subscriptionBufferSize int
-	subscriptions          *syncx.Map[string, chan RateUpdated]
+	shards                 []*broadcasterShard
 }
 
-func NewBroadcaster(updates chan RateUpdated, subscriptionBufferSize int) *Broadcaster {
-	return &Broadcaster{
+type broadcasterShard struct {
+	// mutex is held for reading while delivering an update, so subscriptions are not closed meanwhile.
+	mutex         sync.RWMutex
+	subscriptions map[string]*broadcasterSubscription
+	updates       chan *Update
+}
+
+// broadcasterSubscription receives either the rates or the shared updates.
+type broadcasterSubscription struct {
+	rates   chan RateUpdated
+	updates chan *Update
+}
+
+// NewBroadcaster creates a broadcaster with a shard per CPU.
+func NewBroadcaster(updates <-chan RateUpdated, subscriptionBufferSize int) *Broadcaster {
+	return NewShardedBroadcaster(updates, subscriptionBufferSize, runtime.GOMAXPROCS(0))
+}
+
+// NewShardedBroadcaster creates a broadcaster with the number of shards, a shard per CPU when it is not positive.
+func NewShardedBroadcaster(updates <-chan RateUpdated, subscriptionBufferSize int, shards int) *Broadcaster {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+
+	b := &Broadcaster{
 		updates:                updates,
 		subscriptionBufferSize: subscriptionBufferSize,
-		subscriptions:          new(syncx.Map[string, chan RateUpdated]),
+		shards:                 make([]*broadcasterShard, shards),
+	}
+	for i := range b.shards {
+		b.shards[i] = &broadcasterShard{
+			subscriptions: make(map[string]*broadcasterSubscription),
+			updates:       make(chan *Update, shardQueueSize),
+		}
 	}
+	return b
+}
+
+// NewLogBroadcaster creates a broadcaster of the records read from a log, with the number of shards. Every update
+// carries the offset of its record, so the log is read once for all the subscriptions.
+func NewLogBroadcaster(records <-chan Record, subscriptionBufferSize int, shards int) *Broadcaster {
+	b := NewShardedBroadcaster(nil, subscriptionBufferSize, shards)
+	b.records = records
+	return b
 }
 
+// ListenAndServer delivers the updates to the subscriptions until the updates channel is closed.
 func (b *Broadcaster) ListenAndServer() {
-	for {
-		select {
-		case update, ok := <-b.updates:
-			if !ok {
-				// Channel closed, stopping consumer
-				return
+	var wg sync.WaitGroup
+	for _, shard := range b.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shard.serve()
+		}()
+	}
+
+	// The same update is shared by every shard, so its payloads are only encoded once.
+	if b.records != nil {
+		for record := range b.records {
+			b.publish(&Update{Rate: record.Rate, Offset: record.Offset})
+		}
+	} else {
+		for rate := range b.updates {
+			b.publish(&Update{Rate: rate, Offset: NoOffset})
+		}
+	}
+
+	// Channel closed, stopping the shards once they delivered the pending updates
+	for _, shard := range b.shards {
+		close(shard.updates)
+	}
+	wg.Wait()
+}
+
+func (b *Broadcaster) publish(update *Update) {
+	for _, shard := range b.shards {
+		shard.updates <- update
+	}
+}
+
+func (s *broadcasterShard) serve() {
+	for update := range s.updates {
+		s.mutex.RLock()
+		for id, subscription := range s.subscriptions {
+			if !subscription.deliver(update) {
+				// subscription is full, skip that update as we don't want to block other subscriptions.
+				log.Printf("rate update '%v' skipped for subscription '%v' as channel was full", update.Rate, id)
 			}
+		}
+		s.mutex.RUnlock()
+	}
+}
 
-			b.subscriptions.Range(func(id string, subscription chan RateUpdated) bool {
-				select {
-				case subscription <- update:
-					// subscription received the rate update successfully.
-				default:
-					// subscription is full, skip that update as we don't want to block other subscriptions.
-					log.Printf("rate update '%v' skipped for subscription '%v' as channel was full", update, id)
-				}
-
-				return true
-			})
+// deliver sends the update without blocking, returning whether the subscription had room for it.
+func (s *broadcasterSubscription) deliver(update *Update) bool {
+	if s.updates != nil {
+		select {
+		case s.updates <- update:
+			return true
+		default:
+			return false
 		}
 	}
+
+	select {
+	case s.rates <- update.Rate:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *broadcasterSubscription) close() {
+	if s.updates != nil {
+		close(s.updates)
+		return
+	}
+	close(s.rates)
 }
 
 func (b *Broadcaster) Close() {
-	b.subscriptions.Range(func(id string, subscription chan RateUpdated) bool {
-		b.Unsubscribe(id)
-		return true
-	})
+	for _, shard := range b.shards {
+		shard.mutex.Lock()
+		for id, subscription := range shard.subscriptions {
+			delete(shard.subscriptions, id)
+			subscription.close()
+		}
+		shard.mutex.Unlock()
+	}
 }
 
 func (b *Broadcaster) Subscribe(id string) (<-chan RateUpdated, error) {
-	subscription := make(chan RateUpdated, b.subscriptionBufferSize)
+	subscription := &broadcasterSubscription{rates: make(chan RateUpdated, b.subscriptionBufferSize)}
+	if err := b.subscribe(id, subscription); err != nil {
+		return nil, err
+	}
+
+	return subscription.rates, nil
+}
 
-	_, loaded := b.subscriptions.LoadOrStore(id, subscription)
-	if loaded {
-		return nil, fmt.Errorf("there is another subscription with the same id (%s), it can not be added", id)
+// SubscribeUpdates subscribes to the updates shared by every subscription, for the subscriptions sending
+// the same payloads to their clients.
+func (b *Broadcaster) SubscribeUpdates(id string) (<-chan *Update, error) {
+	subscription := &broadcasterSubscription{updates: make(chan *Update, b.subscriptionBufferSize)}
+	if err := b.subscribe(id, subscription); err != nil {
+		return nil, err
 	}
 
-	return subscription, nil
+	return subscription.updates, nil
+}
+
+func (b *Broadcaster) subscribe(id string, subscription *broadcasterSubscription) error {
+	shard := b.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if _, ok := shard.subscriptions[id]; ok {
+		return fmt.Errorf("there is another subscription with the same id (%s), it can not be added", id)
+	}
+	shard.subscriptions[id] = subscription
+	return nil
 }
 
 func (b *Broadcaster) Unsubscribe(id string) {
-	subscription, loaded := b.subscriptions.LoadAndDelete(id)
-	if !loaded {
+	shard := b.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	subscription, ok := shard.subscriptions[id]
+	if !ok {
 		return
 	}
+	delete(shard.subscriptions, id)
+	subscription.close()
+}
+
+// shard returns the shard owning the subscription.
+func (b *Broadcaster) shard(id string) *broadcasterShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return b.shards[hash.Sum32()%uint32(len(b.shards))]
+}
+
+// Update is a rate update delivered by the broadcaster. The same update is shared by every subscription,
+// so the payloads sent to their clients are encoded once, by the first subscription needing each of them.
+type Update struct {
+	Rate RateUpdated
+	// Offset is the offset of the record of the rate in the log it was read from, NoOffset when it was not.
+	Offset int64
+
+	mutex    sync.RWMutex
+	payloads map[string]*payload
+}
+
+// payload is an encoding of the update, guarded by its own mutex so encoding it only blocks the
+// subscriptions waiting for the same one.
+type payload struct {
+	mutex   sync.Mutex
+	encoded atomic.Bool
+	value   any
+}
 
-	close(subscription)
+// Payload returns the payload of the update for the key, encoding it the first time it is requested.
+// Failed encodings are not kept. Once encoded, it is returned without taking any exclusive lock.
+func (u *Update) Payload(key string, encode func(rate RateUpdated) (any, error)) (any, error) {
+	u.mutex.RLock()
+	p, ok := u.payloads[key]
+	u.mutex.RUnlock()
+	if !ok {
+		u.mutex.Lock()
+		if p, ok = u.payloads[key]; !ok {
+			if u.payloads == nil {
+				u.payloads = make(map[string]*payload)
+			}
+			p = &payload{}
+			u.payloads[key] = p
+		}
+		u.mutex.Unlock()
+	}
+
+	if p.encoded.Load() {
+		return p.value, nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.encoded.Load() {
+		return p.value, nil
+	}
+	value, err := encode(u.Rate)
+	if err != nil {
+		return nil, err
+	}
+	p.value = value
+	p.encoded.Store(true)
+	return value, nil
 }
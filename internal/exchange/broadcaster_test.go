@@ -1,6 +1,11 @@
 package exchange
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -186,3 +191,210 @@ func TestBroadcastWithFullChannel(t *testing.T) {
 		// Expected case
 	}
 }
+
+func TestBroadcast_Shards(t *testing.T) {
+	updates := make(chan RateUpdated)
+	broadcaster := NewShardedBroadcaster(updates, 1, 4)
+	defer close(updates)
+	go broadcaster.ListenAndServer()
+
+	// subscriptions are spread across every shard
+	subscriptions := make([]<-chan RateUpdated, 100)
+	for i := range subscriptions {
+		subscription, err := broadcaster.Subscribe(fmt.Sprintf("sub%d", i))
+		require.NoError(t, err)
+		subscriptions[i] = subscription
+	}
+	for _, shard := range broadcaster.shards {
+		assert.NotEmpty(t, shard.subscriptions)
+	}
+
+	update := RateUpdated{From: "USD", To: "BTC", At: time.Now(), Rate: "69,420.00"}
+	updates <- update
+	for i, subscription := range subscriptions {
+		select {
+		case received := <-subscription:
+			assert.Equal(t, update, received)
+		case <-time.After(time.Second):
+			t.Fatalf("sub%d did not receive update", i)
+		}
+	}
+}
+
+func TestSubscribeUpdates(t *testing.T) {
+	updates := make(chan RateUpdated)
+	broadcaster := NewShardedBroadcaster(updates, 1, 2)
+	defer close(updates)
+	go broadcaster.ListenAndServer()
+
+	sub1, err := broadcaster.SubscribeUpdates("sub1")
+	require.NoError(t, err)
+	sub2, err := broadcaster.SubscribeUpdates("sub2")
+	require.NoError(t, err)
+	_, err = broadcaster.Subscribe("sub1")
+	assert.Error(t, err)
+
+	rate := RateUpdated{From: "USD", To: "BTC", At: time.Now(), Rate: "69,420.00"}
+	updates <- rate
+	first, second := receiveUpdate(t, sub1), receiveUpdate(t, sub2)
+
+	// every subscription gets the same update, encoding its payloads once
+	assert.Same(t, first, second)
+	assert.Equal(t, rate, first.Rate)
+	assert.Equal(t, NoOffset, first.Offset)
+	encoded := 0
+	encode := func(rate RateUpdated) (any, error) {
+		encoded++
+		return json.Marshal(rate)
+	}
+	firstPayload, err := first.Payload("json", encode)
+	require.NoError(t, err)
+	secondPayload, err := second.Payload("json", encode)
+	require.NoError(t, err)
+	assert.Equal(t, firstPayload, secondPayload)
+	assert.Equal(t, 1, encoded)
+
+	broadcaster.Unsubscribe("sub1")
+	_, ok := <-sub1
+	assert.False(t, ok)
+}
+
+func TestLogBroadcaster(t *testing.T) {
+	records := make(chan Record, 1)
+	broadcaster := NewLogBroadcaster(records, 5, 2)
+	go broadcaster.ListenAndServer()
+	defer broadcaster.Close()
+
+	updates, err := broadcaster.SubscribeUpdates("sub1")
+	require.NoError(t, err)
+	rates, err := broadcaster.Subscribe("sub2")
+	require.NoError(t, err)
+
+	// every update carries the offset of its record
+	rate := RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 0).UTC(), Rate: "50000.00"}
+	records <- Record{Offset: 7, Rate: rate}
+	update := receiveUpdate(t, updates)
+	assert.Equal(t, rate, update.Rate)
+	assert.Equal(t, int64(7), update.Offset)
+	select {
+	case received := <-rates:
+		assert.Equal(t, rate, received)
+	case <-time.After(time.Second):
+		require.FailNow(t, "no rate received")
+	}
+}
+
+func TestUpdate_Payload_Failed(t *testing.T) {
+	update := &Update{Rate: RateUpdated{From: "USD", To: "BTC"}}
+
+	_, err := update.Payload("json", func(rate RateUpdated) (any, error) {
+		return nil, errors.New("failed")
+	})
+	assert.EqualError(t, err, "failed")
+
+	// failed encodings are tried again
+	payload, err := update.Payload("json", func(rate RateUpdated) (any, error) {
+		return rate.Pair(), nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "USD-BTC", payload)
+}
+
+func TestUpdate_Payload_Concurrent(t *testing.T) {
+	update := &Update{Rate: RateUpdated{From: "USD", To: "BTC"}}
+
+	// an encoding in progress does not block the ones of the other keys
+	encoding := make(chan struct{})
+	release := make(chan struct{})
+	slow := make(chan any, 1)
+	go func() {
+		payload, _ := update.Payload("slow", func(rate RateUpdated) (any, error) {
+			close(encoding)
+			<-release
+			return "slow", nil
+		})
+		slow <- payload
+	}()
+	<-encoding
+
+	var (
+		encoded atomic.Int32
+		wg      sync.WaitGroup
+	)
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payload, err := update.Payload("json", func(rate RateUpdated) (any, error) {
+				encoded.Add(1)
+				return json.Marshal(rate)
+			})
+			assert.NoError(t, err)
+			assert.NotNil(t, payload)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), encoded.Load())
+
+	close(release)
+	assert.Equal(t, "slow", <-slow)
+}
+
+func receiveUpdate(t *testing.T, updates <-chan *Update) *Update {
+	t.Helper()
+	select {
+	case update, ok := <-updates:
+		require.True(t, ok, "subscription closed")
+		return update
+	case <-time.After(time.Second):
+		require.FailNow(t, "no update received")
+		return nil
+	}
+}
+
+func BenchmarkBroadcaster(b *testing.B) {
+	for _, subscribers := range []int{10_000, 50_000} {
+		for _, shards := range []int{1, 8} {
+			b.Run(fmt.Sprintf("subscribers=%d/shards=%d", subscribers, shards), func(b *testing.B) {
+				benchmarkBroadcaster(b, subscribers, shards, func(update *Update) {})
+			})
+		}
+		// every subscription sends the JSON payload of the update, encoded once
+		b.Run(fmt.Sprintf("subscribers=%d/payload", subscribers), func(b *testing.B) {
+			benchmarkBroadcaster(b, subscribers, 0, func(update *Update) {
+				if _, err := update.Payload("json", func(rate RateUpdated) (any, error) { return json.Marshal(rate) }); err != nil {
+					b.Error(err)
+				}
+			})
+		})
+	}
+}
+
+// benchmarkBroadcaster broadcasts every update to the subscribers, waiting for all of them to receive it.
+func benchmarkBroadcaster(b *testing.B, subscribers, shards int, receive func(update *Update)) {
+	updates := make(chan RateUpdated)
+	broadcaster := NewShardedBroadcaster(updates, 1, shards)
+	go broadcaster.ListenAndServer()
+	defer broadcaster.Close()
+	defer close(updates)
+
+	var received sync.WaitGroup
+	for i := 0; i < subscribers; i++ {
+		subscription, err := broadcaster.SubscribeUpdates(fmt.Sprintf("sub%d", i))
+		require.NoError(b, err)
+		go func() {
+			for update := range subscription {
+				receive(update)
+				received.Done()
+			}
+		}()
+	}
+
+	rate := RateUpdated{From: "USD", To: "BTC", At: time.Now(), Rate: "69,420.00", Source: "coindesk"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		received.Add(subscribers)
+		updates <- rate
+		received.Wait()
+	}
+}
@@ -0,0 +1,137 @@
+// Package codec encodes the rate messages streamed to the WebSocket clients. Every encoding follows the same
+// schema, described by rate.proto, and is negotiated with the WebSocket subprotocol of its name.
+package codec
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Rate is the message of a rate update, the schema shared by every encoding. CBOR uses the JSON field names.
+type Rate struct {
+	Channel string    `json:"channel" msgpack:"channel"`
+	From    string    `json:"from" msgpack:"from"`
+	To      string    `json:"to" msgpack:"to"`
+	At      time.Time `json:"at" msgpack:"at"`
+	Rate    string    `json:"rate" msgpack:"rate"`
+	Source  string    `json:"source,omitempty" msgpack:"source,omitempty"`
+	Stale   bool      `json:"stale,omitempty" msgpack:"stale,omitempty"`
+	Token   string    `json:"token,omitempty" msgpack:"token,omitempty"`
+}
+
+// NewRate creates the message of the rate update on the channel.
+func NewRate(channel string, rate exchange.RateUpdated) Rate {
+	return Rate{
+		Channel: channel,
+		From:    rate.From,
+		To:      rate.To,
+		At:      rate.At,
+		Rate:    rate.Rate,
+		Source:  rate.Source,
+	}
+}
+
+type Codec interface {
+	// Name of the encoding, the WebSocket subprotocol negotiating it.
+	Name() string
+	// Binary tells whether the messages are sent in binary frames, instead of text ones.
+	Binary() bool
+	Marshal(rate Rate) ([]byte, error)
+	Unmarshal(data []byte, rate *Rate) error
+}
+
+// Supported encodings.
+var (
+	JSON        Codec = jsonCodec{}
+	MessagePack Codec = msgpackCodec{}
+	CBOR        Codec = newCBORCodec()
+	Protobuf    Codec = protobufCodec{}
+)
+
+// Codecs are the supported encodings, JSON being the default one.
+var Codecs = []Codec{JSON, MessagePack, CBOR, Protobuf}
+
+// Lookup returns the codec of the encoding.
+func Lookup(name string) (Codec, bool) {
+	for _, codec := range Codecs {
+		if codec.Name() == name {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// Names returns the name of every supported encoding.
+func Names() []string {
+	names := make([]string, len(Codecs))
+	for i, codec := range Codecs {
+		names[i] = codec.Name()
+	}
+	return names
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Marshal(rate Rate) ([]byte, error) {
+	return json.Marshal(rate)
+}
+
+func (jsonCodec) Unmarshal(data []byte, rate *Rate) error {
+	return json.Unmarshal(data, rate)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Binary() bool { return true }
+
+func (msgpackCodec) Marshal(rate Rate) ([]byte, error) {
+	return msgpack.Marshal(rate)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, rate *Rate) error {
+	if err := msgpack.Unmarshal(data, rate); err != nil {
+		return err
+	}
+	// times are decoded in the local time zone
+	rate.At = rate.At.UTC()
+	return nil
+}
+
+type cborCodec struct {
+	encoder cbor.EncMode
+}
+
+func newCBORCodec() cborCodec {
+	// times are encoded as RFC 3339 strings, so they keep their nanoseconds
+	encoder, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano, TimeTag: cbor.EncTagRequired}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return cborCodec{encoder: encoder}
+}
+
+func (cborCodec) Name() string { return "cbor" }
+
+func (cborCodec) Binary() bool { return true }
+
+func (c cborCodec) Marshal(rate Rate) ([]byte, error) {
+	return c.encoder.Marshal(rate)
+}
+
+func (cborCodec) Unmarshal(data []byte, rate *Rate) error {
+	if err := cbor.Unmarshal(data, rate); err != nil {
+		return err
+	}
+	rate.At = rate.At.UTC()
+	return nil
+}
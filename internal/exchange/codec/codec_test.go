@@ -0,0 +1,78 @@
+package codec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	rate := NewRate("rates", exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1744280237, 123456789).UTC(), Rate: "69,420.00", Source: "coindesk"})
+	stale := rate
+	stale.Stale = true
+	stale.Token = "42"
+	tests := map[string]Rate{
+		"rate":             rate,
+		"stale with token": stale,
+		"empty":            {},
+	}
+
+	for _, codec := range Codecs {
+		for name, tt := range tests {
+			t.Run(codec.Name()+"/"+name, func(t *testing.T) {
+				data, err := codec.Marshal(tt)
+				require.NoError(t, err)
+
+				var decoded Rate
+				require.NoError(t, codec.Unmarshal(data, &decoded))
+				if tt.At.IsZero() {
+					// the zero time has no time zone to keep
+					assert.True(t, decoded.At.IsZero())
+					decoded.At = tt.At
+				}
+				assert.Equal(t, tt, decoded)
+			})
+		}
+	}
+}
+
+func TestCodecs_Invalid(t *testing.T) {
+	for _, codec := range Codecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			var decoded Rate
+			assert.Error(t, codec.Unmarshal([]byte{0xff, 0xff}, &decoded))
+		})
+	}
+}
+
+func TestProtobuf_Wire(t *testing.T) {
+	at := time.Unix(1744280237, 123456789).UTC()
+	data, err := Protobuf.Marshal(Rate{Channel: "rates", From: "USD", At: at, Stale: true})
+	require.NoError(t, err)
+
+	// fields are encoded as described by rate.proto, at being a google.protobuf.Timestamp
+	timestamp, err := proto.Marshal(timestamppb.New(at))
+	require.NoError(t, err)
+	expected := append([]byte{0x0a, 5, 'r', 'a', 't', 'e', 's', 0x12, 3, 'U', 'S', 'D', 0x22, byte(len(timestamp))}, timestamp...)
+	expected = append(expected, 0x38, 1)
+	assert.Equal(t, expected, data)
+
+	// unknown fields are skipped
+	var decoded Rate
+	require.NoError(t, Protobuf.Unmarshal(append(data, 0x48, 1, 0x52, 1, 'x'), &decoded))
+	assert.Equal(t, Rate{Channel: "rates", From: "USD", At: at, Stale: true}, decoded)
+}
+
+func TestLookup(t *testing.T) {
+	codec, ok := Lookup("msgpack")
+	require.True(t, ok)
+	assert.Equal(t, MessagePack, codec)
+	_, ok = Lookup("xml")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"json", "msgpack", "cbor", "protobuf"}, Names())
+}
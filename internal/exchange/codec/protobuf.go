@@ -0,0 +1,148 @@
+package codec
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the messages of rate.proto.
+const (
+	rateChannel protowire.Number = 1
+	rateFrom    protowire.Number = 2
+	rateTo      protowire.Number = 3
+	rateAt      protowire.Number = 4
+	rateRate    protowire.Number = 5
+	rateSource  protowire.Number = 6
+	rateStale   protowire.Number = 7
+	rateToken   protowire.Number = 8
+
+	timestampSeconds protowire.Number = 1
+	timestampNanos   protowire.Number = 2
+)
+
+// protobufCodec encodes the Rate message of rate.proto on the wire, so no code needs to be generated for it.
+// Default values are omitted, as proto3 does.
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "protobuf" }
+
+func (protobufCodec) Binary() bool { return true }
+
+func (protobufCodec) Marshal(rate Rate) ([]byte, error) {
+	var data []byte
+	data = appendString(data, rateChannel, rate.Channel)
+	data = appendString(data, rateFrom, rate.From)
+	data = appendString(data, rateTo, rate.To)
+	if !rate.At.IsZero() {
+		var at []byte
+		if seconds := rate.At.Unix(); seconds != 0 {
+			at = protowire.AppendTag(at, timestampSeconds, protowire.VarintType)
+			at = protowire.AppendVarint(at, uint64(seconds))
+		}
+		if nanos := rate.At.Nanosecond(); nanos != 0 {
+			at = protowire.AppendTag(at, timestampNanos, protowire.VarintType)
+			at = protowire.AppendVarint(at, uint64(nanos))
+		}
+		data = protowire.AppendTag(data, rateAt, protowire.BytesType)
+		data = protowire.AppendBytes(data, at)
+	}
+	data = appendString(data, rateRate, rate.Rate)
+	data = appendString(data, rateSource, rate.Source)
+	if rate.Stale {
+		data = protowire.AppendTag(data, rateStale, protowire.VarintType)
+		data = protowire.AppendVarint(data, protowire.EncodeBool(true))
+	}
+	data = appendString(data, rateToken, rate.Token)
+	return data, nil
+}
+
+func appendString(data []byte, number protowire.Number, value string) []byte {
+	if value == "" {
+		return data
+	}
+	data = protowire.AppendTag(data, number, protowire.BytesType)
+	return protowire.AppendString(data, value)
+}
+
+func (protobufCodec) Unmarshal(data []byte, rate *Rate) error {
+	*rate = Rate{}
+	return consumeFields(data, func(number protowire.Number, typ protowire.Type, data []byte) (int, error) {
+		switch {
+		case number == rateAt && typ == protowire.BytesType:
+			at, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return n, nil
+			}
+			var seconds, nanos int64
+			err := consumeFields(at, func(number protowire.Number, typ protowire.Type, data []byte) (int, error) {
+				if typ != protowire.VarintType || (number != timestampSeconds && number != timestampNanos) {
+					return protowire.ConsumeFieldValue(number, typ, data), nil
+				}
+				value, n := protowire.ConsumeVarint(data)
+				if number == timestampSeconds {
+					seconds = int64(value)
+				} else {
+					nanos = int64(int32(value))
+				}
+				return n, nil
+			})
+			if err != nil {
+				return 0, fmt.Errorf("invalid at: %v", err)
+			}
+			rate.At = time.Unix(seconds, nanos).UTC()
+			return n, nil
+		case number == rateStale && typ == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(data)
+			rate.Stale = protowire.DecodeBool(value)
+			return n, nil
+		case typ == protowire.BytesType:
+			var field *string
+			switch number {
+			case rateChannel:
+				field = &rate.Channel
+			case rateFrom:
+				field = &rate.From
+			case rateTo:
+				field = &rate.To
+			case rateRate:
+				field = &rate.Rate
+			case rateSource:
+				field = &rate.Source
+			case rateToken:
+				field = &rate.Token
+			default:
+				return protowire.ConsumeFieldValue(number, typ, data), nil
+			}
+			value, n := protowire.ConsumeString(data)
+			*field = value
+			return n, nil
+		default:
+			// unknown fields are skipped, so new ones can be added to the schema
+			return protowire.ConsumeFieldValue(number, typ, data), nil
+		}
+	})
+}
+
+// consumeFields calls consume with the value of every field of the message, which returns the length of the
+// value, or a negative one when it is invalid.
+func consumeFields(data []byte, consume func(number protowire.Number, typ protowire.Type, data []byte) (int, error)) error {
+	for len(data) > 0 {
+		number, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		n, err := consume(number, typ, data)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -11,6 +11,8 @@ import (
 
 const (
 	CurrencyBTC = "BTC"
+	// Source identifies the rates fetched from CoinDesk.
+	Source = "coindesk"
 )
 
 type client interface {
@@ -44,10 +46,11 @@ func (f *Fetcher) Fetch(ctx context.Context) ([]exchange.RateUpdated, error) {
 		}
 
 		rates = append(rates, exchange.RateUpdated{
-			From: currency,
-			To:   CurrencyBTC,
-			At:   response.Time.UpdatedISO,
-			Rate: price.Rate,
+			From:   currency,
+			To:     CurrencyBTC,
+			At:     response.Time.UpdatedISO,
+			Rate:   price.Rate,
+			Source: Source,
 		})
 	}
 
@@ -125,6 +125,7 @@ func TestFetcher_Fetch(t *testing.T) {
 				assert.Equal(t, tt.expectedRates[i].At, rate.At)
 				assert.Equal(t, tt.expectedRates[i].To, rate.To)
 				assert.Equal(t, tt.expectedRates[i].Rate, rate.Rate)
+				assert.Equal(t, Source, rate.Source)
 			}
 		})
 	}
@@ -0,0 +1,67 @@
+package coindesk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/pkg/coindesk"
+)
+
+type historyClient interface {
+	FetchHistoricalClose(ctx context.Context, currency string, start, end time.Time) (*coindesk.FetchHistoricalCloseResponse, error)
+}
+
+// HistoryFetcher fetches the daily close rates of the past from the CoinDesk archive.
+type HistoryFetcher struct {
+	client historyClient
+	now    func() time.Time
+}
+
+func NewHistoryFetcher(client historyClient) *HistoryFetcher {
+	return &HistoryFetcher{client: client, now: time.Now}
+}
+
+// FetchHistory returns the close rate of the pair, e.g. USD-BTC, of every day between both dates, sorted by At.
+// The close of a day is at the midnight (UTC) that ends it, so the day in progress, whose price is not a close yet,
+// is left out.
+func (f *HistoryFetcher) FetchHistory(ctx context.Context, pair string, from, to time.Time) ([]exchange.RateUpdated, error) {
+	currency, target, ok := strings.Cut(pair, "-")
+	if !ok || target != CurrencyBTC {
+		return nil, fmt.Errorf("unsupported pair %q, CoinDesk only has the history of <currency>-%s pairs", pair, CurrencyBTC)
+	}
+
+	response, err := f.client.FetchHistoricalClose(ctx, currency, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	now := f.now()
+	rates := make([]exchange.RateUpdated, 0, len(response.BPI))
+	for date, price := range response.BPI {
+		day, err := time.Parse(coindesk.HistoricalDateLayout, date)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the date %q of the close: %v", date, err)
+		}
+		if day.Add(24 * time.Hour).After(now) {
+			continue
+		}
+
+		rates = append(rates, exchange.RateUpdated{
+			From:   currency,
+			To:     CurrencyBTC,
+			At:     day.Add(24 * time.Hour),
+			Rate:   strconv.FormatFloat(price, 'f', -1, 64),
+			Source: Source,
+		})
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].At.Before(rates[j].At)
+	})
+
+	return rates, nil
+}
@@ -0,0 +1,98 @@
+package coindesk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/pkg/coindesk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryFetcher_FetchHistory(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		pair          string
+		response      *coindesk.FetchHistoricalCloseResponse
+		err           error
+		now           time.Time
+		expectedRates []exchange.RateUpdated
+		expectedErr   string
+	}{
+		{
+			name:     "closes sorted by day",
+			pair:     "EUR-BTC",
+			response: &coindesk.FetchHistoricalCloseResponse{BPI: map[string]float64{"2026-03-02": 62000.1, "2026-03-01": 61234.5678}},
+			expectedRates: []exchange.RateUpdated{
+				{From: "EUR", To: CurrencyBTC, At: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), Rate: "61234.5678", Source: Source},
+				{From: "EUR", To: CurrencyBTC, At: time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC), Rate: "62000.1", Source: Source},
+			},
+		},
+		{
+			name:     "day in progress left out",
+			pair:     "EUR-BTC",
+			now:      time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC),
+			response: &coindesk.FetchHistoricalCloseResponse{BPI: map[string]float64{"2026-03-02": 62000.1, "2026-03-01": 61234.5678}},
+			expectedRates: []exchange.RateUpdated{
+				{From: "EUR", To: CurrencyBTC, At: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), Rate: "61234.5678", Source: Source},
+			},
+		},
+		{
+			name:        "unsupported pair",
+			pair:        "BTC-EUR",
+			expectedErr: `unsupported pair "BTC-EUR"`,
+		},
+		{
+			name:        "invalid date",
+			pair:        "EUR-BTC",
+			response:    &coindesk.FetchHistoricalCloseResponse{BPI: map[string]float64{"March 1": 61234.5678}},
+			expectedErr: `failed to parse the date "March 1"`,
+		},
+		{
+			name:        "client returns error",
+			pair:        "EUR-BTC",
+			err:         errors.New("api error"),
+			expectedErr: "api error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockHistoryClient{response: tt.response, err: tt.err}
+			fetcher := NewHistoryFetcher(client)
+			if !tt.now.IsZero() {
+				fetcher.now = func() time.Time { return tt.now }
+			}
+			rates, err := fetcher.FetchHistory(context.Background(), tt.pair, from, to)
+
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedRates, rates)
+			assert.Equal(t, "EUR", client.currency)
+			assert.Equal(t, from, client.start)
+			assert.Equal(t, to, client.end)
+		})
+	}
+}
+
+// mockHistoryClient implements the historyClient interface for testing
+type mockHistoryClient struct {
+	response   *coindesk.FetchHistoricalCloseResponse
+	err        error
+	currency   string
+	start, end time.Time
+}
+
+func (m *mockHistoryClient) FetchHistoricalClose(_ context.Context, currency string, start, end time.Time) (*coindesk.FetchHistoricalCloseResponse, error) {
+	m.currency, m.start, m.end = currency, start, end
+	return m.response, m.err
+}
@@ -0,0 +1,56 @@
+package export
+
+import (
+	"context"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+)
+
+// DefaultPageSize is the number of rates listed from the repository at once.
+const DefaultPageSize = 1000
+
+// Lister lists the rates of a repository.
+type Lister interface {
+	List(ctx context.Context, query exchange.Query) ([]exchange.RateUpdated, error)
+}
+
+// Export writes the rates matching the pair, since and until of the query, listing them a page at a time
+// so the whole history is never in memory. It returns the number of rates written.
+func Export(ctx context.Context, lister Lister, query exchange.Query, pageSize int, writer Writer) (int, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	query.Offset, query.Limit = 0, pageSize
+
+	var (
+		written int
+		// cursor is the At of the last rate written, skipped are the rates written with that same At
+		cursor  = query.Since
+		skipped int
+	)
+	for {
+		rates, err := lister.List(ctx, query)
+		if err != nil {
+			return written, err
+		}
+
+		for _, rate := range rates {
+			if err := writer.Write(rate); err != nil {
+				return written, err
+			}
+			written++
+
+			if !rate.At.Equal(cursor) {
+				cursor, skipped = rate.At, 0
+			}
+			skipped++
+		}
+		if len(rates) < pageSize {
+			return written, nil
+		}
+
+		// since is exclusive, so the next page starts right before the cursor, skipping the rates already written
+		// at it, as keeping the offset would list the whole history again on every page
+		query.Since, query.Offset = cursor.Add(-1), skipped
+	}
+}
@@ -0,0 +1,116 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	repository := exchange.NewInMemoryRepository(24*time.Hour, 0)
+	start := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+
+	// several rates share the same At, so they are split across pages
+	var expected []exchange.RateUpdated
+	for i := range 10 {
+		for _, from := range []string{"USD", "EUR", "GBP"} {
+			rate := exchange.RateUpdated{From: from, To: "BTC", At: start.Add(time.Duration(i) * time.Second), Rate: "1", Source: "coindesk"}
+			require.NoError(t, repository.Insert(ctx, rate))
+			expected = append(expected, rate)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    exchange.Query
+		expected []exchange.RateUpdated
+	}{
+		{name: "whole history", expected: expected},
+		{name: "since and until", query: exchange.Query{Since: start.Add(2 * time.Second), Until: start.Add(4 * time.Second)}, expected: expected[9:15]},
+		{name: "pair", query: exchange.Query{Pair: "EUR-BTC"}, expected: []exchange.RateUpdated{expected[1], expected[4], expected[7], expected[10], expected[13], expected[16], expected[19], expected[22], expected[25], expected[28]}},
+	}
+
+	for _, tt := range tests {
+		for _, pageSize := range []int{1, 2, 4, 100} {
+			t.Run(fmt.Sprintf("%s in pages of %d", tt.name, pageSize), func(t *testing.T) {
+				writer := &recordingWriter{}
+				written, err := Export(ctx, repository, tt.query, pageSize, writer)
+				require.NoError(t, err)
+				assert.Equal(t, len(tt.expected), written)
+				assert.Equal(t, tt.expected, writer.rates)
+			})
+		}
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	rates := []exchange.RateUpdated{
+		{From: "USD", To: "BTC", At: time.Date(2026, 3, 1, 12, 0, 0, 1, time.UTC), Rate: "69,420.00", Source: "coindesk"},
+		{From: "EUR", To: "BTC", At: time.Date(2026, 3, 1, 12, 0, 1, 0, time.UTC), Rate: "64000.5"},
+	}
+	write := func(t *testing.T, format string) []byte {
+		var buffer bytes.Buffer
+		writer, err := NewWriter(format, &buffer)
+		require.NoError(t, err)
+		for _, rate := range rates {
+			require.NoError(t, writer.Write(rate))
+		}
+		require.NoError(t, writer.Close())
+		return buffer.Bytes()
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		assert.Equal(t, "from,to,at,rate,source\n"+
+			"USD,BTC,2026-03-01T12:00:00.000000001Z,\"69,420.00\",coindesk\n"+
+			"EUR,BTC,2026-03-01T12:00:01Z,64000.5,\n", string(write(t, FormatCSV)))
+	})
+
+	t.Run("jsonl", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSpace(string(write(t, FormatJSONL))), "\n")
+		require.Len(t, lines, 2)
+		for i, line := range lines {
+			var rate exchange.RateUpdated
+			require.NoError(t, json.Unmarshal([]byte(line), &rate))
+			assert.Equal(t, rates[i], rate)
+		}
+	})
+
+	t.Run("parquet", func(t *testing.T) {
+		output := write(t, FormatParquet)
+		rows, err := parquet.Read[parquetRate](bytes.NewReader(output), int64(len(output)))
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+		for i, row := range rows {
+			assert.Equal(t, rates[i], exchange.RateUpdated{From: row.From, To: row.To, At: row.At.UTC(), Rate: row.Rate, Source: row.Source})
+		}
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		_, err := NewWriter("xml", &bytes.Buffer{})
+		assert.ErrorContains(t, err, `unsupported format "xml"`)
+	})
+}
+
+// recordingWriter records the written rates.
+type recordingWriter struct {
+	rates []exchange.RateUpdated
+}
+
+func (w *recordingWriter) Write(rate exchange.RateUpdated) error {
+	w.rates = append(w.rates, rate)
+	return nil
+}
+
+func (w *recordingWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,100 @@
+// Package export writes the history of the rates in the formats used to analyze it elsewhere.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// parquetRowGroupSize is the number of rates buffered before they are written as a row group.
+const parquetRowGroupSize = 10000
+
+// Writer writes rates in a format. Close must be called once all of them are written to complete the output.
+type Writer interface {
+	Write(rate exchange.RateUpdated) error
+	Close() error
+}
+
+// NewWriter creates the writer of the format, writing into w.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w)
+	case FormatJSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		return &parquetWriter{writer: parquet.NewGenericWriter[parquetRate](w, parquet.MaxRowsPerRowGroup(parquetRowGroupSize))}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q, must be one of: %s, %s, %s", format, FormatCSV, FormatJSONL, FormatParquet)
+	}
+}
+
+// CSVHeader are the columns of the CSV format.
+var CSVHeader = []string{"from", "to", "at", "rate", "source"}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(CSVHeader); err != nil {
+		return nil, fmt.Errorf("failed to write the CSV header: %v", err)
+	}
+	return &csvWriter{writer: writer}, nil
+}
+
+func (w *csvWriter) Write(rate exchange.RateUpdated) error {
+	return w.writer.Write([]string{rate.From, rate.To, rate.At.Format(time.RFC3339Nano), rate.Rate, rate.Source})
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonlWriter) Write(rate exchange.RateUpdated) error {
+	return w.encoder.Encode(rate)
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
+
+// parquetRate is the schema of the Parquet format.
+type parquetRate struct {
+	From   string    `parquet:"from"`
+	To     string    `parquet:"to"`
+	At     time.Time `parquet:"at,timestamp(nanosecond)"`
+	Rate   string    `parquet:"rate"`
+	Source string    `parquet:"source"`
+}
+
+type parquetWriter struct {
+	writer *parquet.GenericWriter[parquetRate]
+}
+
+func (w *parquetWriter) Write(rate exchange.RateUpdated) error {
+	_, err := w.writer.Write([]parquetRate{{From: rate.From, To: rate.To, At: rate.At, Rate: rate.Rate, Source: rate.Source}})
+	return err
+}
+
+func (w *parquetWriter) Close() error {
+	return w.writer.Close()
+}
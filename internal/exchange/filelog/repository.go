@@ -0,0 +1,327 @@
+package filelog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+)
+
+const (
+	DefaultMaxSegmentSize = 64 << 20
+	DefaultMaxSegmentAge  = time.Hour
+)
+
+type Config struct {
+	// Dir is the directory where the segments are stored, created if it does not exist.
+	Dir string
+	// TTL is the time until the rates are evicted. Rates older than it are never returned.
+	TTL time.Duration
+	// MaxSegmentSize is the size in bytes after which a new segment is started.
+	MaxSegmentSize int64
+	// MaxSegmentAge is the time after which a new segment is started.
+	MaxSegmentAge time.Duration
+}
+
+// entry locates a record of the log, sorted by At in the index.
+type entry struct {
+	at      time.Time
+	pair    string
+	segment *segment
+	offset  int64
+}
+
+// Repository stores the rates in an append-only log on disk split in segments, so they
+// survive restarts without any external dependency. An in-memory index sorted by At,
+// rebuilt on start up, locates the records to read when listing them.
+// Records are not fsynced on every insert, only when a segment is rotated or closed.
+type Repository struct {
+	config Config
+	now    func() time.Time
+
+	mutex    sync.RWMutex
+	segments []*segment
+	index    []entry
+}
+
+// Open opens the log of the directory, rebuilding its index. A torn record at the end of the
+// last segment, left by a crash in the middle of a write, is truncated.
+func Open(config Config) (*Repository, error) {
+	if config.MaxSegmentSize <= 0 {
+		config.MaxSegmentSize = DefaultMaxSegmentSize
+	}
+	if config.MaxSegmentAge <= 0 {
+		config.MaxSegmentAge = DefaultMaxSegmentAge
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+
+	files, err := segmentFiles(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Repository{
+		config: config,
+		now:    time.Now,
+	}
+	for i, file := range files {
+		s, offset, err := openSegment(file, func(s *segment, rate exchange.RateUpdated, offset int64) {
+			r.index = append(r.index, entry{at: rate.At, pair: rate.Pair(), segment: s, offset: offset})
+		})
+		if s != nil {
+			r.segments = append(r.segments, s)
+		}
+		if errors.Is(err, errTornRecord) && i == len(files)-1 {
+			log.Printf("Truncating torn record of segment %d at offset %d", file.id, offset)
+			err = s.truncate(offset)
+		}
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to read segment %d at offset %d: %v", file.id, offset, err)
+		}
+	}
+
+	if len(r.segments) == 0 {
+		s, err := createSegment(config.Dir, 1, r.now())
+		if err != nil {
+			return nil, err
+		}
+		r.segments = append(r.segments, s)
+	}
+
+	// records are appended in arrival order, which is not necessarily the order of At
+	sort.SliceStable(r.index, func(i, j int) bool {
+		return r.index[i].at.Before(r.index[j].at)
+	})
+
+	return r, nil
+}
+
+func (r *Repository) Insert(_ context.Context, rate exchange.RateUpdated) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	active := r.active()
+	if active.count > 0 && (active.size >= r.config.MaxSegmentSize || r.now().Sub(active.createdAt) >= r.config.MaxSegmentAge) {
+		var err error
+		if active, err = r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	offset, _, err := active.append(rate)
+	if err != nil {
+		return err
+	}
+
+	// keep the index sorted by At, after the rates with the same At to preserve the insertion order
+	position := sort.Search(len(r.index), func(i int) bool {
+		return r.index[i].at.After(rate.At)
+	})
+	r.index = append(r.index, entry{})
+	copy(r.index[position+1:], r.index[position:])
+	r.index[position] = entry{at: rate.At, pair: rate.Pair(), segment: active, offset: offset}
+
+	return nil
+}
+
+// ListSince returns all the rates with At newer than the passed since time, sorted by At.
+func (r *Repository) ListSince(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error) {
+	return r.List(ctx, exchange.Query{Since: since})
+}
+
+// List returns the rates matching the query, sorted by At. Only the records of the requested page are read.
+func (r *Repository) List(_ context.Context, query exchange.Query) ([]exchange.RateUpdated, error) {
+	if expiration := r.now().Add(-r.config.TTL); query.Since.Before(expiration) {
+		query.Since = expiration
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	start := sort.Search(len(r.index), func(i int) bool {
+		return r.index[i].at.After(query.Since)
+	})
+	end := len(r.index)
+	if !query.Until.IsZero() {
+		end = start + sort.Search(len(r.index)-start, func(i int) bool {
+			return r.index[start+i].at.After(query.Until)
+		})
+	}
+
+	var (
+		result  []exchange.RateUpdated
+		skipped int
+	)
+	for n := 0; n < end-start; n++ {
+		e := r.index[start+n]
+		if query.Descending {
+			e = r.index[end-1-n]
+		}
+		if query.Pair != "" && e.pair != query.Pair {
+			continue
+		}
+		if skipped < query.Offset {
+			skipped++
+			continue
+		}
+
+		rate, _, err := e.segment.read(e.offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read segment %d at offset %d: %v", e.segment.id, e.offset, err)
+		}
+		result = append(result, rate)
+		if query.Limit > 0 && len(result) == query.Limit {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// RateAt returns the last rate of the pair at or before the time, as long as it is not older than the lookback.
+// A zero lookback only limits it by the TTL. False is returned when there is no such rate.
+func (r *Repository) RateAt(_ context.Context, pair string, at time.Time, lookback time.Duration) (exchange.RateUpdated, bool, error) {
+	expiration := r.now().Add(-r.config.TTL)
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	position := sort.Search(len(r.index), func(i int) bool {
+		return r.index[i].at.After(at)
+	})
+	for i := position - 1; i >= 0; i-- {
+		e := r.index[i]
+		if !e.at.After(expiration) || (lookback > 0 && e.at.Before(at.Add(-lookback))) {
+			break
+		}
+		if e.pair != pair {
+			continue
+		}
+
+		rate, _, err := e.segment.read(e.offset)
+		if err != nil {
+			return exchange.RateUpdated{}, false, fmt.Errorf("failed to read segment %d at offset %d: %v", e.segment.id, e.offset, err)
+		}
+		return rate, true, nil
+	}
+
+	return exchange.RateUpdated{}, false, nil
+}
+
+// EvictExpired deletes the segments whose rates are all older than the TTL,
+// returning the number of rates deleted.
+func (r *Repository) EvictExpired(_ context.Context) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	expiration := r.now().Add(-r.config.TTL)
+
+	// when no rates were received for longer than the TTL, start a new segment so the active one can be deleted
+	if active := r.active(); active.count > 0 && !active.maxAt.After(expiration) {
+		if _, err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	expired := make(map[*segment]bool)
+	kept := r.segments[:0]
+	for _, s := range r.segments {
+		if s != r.active() && !s.maxAt.After(expiration) {
+			expired[s] = true
+			continue
+		}
+		kept = append(kept, s)
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	r.segments = kept
+
+	index := r.index[:0]
+	for _, e := range r.index {
+		if !expired[e.segment] {
+			index = append(index, e)
+		}
+	}
+	clear(r.index[len(index):])
+	r.index = index
+
+	var (
+		evicted int
+		errs    []error
+	)
+	for s := range expired {
+		evicted += s.count
+		if err := s.remove(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete segment %d: %v", s.id, err))
+		}
+	}
+
+	return evicted, errors.Join(errs...)
+}
+
+// Close syncs the active segment and closes all of them.
+func (r *Repository) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var errs []error
+	if len(r.segments) > 0 {
+		errs = append(errs, r.active().file.Sync())
+	}
+	for _, s := range r.segments {
+		errs = append(errs, s.file.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Repository) active() *segment {
+	return r.segments[len(r.segments)-1]
+}
+
+// rotate syncs the active segment and starts a new one.
+func (r *Repository) rotate() (*segment, error) {
+	active := r.active()
+	if err := active.file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync segment %d: %v", active.id, err)
+	}
+
+	s, err := createSegment(r.config.Dir, active.id+1, r.now())
+	if err != nil {
+		return nil, err
+	}
+
+	r.segments = append(r.segments, s)
+	return s, nil
+}
+
+// segmentFiles returns the segments of the directory, sorted by ID.
+func segmentFiles(dir string) ([]segmentFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log directory: %v", err)
+	}
+
+	var files []segmentFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if file, ok := parseSegmentName(dir, entry.Name()); ok {
+			files = append(files, file)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].id < files[j].id })
+	return files, nil
+}
@@ -0,0 +1,267 @@
+package filelog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/repotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_InsertAndListSince(t *testing.T) {
+	ctx := context.Background()
+	repository := openRepository(t, Config{Dir: t.TempDir(), TTL: time.Hour})
+
+	now := time.Now().UTC()
+	rates := []exchange.RateUpdated{
+		{From: "USD", To: "BTC", At: now.Add(-30 * time.Minute), Rate: "84,000.1", Source: "coindesk"},
+		{From: "EUR", To: "BTC", At: now.Add(-20 * time.Minute), Rate: "78,000.2", Source: "coindesk"},
+		{From: "USD", To: "BTC", At: now.Add(-10 * time.Minute), Rate: "84,100.3"},
+	}
+	// inserted out of order, they must be listed sorted by At
+	for _, i := range []int{1, 0, 2} {
+		require.NoError(t, repository.Insert(ctx, rates[i]))
+	}
+
+	result, err := repository.ListSince(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, rates, result)
+
+	result, err = repository.ListSince(ctx, now.Add(-20*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, rates[2:], result)
+
+	result, err = repository.ListSince(ctx, now)
+	require.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+func TestRepository_RebuildsIndexOnOpen(t *testing.T) {
+	ctx := context.Background()
+	config := Config{Dir: t.TempDir(), TTL: time.Hour, MaxSegmentSize: 1}
+
+	repository, err := Open(config)
+	require.NoError(t, err)
+	rates := insertRates(t, repository, time.Now().UTC().Add(-time.Minute), 5)
+	require.NoError(t, repository.Close())
+
+	repository = openRepository(t, config)
+	result, err := repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, rates, result)
+
+	// new records are appended to the last segment
+	rate := exchange.RateUpdated{From: "USD", To: "BTC", At: time.Now().UTC(), Rate: "6"}
+	require.NoError(t, repository.Insert(ctx, rate))
+	result, err = repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, append(rates, rate), result)
+}
+
+func TestRepository_RotatesSegments(t *testing.T) {
+	t.Run("by size", func(t *testing.T) {
+		dir := t.TempDir()
+		repository := openRepository(t, Config{Dir: dir, TTL: time.Hour, MaxSegmentSize: 200})
+
+		insertRates(t, repository, time.Now().UTC(), 10)
+
+		assert.Greater(t, countSegments(t, dir), 1)
+		for _, s := range repository.segments[:len(repository.segments)-1] {
+			assert.GreaterOrEqual(t, s.size, int64(200))
+		}
+	})
+
+	t.Run("by age", func(t *testing.T) {
+		dir := t.TempDir()
+		repository := openRepository(t, Config{Dir: dir, TTL: time.Hour, MaxSegmentAge: time.Minute})
+		now := time.Now()
+		repository.now = func() time.Time { return now }
+
+		insertRates(t, repository, now, 2)
+		assert.Equal(t, 1, countSegments(t, dir))
+
+		now = now.Add(time.Minute)
+		insertRates(t, repository, now, 1)
+		assert.Equal(t, 2, countSegments(t, dir))
+	})
+
+	t.Run("by age since the creation of a reopened segment", func(t *testing.T) {
+		dir := t.TempDir()
+		config := Config{Dir: dir, TTL: time.Hour, MaxSegmentAge: time.Minute}
+		repository, err := Open(config)
+		require.NoError(t, err)
+		createdAt := time.Now()
+		insertRates(t, repository, createdAt, 1)
+
+		// written again right before the restart
+		now := createdAt.Add(50 * time.Second)
+		repository.now = func() time.Time { return now }
+		insertRates(t, repository, now, 1)
+		require.NoError(t, repository.Close())
+		require.NoError(t, os.Chtimes(repository.segments[0].path, now, now))
+
+		repository = openRepository(t, config)
+		now = createdAt.Add(time.Minute + time.Second)
+		repository.now = func() time.Time { return now }
+		insertRates(t, repository, now, 1)
+		assert.Equal(t, 2, countSegments(t, dir))
+	})
+}
+
+func TestRepository_OpensSegmentsNamedAfterTheirID(t *testing.T) {
+	config := Config{Dir: t.TempDir(), TTL: time.Hour}
+	repository, err := Open(config)
+	require.NoError(t, err)
+	rates := insertRates(t, repository, time.Now().UTC().Add(-time.Minute), 2)
+	require.NoError(t, repository.Close())
+
+	// segments created by older versions are only named after their ID
+	legacy := filepath.Join(config.Dir, fmt.Sprintf("%020d%s", 1, segmentExtension))
+	require.NoError(t, os.Rename(repository.segments[0].path, legacy))
+
+	repository = openRepository(t, config)
+	result, err := repository.ListSince(context.Background(), time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, rates, result)
+}
+
+func TestRepository_TTL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repository := openRepository(t, Config{Dir: dir, TTL: time.Hour, MaxSegmentSize: 1})
+
+	now := time.Now().UTC()
+	expired := insertRates(t, repository, now.Add(-3*time.Hour), 2)
+	fresh := insertRates(t, repository, now.Add(-time.Minute), 2)
+	require.Equal(t, 4, countSegments(t, dir))
+
+	// expired rates are never returned, even before being evicted
+	result, err := repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, fresh, result)
+
+	evicted, err := repository.EvictExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(expired), evicted)
+	assert.Equal(t, 2, countSegments(t, dir))
+
+	result, err = repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, fresh, result)
+
+	// once everything expired, the active segment is deleted too
+	repository.now = func() time.Time { return now.Add(2 * time.Hour) }
+	evicted, err = repository.EvictExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(fresh), evicted)
+	assert.Equal(t, 1, countSegments(t, dir))
+	assert.Empty(t, repository.index)
+}
+
+func TestRepository_RecoversFromTornWrite(t *testing.T) {
+	ctx := context.Background()
+	testCases := map[string]func(content []byte) []byte{
+		"partial header": func(content []byte) []byte {
+			return append(content, 0, 0)
+		},
+		"partial payload": func(content []byte) []byte {
+			return content[:len(content)-3]
+		},
+		"checksum mismatch": func(content []byte) []byte {
+			content[len(content)-2] ^= 0xff
+			return content
+		},
+	}
+
+	for name, tear := range testCases {
+		t.Run(name, func(t *testing.T) {
+			config := Config{Dir: t.TempDir(), TTL: time.Hour}
+			repository, err := Open(config)
+			require.NoError(t, err)
+			rates := insertRates(t, repository, time.Now().UTC().Add(-time.Minute), 3)
+			require.NoError(t, repository.Close())
+
+			path := repository.segments[0].path
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(path, tear(content), 0o644))
+
+			repository = openRepository(t, config)
+			result, err := repository.ListSince(ctx, time.Time{})
+			require.NoError(t, err)
+			if name == "partial header" {
+				assert.Equal(t, rates, result)
+			} else {
+				// the last record was the torn one
+				assert.Equal(t, rates[:2], result)
+			}
+
+			rate := exchange.RateUpdated{From: "USD", To: "BTC", At: time.Now().UTC(), Rate: "4"}
+			require.NoError(t, repository.Insert(ctx, rate))
+			result, err = repository.ListSince(ctx, time.Time{})
+			require.NoError(t, err)
+			assert.Equal(t, rate, result[len(result)-1])
+		})
+	}
+}
+
+func TestRepository_FailsOnCorruptedSegment(t *testing.T) {
+	config := Config{Dir: t.TempDir(), TTL: time.Hour, MaxSegmentSize: 1}
+	repository, err := Open(config)
+	require.NoError(t, err)
+	insertRates(t, repository, time.Now().UTC(), 2)
+	require.NoError(t, repository.Close())
+
+	// only the last segment can have a torn record, any other is corrupted
+	path := repository.segments[0].path
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	content[len(content)-2] ^= 0xff
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+
+	_, err = Open(config)
+	assert.ErrorContains(t, err, "failed to read segment 1")
+}
+
+func openRepository(t *testing.T, config Config) *Repository {
+	t.Helper()
+
+	repository, err := Open(config)
+	require.NoError(t, err)
+	t.Cleanup(func() { repository.Close() })
+
+	return repository
+}
+
+// insertRates inserts count rates one second apart starting at from.
+func insertRates(t *testing.T, repository *Repository, from time.Time, count int) []exchange.RateUpdated {
+	t.Helper()
+
+	rates := make([]exchange.RateUpdated, count)
+	for i := range rates {
+		rates[i] = exchange.RateUpdated{From: "USD", To: "BTC", At: from.Add(time.Duration(i) * time.Second), Rate: "1", Source: "coindesk"}
+		require.NoError(t, repository.Insert(context.Background(), rates[i]))
+	}
+
+	return rates
+}
+
+func countSegments(t *testing.T, dir string) int {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	require.NoError(t, err)
+	return len(files)
+}
+
+func TestRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T, ttl time.Duration) repotest.Repository {
+		return openRepository(t, Config{Dir: t.TempDir(), TTL: ttl})
+	})
+}
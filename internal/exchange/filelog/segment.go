@@ -0,0 +1,207 @@
+package filelog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+)
+
+const (
+	// headerSize is the size of the header preceding every record: the length of the payload
+	// followed by its CRC-32 (Castagnoli), both big endian.
+	headerSize = 8
+	// maxRecordSize protects against allocating huge buffers when reading a corrupted length.
+	maxRecordSize = 1 << 20
+
+	segmentExtension = ".log"
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// errTornRecord is returned when a record is incomplete or does not match its checksum,
+	// which happens when the process crashed in the middle of a write.
+	errTornRecord = errors.New("torn record")
+)
+
+// segment is a file of the log. Records are only appended to the last segment of the log,
+// the other ones are read only until they are deleted.
+type segment struct {
+	id        uint64
+	path      string
+	file      *os.File
+	size      int64
+	createdAt time.Time
+	// maxAt is the newest At of the records of the segment, used to know when it is expired.
+	maxAt time.Time
+	count int
+}
+
+// segmentFile is a segment found in the log directory. Segments are named after their ID and creation time,
+// the ones named only after their ID were created by older versions, which did not keep the creation time.
+type segmentFile struct {
+	id        uint64
+	path      string
+	createdAt time.Time
+}
+
+// segmentName returns the name of a segment, e.g. 00000000000000000001-1767225600.log, sorted by ID.
+func segmentName(id uint64, createdAt time.Time) string {
+	return fmt.Sprintf("%020d-%d%s", id, createdAt.Unix(), segmentExtension)
+}
+
+// parseSegmentName returns the segment file of the name, false when it is not a segment.
+func parseSegmentName(dir, name string) (segmentFile, bool) {
+	base, ok := strings.CutSuffix(name, segmentExtension)
+	if !ok {
+		return segmentFile{}, false
+	}
+
+	idPart, createdPart, named := strings.Cut(base, "-")
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		return segmentFile{}, false
+	}
+	file := segmentFile{id: id, path: filepath.Join(dir, name)}
+	if named {
+		created, err := strconv.ParseInt(createdPart, 10, 64)
+		if err != nil {
+			return segmentFile{}, false
+		}
+		file.createdAt = time.Unix(created, 0)
+	}
+	return file, true
+}
+
+func createSegment(dir string, id uint64, now time.Time) (*segment, error) {
+	path := filepath.Join(dir, segmentName(id, now))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment: %v", err)
+	}
+
+	return &segment{id: id, path: path, file: file, createdAt: now}, nil
+}
+
+// openSegment opens an existing segment and calls fn with every valid record, in the order they were appended.
+// It stops at the first torn record, returning its offset together with errTornRecord.
+func openSegment(f segmentFile, fn func(s *segment, rate exchange.RateUpdated, offset int64)) (*segment, int64, error) {
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open segment: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to open segment: %v", err)
+	}
+
+	s := &segment{id: f.id, path: f.path, file: file, createdAt: f.createdAt}
+	if s.createdAt.IsZero() {
+		// the segments of older versions only have the time of their last write
+		s.createdAt = info.ModTime()
+	}
+	for s.size < info.Size() {
+		rate, length, err := s.read(s.size)
+		if err != nil {
+			return s, s.size, err
+		}
+
+		fn(s, rate, s.size)
+		s.track(rate, length)
+	}
+
+	return s, s.size, nil
+}
+
+// append writes the record at the end of the segment, returning its offset and length.
+// The header and the payload are written at once, so a crash can only leave the last record torn.
+func (s *segment) append(rate exchange.RateUpdated) (int64, int, error) {
+	payload, err := json.Marshal(rate)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[headerSize:], payload)
+
+	offset := s.size
+	if _, err := s.file.Write(record); err != nil {
+		// do not leave a partial record behind that would be followed by valid ones
+		s.file.Truncate(offset)
+		return 0, 0, fmt.Errorf("failed to append record: %v", err)
+	}
+
+	s.track(rate, len(record))
+	return offset, len(record), nil
+}
+
+// read decodes the record at the offset, returning it together with its length.
+func (s *segment) read(offset int64) (exchange.RateUpdated, int, error) {
+	header := make([]byte, headerSize)
+	if _, err := s.file.ReadAt(header, offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return exchange.RateUpdated{}, 0, errTornRecord
+		}
+		return exchange.RateUpdated{}, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return exchange.RateUpdated{}, 0, errTornRecord
+	}
+
+	payload := make([]byte, length)
+	if _, err := s.file.ReadAt(payload, offset+headerSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return exchange.RateUpdated{}, 0, errTornRecord
+		}
+		return exchange.RateUpdated{}, 0, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return exchange.RateUpdated{}, 0, errTornRecord
+	}
+
+	var rate exchange.RateUpdated
+	if err := json.Unmarshal(payload, &rate); err != nil {
+		return exchange.RateUpdated{}, 0, errTornRecord
+	}
+	rate.At = rate.At.UTC()
+
+	return rate, headerSize + int(length), nil
+}
+
+// truncate drops everything after the offset, used to remove a torn record.
+func (s *segment) truncate(offset int64) error {
+	if err := s.file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate segment: %v", err)
+	}
+	s.size = offset
+	return nil
+}
+
+func (s *segment) track(rate exchange.RateUpdated, length int) {
+	s.size += int64(length)
+	s.count++
+	if rate.At.After(s.maxAt) {
+		s.maxAt = rate.At
+	}
+}
+
+func (s *segment) remove() error {
+	s.file.Close()
+	return os.Remove(s.path)
+}
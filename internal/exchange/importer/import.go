@@ -0,0 +1,74 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+)
+
+// Stats counts what happened to the records of an import.
+type Stats struct {
+	// Imported are the rates inserted into the repository.
+	Imported int
+	// Duplicated are the rates found earlier in the same file.
+	Duplicated int
+	// Skipped are the rates not inserted by the repository, e.g. because it already has them.
+	Skipped int
+	// Rejected are the lines that are not valid rates.
+	Rejected int
+}
+
+// InsertFunc inserts a rate, returning whether it was inserted.
+type InsertFunc func(ctx context.Context, rate exchange.RateUpdated) (bool, error)
+
+// RejectFunc is called with every line that is not a valid rate and the reason.
+type RejectFunc func(line int, reason error)
+
+// key identifies the duplicated rates.
+type key struct {
+	pair   string
+	at     time.Time
+	source string
+}
+
+// Import inserts the valid rates read from the reader, once each pair, time and source, and reports the invalid ones.
+func Import(ctx context.Context, reader Reader, insert InsertFunc, reject RejectFunc) (Stats, error) {
+	var stats Stats
+	seen := make(map[key]struct{})
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		if record.Err != nil {
+			stats.Rejected++
+			reject(record.Line, record.Err)
+			continue
+		}
+
+		// times are compared in UTC, as the same instant could be written with different offsets
+		k := key{pair: record.Rate.Pair(), at: record.Rate.At.UTC(), source: record.Rate.Source}
+		if _, ok := seen[k]; ok {
+			stats.Duplicated++
+			continue
+		}
+		seen[k] = struct{}{}
+
+		inserted, err := insert(ctx, record.Rate)
+		if err != nil {
+			return stats, fmt.Errorf("failed to insert the rate of line %d: %v", record.Line, err)
+		}
+		if inserted {
+			stats.Imported++
+		} else {
+			stats.Skipped++
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	at := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	repository := exchange.NewInMemoryRepository(24*time.Hour, 0)
+	persisted := exchange.RateUpdated{From: "EUR", To: "BTC", At: at, Rate: "2", Source: "coindesk"}
+	require.NoError(t, repository.Insert(ctx, persisted))
+
+	input := "from,to,at,rate,source\n" +
+		"USD,BTC," + at.Format(time.RFC3339) + ",1,coindesk\n" +
+		// the same pair, time and source, with another offset
+		"USD,BTC," + at.In(time.FixedZone("CET", 3600)).Format(time.RFC3339) + ",1.5,coindesk\n" +
+		// another source
+		"USD,BTC," + at.Format(time.RFC3339) + ",1.1,binance\n" +
+		// already in the repository
+		"EUR,BTC," + at.Format(time.RFC3339) + ",2,coindesk\n" +
+		"USD,BTC," + at.Format(time.RFC3339) + ",one,coindesk\n"
+	reader, err := NewReader("csv", strings.NewReader(input))
+	require.NoError(t, err)
+
+	rejected := map[int]string{}
+	stats, err := Import(ctx, reader, func(ctx context.Context, rate exchange.RateUpdated) (bool, error) {
+		return exchange.InsertMissing(ctx, repository, rate)
+	}, func(line int, reason error) {
+		rejected[line] = reason.Error()
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, Stats{Imported: 2, Duplicated: 1, Skipped: 1, Rejected: 1}, stats)
+	assert.Equal(t, map[int]string{6: `invalid rate "one", must be a positive number`}, rejected)
+
+	rates, err := repository.List(ctx, exchange.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []exchange.RateUpdated{
+		persisted,
+		{From: "USD", To: "BTC", At: at, Rate: "1", Source: "coindesk"},
+		{From: "USD", To: "BTC", At: at, Rate: "1.1", Source: "binance"},
+	}, rates)
+}
+
+func TestImport_InsertError(t *testing.T) {
+	reader, err := NewReader("jsonl", strings.NewReader(`{"from":"USD","to":"BTC","at":"2026-03-01T12:00:00Z","rate":"1"}`))
+	require.NoError(t, err)
+
+	_, err = Import(context.Background(), reader, func(context.Context, exchange.RateUpdated) (bool, error) {
+		return false, errors.New("database is locked")
+	}, func(int, error) {})
+	assert.EqualError(t, err, "failed to insert the rate of line 1: database is locked")
+}
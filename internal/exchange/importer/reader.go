@@ -0,0 +1,162 @@
+// Package importer loads rates from the files written by the export package, validating every one of them.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/export"
+)
+
+// maxLineSize is the longest line of a JSON Lines file.
+const maxLineSize = 1024 * 1024
+
+// Record is a rate read from a line of a file. Err is why the line is not a valid rate.
+type Record struct {
+	Line int
+	Rate exchange.RateUpdated
+	Err  error
+}
+
+// Reader reads the records of a file one at a time. Next returns io.EOF once all of them are read.
+type Reader interface {
+	Next() (Record, error)
+}
+
+// NewReader creates the reader of the format, reading from r.
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch format {
+	case export.FormatCSV:
+		return newCSVReader(r)
+	case export.FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, maxLineSize)
+		return &jsonlReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q, must be one of: %s, %s", format, export.FormatCSV, export.FormatJSONL)
+	}
+}
+
+// Validate returns why the rate does not fit the RateUpdated schema, nil when it does.
+func Validate(rate exchange.RateUpdated) error {
+	for _, currency := range []struct{ field, value string }{{"from", rate.From}, {"to", rate.To}} {
+		if currency.value == "" {
+			return fmt.Errorf("missing %s currency", currency.field)
+		}
+		if strings.Contains(currency.value, "-") {
+			return fmt.Errorf("invalid %s currency %q", currency.field, currency.value)
+		}
+	}
+	if rate.At.IsZero() {
+		return errors.New("missing at time")
+	}
+	value, err := rate.Value()
+	if err != nil || !(value > 0) || math.IsInf(value, 1) {
+		return fmt.Errorf("invalid rate %q, must be a positive number", rate.Rate)
+	}
+	return nil
+}
+
+// csvColumns are the columns a CSV file must have, source is optional.
+var csvColumns = []string{"from", "to", "at", "rate"}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CSV header: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.TrimSpace(column)] = i
+	}
+	for _, column := range csvColumns {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("missing %s column in the CSV header, it must have: %s", column, strings.Join(export.CSVHeader, ","))
+		}
+	}
+
+	return &csvReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvReader) Next() (Record, error) {
+	fields, err := r.reader.Read()
+	if err == io.EOF {
+		return Record{}, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return Record{Line: parseErr.Line, Err: parseErr.Err}, nil
+	}
+	if err != nil {
+		return Record{}, err
+	}
+
+	line, _ := r.reader.FieldPos(0)
+	field := func(column string) string {
+		i, ok := r.columns[column]
+		if !ok || i >= len(fields) {
+			return ""
+		}
+		return strings.TrimSpace(fields[i])
+	}
+	if len(fields) != len(r.columns) {
+		return Record{Line: line, Err: fmt.Errorf("has %d fields instead of %d", len(fields), len(r.columns))}, nil
+	}
+
+	rate := exchange.RateUpdated{From: field("from"), To: field("to"), Rate: field("rate"), Source: field("source")}
+	if at := field("at"); at != "" {
+		if rate.At, err = time.Parse(time.RFC3339Nano, at); err != nil {
+			return Record{Line: line, Err: fmt.Errorf("invalid at time %q, must be RFC 3339", at)}, nil
+		}
+	}
+
+	return Record{Line: line, Rate: rate, Err: Validate(rate)}, nil
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *jsonlReader) Next() (Record, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		var rate exchange.RateUpdated
+		if err := decoder.Decode(&rate); err != nil {
+			return Record{Line: r.line, Err: fmt.Errorf("invalid JSON: %v", err)}, nil
+		}
+		if decoder.More() {
+			return Record{Line: r.line, Err: errors.New("invalid JSON: more than one value in the line")}, nil
+		}
+
+		return Record{Line: r.line, Rate: rate, Err: Validate(rate)}, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Record{}, fmt.Errorf("failed to read line %d: %v", r.line+1, err)
+	}
+	return Record{}, io.EOF
+}
@@ -0,0 +1,121 @@
+package importer
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReader(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 1, time.UTC)
+	valid := exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: "69,420.00", Source: "coindesk"}
+
+	tests := []struct {
+		name        string
+		format      string
+		input       string
+		expected    []Record
+		expectedErr string
+	}{
+		{
+			name:   "csv",
+			format: "csv",
+			input: "from,to,at,rate,source\n" +
+				"USD,BTC,2026-03-01T12:00:00.000000001Z,\"69,420.00\",coindesk\n" +
+				"USD,BTC,yesterday,1,coindesk\n" +
+				"USD,BTC,2026-03-01T12:00:00Z,-1,coindesk\n" +
+				"USD,BTC\n" +
+				",BTC,2026-03-01T12:00:00Z,1,\n" +
+				"USD,BTC,2026-03-01T12:00:00Z,\"1,coindesk\n",
+			expected: []Record{
+				{Line: 2, Rate: valid},
+				{Line: 3, Err: errorString(`invalid at time "yesterday", must be RFC 3339`)},
+				{Line: 4, Err: errorString(`invalid rate "-1", must be a positive number`)},
+				{Line: 5, Err: errorString("has 2 fields instead of 5")},
+				{Line: 6, Err: errorString("missing from currency")},
+				{Line: 7, Err: errorString(`extraneous or missing " in quoted-field`)},
+			},
+		},
+		{
+			name:   "csv columns in any order and without source",
+			format: "csv",
+			input:  "rate,at,to,from\n1,2026-03-01T12:00:00.000000001Z,BTC,EUR\n",
+			expected: []Record{
+				{Line: 2, Rate: exchange.RateUpdated{From: "EUR", To: "BTC", At: at, Rate: "1"}},
+			},
+		},
+		{
+			name:        "csv without a required column",
+			format:      "csv",
+			input:       "from,to,rate\nUSD,BTC,1\n",
+			expectedErr: "missing at column in the CSV header",
+		},
+		{
+			name:   "jsonl",
+			format: "jsonl",
+			input: `{"from":"USD","to":"BTC","at":"2026-03-01T12:00:00.000000001Z","rate":"69,420.00","source":"coindesk"}` + "\n" +
+				"\n" +
+				`{"from":"USD","to":"BTC","at":"2026-03-01T12:00:00Z","rate":"NaN"}` + "\n" +
+				`{"from":"USD","to":"BTC","at":"2026-03-01T12:00:00Z","rate":"1","price":1}` + "\n" +
+				`{"from":"USD","to":"BTC-EUR","at":"2026-03-01T12:00:00Z","rate":"1"}` + "\n" +
+				`{"from":"USD","to":"BTC","rate":"1"}` + "\n" +
+				`{"from":"USD"`,
+			expected: []Record{
+				{Line: 1, Rate: valid},
+				{Line: 3, Err: errorString(`invalid rate "NaN", must be a positive number`)},
+				{Line: 4, Err: errorString(`invalid JSON: json: unknown field "price"`)},
+				{Line: 5, Err: errorString(`invalid to currency "BTC-EUR"`)},
+				{Line: 6, Err: errorString("missing at time")},
+				{Line: 7, Err: errorString("invalid JSON: unexpected EOF")},
+			},
+		},
+		{
+			name:        "unsupported format",
+			format:      "parquet",
+			expectedErr: `unsupported format "parquet"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := NewReader(tt.format, strings.NewReader(tt.input))
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var records []Record
+			for {
+				record, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				records = append(records, record)
+			}
+
+			require.Len(t, records, len(tt.expected))
+			for i, record := range records {
+				assert.Equal(t, tt.expected[i].Line, record.Line)
+				if tt.expected[i].Err != nil {
+					assert.EqualError(t, record.Err, tt.expected[i].Err.Error())
+					continue
+				}
+				assert.NoError(t, record.Err)
+				assert.Equal(t, tt.expected[i].Rate, record.Rate)
+			}
+		})
+	}
+}
+
+type errorString string
+
+func (e errorString) Error() string {
+	return string(e)
+}
@@ -0,0 +1,253 @@
+// Package kafka implements the update log over a Kafka topic, so the updates are kept for auditing and read again
+// from any offset, and the consumer groups commit their offsets to the brokers, resuming from them after a restart.
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	// DefaultTopic is the topic the updates are appended to.
+	DefaultTopic = "rates"
+	// DefaultRetention is how long the topic keeps the updates when it is created.
+	DefaultRetention = 7 * 24 * time.Hour
+
+	// partition is the only partition the updates are appended to, so all of them are ordered and a single offset
+	// locates any of them.
+	partition int32 = 0
+)
+
+type Config struct {
+	// Brokers are the addresses of the brokers the clients are bootstrapped from.
+	Brokers []string
+	// Topic the updates are appended to, created with a single partition if it does not exist.
+	Topic string
+	// Retention is how long the topic keeps the updates when it is created.
+	Retention time.Duration
+}
+
+type Log struct {
+	config Config
+	client *kgo.Client
+	admin  *kadm.Client
+
+	mutex   sync.Mutex
+	readers map[*reader]struct{}
+	closed  bool
+}
+
+type reader struct {
+	cancel context.CancelFunc
+}
+
+// NewLog connects to the brokers, creating the topic if it does not exist.
+func NewLog(ctx context.Context, config Config) (*Log, error) {
+	if config.Topic == "" {
+		config.Topic = DefaultTopic
+	}
+	if config.Retention == 0 {
+		config.Retention = DefaultRetention
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.DefaultProduceTopic(config.Topic),
+		kgo.RecordPartitioner(kgo.ManualPartitioner()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the Kafka client: %v", err)
+	}
+	l := &Log{
+		config:  config,
+		client:  client,
+		admin:   kadm.NewClient(client),
+		readers: make(map[*reader]struct{}),
+	}
+
+	retention := strconv.FormatInt(config.Retention.Milliseconds(), 10)
+	_, err = l.admin.CreateTopic(ctx, 1, -1, map[string]*string{"retention.ms": &retention}, config.Topic)
+	if err != nil && !errors.Is(err, kerr.TopicAlreadyExists) {
+		client.Close()
+		return nil, fmt.Errorf("failed to create the %s topic: %v", config.Topic, err)
+	}
+
+	return l, nil
+}
+
+// Publish appends the update to the topic, waiting for the brokers to acknowledge it so it is not lost.
+func (l *Log) Publish(ctx context.Context, rate exchange.RateUpdated) error {
+	if l.isClosed() {
+		return exchange.ErrTopicClosed
+	}
+	payload, err := json.Marshal(rate)
+	if err != nil {
+		return fmt.Errorf("failed to encode the rate: %v", err)
+	}
+
+	record := &kgo.Record{Key: []byte(rate.Pair()), Value: payload, Partition: partition}
+	if err := l.client.ProduceSync(ctx, record).FirstErr(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %v", l.config.Topic, err)
+	}
+	return nil
+}
+
+func (l *Log) Subscribe(ctx context.Context) (<-chan exchange.RateUpdated, error) {
+	records, err := l.Read(ctx, exchange.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+	return exchange.Rates(ctx, records), nil
+}
+
+// Read consumes the topic from the offset with a client of its own.
+func (l *Log) Read(ctx context.Context, offset int64) (<-chan exchange.Record, error) {
+	start := kgo.NewOffset().AtStart()
+	if offset == exchange.OffsetNewest {
+		// resolve it now, so the updates published once Read returns are received
+		offsets, err := l.admin.ListEndOffsets(ctx, l.config.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the end offset of %s: %v", l.config.Topic, err)
+		}
+		end, ok := offsets.Lookup(l.config.Topic, partition)
+		if !ok || end.Err != nil {
+			return nil, fmt.Errorf("failed to get the end offset of %s: %v", l.config.Topic, end.Err)
+		}
+		offset = end.Offset
+	}
+	if offset >= 0 {
+		start = kgo.NewOffset().At(offset)
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(l.config.Brokers...),
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{l.config.Topic: {partition: start}}),
+		// offsets no longer kept are read from the oldest one
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the Kafka client: %v", err)
+	}
+
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		client.Close()
+		return nil, exchange.ErrTopicClosed
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r := &reader{cancel: cancel}
+	l.readers[r] = struct{}{}
+	l.mutex.Unlock()
+
+	records := make(chan exchange.Record)
+	go func() {
+		defer close(records)
+		defer l.stopReading(r)
+		defer client.Close()
+
+		for {
+			fetches := client.PollFetches(ctx)
+			if ctx.Err() != nil || fetches.IsClientClosed() {
+				return
+			}
+			fetches.EachError(func(topic string, _ int32, err error) {
+				log.Printf("Failed to consume the %s topic: %v", topic, err)
+			})
+
+			for _, record := range fetches.Records() {
+				var rate exchange.RateUpdated
+				if err := json.Unmarshal(record.Value, &rate); err != nil {
+					log.Printf("Discarding invalid rate update at offset %d of %s: %v", record.Offset, record.Topic, err)
+					continue
+				}
+				select {
+				case records <- exchange.Record{Offset: record.Offset, Rate: rate}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return records, nil
+}
+
+// Committed fetches the offset committed by the consumer group.
+func (l *Log) Committed(ctx context.Context, group string) (int64, error) {
+	offsets, err := l.admin.FetchOffsets(ctx, group)
+	if errors.Is(err, kerr.GroupIDNotFound) {
+		return exchange.OffsetOldest, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch the offsets of %s: %v", group, err)
+	}
+	offset, ok := offsets.Lookup(l.config.Topic, partition)
+	if !ok {
+		return exchange.OffsetOldest, nil
+	}
+	if offset.Err != nil {
+		return 0, fmt.Errorf("failed to fetch the offsets of %s: %v", group, offset.Err)
+	}
+	if offset.At < 0 {
+		return exchange.OffsetOldest, nil
+	}
+	return offset.At, nil
+}
+
+// Commit commits the offset of the consumer group. The group has no members, its offsets are only
+// managed through Commit.
+func (l *Log) Commit(ctx context.Context, group string, offset int64) error {
+	offsets := make(kadm.Offsets)
+	offsets.Add(kadm.Offset{Topic: l.config.Topic, Partition: partition, At: offset, LeaderEpoch: -1})
+	if err := l.admin.CommitAllOffsets(ctx, group, offsets); err != nil {
+		return fmt.Errorf("failed to commit the offset of %s: %v", group, err)
+	}
+	return nil
+}
+
+// Close stops every reader and closes the client, no update can be published afterwards.
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil
+	}
+	l.closed = true
+	readers := make([]*reader, 0, len(l.readers))
+	for r := range l.readers {
+		readers = append(readers, r)
+	}
+	l.mutex.Unlock()
+
+	for _, r := range readers {
+		r.cancel()
+	}
+	l.client.Close()
+	return nil
+}
+
+func (l *Log) isClosed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.closed
+}
+
+func (l *Log) stopReading(r *reader) {
+	r.cancel()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.readers, r)
+}
@@ -0,0 +1,130 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+)
+
+func TestLog(t *testing.T) {
+	ctx := context.Background()
+	brokers := runCluster(t)
+	rates := testRates(4)
+
+	// two logs with their own clients, like a provider and a broadcaster running as separate processes
+	provider, broadcaster := newLog(t, brokers), newLog(t, brokers)
+	for _, rate := range rates[:2] {
+		require.NoError(t, provider.Publish(ctx, rate))
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	updates, err := broadcaster.Subscribe(cancelled)
+	require.NoError(t, err)
+	oldest, err := broadcaster.Read(ctx, exchange.OffsetOldest)
+	require.NoError(t, err)
+	fromOffset, err := broadcaster.Read(ctx, 1)
+	require.NoError(t, err)
+	for _, rate := range rates[2:] {
+		require.NoError(t, provider.Publish(ctx, rate))
+	}
+
+	// subscriptions only receive the updates published after they are created
+	assert.Equal(t, rates[2], receive(t, updates))
+	assert.Equal(t, rates[3], receive(t, updates))
+	for i, rate := range rates {
+		assert.Equal(t, exchange.Record{Offset: int64(i), Rate: rate}, receiveRecord(t, oldest))
+	}
+	for i, rate := range rates[1:] {
+		assert.Equal(t, exchange.Record{Offset: int64(i + 1), Rate: rate}, receiveRecord(t, fromOffset))
+	}
+
+	// a subscription is closed once its context is done
+	cancel()
+	assertClosed(t, updates)
+
+	// and every reader once the log is closed
+	require.NoError(t, broadcaster.Close())
+	assertClosed(t, exchange.Rates(ctx, oldest))
+	assert.ErrorIs(t, broadcaster.Publish(ctx, rates[0]), exchange.ErrTopicClosed)
+	_, err = broadcaster.Read(ctx, exchange.OffsetOldest)
+	assert.ErrorIs(t, err, exchange.ErrTopicClosed)
+}
+
+func TestLog_CommittedOffsets(t *testing.T) {
+	ctx := context.Background()
+	brokers := runCluster(t)
+
+	offset, err := newLog(t, brokers).Committed(ctx, "persister")
+	require.NoError(t, err)
+	assert.Equal(t, exchange.OffsetOldest, offset)
+
+	require.NoError(t, newLog(t, brokers).Commit(ctx, "persister", 3))
+
+	// the offsets are kept by the brokers, so they are known by a new process
+	offset, err = newLog(t, brokers).Committed(ctx, "persister")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), offset)
+}
+
+func runCluster(t *testing.T) []string {
+	t.Helper()
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1))
+	require.NoError(t, err)
+	t.Cleanup(cluster.Close)
+	return cluster.ListenAddrs()
+}
+
+func newLog(t *testing.T, brokers []string) *Log {
+	t.Helper()
+	l, err := NewLog(context.Background(), Config{Brokers: brokers})
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func testRates(count int) []exchange.RateUpdated {
+	rates := make([]exchange.RateUpdated, count)
+	for i := range rates {
+		rates[i] = exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(int64(1000+i), 0).UTC(), Rate: "69,420.00", Source: "coindesk"}
+	}
+	return rates
+}
+
+func receive(t *testing.T, updates <-chan exchange.RateUpdated) exchange.RateUpdated {
+	t.Helper()
+	select {
+	case update, ok := <-updates:
+		require.True(t, ok, "subscription closed")
+		return update
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no update received")
+		return exchange.RateUpdated{}
+	}
+}
+
+func receiveRecord(t *testing.T, records <-chan exchange.Record) exchange.Record {
+	t.Helper()
+	select {
+	case record, ok := <-records:
+		require.True(t, ok, "log closed")
+		return record
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no record received")
+		return exchange.Record{}
+	}
+}
+
+func assertClosed(t *testing.T, updates <-chan exchange.RateUpdated) {
+	t.Helper()
+	select {
+	case _, ok := <-updates:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "subscription not closed")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package leader
+
+import (
+	"errors"
+	"io"
+)
+
+type lockFile = io.Closer
+
+func tryLock(path string) (lockFile, error) {
+	return nil, errors.New("file locks are only supported on unix systems")
+}
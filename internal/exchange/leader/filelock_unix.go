@@ -0,0 +1,29 @@
+//go:build unix
+
+package leader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile is a file locked exclusively, unlocked once closed.
+type lockFile = *os.File
+
+// tryLock locks the file, returning nil when it is locked by another process.
+func tryLock(path string) (lockFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the lock file: %v", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+	return file, nil
+}
@@ -0,0 +1,232 @@
+// Package leader elects a single leader among the replicas of the service, so only one of them polls the
+// upstream providers while the rest just relay the updates of the shared topic.
+package leader
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long a lease is held without being renewed.
+	DefaultTTL = 10 * time.Second
+	// DefaultRetryInterval is how often the followers try to become the leader.
+	DefaultRetryInterval = time.Second
+)
+
+// Elector campaigns for the leadership on behalf of a replica.
+type Elector interface {
+	// Campaign blocks until the replica is elected or ctx is done. The returned context is done once the
+	// leadership is lost, or ctx is done.
+	Campaign(ctx context.Context) (context.Context, error)
+	// Resign gives up the leadership, so another replica is elected without waiting for it to expire.
+	Resign(ctx context.Context) error
+}
+
+// Run calls lead every time the replica is elected, until ctx is done. The context of lead is done once the
+// leadership is lost, and lead must return then, so the leadership is resigned. Failed campaigns are retried
+// every DefaultRetryInterval, so the replica keeps taking part in the election.
+func Run(ctx context.Context, elector Elector, lead func(ctx context.Context)) {
+	for {
+		term, err := elector.Campaign(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to campaign for the leadership, retrying in %s: %v", DefaultRetryInterval, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(DefaultRetryInterval):
+			}
+			continue
+		}
+
+		log.Println("Elected as the leader")
+		lead(term)
+
+		// resign even when ctx is done, so another replica takes over straight away
+		resignCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultRetryInterval)
+		if err := elector.Resign(resignCtx); err != nil {
+			log.Printf("Failed to resign the leadership: %v", err)
+		}
+		cancel()
+		log.Println("No longer the leader")
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// LeaseStore keeps the leases in a store shared by every replica.
+type LeaseStore interface {
+	// Acquire takes the lease for the holder until the TTL expires, when it is free, expired or already held
+	// by the same holder, which renews it. It returns whether the holder holds the lease.
+	Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	// Release frees the lease when it is held by the holder.
+	Release(ctx context.Context, name, holder string) error
+}
+
+type LeaseConfig struct {
+	// Name of the lease, shared by every replica.
+	Name string
+	// Holder identifies the replica, it must be unique.
+	Holder string
+	// TTL is how long the lease is held without being renewed, and so how long the leadership takes to be
+	// handed over when the leader is lost without resigning. The lease is renewed every third of it.
+	TTL time.Duration
+	// RetryInterval is how often the followers try to acquire the lease.
+	RetryInterval time.Duration
+}
+
+// LeaseElector elects the replica holding a lease of the store. The leader renews the lease, and gives up
+// the leadership as soon as it is renewed by someone else, or before it expires when it cannot be renewed.
+type LeaseElector struct {
+	store  LeaseStore
+	config LeaseConfig
+	now    func() time.Time
+}
+
+func NewLeaseElector(store LeaseStore, config LeaseConfig) *LeaseElector {
+	if config.TTL <= 0 {
+		config.TTL = DefaultTTL
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = DefaultRetryInterval
+	}
+
+	return &LeaseElector{
+		store:  store,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+func (e *LeaseElector) Campaign(ctx context.Context) (context.Context, error) {
+	ticker := time.NewTicker(e.config.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		// the lease expires TTL after it was requested at the latest
+		acquiredAt := e.now()
+		acquired, err := e.store.Acquire(ctx, e.config.Name, e.config.Holder, e.config.TTL)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to acquire the %s lease: %v", e.config.Name, err)
+		}
+		if acquired {
+			term, cancel := context.WithCancel(ctx)
+			go e.renew(term, cancel, acquiredAt.Add(e.config.TTL))
+			return term, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew renews the lease until the term is done, ending it once the lease is lost.
+func (e *LeaseElector) renew(term context.Context, cancel context.CancelFunc, expiry time.Time) {
+	defer cancel()
+
+	interval := e.config.TTL / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-term.Done():
+			return
+		case <-ticker.C:
+		}
+
+		renewedAt := e.now()
+		renewed, err := e.store.Acquire(term, e.config.Name, e.config.Holder, e.config.TTL)
+		switch {
+		case err != nil:
+			if term.Err() != nil {
+				return
+			}
+			log.Printf("Failed to renew the %s lease: %v", e.config.Name, err)
+			// stop leading before the lease expires, as another replica could acquire it then
+			if !e.now().Add(interval).Before(expiry) {
+				log.Printf("The %s lease could not be renewed before expiring", e.config.Name)
+				return
+			}
+		case !renewed:
+			log.Printf("The %s lease is held by another replica", e.config.Name)
+			return
+		default:
+			expiry = renewedAt.Add(e.config.TTL)
+		}
+	}
+}
+
+func (e *LeaseElector) Resign(ctx context.Context) error {
+	return e.store.Release(ctx, e.config.Name, e.config.Holder)
+}
+
+// FileLock elects the process holding an exclusive lock on a file, for replicas running on the same host.
+// The lock is released by the operating system when the process exits, so the leadership is handed over
+// as soon as the leader is lost.
+type FileLock struct {
+	path          string
+	retryInterval time.Duration
+
+	mutex sync.Mutex
+	file  lockFile
+}
+
+func NewFileLock(path string, retryInterval time.Duration) *FileLock {
+	if retryInterval <= 0 {
+		retryInterval = DefaultRetryInterval
+	}
+
+	return &FileLock{
+		path:          path,
+		retryInterval: retryInterval,
+	}
+}
+
+// Campaign waits for the lock. The leadership is never lost while the lock is held, so the returned
+// context is only done once ctx is.
+func (l *FileLock) Campaign(ctx context.Context) (context.Context, error) {
+	ticker := time.NewTicker(l.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		file, err := tryLock(l.path)
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			l.mutex.Lock()
+			l.file = file
+			l.mutex.Unlock()
+			return ctx, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *FileLock) Resign(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
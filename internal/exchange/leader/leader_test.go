@@ -0,0 +1,296 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTTL           = 30 * time.Millisecond
+	testRetryInterval = 5 * time.Millisecond
+)
+
+func TestLeaseElector_Handover(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeLeaseStore()
+	first, second := newLeaseElector(store, "first"), newLeaseElector(store, "second")
+
+	firstTerm, err := first.Campaign(ctx)
+	require.NoError(t, err)
+	elected := campaign(ctx, second)
+
+	// the leader keeps renewing the lease while the follower waits for it
+	time.Sleep(3 * testTTL)
+	assert.NoError(t, firstTerm.Err())
+	assertNotElected(t, elected)
+
+	// resigning hands the leadership over straight away
+	require.NoError(t, first.Resign(ctx))
+	secondTerm := assertElected(t, elected)
+	assert.Equal(t, "second", store.holder("leader"))
+	assert.NoError(t, secondTerm.Err())
+}
+
+func TestLeaseElector_LeaseLost(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeLeaseStore()
+	elector := newLeaseElector(store, "first")
+	term, err := elector.Campaign(ctx)
+	require.NoError(t, err)
+
+	// the lease expired and was acquired by another replica
+	store.steal("leader", "second")
+	assertDone(t, term)
+}
+
+func TestLeaseElector_RenewalFailed(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeLeaseStore()
+	elector := newLeaseElector(store, "first")
+	term, err := elector.Campaign(ctx)
+	require.NoError(t, err)
+
+	// the leadership ends before the lease expires, when it could be acquired by another replica
+	store.fail(errors.New("unavailable"))
+	assertDone(t, term)
+	assert.Equal(t, "first", store.holder("leader"))
+}
+
+func TestLeaseElector_CampaignCancelled(t *testing.T) {
+	store := newFakeLeaseStore()
+	store.steal("leader", "second")
+	ctx, cancel := context.WithCancel(context.Background())
+	elected := campaign(ctx, newLeaseElector(store, "first"))
+
+	cancel()
+	select {
+	case result := <-elected:
+		assert.ErrorIs(t, result.err, context.Canceled)
+	case <-time.After(time.Second):
+		require.FailNow(t, "campaign not cancelled")
+	}
+}
+
+func TestRun(t *testing.T) {
+	store := newFakeLeaseStore()
+	elector := newLeaseElector(store, "first")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	terms := make(chan context.Context)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(ctx, elector, func(term context.Context) {
+			terms <- term
+			<-term.Done()
+		})
+	}()
+
+	// the replica leads again once it is elected after losing the lease
+	term := receiveTerm(t, terms)
+	store.steal("leader", "second")
+	assertDone(t, term)
+	store.steal("leader", "")
+	term = receiveTerm(t, terms)
+	assert.Equal(t, "first", store.holder("leader"))
+
+	// and resigns when it stops
+	cancel()
+	assertDone(t, term)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.FailNow(t, "run did not return")
+	}
+	assert.Equal(t, "", store.holder("leader"))
+}
+
+func TestRun_CampaignFailed(t *testing.T) {
+	// the lock file cannot be opened until its directory is created
+	dir := filepath.Join(t.TempDir(), "locks")
+	elector := NewFileLock(filepath.Join(dir, "leader.lock"), testRetryInterval)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	terms := make(chan context.Context)
+	go Run(ctx, elector, func(term context.Context) {
+		terms <- term
+		<-term.Done()
+	})
+
+	// the campaign is retried, so the replica is elected once the lock can be taken
+	time.Sleep(5 * testRetryInterval)
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	select {
+	case <-terms:
+	case <-time.After(3 * DefaultRetryInterval):
+		require.FailNow(t, "not leading after the failed campaign")
+	}
+}
+
+func TestLeaseElector_CampaignStoreFailed(t *testing.T) {
+	store := newFakeLeaseStore()
+	store.failNext(errors.New("unreachable"))
+
+	// the lease is acquired once the store is reachable again
+	elected := campaign(context.Background(), newLeaseElector(store, "first"))
+	assertElected(t, elected)
+	assert.Equal(t, "first", store.holder("leader"))
+}
+
+func TestFileLock(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	first, second := NewFileLock(path, testRetryInterval), NewFileLock(path, testRetryInterval)
+
+	_, err := first.Campaign(ctx)
+	require.NoError(t, err)
+	elected := campaign(ctx, second)
+	time.Sleep(5 * testRetryInterval)
+	assertNotElected(t, elected)
+
+	require.NoError(t, first.Resign(ctx))
+	assertElected(t, elected)
+	require.NoError(t, second.Resign(ctx))
+}
+
+type campaignResult struct {
+	term context.Context
+	err  error
+}
+
+func campaign(ctx context.Context, elector Elector) <-chan campaignResult {
+	result := make(chan campaignResult, 1)
+	go func() {
+		term, err := elector.Campaign(ctx)
+		result <- campaignResult{term: term, err: err}
+	}()
+	return result
+}
+
+func assertElected(t *testing.T, elected <-chan campaignResult) context.Context {
+	t.Helper()
+	select {
+	case result := <-elected:
+		require.NoError(t, result.err)
+		return result.term
+	case <-time.After(time.Second):
+		require.FailNow(t, "not elected")
+		return nil
+	}
+}
+
+func assertNotElected(t *testing.T, elected <-chan campaignResult) {
+	t.Helper()
+	select {
+	case <-elected:
+		assert.Fail(t, "elected while the leadership is held by another replica")
+	default:
+	}
+}
+
+func assertDone(t *testing.T, term context.Context) {
+	t.Helper()
+	select {
+	case <-term.Done():
+	case <-time.After(time.Second):
+		assert.Fail(t, "term not done")
+	}
+}
+
+func receiveTerm(t *testing.T, terms <-chan context.Context) context.Context {
+	t.Helper()
+	select {
+	case term := <-terms:
+		return term
+	case <-time.After(time.Second):
+		require.FailNow(t, "not leading")
+		return nil
+	}
+}
+
+func newLeaseElector(store LeaseStore, holder string) *LeaseElector {
+	return NewLeaseElector(store, LeaseConfig{
+		Name:          "leader",
+		Holder:        holder,
+		TTL:           testTTL,
+		RetryInterval: testRetryInterval,
+	})
+}
+
+// fakeLeaseStore keeps the holder of every lease, which never expire unless they are stolen.
+type fakeLeaseStore struct {
+	mutex   sync.Mutex
+	holders map[string]string
+	err     error
+	// nextErr fails the next call only
+	nextErr error
+}
+
+func newFakeLeaseStore() *fakeLeaseStore {
+	return &fakeLeaseStore{holders: make(map[string]string)}
+}
+
+func (s *fakeLeaseStore) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.nextErr; err != nil {
+		s.nextErr = nil
+		return false, err
+	}
+	if s.err != nil {
+		return false, s.err
+	}
+	if current := s.holders[name]; current != "" && current != holder {
+		return false, nil
+	}
+	s.holders[name] = holder
+	return true, nil
+}
+
+func (s *fakeLeaseStore) Release(ctx context.Context, name, holder string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if s.holders[name] == holder {
+		delete(s.holders, name)
+	}
+	return nil
+}
+
+func (s *fakeLeaseStore) holder(name string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.holders[name]
+}
+
+// steal hands the lease over to the holder, as if it expired and was acquired by another replica.
+func (s *fakeLeaseStore) steal(name, holder string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.holders[name] = holder
+}
+
+func (s *fakeLeaseStore) fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err
+}
+
+func (s *fakeLeaseStore) failNext(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextErr = err
+}
@@ -0,0 +1,181 @@
+package exchange
+
+import (
+	"context"
+	"sync"
+)
+
+// Offsets resolved by the log when reading it.
+const (
+	// OffsetOldest is the offset of the oldest record still kept by the log.
+	OffsetOldest int64 = -2
+	// OffsetNewest is the offset the next appended record will get.
+	OffsetNewest int64 = -1
+	// NoOffset is the offset of the updates that were not read from a log.
+	NoOffset int64 = -3
+)
+
+// Record is a rate update stored in a log.
+type Record struct {
+	Offset int64
+	Rate   RateUpdated
+}
+
+// Log is a topic keeping the published updates in order, each one at an increasing offset, so they can be
+// read again from any offset still kept. Consumer groups commit the offset they must resume from, the one
+// following the last record they processed.
+type Log interface {
+	Topic
+	// Read delivers the records from the offset on, until ctx is done or the log is closed, when its channel is closed.
+	// An offset that is no longer kept is read from the oldest record.
+	Read(ctx context.Context, offset int64) (<-chan Record, error)
+	// Committed returns the offset committed by the group, OffsetOldest when it never committed one.
+	Committed(ctx context.Context, group string) (int64, error)
+	Commit(ctx context.Context, group string, offset int64) error
+}
+
+// Rates forwards the rates of the records until the records channel is closed or ctx is done,
+// when the returned channel is closed.
+func Rates(ctx context.Context, records <-chan Record) <-chan RateUpdated {
+	rates := make(chan RateUpdated)
+	go func() {
+		defer close(rates)
+		for record := range records {
+			select {
+			case rates <- record.Rate:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return rates
+}
+
+// MemoryLog is the in-process log, keeping every update in memory. It stands in for a durable log
+// in tests and single instance deployments that do not need to survive restarts.
+type MemoryLog struct {
+	mutex     sync.Mutex
+	rates     []RateUpdated
+	committed map[string]int64
+	closed    bool
+	// appended is closed and replaced every time an update is appended, waking up the readers
+	appended chan struct{}
+	// done is closed once the log is closed
+	done chan struct{}
+}
+
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{
+		committed: make(map[string]int64),
+		appended:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Publish appends the update to the log, without waiting for the readers.
+func (l *MemoryLog) Publish(ctx context.Context, rate RateUpdated) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return ErrTopicClosed
+	}
+	l.rates = append(l.rates, rate)
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return nil
+}
+
+func (l *MemoryLog) Subscribe(ctx context.Context) (<-chan RateUpdated, error) {
+	records, err := l.Read(ctx, OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+	return Rates(ctx, records), nil
+}
+
+func (l *MemoryLog) Read(ctx context.Context, offset int64) (<-chan Record, error) {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil, ErrTopicClosed
+	}
+	switch {
+	case offset == OffsetNewest || offset > int64(len(l.rates)):
+		offset = int64(len(l.rates))
+	case offset < 0:
+		offset = 0
+	}
+	l.mutex.Unlock()
+
+	records := make(chan Record)
+	go func() {
+		defer close(records)
+		for {
+			l.mutex.Lock()
+			appended := l.appended
+			var rates []RateUpdated
+			if offset < int64(len(l.rates)) {
+				rates = l.rates[offset:]
+			}
+			l.mutex.Unlock()
+
+			for _, rate := range rates {
+				select {
+				case records <- Record{Offset: offset, Rate: rate}:
+					offset++
+				case <-ctx.Done():
+					return
+				case <-l.done:
+					return
+				}
+			}
+			if len(rates) > 0 {
+				continue
+			}
+
+			select {
+			case <-appended:
+			case <-ctx.Done():
+				return
+			case <-l.done:
+				return
+			}
+		}
+	}()
+
+	return records, nil
+}
+
+func (l *MemoryLog) Committed(ctx context.Context, group string) (int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if offset, ok := l.committed[group]; ok {
+		return offset, nil
+	}
+	return OffsetOldest, nil
+}
+
+func (l *MemoryLog) Commit(ctx context.Context, group string, offset int64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return ErrTopicClosed
+	}
+	l.committed[group] = offset
+	return nil
+}
+
+// Close stops every reader, no update can be published afterwards.
+func (l *MemoryLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+	}
+	return nil
+}
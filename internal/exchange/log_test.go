@@ -0,0 +1,84 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLog(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLog()
+	rates := testRates(4)
+
+	for _, rate := range rates[:2] {
+		require.NoError(t, l.Publish(ctx, rate))
+	}
+	subscription, err := l.Subscribe(ctx)
+	require.NoError(t, err)
+	oldest, err := l.Read(ctx, OffsetOldest)
+	require.NoError(t, err)
+	fromOffset, err := l.Read(ctx, 1)
+	require.NoError(t, err)
+	for _, rate := range rates[2:] {
+		require.NoError(t, l.Publish(ctx, rate))
+	}
+
+	// subscriptions only receive the updates published after they are created
+	assert.Equal(t, rates[2], receive(t, subscription))
+	assert.Equal(t, rates[3], receive(t, subscription))
+	for i, rate := range rates {
+		assert.Equal(t, Record{Offset: int64(i), Rate: rate}, receiveRecord(t, oldest))
+	}
+	for i, rate := range rates[1:] {
+		assert.Equal(t, Record{Offset: int64(i + 1), Rate: rate}, receiveRecord(t, fromOffset))
+	}
+
+	offset, err := l.Committed(ctx, "persister")
+	require.NoError(t, err)
+	assert.Equal(t, OffsetOldest, offset)
+	require.NoError(t, l.Commit(ctx, "persister", 3))
+	offset, err = l.Committed(ctx, "persister")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), offset)
+
+	// closing the log closes every reader
+	require.NoError(t, l.Close())
+	_, ok := <-subscription
+	assert.False(t, ok)
+	_, ok = <-oldest
+	assert.False(t, ok)
+	assert.ErrorIs(t, l.Publish(ctx, rates[0]), ErrTopicClosed)
+	_, err = l.Read(ctx, OffsetOldest)
+	assert.ErrorIs(t, err, ErrTopicClosed)
+}
+
+func TestMemoryLog_ReadCancelled(t *testing.T) {
+	l := NewMemoryLog()
+	ctx, cancel := context.WithCancel(context.Background())
+	records, err := l.Read(ctx, OffsetNewest)
+	require.NoError(t, err)
+
+	cancel()
+	select {
+	case _, ok := <-records:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		require.FailNow(t, "records not closed")
+	}
+}
+
+func receiveRecord(t *testing.T, records <-chan Record) Record {
+	t.Helper()
+	select {
+	case record, ok := <-records:
+		require.True(t, ok, "log closed")
+		return record
+	case <-time.After(time.Second):
+		require.FailNow(t, "no record received")
+		return Record{}
+	}
+}
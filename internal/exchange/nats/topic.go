@@ -0,0 +1,290 @@
+// Package nats implements the update topic over NATS, publishing every update to the rates.<base>.<quote> subject,
+// e.g. rates.BTC.USD for the price of BTC in USD, so the providers can run as separate processes. Optionally, the updates are kept in a JetStream stream and
+// consumed by a durable consumer, so a broadcaster receives the updates published while it was down.
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// DefaultSubjectPrefix is the prefix of the subjects the updates are published to.
+	DefaultSubjectPrefix = "rates"
+	// DefaultStreamMaxAge is how long the JetStream stream keeps the updates.
+	DefaultStreamMaxAge = 24 * time.Hour
+
+	// pendingMessages is the number of messages buffered per subscription, NATS drops the ones over it.
+	pendingMessages = 1024
+)
+
+type Config struct {
+	// SubjectPrefix of the subjects, the updates are published to <prefix>.<from>.<to>.
+	SubjectPrefix string
+	// Stream is the JetStream stream keeping the updates. Empty publishes them with core NATS, which does not keep them.
+	Stream string
+	// StreamMaxAge is how long the stream keeps the updates.
+	StreamMaxAge time.Duration
+	// Durable is the name of the JetStream consumer of the subscriptions, which resume after the last update they
+	// received, even after a restart. It must be unique per instance. Empty only receives the new updates.
+	Durable string
+}
+
+type Topic struct {
+	conn   *nats.Conn
+	config Config
+	js     jetstream.JetStream
+	stream jetstream.Stream
+
+	mutex         sync.Mutex
+	subscriptions map[*subscription]struct{}
+	closed        bool
+}
+
+type subscription struct {
+	cancel context.CancelFunc
+}
+
+// NewTopic creates a topic over the connection, creating or updating the stream when it is configured.
+// The connection is not closed by the topic.
+func NewTopic(ctx context.Context, conn *nats.Conn, config Config) (*Topic, error) {
+	if config.SubjectPrefix == "" {
+		config.SubjectPrefix = DefaultSubjectPrefix
+	}
+	if config.StreamMaxAge == 0 {
+		config.StreamMaxAge = DefaultStreamMaxAge
+	}
+	if config.Durable != "" && config.Stream == "" {
+		return nil, errors.New("a durable consumer needs a stream")
+	}
+
+	topic := &Topic{
+		conn:          conn,
+		config:        config,
+		subscriptions: make(map[*subscription]struct{}),
+	}
+	if config.Stream == "" {
+		return topic, nil
+	}
+
+	var err error
+	if topic.js, err = jetstream.New(conn); err != nil {
+		return nil, fmt.Errorf("failed to create the JetStream context: %v", err)
+	}
+	topic.stream, err = topic.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     config.Stream,
+		Subjects: []string{topic.allSubjects()},
+		MaxAge:   config.StreamMaxAge,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %s stream: %v", config.Stream, err)
+	}
+
+	return topic, nil
+}
+
+// Subject returns the subject the rate is published to, named after its base currency, the To one priced
+// by the rate, and then its quote currency, the From one it is priced in.
+func (t *Topic) Subject(rate exchange.RateUpdated) (string, error) {
+	base, quote := rate.To, rate.From
+	for _, currency := range []string{base, quote} {
+		if currency == "" || strings.ContainsAny(currency, ".*> \t\r\n") {
+			return "", fmt.Errorf("invalid currency %q for a subject", currency)
+		}
+	}
+	return t.config.SubjectPrefix + "." + base + "." + quote, nil
+}
+
+func (t *Topic) allSubjects() string {
+	return t.config.SubjectPrefix + ".>"
+}
+
+func (t *Topic) Publish(ctx context.Context, rate exchange.RateUpdated) error {
+	subject, err := t.Subject(rate)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(rate)
+	if err != nil {
+		return fmt.Errorf("failed to encode the rate: %v", err)
+	}
+
+	if t.stream != nil {
+		// wait for the stream to acknowledge it, so it is not lost
+		if _, err := t.js.Publish(ctx, subject, payload); err != nil {
+			return fmt.Errorf("failed to publish to %s: %v", subject, err)
+		}
+		return nil
+	}
+
+	if err := t.conn.Publish(subject, payload); err != nil {
+		return fmt.Errorf("failed to publish to %s: %v", subject, err)
+	}
+	return nil
+}
+
+func (t *Topic) Subscribe(ctx context.Context) (<-chan exchange.RateUpdated, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return nil, exchange.ErrTopicClosed
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &subscription{cancel: cancel}
+
+	var (
+		updates <-chan exchange.RateUpdated
+		err     error
+	)
+	if t.stream != nil {
+		updates, err = t.consume(ctx, s)
+	} else {
+		updates, err = t.subscribe(ctx, s)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	t.subscriptions[s] = struct{}{}
+	return updates, nil
+}
+
+// subscribe receives the updates with a core NATS subscription.
+func (t *Topic) subscribe(ctx context.Context, s *subscription) (<-chan exchange.RateUpdated, error) {
+	messages := make(chan *nats.Msg, pendingMessages)
+	natsSubscription, err := t.conn.ChanSubscribe(t.allSubjects(), messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %v", t.allSubjects(), err)
+	}
+	// make sure the server has the subscription, so the updates published once Subscribe returns are received
+	if err := t.conn.Flush(); err != nil {
+		natsSubscription.Unsubscribe()
+		return nil, fmt.Errorf("failed to subscribe to %s: %v", t.allSubjects(), err)
+	}
+
+	updates := make(chan exchange.RateUpdated)
+	go func() {
+		defer close(updates)
+		defer t.unsubscribe(s)
+		defer natsSubscription.Unsubscribe()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message := <-messages:
+				rate, ok := t.decode(message)
+				if !ok {
+					continue
+				}
+				select {
+				case updates <- rate:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return updates, nil
+}
+
+// consume receives the updates from the stream, acknowledging them once they are received.
+func (t *Topic) consume(ctx context.Context, s *subscription) (<-chan exchange.RateUpdated, error) {
+	config := jetstream.ConsumerConfig{
+		Durable:       t.config.Durable,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		FilterSubject: t.allSubjects(),
+	}
+	if t.config.Durable == "" {
+		config.DeliverPolicy = jetstream.DeliverNewPolicy
+	}
+	consumer, err := t.stream.CreateOrUpdateConsumer(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the consumer of the %s stream: %v", t.config.Stream, err)
+	}
+	iterator, err := consumer.Messages()
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume the %s stream: %v", t.config.Stream, err)
+	}
+	go func() {
+		<-ctx.Done()
+		iterator.Stop()
+	}()
+
+	updates := make(chan exchange.RateUpdated)
+	go func() {
+		defer close(updates)
+		defer t.unsubscribe(s)
+
+		for {
+			message, err := iterator.Next()
+			if err != nil {
+				if !errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+					log.Printf("Failed to consume the %s stream: %v", t.config.Stream, err)
+				}
+				return
+			}
+
+			rate, ok := t.decode(&nats.Msg{Subject: message.Subject(), Data: message.Data()})
+			if ok {
+				select {
+				case updates <- rate:
+				case <-ctx.Done():
+					// not acknowledged, so it is received again once resumed
+					return
+				}
+			}
+			if err := message.Ack(); err != nil {
+				log.Printf("Failed to acknowledge the update from %s: %v", message.Subject(), err)
+			}
+		}
+	}()
+
+	return updates, nil
+}
+
+func (t *Topic) decode(message *nats.Msg) (exchange.RateUpdated, bool) {
+	var rate exchange.RateUpdated
+	if err := json.Unmarshal(message.Data, &rate); err != nil {
+		log.Printf("Discarding invalid rate update from %s: %v", message.Subject, err)
+		return exchange.RateUpdated{}, false
+	}
+	return rate, true
+}
+
+// Close closes every subscription.
+func (t *Topic) Close() error {
+	t.mutex.Lock()
+	t.closed = true
+	subscriptions := make([]*subscription, 0, len(t.subscriptions))
+	for s := range t.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	t.mutex.Unlock()
+
+	for _, s := range subscriptions {
+		s.cancel()
+	}
+	return nil
+}
+
+func (t *Topic) unsubscribe(s *subscription) {
+	s.cancel()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.subscriptions, s)
+}
@@ -0,0 +1,156 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopic(t *testing.T) {
+	ctx := context.Background()
+	url := runServer(t)
+	rate := exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 10).UTC(), Rate: "69,420.00", Source: "coindesk"}
+
+	// two topics with their own connections, like a provider and a broadcaster running as separate processes
+	provider, broadcaster := newTopic(t, url, Config{}), newTopic(t, url, Config{})
+	cancelled, cancel := context.WithCancel(ctx)
+	updates, err := broadcaster.Subscribe(cancelled)
+	require.NoError(t, err)
+
+	// updates are published to the subject of their pair, the base currency first
+	conn := connect(t, url)
+	pairUpdates := make(chan *nats.Msg, 1)
+	_, err = conn.ChanSubscribe("rates.BTC.USD", pairUpdates)
+	require.NoError(t, err)
+	require.NoError(t, conn.Flush())
+
+	require.NoError(t, provider.Publish(ctx, rate))
+	assert.Equal(t, rate, receive(t, updates))
+	select {
+	case message := <-pairUpdates:
+		assert.JSONEq(t, `{"from":"USD","to":"BTC","at":"1970-01-01T00:16:40.00000001Z","rate":"69,420.00","source":"coindesk"}`, string(message.Data))
+	case <-time.After(time.Second):
+		assert.Fail(t, "nothing published to the subject of the pair")
+	}
+
+	// invalid updates are discarded
+	require.NoError(t, conn.Publish("rates.BTC.USD", []byte("not a rate")))
+	require.NoError(t, provider.Publish(ctx, rate))
+	assert.Equal(t, rate, receive(t, updates))
+
+	// currencies must be valid subject tokens
+	assert.ErrorContains(t, provider.Publish(ctx, exchange.RateUpdated{From: "US.D", To: "BTC"}), `invalid currency "US.D"`)
+
+	// a subscription is closed once its context is done
+	cancel()
+	assertClosed(t, updates)
+
+	// and all of them once the topic is closed
+	updates, err = broadcaster.Subscribe(ctx)
+	require.NoError(t, err)
+	require.NoError(t, broadcaster.Close())
+	assertClosed(t, updates)
+	_, err = broadcaster.Subscribe(ctx)
+	assert.ErrorIs(t, err, exchange.ErrTopicClosed)
+}
+
+func TestTopic_DurableReplay(t *testing.T) {
+	ctx := context.Background()
+	url := runServer(t)
+	config := Config{Stream: "RATES", Durable: "broadcaster-1"}
+	rates := make([]exchange.RateUpdated, 3)
+	for i := range rates {
+		rates[i] = exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(int64(1000+i), 0).UTC(), Rate: "1", Source: "coindesk"}
+	}
+
+	provider := newTopic(t, url, config)
+	broadcaster := newTopic(t, url, config)
+	cancelled, cancel := context.WithCancel(ctx)
+	updates, err := broadcaster.Subscribe(cancelled)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Publish(ctx, rates[0]))
+	assert.Equal(t, rates[0], receive(t, updates))
+
+	// the updates published while the broadcaster is down are received once it resumes, even in a new process
+	cancel()
+	assertClosed(t, updates)
+	require.NoError(t, provider.Publish(ctx, rates[1]))
+	require.NoError(t, provider.Publish(ctx, rates[2]))
+
+	updates, err = newTopic(t, url, config).Subscribe(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, rates[1], receive(t, updates))
+	assert.Equal(t, rates[2], receive(t, updates))
+}
+
+func TestTopic_Subject(t *testing.T) {
+	topic := &Topic{config: Config{SubjectPrefix: DefaultSubjectPrefix}}
+
+	// the price of BTC in USD
+	subject, err := topic.Subject(exchange.RateUpdated{From: "USD", To: "BTC"})
+	require.NoError(t, err)
+	assert.Equal(t, "rates.BTC.USD", subject)
+
+	_, err = topic.Subject(exchange.RateUpdated{From: "USD", To: "BT>"})
+	assert.ErrorContains(t, err, `invalid currency "BT>"`)
+}
+
+func TestNewTopic_DurableWithoutStream(t *testing.T) {
+	_, err := NewTopic(context.Background(), connect(t, runServer(t)), Config{Durable: "broadcaster-1"})
+	assert.EqualError(t, err, "a durable consumer needs a stream")
+}
+
+func runServer(t *testing.T) string {
+	t.Helper()
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	require.NoError(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second), "NATS server not ready")
+	t.Cleanup(s.Shutdown)
+	return s.ClientURL()
+}
+
+func connect(t *testing.T, url string) *nats.Conn {
+	t.Helper()
+	conn, err := nats.Connect(url)
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func newTopic(t *testing.T, url string, config Config) *Topic {
+	t.Helper()
+	topic, err := NewTopic(context.Background(), connect(t, url), config)
+	require.NoError(t, err)
+	t.Cleanup(func() { topic.Close() })
+	return topic
+}
+
+func receive(t *testing.T, updates <-chan exchange.RateUpdated) exchange.RateUpdated {
+	t.Helper()
+	select {
+	case update, ok := <-updates:
+		require.True(t, ok, "subscription closed")
+		return update
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no update received")
+		return exchange.RateUpdated{}
+	}
+}
+
+func assertClosed(t *testing.T, updates <-chan exchange.RateUpdated) {
+	t.Helper()
+	select {
+	case _, ok := <-updates:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "subscription not closed")
+	}
+}
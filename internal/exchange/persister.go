@@ -2,35 +2,361 @@ package exchange
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/alex-rufo/exchange/pkg/backoff"
+)
+
+const (
+	// commitTimeout bounds committing the offset of the persisted updates to the log.
+	commitTimeout = 5 * time.Second
+
+	DefaultFlushTimeout = 10 * time.Second
+	DefaultMaxSpilled   = 100_000
 )
 
 type Repository interface {
 	Insert(ctx context.Context, rate RateUpdated) error
 }
 
+// BatchRepository is implemented by repositories able to insert several rates at once,
+// e.g. in a single transaction. Either all the rates are inserted or none of them.
+type BatchRepository interface {
+	InsertBatch(ctx context.Context, rates []RateUpdated) error
+}
+
+type PersisterConfig struct {
+	// BatchSize is the maximum number of rates inserted at once.
+	BatchSize int
+	// Linger is how long a batch waits for more rates before being inserted.
+	Linger time.Duration
+	// QueueSize is the number of batches waiting to be inserted, while the failed ones are retried.
+	QueueSize int
+	// MaxAttempts is the number of attempts to insert a batch before spilling it.
+	MaxAttempts int
+	// Backoff computes the delay between attempts.
+	Backoff backoff.Backoff
+	// SpillDir is the directory where the batches that could not be inserted are buffered,
+	// so they survive restarts. Empty keeps them in memory.
+	SpillDir string
+	// MaxSpilled is the maximum number of rates kept in memory when there is no SpillDir.
+	// The batches that do not fit are dropped.
+	MaxSpilled int
+	// DrainInterval is how often inserting the spilled batches is retried.
+	DrainInterval time.Duration
+	// FlushTimeout is how long the pending batches are still inserted for once the context is done,
+	// before spilling them.
+	FlushTimeout time.Duration
+}
+
+// PersisterStats reports the state of the persister.
+type PersisterStats struct {
+	// Queued is the number of rates received that are neither inserted nor spilled yet.
+	Queued int64 `json:"queued"`
+	// Spilled is the number of rates waiting in the spill buffer for the repository to recover.
+	Spilled int `json:"spilled"`
+	// Persisted is the number of rates inserted into the repository.
+	Persisted int64 `json:"persisted"`
+	// Failures is the number of failed attempts to insert a batch.
+	Failures int64 `json:"failures"`
+	// Dropped is the number of rates lost because they could not even be spilled.
+	Dropped int64 `json:"dropped"`
+}
+
+// Persister inserts the rate updates into the repository in batches, retrying the failed
+// inserts with backoff. Batches that still fail are spilled into a buffer, and new ones go
+// straight into it while it is not empty, until it is drained once the repository recovers.
 type Persister struct {
 	repository Repository
+	config     PersisterConfig
+	spill      *spillBuffer
+	batches    chan persisterBatch
+
+	queued    atomic.Int64
+	persisted atomic.Int64
+	failures  atomic.Int64
+	dropped   atomic.Int64
 }
 
-func NewPersister(repository Repository) *Persister {
+func NewPersister(repository Repository, config PersisterConfig) (*Persister, error) {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 1
+	}
+	if config.DrainInterval <= 0 {
+		config.DrainInterval = 5 * time.Second
+	}
+	if config.FlushTimeout <= 0 {
+		config.FlushTimeout = DefaultFlushTimeout
+	}
+	if config.MaxSpilled <= 0 {
+		config.MaxSpilled = DefaultMaxSpilled
+	}
+
+	spill, err := openSpillBuffer(config.SpillDir, config.MaxSpilled)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Persister{
 		repository: repository,
+		config:     config,
+		spill:      spill,
+		batches:    make(chan persisterBatch, max(config.QueueSize, 0)),
+	}, nil
+}
+
+// Stats returns the current state of the persister.
+func (p *Persister) Stats() PersisterStats {
+	return PersisterStats{
+		Queued:    p.queued.Load(),
+		Spilled:   p.spill.len(),
+		Persisted: p.persisted.Load(),
+		Failures:  p.failures.Load(),
+		Dropped:   p.dropped.Load(),
 	}
 }
 
+// PersistUpdates batches the updates until the channel is closed or the context is done.
+// The pending batches are inserted before returning, for at most the flush timeout once
+// the context is done, and spilled when they could not be inserted by then.
 func (p *Persister) PersistUpdates(ctx context.Context, updates <-chan RateUpdated) {
+	p.consume(ctx, updates, nil, nil)
+}
+
+// PersistLog batches the updates of the log from the offset committed by the group, until the context is done
+// or the log is closed. The offset following the last persisted update is committed once every batch is inserted
+// or spilled into the spill directory, so a restart resumes exactly where it left off. Batches spilled in memory
+// are not committed until they are drained, so they are read again after a restart.
+func (p *Persister) PersistLog(ctx context.Context, l Log, group string) error {
+	offset, err := l.Committed(ctx, group)
+	if err != nil {
+		return fmt.Errorf("failed to get the offset committed by %s: %v", group, err)
+	}
+	records, err := l.Read(ctx, offset)
+	if err != nil {
+		return fmt.Errorf("failed to read the log from offset %d: %v", offset, err)
+	}
+
+	p.consume(ctx, nil, records, func(offset int64) {
+		// commit even when the context is done, as the batches are spilled then
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), commitTimeout)
+		defer cancel()
+		if err := l.Commit(ctx, group, offset); err != nil {
+			log.Printf("Failed to commit offset %d of %s: %v", offset, group, err)
+		}
+	})
+	return nil
+}
+
+// persisterBatch is a batch of rates waiting to be inserted.
+type persisterBatch struct {
+	rates []RateUpdated
+	// next is the log offset following the last rate of the batch, or -1 when it was not read from a log.
+	next int64
+}
+
+// consume batches the rates received from either the updates or the records channel, the other one being nil.
+// The offset following the last persisted record is committed after every batch.
+func (p *Persister) consume(ctx context.Context, updates <-chan RateUpdated, records <-chan Record, commit func(offset int64)) {
+	// the pending batches are still written once the context is done, until the flush timeout
+	writeCtx, cancelWrite := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWrite()
+	stop := context.AfterFunc(ctx, func() {
+		timeout := time.NewTimer(p.config.FlushTimeout)
+		defer timeout.Stop()
+		select {
+		case <-timeout.C:
+			cancelWrite()
+		case <-writeCtx.Done():
+		}
+	})
+	defer stop()
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		p.write(writeCtx, commit)
+	}()
+
+	batch := persisterBatch{next: -1}
+	linger := time.NewTimer(p.config.Linger)
+	linger.Stop()
+	defer linger.Stop()
+
+	// spilled is the offset following the last rate spilled without going through the writer
+	spilled := int64(-1)
+	flush := func() {
+		linger.Stop()
+		if len(batch.rates) == 0 {
+			return
+		}
+
+		// batches are inserted or spilled in order, so block until the writer catches up
+		select {
+		case p.batches <- batch:
+		case <-writeCtx.Done():
+			if p.spillBatch(batch.rates) {
+				spilled = batch.next
+			}
+		}
+		batch = persisterBatch{next: -1}
+	}
+
+	add := func(rate RateUpdated) {
+		p.queued.Add(1)
+		batch.rates = append(batch.rates, rate)
+		if len(batch.rates) >= p.config.BatchSize {
+			flush()
+		} else if len(batch.rates) == 1 {
+			linger.Reset(p.config.Linger)
+		}
+	}
+
+	defer func() {
+		flush()
+		close(p.batches)
+		<-written
+		// the writer has committed the batches it received, which come before the spilled ones
+		if commit != nil && spilled >= 0 {
+			commit(spilled)
+		}
+	}()
+
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case rate, ok := <-updates:
 			if !ok {
 				// updates channel was closed, we won't receive any more updates
 				return
 			}
+			add(rate)
+		case record, ok := <-records:
+			if !ok {
+				// the log was closed, we won't receive any more updates
+				return
+			}
+			batch.next = record.Offset + 1
+			add(record.Rate)
+		case <-linger.C:
+			flush()
+		}
+	}
+}
+
+// write inserts the queued batches, committing them when read from a log, and periodically drains the spill buffer.
+func (p *Persister) write(ctx context.Context, commit func(offset int64)) {
+	ticker := time.NewTicker(p.config.DrainInterval)
+	defer ticker.Stop()
 
-			if err := p.repository.Insert(ctx, rate); err != nil {
-				log.Println("Failed to persist the rate into the repository", err, rate)
+	// batches could have been left by a previous run
+	p.drain(ctx)
+
+	for {
+		select {
+		case batch, ok := <-p.batches:
+			if !ok {
+				return
+			}
+			if p.persist(ctx, batch.rates) && commit != nil && batch.next >= 0 {
+				commit(batch.next)
 			}
+		case <-ticker.C:
+			p.drain(ctx)
+		}
+	}
+}
+
+// persist inserts the batch, spilling it when it fails. It returns whether the batch survives a restart,
+// because it was inserted or spilled into the spill directory.
+func (p *Persister) persist(ctx context.Context, batch []RateUpdated) bool {
+	// keep the order of the rates while the spilled ones are not drained, and do not wait
+	// for the retries of a repository that is known to be unavailable
+	if ctx.Err() != nil || p.spill.len() > 0 {
+		return p.spillBatch(batch)
+	}
+
+	inserted := 0
+	err := backoff.Retry(ctx, p.config.Backoff, p.config.MaxAttempts, func(ctx context.Context) error {
+		n, err := p.insert(ctx, batch[inserted:])
+		inserted += n
+		if err != nil {
+			p.failures.Add(1)
+		}
+		return err
+	})
+	p.queued.Add(-int64(inserted))
+	p.persisted.Add(int64(inserted))
+	if err != nil {
+		log.Printf("Failed to persist %d rates into the repository, spilling them: %v", len(batch)-inserted, err)
+		return p.spillBatch(batch[inserted:])
+	}
+	return true
+}
+
+// insert inserts the rates, returning how many of them were inserted.
+func (p *Persister) insert(ctx context.Context, rates []RateUpdated) (int, error) {
+	if repository, ok := p.repository.(BatchRepository); ok {
+		if err := repository.InsertBatch(ctx, rates); err != nil {
+			return 0, err
+		}
+		return len(rates), nil
+	}
+
+	for i, rate := range rates {
+		if err := p.repository.Insert(ctx, rate); err != nil {
+			return i, err
+		}
+	}
+	return len(rates), nil
+}
+
+// spillBatch adds the batch to the spill buffer, returning whether it survives a restart.
+func (p *Persister) spillBatch(batch []RateUpdated) bool {
+	p.queued.Add(-int64(len(batch)))
+	if err := p.spill.push(batch); err != nil {
+		p.dropped.Add(int64(len(batch)))
+		log.Printf("Failed to spill %d rates, they are lost: %v", len(batch), err)
+		return false
+	}
+	return p.spill.dir != ""
+}
+
+// drain inserts the spilled batches, oldest first, until one of them fails.
+func (p *Persister) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		seq, batch, ok, err := p.spill.peek()
+		if err != nil {
+			log.Printf("Failed to read the spilled rates: %v", err)
+			return
+		}
+		if !ok {
+			return
+		}
+
+		inserted, err := p.insert(ctx, batch)
+		p.persisted.Add(int64(inserted))
+		if err != nil {
+			p.failures.Add(1)
+			if inserted > 0 {
+				// do not insert the same rates again
+				err = errors.Join(err, p.spill.replace(seq, batch[inserted:]))
+			}
+			log.Printf("Failed to drain the spilled rates: %v", err)
+			return
+		}
+
+		if err := p.spill.remove(seq); err != nil {
+			log.Printf("Failed to remove the drained rates: %v", err)
+			return
 		}
 	}
 }
@@ -3,10 +3,15 @@ package exchange
 import (
 	"context"
 	"errors"
+	"os"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/alex-rufo/exchange/pkg/backoff"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockRepository struct {
@@ -63,7 +68,8 @@ func TestPersister_PersistUpdates(t *testing.T) {
 			updates := make(chan RateUpdated, len(tt.updates))
 
 			// Create persister
-			persister := NewPersister(tt.repository)
+			persister, err := NewPersister(tt.repository, PersisterConfig{})
+			require.NoError(t, err)
 
 			// Start persister in a goroutine
 			go persister.PersistUpdates(ctx, updates)
@@ -106,7 +112,8 @@ func TestPersister_PersistUpdates_ChannelClosed(t *testing.T) {
 	updates := make(chan RateUpdated)
 
 	// Create persister
-	persister := NewPersister(repository)
+	persister, err := NewPersister(repository, PersisterConfig{})
+	require.NoError(t, err)
 
 	// Start persister in a goroutine
 	go persister.PersistUpdates(ctx, updates)
@@ -120,3 +127,367 @@ func TestPersister_PersistUpdates_ChannelClosed(t *testing.T) {
 	// Verify that no insertions were attempted
 	assert.Equal(t, 0, insertCount)
 }
+
+func TestPersister_Batches(t *testing.T) {
+	repository := &batchRepository{}
+	persister, err := NewPersister(repository, PersisterConfig{BatchSize: 3, Linger: 50 * time.Millisecond, QueueSize: 10})
+	require.NoError(t, err)
+
+	updates := make(chan RateUpdated)
+	done := make(chan struct{})
+	go func() {
+		persister.PersistUpdates(context.Background(), updates)
+		close(done)
+	}()
+
+	rates := testRates(5)
+	for _, rate := range rates {
+		updates <- rate
+	}
+
+	// the first batch is full, the second one is inserted once it lingered
+	require.Eventually(t, func() bool { return len(repository.batches()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, [][]RateUpdated{rates[:3], rates[3:]}, repository.batches())
+
+	close(updates)
+	<-done
+	assert.Equal(t, PersisterStats{Persisted: 5}, persister.Stats())
+}
+
+func TestPersister_RetriesTransientFailures(t *testing.T) {
+	repository := &batchRepository{failures: 2}
+	persister, err := NewPersister(repository, PersisterConfig{
+		BatchSize:   2,
+		Linger:      time.Hour,
+		MaxAttempts: 3,
+		Backoff:     backoff.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1},
+	})
+	require.NoError(t, err)
+
+	persistAll(t, persister, testRates(2))
+
+	assert.Equal(t, [][]RateUpdated{testRates(2)}, repository.batches())
+	assert.Equal(t, PersisterStats{Persisted: 2, Failures: 2}, persister.Stats())
+}
+
+func TestPersister_DoesNotInsertTwiceAfterPartialFailure(t *testing.T) {
+	var inserted []RateUpdated
+	calls := 0
+	repository := &mockRepository{
+		insertFunc: func(ctx context.Context, rate RateUpdated) error {
+			calls++
+			if calls == 2 {
+				return errors.New("insertion failed")
+			}
+			inserted = append(inserted, rate)
+			return nil
+		},
+	}
+	persister, err := NewPersister(repository, PersisterConfig{
+		BatchSize:   3,
+		Linger:      time.Hour,
+		MaxAttempts: 2,
+		Backoff:     backoff.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1},
+	})
+	require.NoError(t, err)
+
+	persistAll(t, persister, testRates(3))
+
+	assert.Equal(t, testRates(3), inserted)
+	assert.Equal(t, PersisterStats{Persisted: 3, Failures: 1}, persister.Stats())
+}
+
+func TestPersister_SpillsAndDrains(t *testing.T) {
+	for name, dir := range map[string]string{"on disk": t.TempDir(), "in memory": ""} {
+		t.Run(name, func(t *testing.T) {
+			repository := &batchRepository{failures: 2}
+			persister, err := NewPersister(repository, PersisterConfig{
+				BatchSize:     2,
+				Linger:        time.Hour,
+				QueueSize:     10,
+				MaxAttempts:   1,
+				SpillDir:      dir,
+				DrainInterval: 20 * time.Millisecond,
+			})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			updates := make(chan RateUpdated)
+			go persister.PersistUpdates(ctx, updates)
+
+			rates := testRates(6)
+			for _, rate := range rates {
+				updates <- rate
+			}
+
+			// the first batch fails and is spilled, so are the next ones until it is drained,
+			// once the repository recovers all of them are inserted in order
+			require.Eventually(t, func() bool { return persister.Stats().Persisted == 6 }, time.Second, 5*time.Millisecond)
+			assert.Equal(t, rates, flatten(repository.batches()))
+
+			stats := persister.Stats()
+			assert.Equal(t, 0, stats.Spilled)
+			assert.Equal(t, int64(2), stats.Failures)
+			assert.Equal(t, int64(0), stats.Queued)
+			if dir != "" {
+				files, err := os.ReadDir(dir)
+				require.NoError(t, err)
+				assert.Empty(t, files)
+			}
+		})
+	}
+}
+
+func TestPersister_DrainsSpilledBatchesOfPreviousRun(t *testing.T) {
+	dir := t.TempDir()
+	rates := testRates(4)
+
+	// the repository is down when the persister stops
+	unavailable := &batchRepository{failures: 100}
+	persister, err := NewPersister(unavailable, PersisterConfig{BatchSize: 2, Linger: time.Hour, SpillDir: dir})
+	require.NoError(t, err)
+	persistAll(t, persister, rates)
+	assert.Equal(t, 4, persister.Stats().Spilled)
+
+	repository := &batchRepository{}
+	persister, err = NewPersister(repository, PersisterConfig{BatchSize: 2, Linger: time.Hour, SpillDir: dir})
+	require.NoError(t, err)
+	assert.Equal(t, 4, persister.Stats().Spilled)
+
+	persistAll(t, persister, nil)
+	assert.Equal(t, rates, flatten(repository.batches()))
+	assert.Equal(t, 0, persister.Stats().Spilled)
+}
+
+func TestPersister_FlushesPendingBatchesOnCancel(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		expected PersisterStats
+	}{
+		{name: "inserted", expected: PersisterStats{Persisted: 3}},
+		// the repository does not recover before the flush timeout
+		{name: "spilled", failures: 100, expected: PersisterStats{Spilled: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &batchRepository{failures: tt.failures}
+			persister, err := NewPersister(repository, PersisterConfig{
+				BatchSize:    10,
+				Linger:       time.Hour,
+				MaxAttempts:  100,
+				Backoff:      backoff.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1},
+				FlushTimeout: 20 * time.Millisecond,
+			})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			updates := make(chan RateUpdated)
+			done := make(chan struct{})
+			go func() {
+				persister.PersistUpdates(ctx, updates)
+				close(done)
+			}()
+
+			for _, rate := range testRates(3) {
+				updates <- rate
+			}
+			cancel()
+			<-done
+
+			stats := persister.Stats()
+			stats.Failures = 0
+			assert.Equal(t, tt.expected, stats)
+			assert.Equal(t, int(tt.expected.Persisted), len(flatten(repository.batches())))
+		})
+	}
+}
+
+func TestPersister_DropsBatchesOverTheSpillLimit(t *testing.T) {
+	repository := &batchRepository{failures: 100}
+	persister, err := NewPersister(repository, PersisterConfig{BatchSize: 2, Linger: time.Hour, MaxSpilled: 3})
+	require.NoError(t, err)
+
+	persistAll(t, persister, testRates(4))
+	assert.Equal(t, PersisterStats{Spilled: 2, Failures: 1, Dropped: 2}, persister.Stats())
+}
+
+// persistAll persists the rates until the updates channel is closed.
+func persistAll(t *testing.T, persister *Persister, rates []RateUpdated) {
+	t.Helper()
+
+	updates := make(chan RateUpdated, len(rates))
+	for _, rate := range rates {
+		updates <- rate
+	}
+	close(updates)
+	persister.PersistUpdates(context.Background(), updates)
+}
+
+func testRates(count int) []RateUpdated {
+	at := time.Unix(1000, 0).UTC()
+	rates := make([]RateUpdated, count)
+	for i := range rates {
+		rates[i] = RateUpdated{From: "USD", To: "BTC", At: at.Add(time.Duration(i) * time.Second), Rate: "50000.00"}
+	}
+	return rates
+}
+
+func flatten(batches [][]RateUpdated) []RateUpdated {
+	var rates []RateUpdated
+	for _, batch := range batches {
+		rates = append(rates, batch...)
+	}
+	return rates
+}
+
+// batchRepository records the inserted batches, failing the first inserts.
+type batchRepository struct {
+	mutex    sync.Mutex
+	failures int
+	inserted [][]RateUpdated
+}
+
+func (r *batchRepository) Insert(ctx context.Context, rate RateUpdated) error {
+	return r.InsertBatch(ctx, []RateUpdated{rate})
+}
+
+func (r *batchRepository) InsertBatch(_ context.Context, rates []RateUpdated) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("repository unavailable")
+	}
+	r.inserted = append(r.inserted, rates)
+	return nil
+}
+
+func (r *batchRepository) batches() [][]RateUpdated {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return slices.Clone(r.inserted)
+}
+
+func TestPersister_PersistLog_ResumesFromCommittedOffset(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLog()
+	repository := &batchRepository{}
+	rates := testRates(8)
+
+	for _, rate := range rates[:5] {
+		require.NoError(t, l.Publish(ctx, rate))
+	}
+	persistLogUntilCommitted(t, repository, l, 5)
+
+	// the updates published while the persister is stopped are persisted once it restarts, and only them
+	for _, rate := range rates[5:] {
+		require.NoError(t, l.Publish(ctx, rate))
+	}
+	persistLogUntilCommitted(t, repository, l, 8)
+
+	assert.Equal(t, rates, flatten(repository.batches()))
+}
+
+func TestPersister_PersistLog_CommitsSpilledBatchesOnCancel(t *testing.T) {
+	tests := []struct {
+		name     string
+		spillDir string
+		expected int64
+	}{
+		{name: "spilled into a directory", spillDir: t.TempDir(), expected: 3},
+		// the batches spilled in memory would be lost by a restart, so they are read again
+		{name: "spilled in memory", expected: OffsetOldest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			l := NewMemoryLog()
+			for _, rate := range testRates(3) {
+				require.NoError(t, l.Publish(ctx, rate))
+			}
+
+			unavailable := &batchRepository{failures: 100}
+			persister, err := NewPersister(unavailable, PersisterConfig{BatchSize: 10, Linger: time.Hour, SpillDir: tt.spillDir, FlushTimeout: time.Millisecond})
+			require.NoError(t, err)
+			cancelled, cancel := context.WithCancel(ctx)
+			done := make(chan struct{})
+			go func() {
+				assert.NoError(t, persister.PersistLog(cancelled, l, "persister"))
+				close(done)
+			}()
+
+			require.Eventually(t, func() bool { return persister.Stats().Queued == 3 }, time.Second, 5*time.Millisecond)
+			cancel()
+			<-done
+
+			assert.Equal(t, 3, persister.Stats().Spilled)
+			offset, err := l.Committed(ctx, "persister")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, offset)
+		})
+	}
+}
+
+func TestPersister_PersistLog_CommitsDrainedBatches(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLog()
+	rates := testRates(4)
+	for _, rate := range rates[:2] {
+		require.NoError(t, l.Publish(ctx, rate))
+	}
+
+	// the first batch is spilled in memory, and only committed with the next one once it was drained
+	repository := &batchRepository{failures: 1}
+	persister, err := NewPersister(repository, PersisterConfig{BatchSize: 2, Linger: time.Hour, DrainInterval: 5 * time.Millisecond})
+	require.NoError(t, err)
+	cancelled, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, persister.PersistLog(cancelled, l, "persister"))
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return len(repository.batches()) == 1 }, time.Second, 5*time.Millisecond)
+	offset, err := l.Committed(ctx, "persister")
+	require.NoError(t, err)
+	assert.Equal(t, OffsetOldest, offset)
+
+	for _, rate := range rates[2:] {
+		require.NoError(t, l.Publish(ctx, rate))
+	}
+	require.Eventually(t, func() bool {
+		committed, err := l.Committed(ctx, "persister")
+		return err == nil && committed == 4
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, rates, flatten(repository.batches()))
+}
+
+// persistLogUntilCommitted persists the log until the group commits the offset.
+func persistLogUntilCommitted(t *testing.T, repository Repository, l Log, offset int64) {
+	t.Helper()
+
+	persister, err := NewPersister(repository, PersisterConfig{BatchSize: 2, Linger: 10 * time.Millisecond})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, persister.PersistLog(ctx, l, "persister"))
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		committed, err := l.Committed(context.Background(), "persister")
+		return err == nil && committed == offset
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+}
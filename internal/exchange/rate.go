@@ -0,0 +1,32 @@
+package exchange
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RateUpdated struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+	Rate string    `json:"rate"`
+	// Source is the name of the provider the rate was fetched from.
+	Source string `json:"source,omitempty"`
+	// Open, High and Low are only set on the rates aggregating a bucket of rates, e.g. in the retention tiers,
+	// whose Rate is the close of the bucket.
+	Open string `json:"open,omitempty"`
+	High string `json:"high,omitempty"`
+	Low  string `json:"low,omitempty"`
+}
+
+// Pair returns the identifier of the currency pair the rate belongs to, e.g. USD-BTC.
+func (r RateUpdated) Pair() string {
+	return r.From + "-" + r.To
+}
+
+// Value parses the rate into a float. Providers are allowed to format the rate
+// with thousands separators (e.g. 69,420.00), so those are stripped first.
+func (r RateUpdated) Value() (float64, error) {
+	return strconv.ParseFloat(strings.ReplaceAll(r.Rate, ",", ""), 64)
+}
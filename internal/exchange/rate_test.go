@@ -0,0 +1,39 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateUpdated_Pair(t *testing.T) {
+	rate := RateUpdated{From: "USD", To: "BTC", Rate: "50000.00"}
+	assert.Equal(t, "USD-BTC", rate.Pair())
+}
+
+func TestRateUpdated_Value(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      string
+		expected  float64
+		expectErr bool
+	}{
+		{name: "plain number", rate: "50000.25", expected: 50000.25},
+		{name: "thousands separators", rate: "69,420.00", expected: 69420},
+		{name: "invalid number", rate: "not-a-number", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := RateUpdated{Rate: tt.rate}.Value()
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
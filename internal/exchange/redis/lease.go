@@ -0,0 +1,52 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// acquireScript sets the key to the holder when it is free or already held by it, renewing its expiry.
+var acquireScript = goredis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder == false or holder == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// releaseScript deletes the key only when it is held by the holder.
+var releaseScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// LeaseStore keeps every lease in a Redis key holding its holder, which expires with the lease.
+type LeaseStore struct {
+	client *goredis.Client
+}
+
+// NewLeaseStore creates a lease store over the client. The client is not closed by the store.
+func NewLeaseStore(client *goredis.Client) *LeaseStore {
+	return &LeaseStore{client: client}
+}
+
+func (s *LeaseStore) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	acquired, err := acquireScript.Run(ctx, s.client, []string{name}, holder, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire the %s lease: %v", name, err)
+	}
+	return acquired == 1, nil
+}
+
+func (s *LeaseStore) Release(ctx context.Context, name, holder string) error {
+	if err := releaseScript.Run(ctx, s.client, []string{name}, holder).Err(); err != nil {
+		return fmt.Errorf("failed to release the %s lease: %v", name, err)
+	}
+	return nil
+}
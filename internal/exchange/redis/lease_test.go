@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseStore(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	store := NewLeaseStore(client)
+
+	acquired, err := store.Acquire(ctx, "leader", "first", 10*time.Second)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	// only the holder renews the lease
+	acquired, err = store.Acquire(ctx, "leader", "second", 10*time.Second)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+	acquired, err = store.Acquire(ctx, "leader", "first", 10*time.Second)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	// and releases it
+	require.NoError(t, store.Release(ctx, "leader", "second"))
+	assert.True(t, server.Exists("leader"))
+	require.NoError(t, store.Release(ctx, "leader", "first"))
+	assert.False(t, server.Exists("leader"))
+
+	// an expired lease is acquired by someone else
+	acquired, err = store.Acquire(ctx, "leader", "first", 10*time.Second)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	server.FastForward(10 * time.Second)
+	acquired, err = store.Acquire(ctx, "leader", "second", 10*time.Second)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	server.Close()
+	_, err = store.Acquire(ctx, "leader", "second", 10*time.Second)
+	assert.ErrorContains(t, err, "failed to acquire the leader lease")
+}
@@ -0,0 +1,133 @@
+// Package redis implements the update topic over Redis Pub/Sub, so the broadcasters of several instances of
+// the service receive the same stream of updates, and the leases electing the leader among them.
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// DefaultChannel is the Redis channel the updates are published to.
+const DefaultChannel = "rates"
+
+// Topic publishes the updates as JSON to a Redis channel. As Redis Pub/Sub does not keep the messages,
+// subscriptions only receive the updates published while they are connected.
+type Topic struct {
+	client  *goredis.Client
+	channel string
+
+	mutex         sync.Mutex
+	subscriptions map[*goredis.PubSub]struct{}
+	closed        bool
+	// done is closed once the topic is closed, ending the subscriptions blocked sending an update
+	done chan struct{}
+}
+
+// NewTopic creates a topic over the channel. The client is not closed by the topic.
+func NewTopic(client *goredis.Client, channel string) *Topic {
+	return &Topic{
+		client:        client,
+		channel:       channel,
+		subscriptions: make(map[*goredis.PubSub]struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+func (t *Topic) Publish(ctx context.Context, rate exchange.RateUpdated) error {
+	payload, err := json.Marshal(rate)
+	if err != nil {
+		return fmt.Errorf("failed to encode the rate: %v", err)
+	}
+	if err := t.client.Publish(ctx, t.channel, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %v", t.channel, err)
+	}
+	return nil
+}
+
+func (t *Topic) Subscribe(ctx context.Context) (<-chan exchange.RateUpdated, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return nil, exchange.ErrTopicClosed
+	}
+
+	pubsub := t.client.Subscribe(ctx, t.channel)
+	// wait for the confirmation, so the updates published once Subscribe returns are received
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s: %v", t.channel, err)
+	}
+	t.subscriptions[pubsub] = struct{}{}
+
+	updates := make(chan exchange.RateUpdated)
+	go func() {
+		defer close(updates)
+		defer t.unsubscribe(pubsub)
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				var rate exchange.RateUpdated
+				if err := json.Unmarshal([]byte(message.Payload), &rate); err != nil {
+					log.Printf("Discarding invalid rate update from %s: %v", t.channel, err)
+					continue
+				}
+				select {
+				case updates <- rate:
+				case <-ctx.Done():
+					return
+				case <-t.done:
+					return
+				}
+			}
+		}
+	}()
+
+	return updates, nil
+}
+
+// Close closes every subscription.
+func (t *Topic) Close() error {
+	t.mutex.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.done)
+	}
+	subscriptions := make([]*goredis.PubSub, 0, len(t.subscriptions))
+	for pubsub := range t.subscriptions {
+		subscriptions = append(subscriptions, pubsub)
+	}
+	t.mutex.Unlock()
+
+	for _, pubsub := range subscriptions {
+		t.unsubscribe(pubsub)
+	}
+	return nil
+}
+
+func (t *Topic) unsubscribe(pubsub *goredis.PubSub) {
+	t.mutex.Lock()
+	_, ok := t.subscriptions[pubsub]
+	delete(t.subscriptions, pubsub)
+	t.mutex.Unlock()
+
+	if ok {
+		// closing it closes its channel, which ends the subscription
+		if err := pubsub.Close(); err != nil {
+			log.Printf("Failed to close the subscription to %s: %v", t.channel, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopic(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	rate := exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 10).UTC(), Rate: "69,420.00", Source: "coindesk"}
+
+	// two topics with their own clients, like two instances of the service
+	first, second := newTopic(t, server), newTopic(t, server)
+	firstUpdates, err := first.Subscribe(ctx)
+	require.NoError(t, err)
+	cancelled, cancel := context.WithCancel(ctx)
+	secondUpdates, err := second.Subscribe(cancelled)
+	require.NoError(t, err)
+
+	// every instance receives the updates published by any of them
+	require.NoError(t, first.Publish(ctx, rate))
+	assert.Equal(t, rate, receive(t, firstUpdates))
+	assert.Equal(t, rate, receive(t, secondUpdates))
+
+	// invalid updates are discarded
+	server.Publish(DefaultChannel, "not a rate")
+	require.NoError(t, second.Publish(ctx, rate))
+	assert.Equal(t, rate, receive(t, firstUpdates))
+	assert.Equal(t, rate, receive(t, secondUpdates))
+
+	// a subscription is closed once its context is done
+	cancel()
+	assertClosed(t, secondUpdates)
+
+	// and all of them once the topic is closed
+	require.NoError(t, first.Close())
+	assertClosed(t, firstUpdates)
+	_, err = first.Subscribe(ctx)
+	assert.ErrorIs(t, err, exchange.ErrTopicClosed)
+}
+
+func TestTopic_Unavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	topic := newTopic(t, server)
+	server.Close()
+
+	_, err := topic.Subscribe(context.Background())
+	assert.ErrorContains(t, err, "failed to subscribe to rates")
+	assert.ErrorContains(t, topic.Publish(context.Background(), exchange.RateUpdated{}), "failed to publish to rates")
+}
+
+func newTopic(t *testing.T, server *miniredis.Miniredis) *Topic {
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	topic := NewTopic(client, DefaultChannel)
+	t.Cleanup(func() { topic.Close() })
+	return topic
+}
+
+func receive(t *testing.T, updates <-chan exchange.RateUpdated) exchange.RateUpdated {
+	t.Helper()
+	select {
+	case update, ok := <-updates:
+		require.True(t, ok, "subscription closed")
+		return update
+	case <-time.After(time.Second):
+		require.FailNow(t, "no update received")
+		return exchange.RateUpdated{}
+	}
+}
+
+func assertClosed(t *testing.T, updates <-chan exchange.RateUpdated) {
+	t.Helper()
+	select {
+	case _, ok := <-updates:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		assert.Fail(t, "subscription not closed")
+	}
+}
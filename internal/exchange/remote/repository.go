@@ -0,0 +1,130 @@
+// Package remote reads the rates persisted by another instance of the service through its REST API, so the
+// WebSocket gateways serve the history without a repository of their own.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+)
+
+// maxPageSize is the maximum number of rates the API lists in a single page.
+const maxPageSize = 1000
+
+type Repository struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewRepository creates a repository querying the API at the base URL, e.g. http://exchange-api:8080.
+func NewRepository(baseURL string, timeout time.Duration) *Repository {
+	return &Repository{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// ListSince returns the rates newer than since from GET /v1/rates, following all its pages.
+func (r *Repository) ListSince(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error) {
+	return r.List(ctx, exchange.Query{Since: since})
+}
+
+// List returns the rates matching the query from GET /v1/rates, following its pages until the limit.
+// The API only filters the rates by their since time.
+func (r *Repository) List(ctx context.Context, query exchange.Query) ([]exchange.RateUpdated, error) {
+	if query.Pair != "" || !query.Until.IsZero() || query.Descending {
+		return nil, errors.New("only the since time, offset and limit of the query are supported")
+	}
+
+	var (
+		rates  []exchange.RateUpdated
+		offset = query.Offset
+	)
+	for {
+		values := url.Values{}
+		values.Set("since", query.Since.UTC().Format(time.RFC3339Nano))
+		values.Set("offset", strconv.Itoa(offset))
+		if query.Limit > 0 {
+			values.Set("limit", strconv.Itoa(min(query.Limit-len(rates), maxPageSize)))
+		}
+
+		var data struct {
+			Rates      []exchange.RateUpdated `json:"rates"`
+			NextOffset *int                   `json:"nextOffset"`
+		}
+		if err := r.get(ctx, "/v1/rates?"+values.Encode(), &data); err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, data.Rates...)
+		if data.NextOffset == nil || len(data.Rates) == 0 || (query.Limit > 0 && len(rates) >= query.Limit) {
+			return rates, nil
+		}
+		offset = *data.NextOffset
+	}
+}
+
+// RateAt returns the last rate of the pair at or before at, within the lookback, from GET /v1/rates/at.
+func (r *Repository) RateAt(ctx context.Context, pair string, at time.Time, lookback time.Duration) (exchange.RateUpdated, bool, error) {
+	query := url.Values{}
+	query.Set("pair", pair)
+	query.Set("at", at.UTC().Format(time.RFC3339Nano))
+	query.Set("lookback", lookback.String())
+
+	var data struct {
+		Rates []struct {
+			Rate *exchange.RateUpdated `json:"rate"`
+		} `json:"rates"`
+	}
+	if err := r.get(ctx, "/v1/rates/at?"+query.Encode(), &data); err != nil {
+		return exchange.RateUpdated{}, false, err
+	}
+	if len(data.Rates) != 1 {
+		return exchange.RateUpdated{}, false, fmt.Errorf("unexpected number of rates: %d", len(data.Rates))
+	}
+	if data.Rates[0].Rate == nil {
+		return exchange.RateUpdated{}, false, nil
+	}
+
+	return *data.Rates[0].Rate, true, nil
+}
+
+func (r *Repository) get(ctx context.Context, path string, data any) error {
+	url := r.baseURL + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch data at %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d, payload: %s", resp.StatusCode, body)
+	}
+
+	if err := json.Unmarshal(body, data); err != nil {
+		return fmt.Errorf("failed to parse JSON: %v, payload: %s", err, body)
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_ListSince(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/rates", r.URL.Path)
+		assert.Equal(t, "2026-03-01T12:00:00Z", r.URL.Query().Get("since"))
+		w.Write([]byte(`{"rates":[{"from":"EUR","to":"BTC","at":"2026-03-01T12:01:00Z","rate":"78,000.12","source":"coindesk"}]}`))
+	}))
+	defer server.Close()
+
+	rates, err := NewRepository(server.URL+"/", time.Second).ListSince(context.Background(), time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, []exchange.RateUpdated{
+		{From: "EUR", To: "BTC", At: time.Date(2026, 3, 1, 12, 1, 0, 0, time.UTC), Rate: "78,000.12", Source: "coindesk"},
+	}, rates)
+}
+
+func TestRepository_List(t *testing.T) {
+	since := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	pages := map[string]string{
+		"0": `{"rates":[{"from":"EUR","to":"BTC","at":"2026-03-01T12:01:00Z","rate":"1"},{"from":"USD","to":"BTC","at":"2026-03-01T12:01:00Z","rate":"2"}],"nextOffset":2}`,
+		"2": `{"rates":[{"from":"EUR","to":"BTC","at":"2026-03-01T12:02:00Z","rate":"3"}]}`,
+	}
+	var limits []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "2026-03-01T12:00:00Z", r.URL.Query().Get("since"))
+		limits = append(limits, r.URL.Query().Get("limit"))
+		w.Write([]byte(pages[r.URL.Query().Get("offset")]))
+	}))
+	defer server.Close()
+	repository := NewRepository(server.URL, time.Second)
+
+	t.Run("follows every page", func(t *testing.T) {
+		limits = nil
+		rates, err := repository.ListSince(context.Background(), since)
+		require.NoError(t, err)
+		assert.Equal(t, []exchange.RateUpdated{
+			{From: "EUR", To: "BTC", At: since.Add(time.Minute), Rate: "1"},
+			{From: "USD", To: "BTC", At: since.Add(time.Minute), Rate: "2"},
+			{From: "EUR", To: "BTC", At: since.Add(2 * time.Minute), Rate: "3"},
+		}, rates)
+		assert.Equal(t, []string{"", ""}, limits)
+	})
+
+	t.Run("stops at the limit", func(t *testing.T) {
+		limits = nil
+		rates, err := repository.List(context.Background(), exchange.Query{Since: since, Limit: 2})
+		require.NoError(t, err)
+		assert.Len(t, rates, 2)
+		assert.Equal(t, []string{"2"}, limits)
+	})
+
+	t.Run("unsupported filters", func(t *testing.T) {
+		_, err := repository.List(context.Background(), exchange.Query{Since: since, Pair: "EUR-BTC"})
+		assert.Error(t, err)
+	})
+}
+
+func TestRepository_RateAt(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		response      string
+		status        int
+		expectedRate  exchange.RateUpdated
+		expectedFound bool
+		expectedErr   string
+	}{
+		{
+			name:          "found",
+			response:      `{"pair":"EUR-BTC","rates":[{"at":"2026-03-01T12:00:00Z","rate":{"from":"EUR","to":"BTC","at":"2026-03-01T11:59:00Z","rate":"78,000.12","source":"coindesk"}}]}`,
+			status:        http.StatusOK,
+			expectedRate:  exchange.RateUpdated{From: "EUR", To: "BTC", At: at.Add(-time.Minute), Rate: "78,000.12", Source: "coindesk"},
+			expectedFound: true,
+		},
+		{
+			name:     "not found",
+			response: `{"pair":"EUR-BTC","rates":[{"at":"2026-03-01T12:00:00Z","rate":null}]}`,
+			status:   http.StatusOK,
+		},
+		{
+			name:        "error",
+			response:    `{"error":"failed to get rates"}`,
+			status:      http.StatusInternalServerError,
+			expectedErr: `unexpected status code: 500, payload: {"error":"failed to get rates"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/v1/rates/at", r.URL.Path)
+				assert.Equal(t, "EUR-BTC", r.URL.Query().Get("pair"))
+				assert.Equal(t, "2026-03-01T12:00:00Z", r.URL.Query().Get("at"))
+				assert.Equal(t, "1h0m0s", r.URL.Query().Get("lookback"))
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			rate, found, err := NewRepository(server.URL, time.Second).RateAt(context.Background(), "EUR-BTC", at, time.Hour)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedRate, rate)
+			assert.Equal(t, tt.expectedFound, found)
+		})
+	}
+}
@@ -1,46 +1,287 @@
 package exchange
 
 import (
-	"container/ring"
 	"context"
+	"log"
+	"slices"
+	"sort"
+	"sync"
 	"time"
 )
 
+// Query filters and paginates the rates listed from a repository. Rates are always sorted by At,
+// keeping the insertion order of the ones with the same At, reversed when they are listed in descending order.
+type Query struct {
+	// Pair only lists the rates of the pair, e.g. USD-BTC. Empty lists all the pairs.
+	Pair string
+	// Since only lists the rates with At newer than it.
+	Since time.Time
+	// Until only lists the rates with At not newer than it. Zero means no upper bound.
+	Until time.Time
+	// Offset is the number of matching rates skipped.
+	Offset int
+	// Limit is the maximum number of rates listed. Zero means no limit.
+	Limit int
+	// Descending lists the newest rates first.
+	Descending bool
+}
+
+// Matches returns whether the rate passes the filters of the query.
+func (q Query) Matches(rate RateUpdated) bool {
+	if q.Pair != "" && rate.Pair() != q.Pair {
+		return false
+	}
+	if !rate.At.After(q.Since) {
+		return false
+	}
+	return q.Until.IsZero() || !rate.At.After(q.Until)
+}
+
+// Paginate returns the page of the matching rates selected by the offset and limit of the query.
+func (q Query) Paginate(rates []RateUpdated) []RateUpdated {
+	if q.Offset >= len(rates) {
+		return nil
+	}
+	rates = rates[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(rates) {
+		rates = rates[:q.Limit]
+	}
+	return rates
+}
+
+// IsWithinLookback returns whether the rate, at or before the time, is not older than the lookback.
+// A zero lookback has no limit.
+func IsWithinLookback(rate RateUpdated, at time.Time, lookback time.Duration) bool {
+	return lookback <= 0 || !rate.At.Before(at.Add(-lookback))
+}
+
+// LookupRepository is a repository able to list the rates it contains.
+type LookupRepository interface {
+	Repository
+	List(ctx context.Context, query Query) ([]RateUpdated, error)
+}
+
+// Contains returns whether the repository has a rate of the same pair and source at the same time as the rate.
+// Only the rates of the pair at exactly that time are listed, as several sources can have a rate at that time.
+func Contains(ctx context.Context, repository LookupRepository, rate RateUpdated) (bool, error) {
+	existing, err := repository.List(ctx, Query{Pair: rate.Pair(), Since: rate.At.Add(-time.Nanosecond), Until: rate.At})
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(existing, func(existing RateUpdated) bool {
+		return existing.Source == rate.Source
+	}), nil
+}
+
+// InsertMissing inserts the rate unless the repository already contains it, so rates can be inserted again,
+// e.g. by a backfill, without duplicating them. It returns whether the rate was inserted.
+func InsertMissing(ctx context.Context, repository LookupRepository, rate RateUpdated) (bool, error) {
+	if contained, err := Contains(ctx, repository, rate); err != nil || contained {
+		return false, err
+	}
+
+	if err := repository.Insert(ctx, rate); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// InMemoryRepository keeps the rates of the last TTL in memory, sorted by At per pair.
+// It is safe for concurrent use.
 type InMemoryRepository struct {
-	rates *ring.Ring
+	ttl             time.Duration
+	maxRatesPerPair int
+	now             func() time.Time
+
+	mutex  sync.RWMutex
+	series map[string][]storedRate
+	// seq keeps the insertion order of the rates with the same At across pairs.
+	seq uint64
 }
 
-func NewInMemoryRepository(maxSize int) *InMemoryRepository {
+type storedRate struct {
+	rate RateUpdated
+	seq  uint64
+}
+
+// NewInMemoryRepository creates a repository that evicts the rates older than the TTL and,
+// to bound its memory, the oldest rates of a pair once it holds more than maxRatesPerPair.
+func NewInMemoryRepository(ttl time.Duration, maxRatesPerPair int) *InMemoryRepository {
 	return &InMemoryRepository{
-		rates: ring.New(maxSize),
+		ttl:             ttl,
+		maxRatesPerPair: maxRatesPerPair,
+		now:             time.Now,
+		series:          make(map[string][]storedRate),
 	}
 }
 
-// Insert adds the received RateUpdated into the ring buffer. In case the ring is full,
-// the oldest rate will be overriden.
+// Insert adds the received RateUpdated to the rates of its pair, evicting the expired ones.
 func (r *InMemoryRepository) Insert(_ context.Context, rate RateUpdated) error {
-	r.rates.Value = rate
-	r.rates = r.rates.Next()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// like the persistent repositories, the times are returned in UTC
+	rate.At = rate.At.UTC()
+	pair := rate.Pair()
+	rates := r.series[pair]
+
+	// rates usually arrive in order, so they are appended most of the times
+	position := sort.Search(len(rates), func(i int) bool {
+		return rates[i].rate.At.After(rate.At)
+	})
+	r.seq++
+	rates = slices.Insert(rates, position, storedRate{rate: rate, seq: r.seq})
+
+	rates = r.evict(rates)
+	if len(rates) == 0 {
+		delete(r.series, pair)
+		return nil
+	}
+	r.series[pair] = rates
 
 	return nil
 }
 
 // ListSince returns all the RateUpdated structs that have At newer than the passed since time.
-// TODO: we should add pagination as a very old since could return a lot of rates.
 func (r *InMemoryRepository) ListSince(ctx context.Context, since time.Time) ([]RateUpdated, error) {
-	var result []RateUpdated
+	return r.List(ctx, Query{Since: since})
+}
 
-	r.rates.Do(func(a any) {
-		if a == nil {
-			// empty ring node
-			return
+// List returns the rates matching the query, sorted by At.
+func (r *InMemoryRepository) List(_ context.Context, query Query) ([]RateUpdated, error) {
+	if expiration := r.now().Add(-r.ttl); query.Since.Before(expiration) {
+		query.Since = expiration
+	}
+
+	r.mutex.RLock()
+	var matching []storedRate
+	if query.Pair != "" {
+		matching = between(r.series[query.Pair], query.Since, query.Until)
+	} else {
+		for _, rates := range r.series {
+			matching = append(matching, between(rates, query.Since, query.Until)...)
 		}
+	}
+	r.mutex.RUnlock()
 
-		rate := a.(RateUpdated)
-		if rate.At.After(since) {
-			result = append(result, rate)
+	if query.Pair == "" {
+		sort.Slice(matching, func(i, j int) bool {
+			if !matching[i].rate.At.Equal(matching[j].rate.At) {
+				return matching[i].rate.At.Before(matching[j].rate.At)
+			}
+			return matching[i].seq < matching[j].seq
+		})
+	}
+
+	result := make([]RateUpdated, len(matching))
+	for i, stored := range matching {
+		result[i] = stored.rate
+	}
+	if query.Descending {
+		slices.Reverse(result)
+	}
+
+	return query.Paginate(result), nil
+}
+
+// RateAt returns the last rate of the pair at or before the time, as long as it is not older than the lookback.
+// A zero lookback only limits it by the TTL. False is returned when there is no such rate.
+func (r *InMemoryRepository) RateAt(_ context.Context, pair string, at time.Time, lookback time.Duration) (RateUpdated, bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	rates := r.series[pair]
+	position := sort.Search(len(rates), func(i int) bool {
+		return rates[i].rate.At.After(at)
+	})
+	if position == 0 {
+		return RateUpdated{}, false, nil
+	}
+
+	rate := rates[position-1].rate
+	if !IsWithinLookback(rate, at, lookback) || !rate.At.After(r.now().Add(-r.ttl)) {
+		return RateUpdated{}, false, nil
+	}
+	return rate, true, nil
+}
+
+// EvictExpired removes the expired rates of the pairs that are not updated anymore,
+// the ones of the updated pairs are already evicted on insert.
+func (r *InMemoryRepository) EvictExpired(_ context.Context) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var evicted int
+	for pair, rates := range r.series {
+		kept := r.evict(rates)
+		evicted += len(rates) - len(kept)
+		if len(kept) == 0 {
+			delete(r.series, pair)
+			continue
 		}
+		r.series[pair] = kept
+	}
+
+	return evicted, nil
+}
+
+// evict drops the expired rates and the oldest ones over the maximum, which are always at the beginning.
+func (r *InMemoryRepository) evict(rates []storedRate) []storedRate {
+	expiration := r.now().Add(-r.ttl)
+	drop := sort.Search(len(rates), func(i int) bool {
+		return rates[i].rate.At.After(expiration)
 	})
+	if r.maxRatesPerPair > 0 {
+		drop = max(drop, len(rates)-r.maxRatesPerPair)
+	}
+
+	// release the dropped rates, the underlying array is reallocated once append runs out of capacity
+	clear(rates[:drop])
+	return rates[drop:]
+}
+
+// between returns the rates with At in the (since, until] range, found with a binary search.
+func between(rates []storedRate, since, until time.Time) []storedRate {
+	from := sort.Search(len(rates), func(i int) bool {
+		return rates[i].rate.At.After(since)
+	})
+	to := len(rates)
+	if !until.IsZero() {
+		to = sort.Search(len(rates), func(i int) bool {
+			return rates[i].rate.At.After(until)
+		})
+	}
+	if from >= to {
+		return nil
+	}
 
-	return result, nil
+	return slices.Clone(rates[from:to])
+}
+
+// Evicter is implemented by repositories that need to periodically remove expired rates.
+type Evicter interface {
+	EvictExpired(ctx context.Context) (int, error)
+}
+
+// EvictPeriodically removes the expired rates from the repository every interval until
+// the context is done.
+func EvictPeriodically(ctx context.Context, evicter Evicter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			evicted, err := evicter.EvictExpired(ctx)
+			if err != nil {
+				log.Printf("Failed to evict expired rates: %v", err)
+				continue
+			}
+			if evicted > 0 {
+				log.Printf("Evicted %d expired rates", evicted)
+			}
+		}
+	}
 }
@@ -0,0 +1,15 @@
+package exchange_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/repotest"
+)
+
+func TestInMemoryRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T, ttl time.Duration) repotest.Repository {
+		return exchange.NewInMemoryRepository(ttl, 1000)
+	})
+}
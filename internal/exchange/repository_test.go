@@ -6,56 +6,36 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestInMemoryRepository_Insert(t *testing.T) {
-	repo := NewInMemoryRepository(3)
+	repo := NewInMemoryRepository(time.Hour, 3)
 	ctx := context.Background()
+	now := time.Now().UTC()
 
-	// Test inserting a single rate
-	rate1 := RateUpdated{
-		From: "USD",
-		To:   "EUR",
-		At:   time.Now(),
-		Rate: "1.0",
-	}
-	err := repo.Insert(ctx, rate1)
-	assert.NoError(t, err)
-
-	// Test inserting multiple rates
-	rate2 := RateUpdated{
-		From: "USD",
-		To:   "EUR",
-		At:   time.Now().Add(time.Hour),
-		Rate: "2.0",
-	}
-	err = repo.Insert(ctx, rate2)
-	assert.NoError(t, err)
-
-	rate3 := RateUpdated{
-		From: "USD",
-		To:   "EUR",
-		At:   time.Now().Add(2 * time.Hour),
-		Rate: "3.0",
+	rates := []RateUpdated{
+		{From: "USD", To: "EUR", At: now.Add(-4 * time.Minute), Rate: "1.0"},
+		{From: "USD", To: "EUR", At: now.Add(-3 * time.Minute), Rate: "2.0"},
+		{From: "USD", To: "EUR", At: now.Add(-2 * time.Minute), Rate: "3.0"},
+		{From: "GBP", To: "EUR", At: now.Add(-2 * time.Minute), Rate: "5.0"},
+		{From: "USD", To: "EUR", At: now.Add(-1 * time.Minute), Rate: "4.0"},
 	}
-	err = repo.Insert(ctx, rate3)
-	assert.NoError(t, err)
-
-	// Test overwriting old rates
-	rate4 := RateUpdated{
-		From: "USD",
-		To:   "EUR",
-		At:   time.Now().Add(3 * time.Hour),
-		Rate: "4.0",
+	for _, rate := range rates {
+		assert.NoError(t, repo.Insert(ctx, rate))
 	}
-	err = repo.Insert(ctx, rate4)
-	assert.NoError(t, err)
+
+	// the oldest rate of the pair is evicted once it has more than the maximum,
+	// without evicting the ones of other pairs
+	result, err := repo.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, rates[1:], result)
 }
 
 func TestInMemoryRepository_ListSince(t *testing.T) {
-	repo := NewInMemoryRepository(5)
+	repo := NewInMemoryRepository(3*time.Hour, 5)
 	ctx := context.Background()
-	now := time.Now()
+	now := time.Now().UTC()
 
 	// Insert rates with different timestamps
 	rates := []RateUpdated{
@@ -94,16 +74,16 @@ func TestInMemoryRepository_ListSince(t *testing.T) {
 			since:    now.Add(-3 * time.Hour),
 			expected: rates,
 		},
-		// {
-		// 	name:     "some rates after since",
-		// 	since:    now.Add(-90 * time.Minute),
-		// 	expected: rates[1:],
-		// },
-		// {
-		// 	name:     "no rates after since",
-		// 	since:    now.Add(time.Hour),
-		// 	expected: []RateUpdated{},
-		// },
+		{
+			name:     "some rates after since",
+			since:    now.Add(-90 * time.Minute),
+			expected: rates[1:],
+		},
+		{
+			name:     "no rates after since",
+			since:    now.Add(time.Hour),
+			expected: []RateUpdated{},
+		},
 	}
 
 	for _, tt := range tests {
@@ -122,3 +102,85 @@ func TestInMemoryRepository_ListSince(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemoryRepository_EvictExpired(t *testing.T) {
+	repo := NewInMemoryRepository(time.Hour, 0)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	repo.now = func() time.Time { return now }
+
+	rates := []RateUpdated{
+		{From: "USD", To: "EUR", At: now.Add(-50 * time.Minute), Rate: "1.0"},
+		{From: "GBP", To: "EUR", At: now.Add(-40 * time.Minute), Rate: "2.0"},
+		{From: "USD", To: "EUR", At: now.Add(-10 * time.Minute), Rate: "3.0"},
+	}
+	for _, rate := range rates {
+		require.NoError(t, repo.Insert(ctx, rate))
+	}
+
+	// the expired rates of an updated pair are evicted on insert
+	now = now.Add(15 * time.Minute)
+	require.NoError(t, repo.Insert(ctx, RateUpdated{From: "USD", To: "EUR", At: now, Rate: "4.0"}))
+	assert.Len(t, repo.series["USD-EUR"], 2)
+
+	// the ones of the pairs that are not updated anymore by EvictExpired
+	now = now.Add(10 * time.Minute)
+	evicted, err := repo.EvictExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+	assert.NotContains(t, repo.series, "GBP-EUR")
+
+	result, err := repo.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []RateUpdated{rates[2], {From: "USD", To: "EUR", At: now.Add(-10 * time.Minute), Rate: "4.0"}}, result)
+}
+
+func TestInsertMissing(t *testing.T) {
+	repo := NewInMemoryRepository(time.Hour, 0)
+	ctx := context.Background()
+	at := time.Now().UTC().Add(-time.Minute)
+	rate := RateUpdated{From: "USD", To: "BTC", At: at, Rate: "1.0", Source: "coindesk"}
+
+	inserted, err := InsertMissing(ctx, repo, rate)
+	require.NoError(t, err)
+	assert.True(t, inserted)
+
+	// inserting it again does not duplicate it
+	inserted, err = InsertMissing(ctx, repo, rate)
+	require.NoError(t, err)
+	assert.False(t, inserted)
+
+	// but the rates of other sources at the same time are inserted
+	other := RateUpdated{From: "USD", To: "BTC", At: at, Rate: "1.1", Source: "binance"}
+	inserted, err = InsertMissing(ctx, repo, other)
+	require.NoError(t, err)
+	assert.True(t, inserted)
+
+	// and none of them is inserted again, whichever was the last one
+	inserted, err = InsertMissing(ctx, repo, rate)
+	require.NoError(t, err)
+	assert.False(t, inserted)
+
+	result, err := repo.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []RateUpdated{rate, other}, result)
+}
+
+func BenchmarkInMemoryRepository_ListSince(b *testing.B) {
+	repo := NewInMemoryRepository(24*time.Hour, 0)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	// a day of rates every 5 seconds
+	for i := 17280; i > 0; i-- {
+		rate := RateUpdated{From: "USD", To: "BTC", At: now.Add(-time.Duration(i) * 5 * time.Second), Rate: "1.0"}
+		require.NoError(b, repo.Insert(ctx, rate))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.ListSince(ctx, now.Add(-time.Minute)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+// Package repotest provides a conformance test suite for the implementations of the rates repository,
+// so every backend behaves the same way.
+package repotest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Repository is the contract every repository must fulfill.
+type Repository interface {
+	Insert(ctx context.Context, rate exchange.RateUpdated) error
+	ListSince(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error)
+	List(ctx context.Context, query exchange.Query) ([]exchange.RateUpdated, error)
+	RateAt(ctx context.Context, pair string, at time.Time, lookback time.Duration) (exchange.RateUpdated, bool, error)
+}
+
+// Factory returns a new empty repository that never returns rates older than the TTL.
+type Factory func(t *testing.T, ttl time.Duration) Repository
+
+// Run runs the conformance test suite against the repositories created by the factory.
+func Run(t *testing.T, newRepository Factory) {
+	const ttl = time.Hour
+
+	t.Run("empty", func(t *testing.T) {
+		repository := newRepository(t, ttl)
+
+		result, err := repository.ListSince(context.Background(), time.Now().Add(-time.Minute))
+		require.NoError(t, err)
+		assert.Empty(t, result)
+
+		result, err = repository.List(context.Background(), exchange.Query{Pair: "USD-BTC", Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, result)
+	})
+
+	t.Run("lists rates newer than since", func(t *testing.T) {
+		ctx := context.Background()
+		repository := newRepository(t, ttl)
+		now := time.Now().UTC()
+		rates := insert(t, repository,
+			rate("USD", "BTC", now.Add(-3*time.Minute), "84,000.1"),
+			rate("EUR", "BTC", now.Add(-2*time.Minute), "78,000.2"),
+			rate("USD", "BTC", now.Add(-time.Minute), "84,100.3"),
+		)
+
+		result, err := repository.ListSince(ctx, now.Add(-30*time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, rates, result)
+
+		result, err = repository.ListSince(ctx, now.Add(-90*time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, rates[2:], result)
+
+		result, err = repository.ListSince(ctx, now)
+		require.NoError(t, err)
+		assert.Empty(t, result)
+	})
+
+	t.Run("since is exclusive and until is inclusive", func(t *testing.T) {
+		ctx := context.Background()
+		repository := newRepository(t, ttl)
+		now := time.Now().UTC()
+		rates := insert(t, repository,
+			rate("USD", "BTC", now.Add(-3*time.Minute), "1"),
+			rate("USD", "BTC", now.Add(-2*time.Minute), "2"),
+			rate("USD", "BTC", now.Add(-time.Minute), "3"),
+		)
+
+		testCases := map[string]struct {
+			query    exchange.Query
+			expected []exchange.RateUpdated
+		}{
+			"since a rate":              {query: exchange.Query{Since: rates[0].At}, expected: rates[1:]},
+			"since right before a rate": {query: exchange.Query{Since: rates[0].At.Add(-time.Nanosecond)}, expected: rates},
+			"until a rate":              {query: exchange.Query{Until: rates[1].At}, expected: rates[:2]},
+			"until right before a rate": {query: exchange.Query{Until: rates[1].At.Add(-time.Nanosecond)}, expected: rates[:1]},
+			"since and until the same":  {query: exchange.Query{Since: rates[1].At, Until: rates[1].At}},
+			"since and until a rate":    {query: exchange.Query{Since: rates[0].At, Until: rates[1].At}, expected: rates[1:2]},
+			"until before since":        {query: exchange.Query{Since: rates[2].At, Until: rates[0].At}},
+			"zero since and zero until": {query: exchange.Query{}, expected: rates},
+		}
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				result, err := repository.List(ctx, tc.query)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, result)
+			})
+		}
+	})
+
+	t.Run("sorts by At", func(t *testing.T) {
+		repository := newRepository(t, ttl)
+		now := time.Now().UTC()
+		rates := []exchange.RateUpdated{
+			rate("USD", "BTC", now.Add(-3*time.Minute), "1"),
+			rate("EUR", "BTC", now.Add(-2*time.Minute), "2"),
+			rate("USD", "BTC", now.Add(-time.Minute), "3"),
+		}
+		insert(t, repository, rates[2], rates[0], rates[1])
+
+		result, err := repository.ListSince(context.Background(), time.Time{})
+		require.NoError(t, err)
+		assert.Equal(t, rates, result)
+	})
+
+	t.Run("keeps the insertion order of rates with the same At", func(t *testing.T) {
+		repository := newRepository(t, ttl)
+		at := time.Now().UTC().Add(-time.Minute)
+		rates := insert(t, repository,
+			rate("USD", "BTC", at, "1"),
+			rate("EUR", "BTC", at, "2"),
+			rate("USD", "BTC", at, "3"),
+		)
+
+		result, err := repository.ListSince(context.Background(), at.Add(-time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, rates, result)
+	})
+
+	t.Run("keeps all the fields", func(t *testing.T) {
+		repository := newRepository(t, ttl)
+		rates := insert(t, repository, exchange.RateUpdated{
+			From:   "USD",
+			To:     "BTC",
+			At:     time.Now().UTC().Add(-2 * time.Minute),
+			Rate:   "84,000.1234",
+			Source: "coindesk",
+		}, exchange.RateUpdated{
+			From:   "USD",
+			To:     "BTC",
+			At:     time.Now().UTC().Add(-time.Minute).Truncate(time.Minute),
+			Rate:   "84,100.00",
+			Source: "coindesk",
+			Open:   "84,000.00",
+			High:   "84,200.00",
+			Low:    "83,900.00",
+		})
+
+		result, err := repository.ListSince(context.Background(), time.Time{})
+		require.NoError(t, err)
+		assert.Equal(t, rates, result)
+	})
+
+	t.Run("returns the times in UTC", func(t *testing.T) {
+		ctx := context.Background()
+		repository := newRepository(t, ttl)
+		at := time.Now().In(time.FixedZone("UTC+5", 5*60*60)).Add(-time.Minute)
+		require.NoError(t, repository.Insert(ctx, rate("USD", "BTC", at, "1")))
+
+		result, err := repository.ListSince(ctx, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, result, 1)
+		assert.Equal(t, time.UTC, result[0].At.Location())
+		assert.True(t, at.Equal(result[0].At))
+
+		found, ok, err := repository.RateAt(ctx, "USD-BTC", at, 0)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, time.UTC, found.At.Location())
+	})
+
+	t.Run("filters by pair", func(t *testing.T) {
+		ctx := context.Background()
+		repository := newRepository(t, ttl)
+		now := time.Now().UTC()
+		rates := insert(t, repository,
+			rate("USD", "BTC", now.Add(-4*time.Minute), "1"),
+			rate("EUR", "BTC", now.Add(-3*time.Minute), "2"),
+			// a pair that starts like another one
+			rate("USD", "BTCX", now.Add(-2*time.Minute), "3"),
+			rate("USD", "BTC", now.Add(-time.Minute), "4"),
+		)
+
+		result, err := repository.List(ctx, exchange.Query{Pair: "USD-BTC"})
+		require.NoError(t, err)
+		assert.Equal(t, []exchange.RateUpdated{rates[0], rates[3]}, result)
+
+		result, err = repository.List(ctx, exchange.Query{Pair: "USD-BTC", Since: rates[0].At})
+		require.NoError(t, err)
+		assert.Equal(t, rates[3:], result)
+
+		result, err = repository.List(ctx, exchange.Query{Pair: "EUR-BTC", Until: rates[1].At})
+		require.NoError(t, err)
+		assert.Equal(t, rates[1:2], result)
+
+		result, err = repository.List(ctx, exchange.Query{Pair: "GBP-BTC"})
+		require.NoError(t, err)
+		assert.Empty(t, result)
+	})
+
+	t.Run("paginates", func(t *testing.T) {
+		ctx := context.Background()
+		repository := newRepository(t, ttl)
+		now := time.Now().UTC()
+		var rates []exchange.RateUpdated
+		for i := 0; i < 5; i++ {
+			rates = append(rates, rate("USD", "BTC", now.Add(-time.Duration(10-i)*time.Minute), fmt.Sprint(i)))
+			rates = append(rates, rate("EUR", "BTC", now.Add(-time.Duration(10-i)*time.Minute), fmt.Sprint(i)))
+		}
+		insert(t, repository, rates...)
+
+		var pages []exchange.RateUpdated
+		for offset := 0; ; offset += 3 {
+			page, err := repository.List(ctx, exchange.Query{Offset: offset, Limit: 3})
+			require.NoError(t, err)
+			if len(page) == 0 {
+				break
+			}
+			assert.LessOrEqual(t, len(page), 3)
+			pages = append(pages, page...)
+		}
+		assert.Equal(t, rates, pages)
+
+		result, err := repository.List(ctx, exchange.Query{Pair: "EUR-BTC", Offset: 1, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []exchange.RateUpdated{rates[3], rates[5]}, result)
+
+		result, err = repository.List(ctx, exchange.Query{Pair: "EUR-BTC", Offset: 3})
+		require.NoError(t, err)
+		assert.Equal(t, []exchange.RateUpdated{rates[7], rates[9]}, result)
+
+		result, err = repository.List(ctx, exchange.Query{Since: rates[7].At, Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, rates[8:9], result)
+
+		result, err = repository.List(ctx, exchange.Query{Offset: len(rates)})
+		require.NoError(t, err)
+		assert.Empty(t, result)
+
+		result, err = repository.List(ctx, exchange.Query{Descending: true, Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, rates[9:], result)
+
+		result, err = repository.List(ctx, exchange.Query{Pair: "USD-BTC", Descending: true, Offset: 1, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []exchange.RateUpdated{rates[6], rates[4]}, result)
+
+		result, err = repository.List(ctx, exchange.Query{Since: rates[3].At, Until: rates[7].At, Descending: true})
+		require.NoError(t, err)
+		assert.Equal(t, []exchange.RateUpdated{rates[7], rates[6], rates[5], rates[4]}, result)
+	})
+
+	t.Run("rate at", func(t *testing.T) {
+		ctx := context.Background()
+		repository := newRepository(t, ttl)
+		now := time.Now().UTC()
+		rates := insert(t, repository,
+			rate("USD", "BTC", now.Add(-10*time.Minute), "1"),
+			rate("USD", "BTC", now.Add(-5*time.Minute), "2"),
+			rate("EUR", "BTC", now.Add(-4*time.Minute), "3"),
+			rate("USD", "BTC", now.Add(-2*time.Minute), "4"),
+			rate("USD", "BTC", now.Add(-2*time.Minute), "5"),
+		)
+
+		testCases := map[string]struct {
+			pair     string
+			at       time.Time
+			lookback time.Duration
+			expected *exchange.RateUpdated
+		}{
+			"between rates":                     {pair: "USD-BTC", at: now.Add(-3 * time.Minute), expected: &rates[1]},
+			"at a rate":                         {pair: "USD-BTC", at: rates[1].At, expected: &rates[1]},
+			"right before a rate":               {pair: "USD-BTC", at: rates[1].At.Add(-time.Nanosecond), expected: &rates[0]},
+			"after the last rate":               {pair: "USD-BTC", at: now.Add(time.Hour), expected: &rates[4]},
+			"before the first rate":             {pair: "USD-BTC", at: rates[0].At.Add(-time.Minute)},
+			"within the lookback":               {pair: "USD-BTC", at: now.Add(-3 * time.Minute), lookback: 2 * time.Minute, expected: &rates[1]},
+			"out of the lookback":               {pair: "USD-BTC", at: now.Add(-3 * time.Minute), lookback: 2*time.Minute - time.Nanosecond},
+			"ignores the rates of other pairs":  {pair: "EUR-BTC", at: now.Add(-3 * time.Minute), lookback: time.Minute, expected: &rates[2]},
+			"before the first rate of the pair": {pair: "EUR-BTC", at: now.Add(-5 * time.Minute)},
+			"unknown pair":                      {pair: "GBP-BTC", at: now},
+		}
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				result, found, err := repository.RateAt(ctx, tc.pair, tc.at, tc.lookback)
+				require.NoError(t, err)
+				if tc.expected == nil {
+					assert.False(t, found)
+					return
+				}
+				assert.True(t, found)
+				assert.Equal(t, *tc.expected, result)
+			})
+		}
+	})
+
+	t.Run("insert missing", func(t *testing.T) {
+		ctx := context.Background()
+		repository := newRepository(t, ttl)
+		now := time.Now().UTC()
+		coindesk := rate("USD", "BTC", now.Add(-time.Minute), "1")
+		binance := coindesk
+		binance.Source, binance.Rate = "binance", "2"
+		other := rate("EUR", "BTC", coindesk.At, "3")
+
+		for _, r := range []exchange.RateUpdated{coindesk, binance, other} {
+			inserted, err := exchange.InsertMissing(ctx, repository, r)
+			require.NoError(t, err)
+			assert.True(t, inserted)
+		}
+
+		// every source at the same pair and time is found, not only the last one
+		for _, r := range []exchange.RateUpdated{coindesk, binance, other} {
+			inserted, err := exchange.InsertMissing(ctx, repository, r)
+			require.NoError(t, err)
+			assert.False(t, inserted)
+		}
+
+		result, err := repository.ListSince(ctx, time.Time{})
+		require.NoError(t, err)
+		assert.Equal(t, []exchange.RateUpdated{coindesk, binance, other}, result)
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		ctx := context.Background()
+		repository := newRepository(t, ttl)
+		now := time.Now().UTC()
+		insert(t, repository,
+			rate("USD", "BTC", now.Add(-2*ttl), "1"),
+			rate("EUR", "BTC", now.Add(-ttl-time.Minute), "2"),
+		)
+		fresh := insert(t, repository,
+			rate("USD", "BTC", now.Add(-ttl+time.Minute), "3"),
+			rate("EUR", "BTC", now.Add(-time.Minute), "4"),
+		)
+
+		// expired rates are never returned, even before being evicted
+		assertFresh := func() {
+			t.Helper()
+
+			result, err := repository.ListSince(ctx, time.Time{})
+			require.NoError(t, err)
+			assert.Equal(t, fresh, result)
+
+			result, err = repository.List(ctx, exchange.Query{Pair: "USD-BTC", Until: now})
+			require.NoError(t, err)
+			assert.Equal(t, fresh[:1], result)
+
+			_, found, err := repository.RateAt(ctx, "USD-BTC", now.Add(-ttl-time.Second), 0)
+			require.NoError(t, err)
+			assert.False(t, found)
+		}
+		assertFresh()
+
+		if evicter, ok := repository.(exchange.Evicter); ok {
+			// some repositories evict whole chunks of rates, so not all the expired ones may be evicted yet
+			evicted, err := evicter.EvictExpired(ctx)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, evicted, 2)
+			assertFresh()
+		}
+	})
+
+	t.Run("concurrent inserts and lists", func(t *testing.T) {
+		const (
+			writers = 4
+			rates   = 50
+			readers = 4
+		)
+
+		ctx := context.Background()
+		repository := newRepository(t, ttl)
+		now := time.Now().UTC().Add(-time.Minute)
+
+		var (
+			writing sync.WaitGroup
+			reading sync.WaitGroup
+			done    = make(chan struct{})
+		)
+		for w := 0; w < writers; w++ {
+			writing.Add(1)
+			go func() {
+				defer writing.Done()
+				for i := 0; i < rates; i++ {
+					at := now.Add(time.Duration(i*writers+w) * time.Millisecond)
+					assert.NoError(t, repository.Insert(ctx, rate("USD", "BTC", at, fmt.Sprint(w))))
+				}
+			}()
+		}
+		for r := 0; r < readers; r++ {
+			reading.Add(1)
+			go func() {
+				defer reading.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+
+					result, err := repository.List(ctx, exchange.Query{Pair: "USD-BTC"})
+					if !assert.NoError(t, err) {
+						return
+					}
+					assert.True(t, sort.SliceIsSorted(result, func(i, j int) bool {
+						return result[i].At.Before(result[j].At)
+					}))
+				}
+			}()
+		}
+
+		writing.Wait()
+		close(done)
+		reading.Wait()
+
+		result, err := repository.ListSince(ctx, time.Time{})
+		require.NoError(t, err)
+		assert.Len(t, result, writers*rates)
+	})
+}
+
+func rate(from, to string, at time.Time, value string) exchange.RateUpdated {
+	return exchange.RateUpdated{From: from, To: to, At: at, Rate: value, Source: "coindesk"}
+}
+
+func insert(t *testing.T, repository Repository, rates ...exchange.RateUpdated) []exchange.RateUpdated {
+	t.Helper()
+
+	for _, rate := range rates {
+		require.NoError(t, repository.Insert(context.Background(), rate))
+	}
+	return rates
+}
@@ -0,0 +1,614 @@
+// Package retention keeps the history of the rates at decreasing resolutions as it gets older,
+// so months of rates do not need to be stored at the rate they are fetched.
+package retention
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+)
+
+// Store is where the rates of a tier are kept. It must not return the rates older than the retention of the tier.
+type Store interface {
+	Insert(ctx context.Context, rate exchange.RateUpdated) error
+	List(ctx context.Context, query exchange.Query) ([]exchange.RateUpdated, error)
+	RateAt(ctx context.Context, pair string, at time.Time, lookback time.Duration) (exchange.RateUpdated, bool, error)
+}
+
+// Tier keeps the rates at the resolution for the retention period.
+type Tier struct {
+	// Resolution is the size of the buckets the rates are downsampled to. Zero keeps every rate.
+	Resolution time.Duration
+	// Retention is how long the rates are kept.
+	Retention time.Duration
+	Store     Store
+}
+
+// Repository inserts the rates into the raw tier, and a compaction job downsamples every tier into the next one,
+// aggregating the rates of every pair, source and bucket into their open, high, low and close. Rates are listed
+// from the finest tier that covers the requested range, and the finer ones for the buckets it has not compacted yet.
+//
+// The buckets receiving rates once compacted are compacted again, adding a newer version of their aggregates,
+// and only the newest version of every aggregate is listed.
+type Repository struct {
+	tiers []Tier
+	now   func() time.Time
+
+	// mutex serializes the compactions and the historical inserts
+	mutex sync.Mutex
+
+	watermarkMutex sync.Mutex
+	// compactedUntil is the end of the last bucket compacted into every tier, zero until it is known.
+	compactedUntil []time.Time
+	// stale are the starts of the buckets of every tier that received rates after their compaction.
+	stale []map[time.Time]struct{}
+}
+
+// NewRepository creates a repository with the tiers, sorted from the finest to the coarsest resolution.
+// The first one must keep the raw rates.
+func NewRepository(tiers []Tier) (*Repository, error) {
+	if len(tiers) == 0 || tiers[0].Resolution != 0 {
+		return nil, errors.New("the first tier must keep the raw rates")
+	}
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].Resolution <= tiers[i-1].Resolution {
+			return nil, fmt.Errorf("tiers must be sorted by resolution, %s is not coarser than %s", tiers[i].Resolution, tiers[i-1].Resolution)
+		}
+		if tiers[i].Retention < tiers[i-1].Retention {
+			return nil, fmt.Errorf("tiers must be sorted by retention, %s tier keeps less than the previous one", tiers[i].Resolution)
+		}
+	}
+
+	stale := make([]map[time.Time]struct{}, len(tiers))
+	for i := range stale {
+		stale[i] = make(map[time.Time]struct{})
+	}
+	return &Repository{
+		tiers:          tiers,
+		now:            time.Now,
+		compactedUntil: make([]time.Time, len(tiers)),
+		stale:          stale,
+	}, nil
+}
+
+func (r *Repository) Insert(ctx context.Context, rate exchange.RateUpdated) error {
+	return r.InsertBatch(ctx, []exchange.RateUpdated{rate})
+}
+
+// InsertBatch inserts the rates at once when the raw tier supports it. Rates older than the raw retention,
+// e.g. the ones drained after an outage, are inserted into the coarser tiers like the historical ones.
+func (r *Repository) InsertBatch(ctx context.Context, rates []exchange.RateUpdated) error {
+	var recent, old []exchange.RateUpdated
+	oldest := r.now().Add(-r.tiers[0].Retention)
+	for _, rate := range rates {
+		if rate.At.After(oldest) {
+			recent = append(recent, rate)
+		} else {
+			old = append(old, rate)
+		}
+	}
+
+	if err := r.insertRaw(ctx, recent); err != nil {
+		return err
+	}
+	r.markStale(1, recent)
+
+	for _, rate := range old {
+		if _, err := r.InsertHistorical(ctx, rate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) insertRaw(ctx context.Context, rates []exchange.RateUpdated) error {
+	if len(rates) == 0 {
+		return nil
+	}
+	if store, ok := r.tiers[0].Store.(exchange.BatchRepository); ok {
+		return store.InsertBatch(ctx, rates)
+	}
+
+	for _, rate := range rates {
+		if err := r.tiers[0].Store.Insert(ctx, rate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertHistorical inserts a rate of the past, e.g. from a backfill, into the finest tier whose retention covers it,
+// and the next compaction aggregates it into the coarser ones. A coarser tier only keeps it when its bucket has no
+// aggregate of the pair and source yet, which would be more accurate. It returns whether the rate was inserted,
+// false when it was already there or it is older than the retention of every tier.
+func (r *Repository) InsertHistorical(ctx context.Context, rate exchange.RateUpdated) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	for i, tier := range r.tiers {
+		if !rate.At.After(now.Add(-tier.Retention)) {
+			continue
+		}
+
+		var (
+			inserted bool
+			err      error
+		)
+		if i == 0 {
+			inserted, err = exchange.InsertMissing(ctx, tier.Store, rate)
+		} else {
+			inserted, err = r.fill(ctx, i, rate)
+		}
+		if err != nil || !inserted {
+			return false, err
+		}
+		r.markStale(i+1, []exchange.RateUpdated{rate})
+		return true, nil
+	}
+	return false, nil
+}
+
+// fill inserts the rate as the aggregate of its bucket of the tier, unless the bucket has one of its pair and source.
+func (r *Repository) fill(ctx context.Context, i int, rate exchange.RateUpdated) (bool, error) {
+	start := rate.At.Truncate(r.tiers[i].Resolution)
+	aggregates, err := r.tiers[i].Store.List(ctx, bucketQuery(rate.Pair(), start, r.tiers[i].Resolution))
+	if err != nil {
+		return false, err
+	}
+	for _, aggregate := range aggregates {
+		if aggregate.Source == rate.Source {
+			return false, nil
+		}
+	}
+
+	if err := r.tiers[i].Store.Insert(ctx, aggregate(start, []exchange.RateUpdated{rate})); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// markStale marks the buckets of the tier the rates belong to for another compaction, when they are complete.
+// The ones not compacted yet are skipped by the compaction of the stale buckets.
+func (r *Repository) markStale(i int, rates []exchange.RateUpdated) {
+	if i >= len(r.tiers) {
+		return
+	}
+
+	resolution := r.tiers[i].Resolution
+	current := r.now().Truncate(resolution)
+	r.watermarkMutex.Lock()
+	defer r.watermarkMutex.Unlock()
+	for _, rate := range rates {
+		if start := rate.At.Truncate(resolution); start.Before(current) {
+			r.stale[i][start] = struct{}{}
+		}
+	}
+}
+
+// ListSince returns the rates with At newer than the passed since time, at the resolution of the finest tier covering it.
+func (r *Repository) ListSince(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error) {
+	return r.List(ctx, exchange.Query{Since: since})
+}
+
+// List returns the rates matching the query from the finest tier that covers its since time. The rates after
+// the buckets compacted into that tier are listed from the finer tiers, so recent rates are never missing.
+func (r *Repository) List(ctx context.Context, query exchange.Query) ([]exchange.RateUpdated, error) {
+	first := r.tierFor(query.Since)
+	if first == 0 {
+		return r.tiers[0].Store.List(ctx, query)
+	}
+
+	queries, err := r.split(ctx, first, query)
+	if err != nil {
+		return nil, err
+	}
+	if query.Descending {
+		slices.Reverse(queries)
+	}
+
+	// the offset spans every tier, so the skipped rates are listed too
+	var result []exchange.RateUpdated
+	for _, q := range queries {
+		q.Descending = query.Descending
+		if query.Limit > 0 {
+			q.Limit = query.Offset + query.Limit - len(result)
+		}
+
+		rates, err := r.listTier(ctx, q.tier, q.Query)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rates...)
+		if query.Limit > 0 && len(result) >= query.Offset+query.Limit {
+			break
+		}
+	}
+
+	return query.Paginate(result), nil
+}
+
+// tierQuery is the part of a query listed from a tier.
+type tierQuery struct {
+	exchange.Query
+	tier int
+}
+
+// split splits the query from the first tier to the raw one, sorted by At. Every tier lists the rates
+// from the end of the buckets compacted into the coarser one.
+func (r *Repository) split(ctx context.Context, first int, query exchange.Query) ([]tierQuery, error) {
+	var queries []tierQuery
+	since := query.Since
+	for i := first; i >= 0; i-- {
+		q := exchange.Query{Pair: query.Pair, Since: since, Until: query.Until}
+		if i > 0 {
+			compactedUntil, err := r.watermark(ctx, i)
+			if err != nil {
+				return nil, err
+			}
+			if !compactedUntil.After(since) {
+				continue
+			}
+			// the tier has the aggregates of the buckets before the watermark, the finer one the rates after it
+			q.Until = compactedUntil.Add(-time.Nanosecond)
+			if !query.Until.IsZero() && query.Until.Before(q.Until) {
+				q.Until = query.Until
+			}
+			since = q.Until
+		}
+		queries = append(queries, tierQuery{Query: q, tier: i})
+
+		if !query.Until.IsZero() && !since.Before(query.Until) {
+			break
+		}
+	}
+	return queries, nil
+}
+
+// RateAt returns the last rate of the pair at or before the time from the finest tier that covers it,
+// so older times are answered with the aggregate of the last bucket complete at that time.
+func (r *Repository) RateAt(ctx context.Context, pair string, at time.Time, lookback time.Duration) (exchange.RateUpdated, bool, error) {
+	i := r.tierFor(at)
+	// aggregates are stamped at the start of their bucket, so the ones complete at the time start a bucket before it
+	return r.tiers[i].Store.RateAt(ctx, pair, at.Add(-r.tiers[i].Resolution), lookback)
+}
+
+// listTier lists the rates of the tier matching the query, only the newest version of the aggregates of the
+// coarser tiers. As the older versions are listed too, the limit is raised until the result is complete.
+func (r *Repository) listTier(ctx context.Context, i int, query exchange.Query) ([]exchange.RateUpdated, error) {
+	if i == 0 {
+		return r.tiers[0].Store.List(ctx, query)
+	}
+
+	limit := query.Limit
+	for {
+		rates, err := r.tiers[i].Store.List(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		result := newestVersions(rates, r.tiers[i].Resolution, query.Descending)
+		if limit == 0 || len(rates) < query.Limit {
+			return truncate(result, limit), nil
+		}
+		// the versions of an aggregate share its At, so the ones of the last needed aggregate were all listed
+		// when a later one follows it
+		if len(result) > limit && !result[len(result)-1].At.Equal(result[limit-1].At) {
+			return result[:limit], nil
+		}
+		query.Limit *= 2
+	}
+}
+
+// tierFor returns the finest tier covering the rates since the time. A tier missing less than a bucket of the
+// next one is still chosen, as the next tier would not have more information about that period anyway.
+func (r *Repository) tierFor(since time.Time) int {
+	now := r.now()
+	for i, tier := range r.tiers[:len(r.tiers)-1] {
+		if !since.Before(now.Add(-tier.Retention - r.tiers[i+1].Resolution)) {
+			return i
+		}
+	}
+	return len(r.tiers) - 1
+}
+
+// Compact downsamples the complete buckets of every tier that were not compacted yet into the next one,
+// and compacts again the ones that received rates since they were compacted.
+func (r *Repository) Compact(ctx context.Context) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i := 1; i < len(r.tiers); i++ {
+		if err := r.recompact(ctx, i); err != nil {
+			return fmt.Errorf("failed to compact the stale buckets of the %s tier: %v", r.tiers[i].Resolution, err)
+		}
+		if err := r.compact(ctx, i); err != nil {
+			return fmt.Errorf("failed to compact the %s tier: %v", r.tiers[i].Resolution, err)
+		}
+	}
+	return nil
+}
+
+// CompactPeriodically compacts the tiers every interval until the context is done.
+func (r *Repository) CompactPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Compact(ctx); err != nil {
+				log.Printf("Failed to compact the rates: %v", err)
+			}
+		}
+	}
+}
+
+// EvictExpired evicts the expired rates of every tier that needs it.
+func (r *Repository) EvictExpired(ctx context.Context) (int, error) {
+	var (
+		evicted int
+		errs    []error
+	)
+	for _, tier := range r.tiers {
+		if evicter, ok := tier.Store.(exchange.Evicter); ok {
+			n, err := evicter.EvictExpired(ctx)
+			evicted += n
+			errs = append(errs, err)
+		}
+	}
+	return evicted, errors.Join(errs...)
+}
+
+func (r *Repository) compact(ctx context.Context, i int) error {
+	target := r.tiers[i]
+
+	from, err := r.watermark(ctx, i)
+	if err != nil {
+		return err
+	}
+
+	// only complete buckets are compacted, the current one could still receive rates
+	until := r.now().Truncate(target.Resolution)
+	if !from.Before(until) {
+		return nil
+	}
+
+	rates, err := r.listTier(ctx, i-1, exchange.Query{
+		Since: from.Add(-time.Nanosecond),
+		Until: until.Add(-time.Nanosecond),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rate := range downsample(rates, target.Resolution) {
+		if err := target.Store.Insert(ctx, rate); err != nil {
+			return err
+		}
+	}
+
+	r.watermarkMutex.Lock()
+	r.compactedUntil[i] = until
+	r.watermarkMutex.Unlock()
+	return nil
+}
+
+// recompact compacts again the stale buckets of the tier, the ones not compacted yet are left to compact.
+func (r *Repository) recompact(ctx context.Context, i int) error {
+	r.watermarkMutex.Lock()
+	stale := r.stale[i]
+	r.stale[i] = make(map[time.Time]struct{})
+	r.watermarkMutex.Unlock()
+
+	compactedUntil, err := r.watermark(ctx, i)
+	if err != nil {
+		return err
+	}
+
+	starts := slices.SortedFunc(maps.Keys(stale), time.Time.Compare)
+	for n, start := range starts {
+		if !start.Before(compactedUntil) {
+			break
+		}
+		if err := r.recompactBucket(ctx, i, start); err != nil {
+			// the buckets left are compacted on the next compaction
+			r.watermarkMutex.Lock()
+			for _, start := range starts[n:] {
+				r.stale[i][start] = struct{}{}
+			}
+			r.watermarkMutex.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// recompactBucket downsamples the bucket of the tier again, inserting the aggregates that changed, and marks
+// the bucket of the next tier they belong to as stale.
+func (r *Repository) recompactBucket(ctx context.Context, i int, start time.Time) error {
+	source, target := r.tiers[i-1], r.tiers[i]
+	query := bucketQuery("", start, target.Resolution)
+
+	rates, err := r.listTier(ctx, i-1, query)
+	if err != nil {
+		return err
+	}
+	compacted, err := r.listTier(ctx, i, query)
+	if err != nil {
+		return err
+	}
+	if !start.After(r.now().Add(-source.Retention)) {
+		// the source tier misses the beginning of the bucket, so the rates are aggregated with the compacted ones
+		rates = slices.Concat(compacted, rates)
+		slices.SortStableFunc(rates, func(a, b exchange.RateUpdated) int { return a.At.Compare(b.At) })
+	}
+
+	current := make(map[bucketKey]exchange.RateUpdated, len(compacted))
+	for _, rate := range compacted {
+		current[keyOf(rate, target.Resolution)] = rate
+	}
+	var changed []exchange.RateUpdated
+	for _, rate := range downsample(rates, target.Resolution) {
+		if previous, ok := current[keyOf(rate, target.Resolution)]; ok && sameAggregate(previous, rate) {
+			continue
+		}
+		if err := target.Store.Insert(ctx, rate); err != nil {
+			return err
+		}
+		changed = append(changed, rate)
+	}
+
+	r.markStale(i+1, changed)
+	return nil
+}
+
+// watermark returns the end of the last bucket compacted into the tier, zero when none was compacted yet.
+func (r *Repository) watermark(ctx context.Context, i int) (time.Time, error) {
+	r.watermarkMutex.Lock()
+	defer r.watermarkMutex.Unlock()
+
+	if r.compactedUntil[i].IsZero() {
+		// resume after the last bucket compacted by a previous run
+		latest, err := latestAt(ctx, r.tiers[i].Store)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !latest.IsZero() {
+			r.compactedUntil[i] = latest.Truncate(r.tiers[i].Resolution).Add(r.tiers[i].Resolution)
+		}
+	}
+	return r.compactedUntil[i], nil
+}
+
+// bucketKey identifies the aggregate of a pair and source in a bucket.
+type bucketKey struct {
+	pair   string
+	source string
+	start  time.Time
+}
+
+func keyOf(rate exchange.RateUpdated, resolution time.Duration) bucketKey {
+	return bucketKey{pair: rate.Pair(), source: rate.Source, start: rate.At.Truncate(resolution)}
+}
+
+// downsample aggregates the rates, sorted by At, of every pair, source and bucket. The result is sorted by At.
+func downsample(rates []exchange.RateUpdated, resolution time.Duration) []exchange.RateUpdated {
+	buckets := make(map[bucketKey][]exchange.RateUpdated)
+	for _, rate := range rates {
+		key := keyOf(rate, resolution)
+		buckets[key] = append(buckets[key], rate)
+	}
+
+	result := make([]exchange.RateUpdated, 0, len(buckets))
+	for key, rates := range buckets {
+		result = append(result, aggregate(key.start, rates))
+	}
+	slices.SortFunc(result, func(a, b exchange.RateUpdated) int {
+		return cmp.Or(a.At.Compare(b.At), strings.Compare(a.Pair(), b.Pair()), strings.Compare(a.Source, b.Source))
+	})
+
+	return result
+}
+
+// aggregate returns the open, high, low and close of the rates of a bucket, sorted by At, stamped at its start.
+// The rates can be the aggregates of a finer tier.
+func aggregate(start time.Time, rates []exchange.RateUpdated) exchange.RateUpdated {
+	first := rates[0]
+	result := rates[len(rates)-1]
+	result.At = start
+	result.Open = cmp.Or(first.Open, first.Rate)
+	result.High = cmp.Or(first.High, first.Rate)
+	result.Low = cmp.Or(first.Low, first.Rate)
+	for _, rate := range rates[1:] {
+		if high := cmp.Or(rate.High, rate.Rate); compareRates(high, result.High) > 0 {
+			result.High = high
+		}
+		if low := cmp.Or(rate.Low, rate.Rate); compareRates(low, result.Low) < 0 {
+			result.Low = low
+		}
+	}
+	return result
+}
+
+// compareRates compares the values of two rates, which are equal when any of them is not a number.
+func compareRates(a, b string) int {
+	x, err := exchange.RateUpdated{Rate: a}.Value()
+	if err != nil {
+		return 0
+	}
+	y, err := exchange.RateUpdated{Rate: b}.Value()
+	if err != nil {
+		return 0
+	}
+	return cmp.Compare(x, y)
+}
+
+// sameAggregate reports whether both aggregates have the same values, regardless of the location of their At.
+func sameAggregate(a, b exchange.RateUpdated) bool {
+	if !a.At.Equal(b.At) {
+		return false
+	}
+	a.At, b.At = time.Time{}, time.Time{}
+	return a == b
+}
+
+// newestVersions keeps the newest version of every aggregate, the last one listed in ascending order
+// and the first one in descending order.
+func newestVersions(rates []exchange.RateUpdated, resolution time.Duration, descending bool) []exchange.RateUpdated {
+	rates = slices.Clone(rates)
+	if !descending {
+		slices.Reverse(rates)
+	}
+
+	seen := make(map[bucketKey]struct{}, len(rates))
+	result := rates[:0]
+	for _, rate := range rates {
+		key := keyOf(rate, resolution)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, rate)
+	}
+
+	if !descending {
+		slices.Reverse(result)
+	}
+	return result
+}
+
+func truncate(rates []exchange.RateUpdated, limit int) []exchange.RateUpdated {
+	if limit > 0 && len(rates) > limit {
+		return rates[:limit]
+	}
+	return rates
+}
+
+// bucketQuery returns the query listing the rates of the pair, or every pair when empty, within the bucket.
+func bucketQuery(pair string, start time.Time, resolution time.Duration) exchange.Query {
+	return exchange.Query{
+		Pair:  pair,
+		Since: start.Add(-time.Nanosecond),
+		Until: start.Add(resolution - time.Nanosecond),
+	}
+}
+
+// latestAt returns the newest At of the store, zero when it is empty.
+func latestAt(ctx context.Context, store Store) (time.Time, error) {
+	rates, err := store.List(ctx, exchange.Query{Limit: 1, Descending: true})
+	if err != nil || len(rates) == 0 {
+		return time.Time{}, err
+	}
+	return rates[0].At, nil
+}
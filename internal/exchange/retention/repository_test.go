@@ -0,0 +1,434 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRepository(t *testing.T) {
+	raw := Tier{Retention: 24 * time.Hour, Store: &fakeStore{}}
+	minute := Tier{Resolution: time.Minute, Retention: 30 * 24 * time.Hour, Store: &fakeStore{}}
+	hour := Tier{Resolution: time.Hour, Retention: 2 * 365 * 24 * time.Hour, Store: &fakeStore{}}
+
+	tests := []struct {
+		name          string
+		tiers         []Tier
+		expectedError string
+	}{
+		{name: "raw tier only", tiers: []Tier{raw}},
+		{name: "several tiers", tiers: []Tier{raw, minute, hour}},
+		{name: "no tiers", expectedError: "the first tier must keep the raw rates"},
+		{name: "no raw tier", tiers: []Tier{minute, hour}, expectedError: "the first tier must keep the raw rates"},
+		{name: "unsorted resolutions", tiers: []Tier{raw, hour, minute}, expectedError: "tiers must be sorted by resolution"},
+		{name: "decreasing retention", tiers: []Tier{raw, minute, {Resolution: time.Hour, Retention: time.Hour, Store: &fakeStore{}}}, expectedError: "tiers must be sorted by retention"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRepository(tt.tiers)
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRepository_List(t *testing.T) {
+	raw, minute, hour := &fakeStore{}, &fakeStore{}, &fakeStore{}
+	repository, err := NewRepository([]Tier{
+		{Retention: 24 * time.Hour, Store: raw},
+		{Resolution: time.Minute, Retention: 30 * 24 * time.Hour, Store: minute},
+		{Resolution: time.Hour, Retention: 2 * 365 * 24 * time.Hour, Store: hour},
+	})
+	require.NoError(t, err)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	repository.now = func() time.Time { return now }
+
+	// the buckets compacted into the coarser tiers so far
+	minuteCompacted, hourCompacted := now.Add(-5*time.Minute), now.Add(-2*time.Hour)
+	repository.compactedUntil = []time.Time{{}, minuteCompacted, hourCompacted}
+
+	tests := []struct {
+		name     string
+		since    time.Time
+		expected [][]exchange.Query
+	}{
+		{
+			name:     "within the raw retention",
+			since:    now.Add(-time.Hour),
+			expected: [][]exchange.Query{{{Since: now.Add(-time.Hour)}}, nil, nil},
+		},
+		{
+			name:     "less than a minute older than the raw retention",
+			since:    now.Add(-24*time.Hour - 30*time.Second),
+			expected: [][]exchange.Query{{{Since: now.Add(-24*time.Hour - 30*time.Second)}}, nil, nil},
+		},
+		{
+			name:  "older than the raw retention",
+			since: now.Add(-48 * time.Hour),
+			expected: [][]exchange.Query{
+				{{Since: minuteCompacted.Add(-time.Nanosecond)}},
+				{{Since: now.Add(-48 * time.Hour), Until: minuteCompacted.Add(-time.Nanosecond)}},
+				nil,
+			},
+		},
+		{
+			name:  "older than the minute retention",
+			since: now.Add(-60 * 24 * time.Hour),
+			expected: [][]exchange.Query{
+				{{Since: minuteCompacted.Add(-time.Nanosecond)}},
+				{{Since: hourCompacted.Add(-time.Nanosecond), Until: minuteCompacted.Add(-time.Nanosecond)}},
+				{{Since: now.Add(-60 * 24 * time.Hour), Until: hourCompacted.Add(-time.Nanosecond)}},
+			},
+		},
+		{
+			name:  "older than every retention",
+			since: time.Time{},
+			expected: [][]exchange.Query{
+				{{Since: minuteCompacted.Add(-time.Nanosecond)}},
+				{{Since: hourCompacted.Add(-time.Nanosecond), Until: minuteCompacted.Add(-time.Nanosecond)}},
+				{{Until: hourCompacted.Add(-time.Nanosecond)}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw.queries, minute.queries, hour.queries = nil, nil, nil
+
+			_, err := repository.ListSince(context.Background(), tt.since)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, [][]exchange.Query{raw.queries, minute.queries, hour.queries})
+		})
+	}
+
+	t.Run("looks up rates in the tier covering the time", func(t *testing.T) {
+		raw.lookups, minute.lookups, hour.lookups = nil, nil, nil
+		recent, old := now.Add(-time.Hour), now.Add(-60*24*time.Hour)
+
+		for _, at := range []time.Time{recent, old} {
+			_, _, err := repository.RateAt(context.Background(), "USD-BTC", at, time.Hour)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, []time.Time{recent}, raw.lookups)
+		assert.Empty(t, minute.lookups)
+		// the last hour complete at the time starts an hour before it
+		assert.Equal(t, []time.Time{old.Add(-time.Hour)}, hour.lookups)
+	})
+
+	t.Run("inserts into the raw tier", func(t *testing.T) {
+		rate := exchange.RateUpdated{From: "USD", To: "BTC", At: now, Rate: "1"}
+		require.NoError(t, repository.Insert(context.Background(), rate))
+		require.NoError(t, repository.InsertBatch(context.Background(), []exchange.RateUpdated{rate}))
+
+		assert.Equal(t, []exchange.RateUpdated{rate, rate}, raw.rates)
+		assert.Empty(t, minute.rates)
+		assert.Empty(t, hour.rates)
+	})
+}
+
+func TestRepository_List_MergesTiers(t *testing.T) {
+	ctx := context.Background()
+	raw := exchange.NewInMemoryRepository(24*time.Hour, 0)
+	minute := exchange.NewInMemoryRepository(30*24*time.Hour, 0)
+	repository, err := NewRepository([]Tier{
+		{Retention: 24 * time.Hour, Store: raw},
+		{Resolution: time.Minute, Retention: 30 * 24 * time.Hour, Store: minute},
+	})
+	require.NoError(t, err)
+
+	// a rate older than the raw retention, and recent ones not compacted yet
+	start := time.Now().UTC().Truncate(time.Minute).Add(-10 * time.Minute)
+	old := rate("USD", start.Add(-48*time.Hour))
+	require.NoError(t, minute.Insert(ctx, old))
+	var recent []exchange.RateUpdated
+	for i := 0; i < 3; i++ {
+		recent = append(recent, rate("USD", start.Add(time.Duration(i)*time.Minute)))
+		require.NoError(t, repository.Insert(ctx, recent[i]))
+	}
+
+	rates, err := repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, append([]exchange.RateUpdated{old}, recent...), rates)
+
+	rates, err = repository.List(ctx, exchange.Query{Descending: true, Offset: 1, Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []exchange.RateUpdated{recent[1], recent[0], old}, rates)
+
+	// once compacted, the aggregates are listed from the coarser tier only until its watermark
+	require.NoError(t, repository.Compact(ctx))
+	rates, err = repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []exchange.RateUpdated{old, aggregated(recent[0]), aggregated(recent[1]), aggregated(recent[2])}, rates)
+}
+
+func TestRepository_Compact(t *testing.T) {
+	ctx := context.Background()
+	raw := exchange.NewInMemoryRepository(24*time.Hour, 0)
+	minute := exchange.NewInMemoryRepository(30*24*time.Hour, 0)
+	hour := exchange.NewInMemoryRepository(2*365*24*time.Hour, 0)
+	tiers := []Tier{
+		{Retention: 24 * time.Hour, Store: raw},
+		{Resolution: time.Minute, Retention: 30 * 24 * time.Hour, Store: minute},
+		{Resolution: time.Hour, Retention: 2 * 365 * 24 * time.Hour, Store: hour},
+	}
+	repository, err := NewRepository(tiers)
+	require.NoError(t, err)
+
+	// 90 minutes of rates every 20 seconds, starting at the beginning of an hour
+	start := time.Now().UTC().Truncate(time.Hour).Add(-3 * time.Hour)
+	for at := start; at.Before(start.Add(90 * time.Minute)); at = at.Add(20 * time.Second) {
+		require.NoError(t, repository.Insert(ctx, rate("USD", at)))
+		if at.Sub(start)%time.Minute == 0 {
+			require.NoError(t, repository.Insert(ctx, rate("EUR", at)))
+		}
+	}
+
+	now := start.Add(90*time.Minute + 10*time.Second)
+	repository.now = func() time.Time { return now }
+	require.NoError(t, repository.Compact(ctx))
+
+	// the aggregate of every complete minute, stamped at its start
+	minutes, err := minute.List(ctx, exchange.Query{Pair: "USD-BTC"})
+	require.NoError(t, err)
+	require.Len(t, minutes, 90)
+	for i, aggregate := range minutes {
+		at := start.Add(time.Duration(i) * time.Minute)
+		expected := rate("USD", at.Add(40*time.Second))
+		expected.At, expected.Open, expected.High, expected.Low = at, rate("USD", at).Rate, expected.Rate, rate("USD", at).Rate
+		assert.Equal(t, expected, aggregate)
+	}
+	eurMinutes, err := minute.List(ctx, exchange.Query{Pair: "EUR-BTC"})
+	require.NoError(t, err)
+	assert.Len(t, eurMinutes, 90)
+
+	// the aggregate of the only complete hour
+	hours, err := hour.List(ctx, exchange.Query{})
+	require.NoError(t, err)
+	eurHour, usdHour := rate("EUR", start.Add(59*time.Minute)), rate("USD", start.Add(59*time.Minute+40*time.Second))
+	eurHour.At, eurHour.Open, eurHour.High, eurHour.Low = start, rate("EUR", start).Rate, eurHour.Rate, rate("EUR", start).Rate
+	usdHour.At, usdHour.Open, usdHour.High, usdHour.Low = start, rate("USD", start).Rate, usdHour.Rate, rate("USD", start).Rate
+	assert.Equal(t, []exchange.RateUpdated{eurHour, usdHour}, hours)
+
+	// compacting again only compacts the new complete buckets
+	require.NoError(t, repository.Insert(ctx, rate("USD", start.Add(90*time.Minute+20*time.Second))))
+	now = start.Add(2*time.Hour + time.Second)
+	require.NoError(t, repository.Compact(ctx))
+
+	minutes, err = minute.List(ctx, exchange.Query{Pair: "USD-BTC"})
+	require.NoError(t, err)
+	assert.Len(t, minutes, 91)
+	hours, err = hour.List(ctx, exchange.Query{})
+	require.NoError(t, err)
+	assert.Len(t, hours, 4)
+
+	// a new repository, like after a restart, resumes after the compacted buckets
+	repository, err = NewRepository(tiers)
+	require.NoError(t, err)
+	repository.now = func() time.Time { return now }
+	require.NoError(t, repository.Compact(ctx))
+
+	minutes, err = minute.List(ctx, exchange.Query{Pair: "USD-BTC"})
+	require.NoError(t, err)
+	assert.Len(t, minutes, 91)
+	hours, err = hour.List(ctx, exchange.Query{})
+	require.NoError(t, err)
+	assert.Len(t, hours, 4)
+
+	// a late rate compacts its buckets again, only their newest aggregates are listed
+	late := rate("USD", start.Add(30*time.Minute+10*time.Second))
+	late.Rate = "1"
+	require.NoError(t, repository.Insert(ctx, late))
+	require.NoError(t, repository.Compact(ctx))
+
+	versions, err := minute.List(ctx, exchange.Query{Pair: "USD-BTC", Since: start.Add(30*time.Minute - time.Nanosecond), Until: start.Add(30 * time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, rate("USD", start.Add(30*time.Minute)).Rate, versions[1].Open)
+	assert.Equal(t, "1", versions[1].Low)
+	assert.Equal(t, rate("USD", start.Add(30*time.Minute+40*time.Second)).Rate, versions[1].Rate)
+
+	minutes, err = repository.listTier(ctx, 1, exchange.Query{Pair: "USD-BTC"})
+	require.NoError(t, err)
+	assert.Len(t, minutes, 91)
+	assert.Equal(t, versions[1], minutes[30])
+
+	hours, err = repository.listTier(ctx, 2, exchange.Query{Pair: "USD-BTC", Descending: true, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, hours, 2)
+	assert.Equal(t, start, hours[1].At)
+	assert.Equal(t, "1", hours[1].Low)
+	assert.Equal(t, usdHour.High, hours[1].High)
+}
+
+func TestRepository_Insert_OlderThanRawRetention(t *testing.T) {
+	ctx := context.Background()
+	raw := exchange.NewInMemoryRepository(24*time.Hour, 0)
+	minute := exchange.NewInMemoryRepository(30*24*time.Hour, 0)
+	hour := exchange.NewInMemoryRepository(2*365*24*time.Hour, 0)
+	repository, err := NewRepository([]Tier{
+		{Retention: 24 * time.Hour, Store: raw},
+		{Resolution: time.Minute, Retention: 30 * 24 * time.Hour, Store: minute},
+		{Resolution: time.Hour, Retention: 2 * 365 * 24 * time.Hour, Store: hour},
+	})
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	require.NoError(t, repository.Insert(ctx, rate("USD", now.Add(-3*time.Hour))))
+	require.NoError(t, repository.Compact(ctx))
+
+	// rates drained after an outage longer than the raw retention still reach the coarser tiers
+	drained := []exchange.RateUpdated{rate("EUR", now.Add(-48*time.Hour)), rate("EUR", now.Add(-2*time.Hour))}
+	require.NoError(t, repository.InsertBatch(ctx, drained))
+	require.NoError(t, repository.Compact(ctx))
+
+	raws, err := raw.List(ctx, exchange.Query{Pair: "EUR-BTC"})
+	require.NoError(t, err)
+	assert.Equal(t, drained[1:], raws)
+
+	minutes, err := repository.listTier(ctx, 1, exchange.Query{Pair: "EUR-BTC"})
+	require.NoError(t, err)
+	require.Len(t, minutes, 2)
+	assert.Equal(t, drained[0].At.Truncate(time.Minute), minutes[0].At)
+	assert.Equal(t, drained[1].At.Truncate(time.Minute), minutes[1].At)
+
+	hours, err := repository.listTier(ctx, 2, exchange.Query{Pair: "EUR-BTC"})
+	require.NoError(t, err)
+	require.Len(t, hours, 2)
+	assert.Equal(t, drained[0].At.Truncate(time.Hour), hours[0].At)
+	assert.Equal(t, drained[0].Rate, hours[0].Rate)
+	assert.Equal(t, drained[1].At.Truncate(time.Hour), hours[1].At)
+}
+
+func TestRepository_InsertHistorical(t *testing.T) {
+	ctx := context.Background()
+	newRepository := func(t *testing.T) (*Repository, []*exchange.InMemoryRepository) {
+		stores := []*exchange.InMemoryRepository{
+			exchange.NewInMemoryRepository(24*time.Hour, 0),
+			exchange.NewInMemoryRepository(30*24*time.Hour, 0),
+			exchange.NewInMemoryRepository(2*365*24*time.Hour, 0),
+		}
+		repository, err := NewRepository([]Tier{
+			{Retention: 24 * time.Hour, Store: stores[0]},
+			{Resolution: time.Minute, Retention: 30 * 24 * time.Hour, Store: stores[1]},
+			{Resolution: time.Hour, Retention: 2 * 365 * 24 * time.Hour, Store: stores[2]},
+		})
+		require.NoError(t, err)
+		return repository, stores
+	}
+	count := func(t *testing.T, store *exchange.InMemoryRepository) int {
+		rates, err := store.List(ctx, exchange.Query{})
+		require.NoError(t, err)
+		return len(rates)
+	}
+
+	t.Run("into the finest tier covering it, compacted into the coarser ones", func(t *testing.T) {
+		repository, stores := newRepository(t)
+		now := time.Now()
+		require.NoError(t, repository.Insert(ctx, rate("USD", now.Add(-3*time.Hour))))
+		require.NoError(t, repository.Compact(ctx))
+
+		tests := []struct {
+			name     string
+			rate     exchange.RateUpdated
+			inserted bool
+			counts   []int
+		}{
+			{name: "within the raw retention", rate: rate("EUR", now.Add(-2*time.Hour)), inserted: true, counts: []int{2, 2, 2}},
+			{name: "again", rate: rate("EUR", now.Add(-2*time.Hour)), counts: []int{2, 2, 2}},
+			{name: "older than the raw retention", rate: rate("EUR", now.Add(-48*time.Hour)), inserted: true, counts: []int{2, 3, 3}},
+			{name: "older than the minute retention", rate: rate("EUR", now.Add(-60*24*time.Hour)), inserted: true, counts: []int{2, 3, 4}},
+			{name: "older than every retention", rate: rate("EUR", now.Add(-3*365*24*time.Hour)), counts: []int{2, 3, 4}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				inserted, err := repository.InsertHistorical(ctx, tt.rate)
+				require.NoError(t, err)
+				assert.Equal(t, tt.inserted, inserted)
+				require.NoError(t, repository.Compact(ctx))
+				for i, store := range stores {
+					assert.Equal(t, tt.counts[i], count(t, store), "tier %d", i)
+				}
+			})
+		}
+	})
+
+	t.Run("leaves the buckets not compacted yet to the compaction", func(t *testing.T) {
+		repository, stores := newRepository(t)
+		now := time.Now()
+
+		inserted, err := repository.InsertHistorical(ctx, rate("EUR", now.Add(-3*time.Hour)))
+		require.NoError(t, err)
+		assert.True(t, inserted)
+		assert.Equal(t, 1, count(t, stores[0]))
+		assert.Zero(t, count(t, stores[1]))
+		assert.Zero(t, count(t, stores[2]))
+
+		require.NoError(t, repository.Insert(ctx, rate("USD", now.Add(-4*time.Hour))))
+		require.NoError(t, repository.Compact(ctx))
+		assert.Equal(t, 2, count(t, stores[1]))
+		assert.Equal(t, 2, count(t, stores[2]))
+	})
+}
+
+func TestRepository_EvictExpired(t *testing.T) {
+	repository, err := NewRepository([]Tier{
+		{Retention: time.Hour, Store: &evictingStore{evicted: 2}},
+		{Resolution: time.Minute, Retention: 2 * time.Hour, Store: &fakeStore{}},
+		{Resolution: time.Hour, Retention: 2 * time.Hour, Store: &evictingStore{evicted: 1}},
+	})
+	require.NoError(t, err)
+
+	evicted, err := repository.EvictExpired(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, evicted)
+}
+
+func rate(from string, at time.Time) exchange.RateUpdated {
+	return exchange.RateUpdated{From: from, To: "BTC", At: at, Rate: fmt.Sprint(at.Unix()), Source: "coindesk"}
+}
+
+// aggregated returns the aggregate of a bucket with a single rate, starting at its At.
+func aggregated(rate exchange.RateUpdated) exchange.RateUpdated {
+	rate.Open, rate.High, rate.Low = rate.Rate, rate.Rate, rate.Rate
+	return rate
+}
+
+// fakeStore records the inserted rates and the queries.
+type fakeStore struct {
+	rates   []exchange.RateUpdated
+	queries []exchange.Query
+	lookups []time.Time
+}
+
+func (s *fakeStore) Insert(_ context.Context, rate exchange.RateUpdated) error {
+	s.rates = append(s.rates, rate)
+	return nil
+}
+
+func (s *fakeStore) RateAt(_ context.Context, pair string, at time.Time, lookback time.Duration) (exchange.RateUpdated, bool, error) {
+	s.lookups = append(s.lookups, at)
+	return exchange.RateUpdated{}, false, nil
+}
+
+func (s *fakeStore) List(_ context.Context, query exchange.Query) ([]exchange.RateUpdated, error) {
+	s.queries = append(s.queries, query)
+	return nil, nil
+}
+
+type evictingStore struct {
+	fakeStore
+	evicted int
+}
+
+func (s *evictingStore) EvictExpired(context.Context) (int, error) {
+	return s.evicted, nil
+}
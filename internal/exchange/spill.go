@@ -0,0 +1,176 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alex-rufo/exchange/pkg/jsonfile"
+)
+
+const spillExtension = ".json"
+
+type spilledBatch struct {
+	seq   uint64
+	count int
+	// rates are only kept in memory when the buffer has no directory, otherwise they are read from their file.
+	rates []RateUpdated
+}
+
+// errSpillFull is returned when a batch does not fit in the in-memory spill buffer.
+var errSpillFull = errors.New("spill buffer is full")
+
+// spillBuffer keeps the batches that could not be persisted, oldest first, until they are drained.
+// Every batch is stored in its own file of the directory, so they survive restarts. Without a
+// directory, the batches are kept in memory, up to the maximum number of rates.
+type spillBuffer struct {
+	dir string
+	// maxRates is the maximum number of rates kept in memory
+	maxRates int
+
+	mutex   sync.Mutex
+	batches []spilledBatch
+	rates   int
+	next    uint64
+}
+
+// openSpillBuffer loads the batches left in the directory by a previous run. The maximum number
+// of rates only applies to the batches kept in memory.
+func openSpillBuffer(dir string, maxRates int) (*spillBuffer, error) {
+	b := &spillBuffer{dir: dir, maxRates: maxRates, next: 1}
+	if dir == "" {
+		return b, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %v", err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spill directory: %v", err)
+	}
+
+	for _, file := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), spillExtension), 10, 64)
+		if file.IsDir() || filepath.Ext(file.Name()) != spillExtension || err != nil {
+			continue
+		}
+
+		var rates []RateUpdated
+		if err := jsonfile.Read(b.path(seq), &rates); err != nil {
+			return nil, err
+		}
+		b.batches = append(b.batches, spilledBatch{seq: seq, count: len(rates)})
+		b.rates += len(rates)
+		b.next = max(b.next, seq+1)
+	}
+	sort.Slice(b.batches, func(i, j int) bool { return b.batches[i].seq < b.batches[j].seq })
+
+	return b, nil
+}
+
+// push adds the batch at the end of the buffer.
+func (b *spillBuffer) push(rates []RateUpdated) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	batch := spilledBatch{seq: b.next, count: len(rates)}
+	if b.dir == "" {
+		if b.rates+len(rates) > b.maxRates {
+			return errSpillFull
+		}
+		batch.rates = rates
+	} else if err := jsonfile.Write(b.path(batch.seq), rates); err != nil {
+		return fmt.Errorf("failed to spill batch: %v", err)
+	}
+
+	b.next++
+	b.batches = append(b.batches, batch)
+	b.rates += batch.count
+	return nil
+}
+
+// peek returns the oldest batch, false when the buffer is empty.
+func (b *spillBuffer) peek() (uint64, []RateUpdated, bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.batches) == 0 {
+		return 0, nil, false, nil
+	}
+
+	batch := b.batches[0]
+	if b.dir == "" {
+		return batch.seq, batch.rates, true, nil
+	}
+
+	var rates []RateUpdated
+	if err := jsonfile.Read(b.path(batch.seq), &rates); err != nil {
+		return 0, nil, false, err
+	}
+	return batch.seq, rates, true, nil
+}
+
+// remove deletes the batch once it was persisted.
+func (b *spillBuffer) remove(seq uint64) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for i, batch := range b.batches {
+		if batch.seq != seq {
+			continue
+		}
+
+		if b.dir != "" {
+			if err := os.Remove(b.path(seq)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove spilled batch: %v", err)
+			}
+		}
+		b.batches = append(b.batches[:i], b.batches[i+1:]...)
+		b.rates -= batch.count
+		return nil
+	}
+
+	return nil
+}
+
+// replace keeps only the passed rates of the batch, once the other ones were persisted.
+func (b *spillBuffer) replace(seq uint64, rates []RateUpdated) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for i := range b.batches {
+		batch := &b.batches[i]
+		if batch.seq != seq {
+			continue
+		}
+
+		if b.dir == "" {
+			batch.rates = rates
+		} else if err := jsonfile.Write(b.path(seq), rates); err != nil {
+			return fmt.Errorf("failed to replace spilled batch: %v", err)
+		}
+		b.rates -= batch.count - len(rates)
+		batch.count = len(rates)
+		return nil
+	}
+
+	return nil
+}
+
+// len returns the number of rates in the buffer.
+func (b *spillBuffer) len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.rates
+}
+
+func (b *spillBuffer) path(seq uint64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d%s", seq, spillExtension))
+}
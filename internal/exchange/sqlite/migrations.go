@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migrations are applied in order and only once. New schema changes must be appended,
+// never modify one that was already released.
+var migrations = []string{
+	`CREATE TABLE rates (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		pair          TEXT    NOT NULL,
+		from_currency TEXT    NOT NULL,
+		to_currency   TEXT    NOT NULL,
+		at            INTEGER NOT NULL, -- unix time in nanoseconds
+		rate          TEXT    NOT NULL,
+		source        TEXT    NOT NULL DEFAULT ''
+	);
+	CREATE INDEX rates_pair_at ON rates (pair, at);
+	CREATE INDEX rates_at ON rates (at);`,
+	`ALTER TABLE rates ADD COLUMN open TEXT NOT NULL DEFAULT '';
+	ALTER TABLE rates ADD COLUMN high TEXT NOT NULL DEFAULT '';
+	ALTER TABLE rates ADD COLUMN low  TEXT NOT NULL DEFAULT '';`,
+}
+
+// migrate applies the migrations that were not applied yet, each of them in its own transaction.
+func migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+
+	var current int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for version := current + 1; version <= len(migrations); version++ {
+		if err := apply(ctx, db, version, migrations[version-1]); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %v", version, err)
+		}
+	}
+
+	return nil
+}
+
+func apply(ctx context.Context, db *sql.DB, version int, migration string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now().Unix()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,192 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	_ "modernc.org/sqlite" // pure Go driver, so the binary can still be built with CGO_ENABLED=0
+)
+
+const (
+	insertStatement = `INSERT INTO rates (pair, from_currency, to_currency, at, rate, source, open, high, low) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	selectColumns   = `SELECT from_currency, to_currency, at, rate, source, open, high, low FROM rates`
+)
+
+// Repository stores the rates in a SQLite database, so they survive restarts.
+// Rates older than the TTL are never returned and are removed by EvictExpired.
+type Repository struct {
+	db  *sql.DB
+	ttl time.Duration
+	now func() time.Time
+}
+
+// Open opens (creating it if needed) the SQLite database of the DSN and migrates its schema,
+// e.g. "exchange.db" or "file:exchange.db?_pragma=busy_timeout(5000)".
+func Open(ctx context.Context, dsn string, ttl time.Duration) (*Repository, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %v", err)
+	}
+
+	// SQLite only allows a single writer, sharing one connection avoids "database is locked" errors.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Repository{
+		db:  db,
+		ttl: ttl,
+		now: time.Now,
+	}, nil
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+func (r *Repository) Insert(ctx context.Context, rate exchange.RateUpdated) error {
+	_, err := r.db.ExecContext(ctx, insertStatement, insertArgs(rate)...)
+	if err != nil {
+		return fmt.Errorf("failed to insert rate: %v", err)
+	}
+
+	return nil
+}
+
+// InsertBatch inserts all the rates in a single transaction.
+func (r *Repository) InsertBatch(ctx context.Context, rates []exchange.RateUpdated) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to insert rates: %v", err)
+	}
+	defer tx.Rollback()
+
+	statement, err := tx.PrepareContext(ctx, insertStatement)
+	if err != nil {
+		return fmt.Errorf("failed to insert rates: %v", err)
+	}
+	defer statement.Close()
+
+	for _, rate := range rates {
+		if _, err := statement.ExecContext(ctx, insertArgs(rate)...); err != nil {
+			return fmt.Errorf("failed to insert rates: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to insert rates: %v", err)
+	}
+	return nil
+}
+
+// ListSince returns all the rates with At newer than the passed since time, sorted by At.
+func (r *Repository) ListSince(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error) {
+	return r.List(ctx, exchange.Query{Since: since})
+}
+
+// List returns the rates matching the query, sorted by At.
+func (r *Repository) List(ctx context.Context, query exchange.Query) ([]exchange.RateUpdated, error) {
+	if expiration := r.now().Add(-r.ttl); query.Since.Before(expiration) {
+		query.Since = expiration
+	}
+
+	statement := selectColumns + ` WHERE at > ?`
+	args := []any{query.Since.UnixNano()}
+	if !query.Until.IsZero() {
+		statement += ` AND at <= ?`
+		args = append(args, query.Until.UnixNano())
+	}
+	if query.Pair != "" {
+		statement += ` AND pair = ?`
+		args = append(args, query.Pair)
+	}
+	if query.Descending {
+		statement += ` ORDER BY at DESC, id DESC`
+	} else {
+		statement += ` ORDER BY at, id`
+	}
+	if query.Limit > 0 || query.Offset > 0 {
+		// SQLite does not support OFFSET without LIMIT, a negative one means no limit
+		limit := query.Limit
+		if limit == 0 {
+			limit = -1
+		}
+		statement += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, query.Offset)
+	}
+
+	rows, err := r.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rates: %v", err)
+	}
+	defer rows.Close()
+
+	return scanRates(rows)
+}
+
+// RateAt returns the last rate of the pair at or before the time, as long as it is not older than the lookback.
+// A zero lookback only limits it by the TTL. False is returned when there is no such rate.
+func (r *Repository) RateAt(ctx context.Context, pair string, at time.Time, lookback time.Duration) (exchange.RateUpdated, bool, error) {
+	oldest := r.now().Add(-r.ttl)
+	if lookback > 0 && at.Add(-lookback).After(oldest) {
+		// the lookback is inclusive, while the TTL is not
+		oldest = at.Add(-lookback - time.Nanosecond)
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		selectColumns+` WHERE pair = ? AND at <= ? AND at > ? ORDER BY at DESC, id DESC LIMIT 1`,
+		pair, at.UnixNano(), oldest.UnixNano(),
+	)
+	if err != nil {
+		return exchange.RateUpdated{}, false, fmt.Errorf("failed to get rate: %v", err)
+	}
+	defer rows.Close()
+
+	rates, err := scanRates(rows)
+	if err != nil || len(rates) == 0 {
+		return exchange.RateUpdated{}, false, err
+	}
+	return rates[0], true, nil
+}
+
+// EvictExpired removes the rates older than the TTL.
+func (r *Repository) EvictExpired(ctx context.Context) (int, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM rates WHERE at <= ?`, r.now().Add(-r.ttl).UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict expired rates: %v", err)
+	}
+
+	evicted, err := result.RowsAffected()
+	return int(evicted), err
+}
+
+func insertArgs(rate exchange.RateUpdated) []any {
+	return []any{rate.Pair(), rate.From, rate.To, rate.At.UnixNano(), rate.Rate, rate.Source, rate.Open, rate.High, rate.Low}
+}
+
+func scanRates(rows *sql.Rows) ([]exchange.RateUpdated, error) {
+	var result []exchange.RateUpdated
+	for rows.Next() {
+		var (
+			rate exchange.RateUpdated
+			at   int64
+		)
+		if err := rows.Scan(&rate.From, &rate.To, &at, &rate.Rate, &rate.Source, &rate.Open, &rate.High, &rate.Low); err != nil {
+			return nil, fmt.Errorf("failed to scan rate: %v", err)
+		}
+
+		rate.At = time.Unix(0, at).UTC()
+		result = append(result, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list rates: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,145 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/internal/exchange/repotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_InsertAndListSince(t *testing.T) {
+	ctx := context.Background()
+	repository := openRepository(t, filepath.Join(t.TempDir(), "exchange.db"), time.Hour)
+
+	now := time.Now().UTC()
+	rates := []exchange.RateUpdated{
+		{From: "USD", To: "BTC", At: now.Add(-30 * time.Minute), Rate: "84,000.1", Source: "coindesk"},
+		{From: "EUR", To: "BTC", At: now.Add(-20 * time.Minute), Rate: "78,000.2", Source: "coindesk"},
+		{From: "USD", To: "BTC", At: now.Add(-10 * time.Minute), Rate: "84,100.3"},
+	}
+	for _, rate := range rates {
+		require.NoError(t, repository.Insert(ctx, rate))
+	}
+
+	result, err := repository.ListSince(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, rates, result)
+
+	result, err = repository.ListSince(ctx, now.Add(-20*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, rates[2:], result)
+
+	result, err = repository.ListSince(ctx, now)
+	require.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+func TestRepository_ListSinceSortsByAt(t *testing.T) {
+	ctx := context.Background()
+	repository := openRepository(t, filepath.Join(t.TempDir(), "exchange.db"), time.Hour)
+
+	now := time.Now().UTC()
+	late := exchange.RateUpdated{From: "USD", To: "BTC", At: now.Add(-time.Minute), Rate: "2"}
+	early := exchange.RateUpdated{From: "USD", To: "BTC", At: now.Add(-2 * time.Minute), Rate: "1"}
+	require.NoError(t, repository.Insert(ctx, late))
+	require.NoError(t, repository.Insert(ctx, early))
+
+	result, err := repository.ListSince(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []exchange.RateUpdated{early, late}, result)
+}
+
+func TestRepository_TTL(t *testing.T) {
+	ctx := context.Background()
+	repository := openRepository(t, filepath.Join(t.TempDir(), "exchange.db"), time.Hour)
+
+	now := time.Now().UTC()
+	expired := exchange.RateUpdated{From: "USD", To: "BTC", At: now.Add(-2 * time.Hour), Rate: "1"}
+	fresh := exchange.RateUpdated{From: "USD", To: "BTC", At: now.Add(-time.Minute), Rate: "2"}
+	require.NoError(t, repository.Insert(ctx, expired))
+	require.NoError(t, repository.Insert(ctx, fresh))
+
+	// expired rates are never returned, even before being evicted
+	result, err := repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []exchange.RateUpdated{fresh}, result)
+
+	evicted, err := repository.EvictExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+
+	evicted, err = repository.EvictExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, evicted)
+
+	var count int
+	require.NoError(t, repository.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM rates`).Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestRepository_SurvivesRestarts(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "exchange.db")
+
+	rate := exchange.RateUpdated{From: "USD", To: "BTC", At: time.Now().UTC().Add(-time.Minute), Rate: "84,000.1", Source: "coindesk"}
+	repository, err := Open(ctx, path, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, repository.Insert(ctx, rate))
+	require.NoError(t, repository.Close())
+
+	// reopening must not apply the migrations again
+	repository = openRepository(t, path, time.Hour)
+	result, err := repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []exchange.RateUpdated{rate}, result)
+
+	var version int
+	require.NoError(t, repository.db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+	assert.Equal(t, len(migrations), version)
+}
+
+func openRepository(t *testing.T, dsn string, ttl time.Duration) *Repository {
+	t.Helper()
+
+	repository, err := Open(context.Background(), dsn, ttl)
+	require.NoError(t, err)
+	t.Cleanup(func() { repository.Close() })
+
+	return repository
+}
+
+func TestRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T, ttl time.Duration) repotest.Repository {
+		return openRepository(t, filepath.Join(t.TempDir(), "exchange.db"), ttl)
+	})
+}
+
+func TestRepository_InsertBatch(t *testing.T) {
+	ctx := context.Background()
+	repository := openRepository(t, filepath.Join(t.TempDir(), "exchange.db"), time.Hour)
+
+	now := time.Now().UTC()
+	rates := []exchange.RateUpdated{
+		{From: "USD", To: "BTC", At: now.Add(-2 * time.Minute), Rate: "1", Source: "coindesk"},
+		{From: "EUR", To: "BTC", At: now.Add(-time.Minute), Rate: "2", Source: "coindesk"},
+	}
+	require.NoError(t, repository.InsertBatch(ctx, rates))
+
+	result, err := repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, rates, result)
+
+	// nothing is inserted when the transaction fails
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Error(t, repository.InsertBatch(cancelled, rates))
+
+	result, err = repository.ListSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, rates, result)
+}
@@ -0,0 +1,248 @@
+package staleness
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/pkg/syncx"
+)
+
+const (
+	StatusStale     = "stale"
+	StatusRecovered = "recovered"
+)
+
+type Config struct {
+	// Threshold is the maximum age of the latest rate of a pair before it is considered stale.
+	Threshold time.Duration
+	// Thresholds overrides the default threshold per provider ("coindesk") or per
+	// provider and pair ("coindesk/USD-BTC"), the most specific one wins.
+	Thresholds map[string]time.Duration
+	// SubscriptionBufferSize is the number of events a subscription can have pending.
+	SubscriptionBufferSize int
+}
+
+// Event notifies that a pair became stale or recovered from being stale.
+type Event struct {
+	Status           string    `json:"status"`
+	Source           string    `json:"source"`
+	Pair             string    `json:"pair"`
+	LastAt           time.Time `json:"lastAt"`
+	ThresholdSeconds float64   `json:"thresholdSeconds"`
+	At               time.Time `json:"at"`
+}
+
+// Freshness describes how old the latest rate of a pair is.
+type Freshness struct {
+	Source           string    `json:"source"`
+	Pair             string    `json:"pair"`
+	LastAt           time.Time `json:"lastAt"`
+	AgeSeconds       float64   `json:"ageSeconds"`
+	ThresholdSeconds float64   `json:"thresholdSeconds"`
+	Stale            bool      `json:"stale"`
+}
+
+type key struct {
+	source string
+	pair   string
+}
+
+type state struct {
+	lastAt time.Time
+	stale  bool
+}
+
+// Monitor detects pairs whose provider stopped updating them. Freshness is based on the
+// time reported by the provider for the rate, not on when it was received, as providers
+// might keep returning the same rate.
+type Monitor struct {
+	config Config
+	now    func() time.Time
+
+	mu            sync.RWMutex
+	pairs         map[key]*state
+	subscriptions *syncx.Map[string, chan Event]
+}
+
+func NewMonitor(config Config) *Monitor {
+	return &Monitor{
+		config:        config,
+		now:           time.Now,
+		pairs:         make(map[key]*state),
+		subscriptions: new(syncx.Map[string, chan Event]),
+	}
+}
+
+// MonitorUpdates observes all the updates received until the channel is closed, checking
+// every interval whether any pair became stale.
+func (m *Monitor) MonitorUpdates(ctx context.Context, updates <-chan exchange.RateUpdated, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case rate, ok := <-updates:
+			if !ok {
+				// updates channel was closed, we won't receive any more updates
+				return
+			}
+
+			m.Observe(rate)
+		case <-ticker.C:
+			m.Check()
+		}
+	}
+}
+
+// Observe records the rate, notifying that its pair recovered if it was stale.
+func (m *Monitor) Observe(rate exchange.RateUpdated) {
+	k := key{source: rate.Source, pair: rate.Pair()}
+
+	m.mu.Lock()
+	s, ok := m.pairs[k]
+	if !ok {
+		s = &state{}
+		m.pairs[k] = s
+	}
+	if !rate.At.After(s.lastAt) {
+		m.mu.Unlock()
+		return
+	}
+
+	s.lastAt = rate.At
+	recovered := s.stale && !m.isStale(k, s)
+	if recovered {
+		s.stale = false
+	}
+	m.mu.Unlock()
+
+	if recovered {
+		m.publish(m.event(StatusRecovered, k, rate.At))
+	}
+}
+
+// Check notifies the pairs that became stale since the last check.
+func (m *Monitor) Check() {
+	var events []Event
+
+	m.mu.Lock()
+	for k, s := range m.pairs {
+		if !s.stale && m.isStale(k, s) {
+			s.stale = true
+			events = append(events, m.event(StatusStale, k, s.lastAt))
+		}
+	}
+	m.mu.Unlock()
+
+	for _, event := range events {
+		m.publish(event)
+	}
+}
+
+// IsStale returns whether the pair of the given provider was flagged as stale.
+func (m *Monitor) IsStale(source, pair string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.pairs[key{source: source, pair: pair}]
+	return ok && s.stale
+}
+
+// Freshness returns the current freshness of every pair, sorted by provider and pair.
+func (m *Monitor) Freshness() []Freshness {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.now()
+	result := make([]Freshness, 0, len(m.pairs))
+	for k, s := range m.pairs {
+		result = append(result, Freshness{
+			Source:           k.source,
+			Pair:             k.pair,
+			LastAt:           s.lastAt,
+			AgeSeconds:       now.Sub(s.lastAt).Seconds(),
+			ThresholdSeconds: m.threshold(k).Seconds(),
+			Stale:            s.stale,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Source == result[j].Source {
+			return result[i].Pair < result[j].Pair
+		}
+		return result[i].Source < result[j].Source
+	})
+	return result
+}
+
+// Subscribe returns a channel receiving every stale and recovered event. Events are
+// skipped for subscriptions that are not keeping up.
+func (m *Monitor) Subscribe(id string) (<-chan Event, error) {
+	subscription := make(chan Event, m.config.SubscriptionBufferSize)
+
+	_, loaded := m.subscriptions.LoadOrStore(id, subscription)
+	if loaded {
+		return nil, fmt.Errorf("there is another subscription with the same id (%s), it can not be added", id)
+	}
+
+	return subscription, nil
+}
+
+func (m *Monitor) Unsubscribe(id string) {
+	subscription, loaded := m.subscriptions.LoadAndDelete(id)
+	if !loaded {
+		return
+	}
+
+	close(subscription)
+}
+
+func (m *Monitor) Close() {
+	m.subscriptions.Range(func(id string, subscription chan Event) bool {
+		m.Unsubscribe(id)
+		return true
+	})
+}
+
+func (m *Monitor) threshold(k key) time.Duration {
+	if threshold, ok := m.config.Thresholds[k.source+"/"+k.pair]; ok {
+		return threshold
+	}
+	if threshold, ok := m.config.Thresholds[k.source]; ok {
+		return threshold
+	}
+	return m.config.Threshold
+}
+
+func (m *Monitor) isStale(k key, s *state) bool {
+	return m.now().Sub(s.lastAt) > m.threshold(k)
+}
+
+func (m *Monitor) event(status string, k key, lastAt time.Time) Event {
+	return Event{
+		Status:           status,
+		Source:           k.source,
+		Pair:             k.pair,
+		LastAt:           lastAt,
+		ThresholdSeconds: m.threshold(k).Seconds(),
+		At:               m.now(),
+	}
+}
+
+func (m *Monitor) publish(event Event) {
+	log.Printf("Rates of %s from %s are %s, last update at %s", event.Pair, event.Source, event.Status, event.LastAt)
+
+	m.subscriptions.Range(func(id string, subscription chan Event) bool {
+		select {
+		case subscription <- event:
+		default:
+			log.Printf("staleness event '%v' skipped for subscription '%v' as channel was full", event, id)
+		}
+		return true
+	})
+}
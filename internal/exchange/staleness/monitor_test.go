@@ -0,0 +1,144 @@
+package staleness
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newTestMonitor(config Config) (*Monitor, *clock) {
+	c := &clock{now: time.Unix(1000, 0).UTC()}
+	monitor := NewMonitor(config)
+	monitor.now = c.Now
+	return monitor, c
+}
+
+func rate(pair string, at time.Time) exchange.RateUpdated {
+	return exchange.RateUpdated{From: pair[:3], To: pair[4:], At: at, Rate: "50000.00", Source: "coindesk"}
+}
+
+func receive(t *testing.T, events <-chan Event) Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("event not received")
+		return Event{}
+	}
+}
+
+func assertNoEvent(t *testing.T, events <-chan Event) {
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %v", event)
+	default:
+	}
+}
+
+func TestMonitor_StaleAndRecovered(t *testing.T) {
+	monitor, c := newTestMonitor(Config{Threshold: time.Minute, SubscriptionBufferSize: 5})
+	events, err := monitor.Subscribe("test-id")
+	require.NoError(t, err)
+
+	lastAt := c.now
+	monitor.Observe(rate("USD-BTC", lastAt))
+	monitor.Check()
+	assertNoEvent(t, events)
+	assert.False(t, monitor.IsStale("coindesk", "USD-BTC"))
+
+	// The provider keeps sending the same rate, which does not make it fresh.
+	c.now = c.now.Add(2 * time.Minute)
+	monitor.Observe(rate("USD-BTC", lastAt))
+	monitor.Check()
+
+	event := receive(t, events)
+	assert.Equal(t, Event{
+		Status:           StatusStale,
+		Source:           "coindesk",
+		Pair:             "USD-BTC",
+		LastAt:           lastAt,
+		ThresholdSeconds: 60,
+		At:               c.now,
+	}, event)
+	assert.True(t, monitor.IsStale("coindesk", "USD-BTC"))
+
+	// It is only notified once.
+	monitor.Check()
+	assertNoEvent(t, events)
+
+	monitor.Observe(rate("USD-BTC", c.now))
+	event = receive(t, events)
+	assert.Equal(t, StatusRecovered, event.Status)
+	assert.Equal(t, c.now, event.LastAt)
+	assert.False(t, monitor.IsStale("coindesk", "USD-BTC"))
+}
+
+func TestMonitor_Thresholds(t *testing.T) {
+	monitor, c := newTestMonitor(Config{
+		Threshold: time.Hour,
+		Thresholds: map[string]time.Duration{
+			"coindesk":         10 * time.Minute,
+			"coindesk/EUR-BTC": time.Minute,
+		},
+	})
+
+	monitor.Observe(rate("USD-BTC", c.now))
+	monitor.Observe(rate("EUR-BTC", c.now))
+	monitor.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: c.now, Rate: "50000.00", Source: "other"})
+
+	c.now = c.now.Add(5 * time.Minute)
+	monitor.Check()
+
+	freshness := monitor.Freshness()
+	require.Len(t, freshness, 3)
+	assert.Equal(t, Freshness{Source: "coindesk", Pair: "EUR-BTC", LastAt: c.now.Add(-5 * time.Minute), AgeSeconds: 300, ThresholdSeconds: 60, Stale: true}, freshness[0])
+	assert.Equal(t, Freshness{Source: "coindesk", Pair: "USD-BTC", LastAt: c.now.Add(-5 * time.Minute), AgeSeconds: 300, ThresholdSeconds: 600, Stale: false}, freshness[1])
+	assert.Equal(t, Freshness{Source: "other", Pair: "USD-BTC", LastAt: c.now.Add(-5 * time.Minute), AgeSeconds: 300, ThresholdSeconds: 3600, Stale: false}, freshness[2])
+}
+
+func TestMonitor_Subscriptions(t *testing.T) {
+	monitor, _ := newTestMonitor(Config{Threshold: time.Minute, SubscriptionBufferSize: 1})
+
+	events, err := monitor.Subscribe("test-id")
+	require.NoError(t, err)
+
+	_, err = monitor.Subscribe("test-id")
+	assert.EqualError(t, err, "there is another subscription with the same id (test-id), it can not be added")
+
+	monitor.Close()
+	_, ok := <-events
+	assert.False(t, ok)
+
+	// Unsubscribing twice must not panic.
+	monitor.Unsubscribe("test-id")
+}
+
+func TestMonitor_MonitorUpdates(t *testing.T) {
+	monitor := NewMonitor(Config{Threshold: time.Millisecond, SubscriptionBufferSize: 5})
+	events, err := monitor.Subscribe("test-id")
+	require.NoError(t, err)
+
+	updates := make(chan exchange.RateUpdated)
+	done := make(chan struct{})
+	go func() {
+		monitor.MonitorUpdates(context.Background(), updates, time.Millisecond)
+		close(done)
+	}()
+
+	// Rate is already older than the threshold, the periodic check flags it.
+	updates <- rate("USD-BTC", time.Now().Add(-time.Minute))
+	assert.Equal(t, StatusStale, receive(t, events).Status)
+
+	close(updates)
+	<-done
+}
@@ -0,0 +1,278 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+)
+
+// DefaultWindow is the window most tickers display their statistics for.
+const DefaultWindow = 24 * time.Hour
+
+type Repository interface {
+	ListSince(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error)
+}
+
+// Stats summarises how the rate of a pair moved during the tracked window.
+type Stats struct {
+	Pair          string    `json:"pair"`
+	At            time.Time `json:"at"`
+	Open          float64   `json:"open"`
+	High          float64   `json:"high"`
+	Low           float64   `json:"low"`
+	Last          float64   `json:"last"`
+	Change        float64   `json:"change"`
+	ChangePercent float64   `json:"changePercent"`
+}
+
+// Latest is the most recent rate of a pair together with its statistics.
+type Latest struct {
+	exchange.RateUpdated
+	Stats Stats `json:"stats"`
+}
+
+type sample struct {
+	at    time.Time
+	value float64
+}
+
+// pairStats keeps the samples within the window together with two monotonic queues,
+// so high and low are always at the front of them and every update is O(1) amortized.
+type pairStats struct {
+	latest  exchange.RateUpdated
+	samples []sample
+	highs   []sample // values in decreasing order
+	lows    []sample // values in increasing order
+}
+
+func (p *pairStats) add(s sample, window time.Duration) {
+	p.samples = append(p.samples, s)
+
+	for len(p.highs) > 0 && p.highs[len(p.highs)-1].value <= s.value {
+		p.highs = p.highs[:len(p.highs)-1]
+	}
+	p.highs = append(p.highs, s)
+
+	for len(p.lows) > 0 && p.lows[len(p.lows)-1].value >= s.value {
+		p.lows = p.lows[:len(p.lows)-1]
+	}
+	p.lows = append(p.lows, s)
+
+	// Samples are strictly ordered by time, so the ones out of the window are always at the front.
+	start := s.at.Add(-window)
+	for p.samples[0].at.Before(start) {
+		p.samples = p.samples[1:]
+	}
+	for p.highs[0].at.Before(start) {
+		p.highs = p.highs[1:]
+	}
+	for p.lows[0].at.Before(start) {
+		p.lows = p.lows[1:]
+	}
+}
+
+// stats returns the statistics of the samples since the start, false when there are none.
+func (p *pairStats) stats(start time.Time) (Stats, bool) {
+	samples := p.samples[since(p.samples, start):]
+	if len(samples) == 0 {
+		return Stats{}, false
+	}
+	// the last sample is in both queues, so they are not empty either
+	highs, lows := p.highs[since(p.highs, start):], p.lows[since(p.lows, start):]
+
+	open := samples[0].value
+	last := samples[len(samples)-1]
+
+	s := Stats{
+		Pair:   p.latest.Pair(),
+		At:     last.at,
+		Open:   open,
+		High:   highs[0].value,
+		Low:    lows[0].value,
+		Last:   last.value,
+		Change: last.value - open,
+	}
+	if open != 0 {
+		s.ChangePercent = s.Change / open * 100
+	}
+
+	return s, true
+}
+
+// since returns the position of the first sample that is not before the start.
+func since(samples []sample, start time.Time) int {
+	return sort.Search(len(samples), func(i int) bool {
+		return !samples[i].at.Before(start)
+	})
+}
+
+// Tracker maintains rolling statistics (open, high, low, change) per pair. The statistics
+// are served for the window until now, so a pair that is not updated anymore has none once
+// its last rate is out of the window.
+type Tracker struct {
+	window                 time.Duration
+	subscriptionBufferSize int
+	now                    func() time.Time
+
+	mu    sync.RWMutex
+	pairs map[string]*pairStats
+
+	subscriptionsMutex sync.RWMutex
+	subscriptions      map[string]chan Stats
+}
+
+// NewTracker creates a tracker for the window, buffering up to subscriptionBufferSize statistics per subscription.
+func NewTracker(window time.Duration, subscriptionBufferSize int) *Tracker {
+	return &Tracker{
+		window:                 window,
+		subscriptionBufferSize: subscriptionBufferSize,
+		now:                    time.Now,
+		pairs:                  make(map[string]*pairStats),
+		subscriptions:          make(map[string]chan Stats),
+	}
+}
+
+// Warmup loads the rates of the last window from the repository, so the statistics
+// are complete right after a restart. It must be called before tracking the updates,
+// as the rates that are not newer than the latest one of their pair are ignored.
+func (t *Tracker) Warmup(ctx context.Context, repository Repository) error {
+	rates, err := repository.ListSince(ctx, t.now().Add(-t.window))
+	if err != nil {
+		return err
+	}
+
+	for _, rate := range rates {
+		// the subscriptions only receive the statistics of the live updates
+		if _, _, err := t.observe(rate); err != nil {
+			log.Println("Failed to track the rate", err, rate)
+		}
+	}
+
+	return nil
+}
+
+// TrackUpdates feeds the tracker with all the updates received until the channel is closed.
+func (t *Tracker) TrackUpdates(ctx context.Context, updates <-chan exchange.RateUpdated) {
+	for {
+		select {
+		case rate, ok := <-updates:
+			if !ok {
+				// updates channel was closed, we won't receive any more updates
+				return
+			}
+
+			if err := t.Observe(rate); err != nil {
+				log.Println("Failed to track the rate", err, rate)
+			}
+		}
+	}
+}
+
+// Observe adds the rate to the statistics of its pair, and sends the updated statistics to the subscriptions.
+// Rates that are not newer than the latest one of the pair are ignored.
+func (t *Tracker) Observe(rate exchange.RateUpdated) error {
+	stats, updated, err := t.observe(rate)
+	if err != nil || !updated {
+		return err
+	}
+
+	t.publish(stats)
+	return nil
+}
+
+// observe adds the rate to the statistics of its pair, returning them and whether the rate was added.
+func (t *Tracker) observe(rate exchange.RateUpdated) (Stats, bool, error) {
+	value, err := rate.Value()
+	if err != nil {
+		return Stats{}, false, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.pairs[rate.Pair()]
+	if !ok {
+		p = &pairStats{}
+		t.pairs[rate.Pair()] = p
+	} else if !rate.At.After(p.latest.At) {
+		return Stats{}, false, nil
+	}
+
+	p.latest = rate
+	p.add(sample{at: rate.At, value: value}, t.window)
+
+	stats, _ := p.stats(rate.At.Add(-t.window))
+	return stats, true, nil
+}
+
+// Subscribe returns a channel receiving the statistics of a pair every time a rate is added to them.
+// Statistics are skipped for subscriptions that are not keeping up.
+func (t *Tracker) Subscribe(id string) (<-chan Stats, error) {
+	t.subscriptionsMutex.Lock()
+	defer t.subscriptionsMutex.Unlock()
+
+	if _, ok := t.subscriptions[id]; ok {
+		return nil, fmt.Errorf("there is another subscription with the same id (%s), it can not be added", id)
+	}
+
+	subscription := make(chan Stats, t.subscriptionBufferSize)
+	t.subscriptions[id] = subscription
+	return subscription, nil
+}
+
+func (t *Tracker) Unsubscribe(id string) {
+	t.subscriptionsMutex.Lock()
+	defer t.subscriptionsMutex.Unlock()
+
+	if subscription, ok := t.subscriptions[id]; ok {
+		delete(t.subscriptions, id)
+		close(subscription)
+	}
+}
+
+func (t *Tracker) publish(stats Stats) {
+	t.subscriptionsMutex.RLock()
+	defer t.subscriptionsMutex.RUnlock()
+
+	for id, subscription := range t.subscriptions {
+		select {
+		case subscription <- stats:
+		default:
+			log.Printf("stats of %s skipped for subscription '%v' as channel was full", stats.Pair, id)
+		}
+	}
+}
+
+// Stats returns the statistics of the given pair, false when it has no rate within the window.
+func (t *Tracker) Stats(pair string) (Stats, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	p, ok := t.pairs[pair]
+	if !ok {
+		return Stats{}, false
+	}
+	return p.stats(t.now().Add(-t.window))
+}
+
+// Latest returns the latest rate and statistics of every pair with a rate within the window, sorted by pair.
+func (t *Tracker) Latest() []Latest {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	start := t.now().Add(-t.window)
+	result := make([]Latest, 0, len(t.pairs))
+	for _, p := range t.pairs {
+		if stats, ok := p.stats(start); ok {
+			result = append(result, Latest{RateUpdated: p.latest, Stats: stats})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Pair() < result[j].Pair() })
+	return result
+}
@@ -0,0 +1,235 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockRepository struct {
+	listSinceFunc func(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error)
+}
+
+func (m *mockRepository) ListSince(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error) {
+	return m.listSinceFunc(ctx, since)
+}
+
+func TestTracker_Observe(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tracker := NewTracker(time.Hour, 0)
+	now := start
+	tracker.now = func() time.Time { return now }
+
+	sequence := []struct {
+		offset   time.Duration
+		rate     string
+		expected Stats
+	}{
+		{
+			offset:   0,
+			rate:     "100",
+			expected: Stats{Open: 100, High: 100, Low: 100, Last: 100},
+		},
+		{
+			offset:   20 * time.Minute,
+			rate:     "150",
+			expected: Stats{Open: 100, High: 150, Low: 100, Last: 150, Change: 50, ChangePercent: 50},
+		},
+		{
+			offset:   40 * time.Minute,
+			rate:     "80",
+			expected: Stats{Open: 100, High: 150, Low: 80, Last: 80, Change: -20, ChangePercent: -20},
+		},
+		{
+			// The first rate is out of the window.
+			offset:   70 * time.Minute,
+			rate:     "120",
+			expected: Stats{Open: 150, High: 150, Low: 80, Last: 120, Change: -30, ChangePercent: -20},
+		},
+		{
+			// The high is out of the window.
+			offset:   90 * time.Minute,
+			rate:     "100",
+			expected: Stats{Open: 80, High: 120, Low: 80, Last: 100, Change: 20, ChangePercent: 25},
+		},
+	}
+
+	for _, step := range sequence {
+		at := start.Add(step.offset)
+		now = at
+		require.NoError(t, tracker.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: step.rate}))
+
+		stats, ok := tracker.Stats("USD-BTC")
+		require.True(t, ok)
+
+		step.expected.Pair = "USD-BTC"
+		step.expected.At = at
+		assert.Equal(t, step.expected.Pair, stats.Pair)
+		assert.True(t, step.expected.At.Equal(stats.At))
+		assert.Equal(t, step.expected.Open, stats.Open)
+		assert.Equal(t, step.expected.High, stats.High)
+		assert.Equal(t, step.expected.Low, stats.Low)
+		assert.Equal(t, step.expected.Last, stats.Last)
+		assert.InDelta(t, step.expected.Change, stats.Change, 1e-9)
+		assert.InDelta(t, step.expected.ChangePercent, stats.ChangePercent, 1e-9)
+	}
+}
+
+func TestTracker_Observe_IgnoresStaleRates(t *testing.T) {
+	at := time.Unix(1000, 0)
+	tracker := NewTracker(time.Hour, 0)
+	tracker.now = func() time.Time { return at }
+
+	require.NoError(t, tracker.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: "100"}))
+	require.NoError(t, tracker.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: "200"}))
+	require.NoError(t, tracker.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: at.Add(-time.Minute), Rate: "50"}))
+
+	stats, ok := tracker.Stats("USD-BTC")
+	require.True(t, ok)
+	assert.Equal(t, 100.0, stats.High)
+	assert.Equal(t, 100.0, stats.Low)
+}
+
+func TestTracker_Observe_InvalidRate(t *testing.T) {
+	tracker := NewTracker(time.Hour, 0)
+
+	err := tracker.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 0), Rate: "invalid"})
+	assert.Error(t, err)
+
+	_, ok := tracker.Stats("USD-BTC")
+	assert.False(t, ok)
+}
+
+func TestTracker_Latest(t *testing.T) {
+	at := time.Unix(1000, 0)
+	tracker := NewTracker(time.Hour, 0)
+	tracker.now = func() time.Time { return at }
+
+	usd := exchange.RateUpdated{From: "USD", To: "BTC", At: at, Rate: "50,000.00"}
+	eur := exchange.RateUpdated{From: "EUR", To: "BTC", At: at, Rate: "45,000.00"}
+	require.NoError(t, tracker.Observe(usd))
+	require.NoError(t, tracker.Observe(eur))
+
+	latest := tracker.Latest()
+	require.Len(t, latest, 2)
+	assert.Equal(t, eur, latest[0].RateUpdated)
+	assert.Equal(t, 45000.0, latest[0].Stats.Last)
+	assert.Equal(t, usd, latest[1].RateUpdated)
+	assert.Equal(t, 50000.0, latest[1].Stats.Last)
+}
+
+func TestTracker_WindowUntilNow(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tracker := NewTracker(time.Hour, 0)
+	now := start
+	tracker.now = func() time.Time { return now }
+
+	require.NoError(t, tracker.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: start, Rate: "150"}))
+	require.NoError(t, tracker.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: start.Add(30 * time.Minute), Rate: "100"}))
+
+	// the first rate is out of the window even if the pair is not updated anymore
+	now = start.Add(70 * time.Minute)
+	stats, ok := tracker.Stats("USD-BTC")
+	require.True(t, ok)
+	assert.Equal(t, 100.0, stats.Open)
+	assert.Equal(t, 100.0, stats.High)
+	assert.Zero(t, stats.Change)
+	require.Len(t, tracker.Latest(), 1)
+
+	// pairs without rates within the window have no statistics
+	now = start.Add(2 * time.Hour)
+	_, ok = tracker.Stats("USD-BTC")
+	assert.False(t, ok)
+	assert.Empty(t, tracker.Latest())
+}
+
+func TestTracker_Warmup(t *testing.T) {
+	now := time.Now()
+	tracker := NewTracker(time.Hour, 0)
+
+	var requestedSince time.Time
+	repository := &mockRepository{
+		listSinceFunc: func(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error) {
+			requestedSince = since
+			return []exchange.RateUpdated{
+				{From: "USD", To: "BTC", At: now.Add(-30 * time.Minute), Rate: "100"},
+				{From: "USD", To: "BTC", At: now.Add(-10 * time.Minute), Rate: "200"},
+			}, nil
+		},
+	}
+
+	require.NoError(t, tracker.Warmup(context.Background(), repository))
+	assert.WithinDuration(t, now.Add(-time.Hour), requestedSince, time.Second)
+
+	stats, ok := tracker.Stats("USD-BTC")
+	require.True(t, ok)
+	assert.Equal(t, 100.0, stats.Open)
+	assert.Equal(t, 200.0, stats.Last)
+}
+
+func TestTracker_Warmup_RepositoryError(t *testing.T) {
+	tracker := NewTracker(time.Hour, 0)
+	repository := &mockRepository{
+		listSinceFunc: func(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error) {
+			return nil, errors.New("repository unavailable")
+		},
+	}
+
+	assert.Error(t, tracker.Warmup(context.Background(), repository))
+}
+
+func TestTracker_TrackUpdates(t *testing.T) {
+	tracker := NewTracker(time.Hour, 0)
+	tracker.now = func() time.Time { return time.Unix(1001, 0) }
+
+	updates := make(chan exchange.RateUpdated, 2)
+	updates <- exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 0), Rate: "100"}
+	updates <- exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(1001, 0), Rate: "110"}
+	close(updates)
+
+	// It returns once the updates channel is closed.
+	tracker.TrackUpdates(context.Background(), updates)
+
+	stats, ok := tracker.Stats("USD-BTC")
+	require.True(t, ok)
+	assert.InDelta(t, 10.0, stats.ChangePercent, 1e-9)
+}
+
+func TestTracker_Subscribe(t *testing.T) {
+	tracker := NewTracker(time.Hour, 2)
+	repository := &mockRepository{
+		listSinceFunc: func(ctx context.Context, since time.Time) ([]exchange.RateUpdated, error) {
+			return []exchange.RateUpdated{{From: "USD", To: "BTC", At: time.Now().Add(-time.Minute), Rate: "100"}}, nil
+		},
+	}
+
+	subscription, err := tracker.Subscribe("client")
+	require.NoError(t, err)
+	_, err = tracker.Subscribe("client")
+	assert.Error(t, err)
+
+	// the rates loaded by the warmup are not sent
+	require.NoError(t, tracker.Warmup(context.Background(), repository))
+	assert.Empty(t, subscription)
+
+	// the statistics already include the rate once they are received
+	require.NoError(t, tracker.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: time.Now(), Rate: "110"}))
+	require.Len(t, subscription, 1)
+	stats := <-subscription
+	assert.Equal(t, "USD-BTC", stats.Pair)
+	assert.Equal(t, 100.0, stats.Open)
+	assert.Equal(t, 110.0, stats.Last)
+
+	// ignored rates do not change the statistics
+	require.NoError(t, tracker.Observe(exchange.RateUpdated{From: "USD", To: "BTC", At: time.Now().Add(-time.Hour), Rate: "90"}))
+	assert.Empty(t, subscription)
+
+	tracker.Unsubscribe("client")
+	_, ok := <-subscription
+	assert.False(t, ok)
+}
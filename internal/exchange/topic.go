@@ -0,0 +1,134 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// ErrTopicClosed is returned when publishing to or subscribing to a closed topic.
+var ErrTopicClosed = errors.New("topic closed")
+
+// Topic carries the rate updates from the providers to the broadcasters. Every subscription receives all the
+// updates published after it is created, until its context is done or the topic is closed, when its channel is closed.
+type Topic interface {
+	Publish(ctx context.Context, rate RateUpdated) error
+	Subscribe(ctx context.Context) (<-chan RateUpdated, error)
+	Close() error
+}
+
+// PublishUpdates publishes every received update to the topic until the updates channel is closed.
+func PublishUpdates(ctx context.Context, topic Topic, updates <-chan RateUpdated) {
+	for update := range updates {
+		if err := topic.Publish(ctx, update); err != nil {
+			log.Printf("Failed to publish the rate update '%v': %v", update, err)
+		}
+	}
+}
+
+// ChanTopic is the in-process topic, delivering the updates to its subscriptions through Go channels.
+// Publish blocks until every subscription receives the update.
+type ChanTopic struct {
+	mutex         sync.RWMutex
+	subscriptions map[*chanSubscription]struct{}
+	closed        bool
+	// done is closed once the topic is closed, unblocking the publishers
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type chanSubscription struct {
+	updates chan RateUpdated
+	// done is closed once the subscription stops receiving updates, unblocking the publishers
+	done chan struct{}
+	once sync.Once
+}
+
+func (s *chanSubscription) stop() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func NewChanTopic() *ChanTopic {
+	return &ChanTopic{
+		subscriptions: make(map[*chanSubscription]struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+func (t *ChanTopic) Publish(ctx context.Context, rate RateUpdated) error {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if t.closed {
+		return ErrTopicClosed
+	}
+	for subscription := range t.subscriptions {
+		select {
+		case subscription.updates <- rate:
+		case <-subscription.done:
+			// the subscription is being removed
+		case <-t.done:
+			return ErrTopicClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (t *ChanTopic) Subscribe(ctx context.Context) (<-chan RateUpdated, error) {
+	subscription := &chanSubscription{
+		updates: make(chan RateUpdated),
+		done:    make(chan struct{}),
+	}
+
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return nil, ErrTopicClosed
+	}
+	t.subscriptions[subscription] = struct{}{}
+	t.mutex.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-subscription.done:
+		}
+		t.unsubscribe(subscription)
+	}()
+
+	return subscription.updates, nil
+}
+
+// Close closes every subscription, no update can be published afterwards.
+func (t *ChanTopic) Close() error {
+	// unblock the publishers before locking, as they hold the lock while blocked
+	t.closeOnce.Do(func() { close(t.done) })
+
+	t.mutex.Lock()
+	t.closed = true
+	subscriptions := make([]*chanSubscription, 0, len(t.subscriptions))
+	for subscription := range t.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	t.mutex.Unlock()
+
+	for _, subscription := range subscriptions {
+		t.unsubscribe(subscription)
+	}
+	return nil
+}
+
+func (t *ChanTopic) unsubscribe(subscription *chanSubscription) {
+	// stop it before locking, as the publishers could be blocked sending it an update while holding the lock
+	subscription.stop()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.subscriptions[subscription]; ok {
+		delete(t.subscriptions, subscription)
+		close(subscription.updates)
+	}
+}
@@ -0,0 +1,99 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChanTopic(t *testing.T) {
+	ctx := context.Background()
+	topic := NewChanTopic()
+	rate := RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 0), Rate: "1"}
+
+	first, err := topic.Subscribe(ctx)
+	require.NoError(t, err)
+	cancelled, cancel := context.WithCancel(ctx)
+	second, err := topic.Subscribe(cancelled)
+	require.NoError(t, err)
+
+	// every subscription receives the update, in any order
+	go func() {
+		assert.NoError(t, topic.Publish(ctx, rate))
+	}()
+	received := make(chan RateUpdated, 2)
+	for _, subscription := range []<-chan RateUpdated{first, second} {
+		go func() {
+			received <- <-subscription
+		}()
+	}
+	assert.Equal(t, rate, receive(t, received))
+	assert.Equal(t, rate, receive(t, received))
+
+	// a subscription is closed once its context is done, without blocking the publishers
+	cancel()
+	_, ok := <-second
+	assert.False(t, ok)
+
+	go func() {
+		assert.NoError(t, topic.Publish(ctx, rate))
+	}()
+	assert.Equal(t, rate, receive(t, first))
+
+	// closing the topic closes every subscription
+	require.NoError(t, topic.Close())
+	_, ok = <-first
+	assert.False(t, ok)
+	assert.ErrorIs(t, topic.Publish(ctx, rate), ErrTopicClosed)
+	_, err = topic.Subscribe(ctx)
+	assert.ErrorIs(t, err, ErrTopicClosed)
+}
+
+func TestChanTopic_SubscriptionNotReceiving(t *testing.T) {
+	topic := NewChanTopic()
+	_, err := topic.Subscribe(context.Background())
+	require.NoError(t, err)
+
+	// publishing blocks while the subscription does not receive the update, until the topic is closed
+	published := make(chan error)
+	go func() {
+		published <- topic.Publish(context.Background(), RateUpdated{From: "USD", To: "BTC"})
+	}()
+	select {
+	case <-published:
+		t.Fatal("publish did not block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	require.NoError(t, topic.Close())
+	assert.ErrorIs(t, <-published, ErrTopicClosed)
+}
+
+func TestPublishUpdates(t *testing.T) {
+	topic := NewChanTopic()
+	subscription, err := topic.Subscribe(context.Background())
+	require.NoError(t, err)
+
+	updates := make(chan RateUpdated)
+	go PublishUpdates(context.Background(), topic, updates)
+
+	rate := RateUpdated{From: "USD", To: "BTC", At: time.Unix(1000, 0), Rate: "1"}
+	updates <- rate
+	assert.Equal(t, rate, receive(t, subscription))
+	close(updates)
+}
+
+func receive(t *testing.T, updates <-chan RateUpdated) RateUpdated {
+	t.Helper()
+	select {
+	case update, ok := <-updates:
+		require.True(t, ok, "subscription closed")
+		return update
+	case <-time.After(time.Second):
+		require.FailNow(t, "no update received")
+		return RateUpdated{}
+	}
+}
@@ -0,0 +1,354 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/google/uuid"
+)
+
+type Store interface {
+	ListEndpoints() []Endpoint
+	GetEndpoint(id string) (Endpoint, error)
+	SaveEndpoint(endpoint Endpoint) error
+	DeleteEndpoint(id string) error
+	ListDeadLetters(endpointID string) []DeadLetter
+	// AddDeadLetter stores the dead letter, removing the oldest ones of its endpoint beyond the limit,
+	// unless it is not positive.
+	AddDeadLetter(deadLetter DeadLetter, limit int) error
+	DeleteDeadLetter(id string) error
+}
+
+type Notifier interface {
+	Send(ctx context.Context, url string, secret string, payload []byte) error
+}
+
+type Config struct {
+	// BatchSize is the maximum number of rates delivered in a single request.
+	BatchSize int
+	// Linger is how long a batch waits for more rates before being delivered.
+	Linger time.Duration
+	// QueueSize is the number of rates every endpoint can have pending for delivery. As many rates
+	// overflowing the queue are kept to be stored as a dead letter, the oldest ones being dropped.
+	QueueSize int
+	// MaxDeadLetters is the number of dead letters kept per endpoint, the oldest ones being removed.
+	// Unlimited when it is not positive.
+	MaxDeadLetters int
+}
+
+// Batch is the payload delivered to the endpoints.
+type Batch struct {
+	ID         string                 `json:"id"`
+	EndpointID string                 `json:"endpointId"`
+	Rates      []exchange.RateUpdated `json:"rates"`
+}
+
+// ReplayResult summarises the outcome of replaying the dead letters of an endpoint.
+type ReplayResult struct {
+	Replayed int `json:"replayed"`
+	Failed   int `json:"failed"`
+}
+
+type worker struct {
+	endpoint Endpoint
+	queue    chan exchange.RateUpdated
+	// overflow are the rates that did not fit in the queue, guarded by the lock of the dispatcher.
+	// The worker stores them as a dead letter once it is signaled through overflowed.
+	overflow   []exchange.RateUpdated
+	overflowed chan struct{}
+	done       chan struct{}
+}
+
+// Dispatcher delivers the rate updates to the registered endpoints. Every endpoint has
+// its own queue and goroutine, so a slow endpoint does not delay the others. Batches
+// that can not be delivered, even after retrying, are stored as dead letters.
+type Dispatcher struct {
+	store    Store
+	notifier Notifier
+	config   Config
+
+	mu      sync.Mutex
+	ctx     context.Context // set while dispatching updates
+	workers map[string]*worker
+}
+
+func NewDispatcher(store Store, notifier Notifier, config Config) *Dispatcher {
+	return &Dispatcher{
+		store:    store,
+		notifier: notifier,
+		config:   config,
+		workers:  make(map[string]*worker),
+	}
+}
+
+// Create validates and stores a new endpoint, generating a signing secret if none was provided.
+func (d *Dispatcher) Create(endpoint Endpoint) (Endpoint, error) {
+	if u, err := url.Parse(endpoint.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Endpoint{}, fmt.Errorf("%w: url must be an absolute http(s) URL", ErrInvalid)
+	}
+
+	endpoint.ID = uuid.NewString()
+	endpoint.CreatedAt = time.Now().UTC()
+	if endpoint.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return Endpoint{}, err
+		}
+		endpoint.Secret = hex.EncodeToString(secret)
+	}
+
+	if err := d.store.SaveEndpoint(endpoint); err != nil {
+		return Endpoint{}, err
+	}
+
+	d.mu.Lock()
+	if d.ctx != nil {
+		d.startWorker(endpoint)
+	}
+	d.mu.Unlock()
+
+	return endpoint, nil
+}
+
+// List returns all the endpoints without their secrets.
+func (d *Dispatcher) List() []Endpoint {
+	endpoints := d.store.ListEndpoints()
+	for i := range endpoints {
+		endpoints[i].Secret = ""
+	}
+	return endpoints
+}
+
+// Get returns the endpoint without its secret.
+func (d *Dispatcher) Get(id string) (Endpoint, error) {
+	endpoint, err := d.store.GetEndpoint(id)
+	if err != nil {
+		return Endpoint{}, err
+	}
+
+	endpoint.Secret = ""
+	return endpoint, nil
+}
+
+// Delete stops delivering updates to the endpoint and removes it with its dead letters.
+func (d *Dispatcher) Delete(id string) error {
+	if err := d.store.DeleteEndpoint(id); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	w, ok := d.workers[id]
+	delete(d.workers, id)
+	d.mu.Unlock()
+
+	if ok {
+		close(w.queue)
+		<-w.done
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) DeadLetters(endpointID string) ([]DeadLetter, error) {
+	if _, err := d.store.GetEndpoint(endpointID); err != nil {
+		return nil, err
+	}
+	return d.store.ListDeadLetters(endpointID), nil
+}
+
+// Replay tries to deliver again all the dead letters of the endpoint. The ones that
+// are delivered are removed, the rest are kept for a later replay.
+func (d *Dispatcher) Replay(ctx context.Context, endpointID string) (ReplayResult, error) {
+	endpoint, err := d.store.GetEndpoint(endpointID)
+	if err != nil {
+		return ReplayResult{}, err
+	}
+
+	var result ReplayResult
+	for _, deadLetter := range d.store.ListDeadLetters(endpointID) {
+		if err := d.send(ctx, endpoint, Batch{ID: deadLetter.ID, EndpointID: endpointID, Rates: deadLetter.Rates}); err != nil {
+			log.Printf("Failed to replay dead letter %s to webhook %s: %v", deadLetter.ID, endpointID, err)
+			result.Failed++
+			continue
+		}
+
+		if err := d.store.DeleteDeadLetter(deadLetter.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			return result, err
+		}
+		result.Replayed++
+	}
+
+	return result, nil
+}
+
+// DispatchUpdates delivers all the updates received until the channel is closed. Then,
+// pending batches are flushed before returning.
+func (d *Dispatcher) DispatchUpdates(ctx context.Context, updates <-chan exchange.RateUpdated) {
+	d.mu.Lock()
+	d.ctx = ctx
+	for _, endpoint := range d.store.ListEndpoints() {
+		d.startWorker(endpoint)
+	}
+	d.mu.Unlock()
+
+	defer d.stopWorkers()
+
+	// until the updates channel is closed, we won't receive any more updates then
+	for rate := range updates {
+		d.dispatch(rate)
+	}
+}
+
+func (d *Dispatcher) dispatch(rate exchange.RateUpdated) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, w := range d.workers {
+		if len(w.endpoint.Pairs) > 0 && !slices.Contains(w.endpoint.Pairs, rate.Pair()) {
+			continue
+		}
+
+		select {
+		case w.queue <- rate:
+		default:
+			// The endpoint is not keeping up, keep the rate so it can be replayed later on. The worker of the
+			// endpoint stores the overflow, so the other endpoints are not delayed by it.
+			if len(w.overflow) >= max(d.config.QueueSize, 1) {
+				log.Printf("Rate update '%v' dropped for webhook %s as its overflow is full", w.overflow[0], w.endpoint.ID)
+				w.overflow = w.overflow[1:]
+			}
+			w.overflow = append(w.overflow, rate)
+			select {
+			case w.overflowed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// startWorker must be called holding the lock. Endpoints that already have a worker are skipped.
+func (d *Dispatcher) startWorker(endpoint Endpoint) {
+	if _, ok := d.workers[endpoint.ID]; ok {
+		return
+	}
+
+	w := &worker{
+		endpoint:   endpoint,
+		queue:      make(chan exchange.RateUpdated, d.config.QueueSize),
+		overflowed: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	d.workers[endpoint.ID] = w
+
+	go d.runWorker(d.ctx, w)
+}
+
+func (d *Dispatcher) stopWorkers() {
+	d.mu.Lock()
+	workers := d.workers
+	d.workers = make(map[string]*worker)
+	d.ctx = nil
+	d.mu.Unlock()
+
+	for _, w := range workers {
+		close(w.queue)
+	}
+	for _, w := range workers {
+		<-w.done
+	}
+}
+
+func (d *Dispatcher) runWorker(ctx context.Context, w *worker) {
+	defer close(w.done)
+
+	var batch []exchange.RateUpdated
+	linger := time.NewTimer(d.config.Linger)
+	linger.Stop()
+
+	for {
+		select {
+		case rate, ok := <-w.queue:
+			if !ok {
+				// The endpoint was removed or the dispatcher is stopping, flush what is pending.
+				if len(batch) > 0 {
+					d.deliver(ctx, w.endpoint, batch)
+				}
+				d.storeOverflow(w)
+				return
+			}
+
+			batch = append(batch, rate)
+			if len(batch) == 1 {
+				linger.Reset(d.config.Linger)
+			}
+			if len(batch) >= d.config.BatchSize {
+				linger.Stop()
+				d.deliver(ctx, w.endpoint, batch)
+				batch = nil
+			}
+		case <-linger.C:
+			d.deliver(ctx, w.endpoint, batch)
+			batch = nil
+		case <-w.overflowed:
+			d.storeOverflow(w)
+		}
+	}
+}
+
+// storeOverflow stores the rates that overflowed the queue of the worker as a single dead letter.
+func (d *Dispatcher) storeOverflow(w *worker) {
+	d.mu.Lock()
+	rates := w.overflow
+	w.overflow = nil
+	d.mu.Unlock()
+
+	if len(rates) > 0 {
+		d.addDeadLetter(w.endpoint, rates, errors.New("delivery queue is full"))
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, endpoint Endpoint, rates []exchange.RateUpdated) {
+	batch := Batch{ID: uuid.NewString(), EndpointID: endpoint.ID, Rates: rates}
+	if err := d.send(ctx, endpoint, batch); err != nil {
+		log.Printf("Failed to deliver batch %s to webhook %s: %v", batch.ID, endpoint.ID, err)
+		d.addDeadLetter(endpoint, rates, err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, endpoint Endpoint, batch Batch) error {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	return d.notifier.Send(ctx, endpoint.URL, endpoint.Secret, payload)
+}
+
+func (d *Dispatcher) addDeadLetter(endpoint Endpoint, rates []exchange.RateUpdated, cause error) {
+	if _, err := d.store.GetEndpoint(endpoint.ID); err != nil {
+		// The endpoint was removed while the batch was being delivered.
+		return
+	}
+
+	deadLetter := DeadLetter{
+		ID:         uuid.NewString(),
+		EndpointID: endpoint.ID,
+		Rates:      rates,
+		Error:      cause.Error(),
+		FailedAt:   time.Now().UTC(),
+	}
+
+	if err := d.store.AddDeadLetter(deadLetter, d.config.MaxDeadLetters); err != nil {
+		log.Printf("Failed to store dead letter for webhook %s, %d rates lost: %v", endpoint.ID, len(rates), err)
+	}
+}
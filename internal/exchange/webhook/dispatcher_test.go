@@ -0,0 +1,277 @@
+package webhook
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/pkg/webhook/webhooktest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receivedRates returns the rates of all the batches received.
+func receivedRates(t *testing.T, r *webhooktest.Receiver) []exchange.RateUpdated {
+	var rates []exchange.RateUpdated
+	for _, batch := range webhooktest.Received[Batch](t, r) {
+		rates = append(rates, batch.Rates...)
+	}
+	return rates
+}
+
+func newTestDispatcher(t *testing.T, store Store, config Config) *Dispatcher {
+	return NewDispatcher(store, webhooktest.NewClient(2), config)
+}
+
+func createEndpoint(t *testing.T, dispatcher *Dispatcher, r *webhooktest.Receiver, pairs ...string) Endpoint {
+	endpoint, err := dispatcher.Create(Endpoint{URL: r.URL, Pairs: pairs})
+	require.NoError(t, err)
+	r.AddSecret(endpoint.Secret)
+	return endpoint
+}
+
+func testRates(n int) []exchange.RateUpdated {
+	rates := make([]exchange.RateUpdated, 0, n)
+	for i := 0; i < n; i++ {
+		rates = append(rates, exchange.RateUpdated{From: "USD", To: "BTC", At: time.Unix(int64(1000+i), 0).UTC(), Rate: "50000.00"})
+	}
+	return rates
+}
+
+// dispatchAll sends the rates through the dispatcher and waits until they were all delivered.
+func dispatchAll(dispatcher *Dispatcher, rates ...exchange.RateUpdated) {
+	updates := make(chan exchange.RateUpdated, len(rates))
+	for _, rate := range rates {
+		updates <- rate
+	}
+	close(updates)
+
+	dispatcher.DispatchUpdates(context.Background(), updates)
+}
+
+func TestDispatcher_BatchesBySize(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	dispatcher := newTestDispatcher(t, store, Config{BatchSize: 2, Linger: time.Hour, QueueSize: 10})
+	endpoint := createEndpoint(t, dispatcher, r)
+
+	rates := testRates(5)
+	dispatchAll(dispatcher, rates...)
+
+	// Two full batches and the pending one flushed when stopping.
+	batches := webhooktest.Received[Batch](t, r)
+	require.Len(t, batches, 3)
+	assert.Len(t, batches[0].Rates, 2)
+	assert.Len(t, batches[1].Rates, 2)
+	assert.Len(t, batches[2].Rates, 1)
+	for _, batch := range batches {
+		assert.Equal(t, endpoint.ID, batch.EndpointID)
+		assert.NotEmpty(t, batch.ID)
+	}
+	assert.Equal(t, rates, receivedRates(t, r))
+}
+
+func TestDispatcher_BatchesByLinger(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	dispatcher := newTestDispatcher(t, store, Config{BatchSize: 100, Linger: 10 * time.Millisecond, QueueSize: 10})
+	createEndpoint(t, dispatcher, r)
+
+	updates := make(chan exchange.RateUpdated)
+	done := make(chan struct{})
+	go func() {
+		dispatcher.DispatchUpdates(context.Background(), updates)
+		close(done)
+	}()
+
+	rates := testRates(2)
+	updates <- rates[0]
+	updates <- rates[1]
+
+	assert.Eventually(t, func() bool { return len(webhooktest.Received[Batch](t, r)) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, rates, receivedRates(t, r))
+
+	close(updates)
+	<-done
+}
+
+func TestDispatcher_FiltersPairs(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	dispatcher := newTestDispatcher(t, store, Config{BatchSize: 10, Linger: time.Hour, QueueSize: 10})
+	createEndpoint(t, dispatcher, r, "EUR-BTC")
+
+	eur := exchange.RateUpdated{From: "EUR", To: "BTC", At: time.Unix(1000, 0).UTC(), Rate: "45000.00"}
+	dispatchAll(dispatcher, testRates(1)[0], eur)
+
+	assert.Equal(t, []exchange.RateUpdated{eur}, receivedRates(t, r))
+}
+
+func TestDispatcher_DeadLettersAndReplay(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	r.FailNext(-1)
+
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	dispatcher := newTestDispatcher(t, store, Config{BatchSize: 2, Linger: time.Hour, QueueSize: 10})
+	endpoint := createEndpoint(t, dispatcher, r)
+
+	rates := testRates(3)
+	dispatchAll(dispatcher, rates...)
+	assert.Empty(t, webhooktest.Received[Batch](t, r))
+
+	deadLetters, err := dispatcher.DeadLetters(endpoint.ID)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 2)
+	assert.Contains(t, deadLetters[0].Error, "503")
+
+	// Replaying while the endpoint is still failing keeps the dead letters.
+	result, err := dispatcher.Replay(context.Background(), endpoint.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ReplayResult{Replayed: 0, Failed: 2}, result)
+
+	r.FailNext(0)
+	result, err = dispatcher.Replay(context.Background(), endpoint.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ReplayResult{Replayed: 2, Failed: 0}, result)
+	assert.ElementsMatch(t, rates, receivedRates(t, r))
+
+	deadLetters, err = dispatcher.DeadLetters(endpoint.ID)
+	require.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
+
+func TestDispatcher_QueueFull(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	dispatcher := newTestDispatcher(t, store, Config{BatchSize: 10, Linger: time.Hour, QueueSize: 1, MaxDeadLetters: 1})
+	endpoint := createEndpoint(t, dispatcher, r)
+
+	// Worker is not running, so nothing is consumed from the queue.
+	w := &worker{endpoint: endpoint, queue: make(chan exchange.RateUpdated, 1), overflowed: make(chan struct{}, 1), done: make(chan struct{})}
+	dispatcher.mu.Lock()
+	dispatcher.workers[endpoint.ID] = w
+	dispatcher.mu.Unlock()
+
+	// The overflow is kept up to the size of the queue, dropping the oldest rates.
+	rates := testRates(4)
+	for _, rate := range rates {
+		dispatcher.dispatch(rate)
+	}
+	assert.Len(t, w.overflowed, 1)
+	dispatcher.storeOverflow(w)
+
+	deadLetters, err := dispatcher.DeadLetters(endpoint.ID)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, rates[3:], deadLetters[0].Rates)
+	assert.Equal(t, "delivery queue is full", deadLetters[0].Error)
+
+	// and only the latest dead letters are kept
+	dispatcher.dispatch(rates[0])
+	dispatcher.storeOverflow(w)
+	deadLetters, err = dispatcher.DeadLetters(endpoint.ID)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, rates[:1], deadLetters[0].Rates)
+}
+
+func TestDispatcher_StartWorkerOnce(t *testing.T) {
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	dispatcher := newTestDispatcher(t, store, Config{BatchSize: 10, Linger: time.Hour, QueueSize: 10})
+	endpoint := Endpoint{ID: "first", URL: "https://example.com/hook"}
+
+	// Create and DispatchUpdates can both start the worker of a new endpoint
+	dispatcher.mu.Lock()
+	dispatcher.ctx = context.Background()
+	dispatcher.startWorker(endpoint)
+	w := dispatcher.workers[endpoint.ID]
+	dispatcher.startWorker(endpoint)
+	assert.Same(t, w, dispatcher.workers[endpoint.ID])
+	dispatcher.mu.Unlock()
+
+	dispatcher.stopWorkers()
+}
+
+func TestDispatcher_Delete(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	dispatcher := newTestDispatcher(t, store, Config{BatchSize: 10, Linger: time.Hour, QueueSize: 10})
+	endpoint := createEndpoint(t, dispatcher, r)
+
+	updates := make(chan exchange.RateUpdated)
+	done := make(chan struct{})
+	go func() {
+		dispatcher.DispatchUpdates(context.Background(), updates)
+		close(done)
+	}()
+
+	rates := testRates(2)
+	updates <- rates[0]
+
+	// The pending batch is flushed when the endpoint is removed, and nothing else is delivered.
+	require.Eventually(t, func() bool {
+		dispatcher.mu.Lock()
+		defer dispatcher.mu.Unlock()
+		return len(dispatcher.workers) == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, dispatcher.Delete(endpoint.ID))
+	updates <- rates[1]
+	close(updates)
+	<-done
+
+	assert.Equal(t, rates[:1], receivedRates(t, r))
+	assert.ErrorIs(t, dispatcher.Delete(endpoint.ID), ErrNotFound)
+
+	_, err = dispatcher.DeadLetters(endpoint.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDispatcher_SurvivesRestarts(t *testing.T) {
+	r := webhooktest.NewReceiver(t)
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	config := Config{BatchSize: 10, Linger: time.Hour, QueueSize: 10}
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	created := createEndpoint(t, newTestDispatcher(t, store, config), r)
+
+	store, err = NewFileStore(path)
+	require.NoError(t, err)
+	dispatcher := newTestDispatcher(t, store, config)
+
+	endpoints := dispatcher.List()
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, created.ID, endpoints[0].ID)
+	assert.Empty(t, endpoints[0].Secret)
+
+	rates := testRates(1)
+	dispatchAll(dispatcher, rates...)
+	assert.Equal(t, rates, receivedRates(t, r))
+}
+
+func TestDispatcher_Create(t *testing.T) {
+	store, err := NewFileStore("")
+	require.NoError(t, err)
+	dispatcher := newTestDispatcher(t, store, Config{BatchSize: 10, Linger: time.Hour, QueueSize: 10})
+
+	created, err := dispatcher.Create(Endpoint{URL: "https://example.com/hook", Secret: "secret"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, "secret", created.Secret)
+
+	fetched, err := dispatcher.Get(created.ID)
+	require.NoError(t, err)
+	assert.Empty(t, fetched.Secret)
+
+	_, err = dispatcher.Create(Endpoint{URL: "example.com/hook"})
+	assert.ErrorIs(t, err, ErrInvalid)
+}
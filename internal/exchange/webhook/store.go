@@ -0,0 +1,249 @@
+package webhook
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/alex-rufo/exchange/pkg/jsonfile"
+)
+
+var (
+	ErrNotFound = errors.New("webhook not found")
+	ErrInvalid  = errors.New("invalid webhook")
+)
+
+// Endpoint is an HTTP endpoint subscribed to the rate updates.
+type Endpoint struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Pairs restricts the updates delivered to the given pairs, all of them when empty.
+	Pairs []string `json:"pairs,omitempty"`
+	// Secret is used to sign the batches. It is only returned when the endpoint is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DeadLetter is a batch that could not be delivered to its endpoint.
+type DeadLetter struct {
+	ID         string                 `json:"id"`
+	EndpointID string                 `json:"endpointId"`
+	Rates      []exchange.RateUpdated `json:"rates"`
+	Error      string                 `json:"error"`
+	FailedAt   time.Time              `json:"failedAt"`
+}
+
+type state struct {
+	Endpoints   []Endpoint   `json:"endpoints"`
+	DeadLetters []DeadLetter `json:"deadLetters"`
+}
+
+// FileStore keeps the endpoints and dead letters in memory, writing all of them into a
+// JSON file on every change. An empty path keeps them in memory only.
+type FileStore struct {
+	path string
+
+	mu          sync.RWMutex
+	endpoints   map[string]Endpoint
+	deadLetters map[string]DeadLetter
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:        path,
+		endpoints:   make(map[string]Endpoint),
+		deadLetters: make(map[string]DeadLetter),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	var persisted state
+	if err := jsonfile.Read(path, &persisted); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, endpoint := range persisted.Endpoints {
+		s.endpoints[endpoint.ID] = endpoint
+	}
+	for _, deadLetter := range persisted.DeadLetters {
+		s.deadLetters[deadLetter.ID] = deadLetter
+	}
+
+	return s, nil
+}
+
+// ListEndpoints returns all the endpoints sorted by creation time.
+func (s *FileStore) ListEndpoints() []Endpoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.listEndpoints()
+}
+
+func (s *FileStore) GetEndpoint(id string) (Endpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	endpoint, ok := s.endpoints[id]
+	if !ok {
+		return Endpoint{}, ErrNotFound
+	}
+	return endpoint, nil
+}
+
+func (s *FileStore) SaveEndpoint(endpoint Endpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.endpoints[endpoint.ID]
+	s.endpoints[endpoint.ID] = endpoint
+	if err := s.flush(); err != nil {
+		if existed {
+			s.endpoints[endpoint.ID] = previous
+		} else {
+			delete(s.endpoints, endpoint.ID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// DeleteEndpoint removes the endpoint together with its dead letters.
+func (s *FileStore) DeleteEndpoint(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoint, ok := s.endpoints[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	deadLetters := make(map[string]DeadLetter)
+	for _, deadLetter := range s.deadLetters {
+		if deadLetter.EndpointID == id {
+			deadLetters[deadLetter.ID] = deadLetter
+			delete(s.deadLetters, deadLetter.ID)
+		}
+	}
+	delete(s.endpoints, id)
+
+	if err := s.flush(); err != nil {
+		s.endpoints[id] = endpoint
+		for _, deadLetter := range deadLetters {
+			s.deadLetters[deadLetter.ID] = deadLetter
+		}
+		return err
+	}
+
+	return nil
+}
+
+// ListDeadLetters returns the dead letters of the endpoint sorted by failure time.
+func (s *FileStore) ListDeadLetters(endpointID string) []DeadLetter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deadLetters := []DeadLetter{}
+	for _, deadLetter := range s.deadLetters {
+		if deadLetter.EndpointID == endpointID {
+			deadLetters = append(deadLetters, deadLetter)
+		}
+	}
+
+	sortDeadLetters(deadLetters)
+	return deadLetters
+}
+
+func (s *FileStore) AddDeadLetter(deadLetter DeadLetter, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetters[deadLetter.ID] = deadLetter
+
+	// the oldest dead letters of the endpoint beyond the limit are removed with the same write
+	var removed []DeadLetter
+	if limit > 0 {
+		var deadLetters []DeadLetter
+		for _, existing := range s.deadLetters {
+			if existing.EndpointID == deadLetter.EndpointID {
+				deadLetters = append(deadLetters, existing)
+			}
+		}
+		sortDeadLetters(deadLetters)
+		for len(deadLetters) > limit {
+			removed = append(removed, deadLetters[0])
+			delete(s.deadLetters, deadLetters[0].ID)
+			deadLetters = deadLetters[1:]
+		}
+	}
+
+	if err := s.flush(); err != nil {
+		delete(s.deadLetters, deadLetter.ID)
+		for _, existing := range removed {
+			s.deadLetters[existing.ID] = existing
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStore) DeleteDeadLetter(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadLetter, ok := s.deadLetters[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(s.deadLetters, id)
+	if err := s.flush(); err != nil {
+		s.deadLetters[id] = deadLetter
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStore) listEndpoints() []Endpoint {
+	endpoints := make([]Endpoint, 0, len(s.endpoints))
+	for _, endpoint := range s.endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].CreatedAt.Equal(endpoints[j].CreatedAt) {
+			return endpoints[i].ID < endpoints[j].ID
+		}
+		return endpoints[i].CreatedAt.Before(endpoints[j].CreatedAt)
+	})
+	return endpoints
+}
+
+func (s *FileStore) flush() error {
+	if s.path == "" {
+		return nil
+	}
+
+	deadLetters := make([]DeadLetter, 0, len(s.deadLetters))
+	for _, deadLetter := range s.deadLetters {
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	sortDeadLetters(deadLetters)
+
+	return jsonfile.Write(s.path, state{Endpoints: s.listEndpoints(), DeadLetters: deadLetters})
+}
+
+func sortDeadLetters(deadLetters []DeadLetter) {
+	sort.Slice(deadLetters, func(i, j int) bool {
+		if deadLetters[i].FailedAt.Equal(deadLetters[j].FailedAt) {
+			return deadLetters[i].ID < deadLetters[j].ID
+		}
+		return deadLetters[i].FailedAt.Before(deadLetters[j].FailedAt)
+	})
+}
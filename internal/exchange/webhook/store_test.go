@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/internal/exchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	first := Endpoint{ID: "first", URL: "https://example.com/first", CreatedAt: time.Unix(1000, 0).UTC()}
+	second := Endpoint{ID: "second", URL: "https://example.com/second", CreatedAt: time.Unix(2000, 0).UTC()}
+	require.NoError(t, store.SaveEndpoint(second))
+	require.NoError(t, store.SaveEndpoint(first))
+	assert.Equal(t, []Endpoint{first, second}, store.ListEndpoints())
+
+	rates := []exchange.RateUpdated{{From: "USD", To: "BTC", At: time.Unix(1000, 0).UTC(), Rate: "50000.00"}}
+	firstLetter := DeadLetter{ID: "a", EndpointID: first.ID, Rates: rates, Error: "timeout", FailedAt: time.Unix(3000, 0).UTC()}
+	secondLetter := DeadLetter{ID: "b", EndpointID: second.ID, Rates: rates, Error: "timeout", FailedAt: time.Unix(3000, 0).UTC()}
+	require.NoError(t, store.AddDeadLetter(firstLetter, 0))
+	require.NoError(t, store.AddDeadLetter(secondLetter, 0))
+	assert.Equal(t, []DeadLetter{firstLetter}, store.ListDeadLetters(first.ID))
+
+	// Removing an endpoint removes its dead letters too.
+	require.NoError(t, store.DeleteEndpoint(first.ID))
+	assert.ErrorIs(t, store.DeleteEndpoint(first.ID), ErrNotFound)
+	assert.Empty(t, store.ListDeadLetters(first.ID))
+
+	// A new store loads what was persisted.
+	reloaded, err := NewFileStore(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Endpoint{second}, reloaded.ListEndpoints())
+	assert.Equal(t, []DeadLetter{secondLetter}, reloaded.ListDeadLetters(second.ID))
+
+	require.NoError(t, reloaded.DeleteDeadLetter(secondLetter.ID))
+	assert.ErrorIs(t, reloaded.DeleteDeadLetter(secondLetter.ID), ErrNotFound)
+
+	_, err = reloaded.GetEndpoint(first.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileStore_AddDeadLetter_Limit(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "webhooks.json"))
+	require.NoError(t, err)
+
+	var deadLetters []DeadLetter
+	for i := 0; i < 3; i++ {
+		deadLetter := DeadLetter{ID: string(rune('a' + i)), EndpointID: "first", Error: "timeout", FailedAt: time.Unix(int64(3000+i), 0).UTC()}
+		require.NoError(t, store.AddDeadLetter(deadLetter, 2))
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	other := DeadLetter{ID: "d", EndpointID: "second", Error: "timeout", FailedAt: time.Unix(1000, 0).UTC()}
+	require.NoError(t, store.AddDeadLetter(other, 2))
+
+	// the oldest dead letters of the endpoint are removed
+	assert.Equal(t, deadLetters[1:], store.ListDeadLetters("first"))
+	assert.Equal(t, []DeadLetter{other}, store.ListDeadLetters("second"))
+}
+
+func TestNewFileStore_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o644))
+
+	_, err := NewFileStore(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff computes exponentially increasing delays between attempts. A random jitter
+// of up to 20% is subtracted so that clients retrying at the same time spread out.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// Default is a sensible configuration for calls to external services.
+var Default = Backoff{
+	Initial:    500 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+}
+
+// Delay returns how long to wait before the given retry (starting at 1).
+func (b Backoff) Delay(retry int) time.Duration {
+	delay := float64(b.Initial)
+	for i := 1; i < retry && delay < float64(b.Max); i++ {
+		delay *= b.Multiplier
+	}
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	return time.Duration(delay * (1 - 0.2*rand.Float64()))
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error to signal Retry that there is no point in trying again.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Retry calls fn until it succeeds, returns a permanent error, the context is done or
+// maxAttempts is reached. The last error is returned.
+func Retry(ctx context.Context, b Backoff, maxAttempts int, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = fn(ctx); err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if attempt == maxAttempts {
+			break
+		}
+
+		timer := time.NewTimer(b.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,85 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+
+	tests := []struct {
+		retry    int
+		expected time.Duration
+	}{
+		{retry: 1, expected: 100 * time.Millisecond},
+		{retry: 2, expected: 200 * time.Millisecond},
+		{retry: 3, expected: 400 * time.Millisecond},
+		{retry: 4, expected: 800 * time.Millisecond},
+		{retry: 5, expected: time.Second},
+		{retry: 100, expected: time.Second},
+	}
+
+	for _, tt := range tests {
+		delay := b.Delay(tt.retry)
+		assert.LessOrEqual(t, delay, tt.expected)
+		assert.GreaterOrEqual(t, delay, tt.expected*8/10)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	b := Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 2}
+
+	t.Run("succeeds after transient errors", func(t *testing.T) {
+		calls := 0
+		err := Retry(context.Background(), b, 5, func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return errors.New("transient")
+			}
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		calls := 0
+		err := Retry(context.Background(), b, 3, func(ctx context.Context) error {
+			calls++
+			return errors.New("transient")
+		})
+
+		assert.EqualError(t, err, "transient")
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("stops on permanent errors", func(t *testing.T) {
+		calls := 0
+		err := Retry(context.Background(), b, 3, func(ctx context.Context) error {
+			calls++
+			return Permanent(errors.New("bad request"))
+		})
+
+		assert.EqualError(t, err, "bad request")
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := Retry(ctx, Backoff{Initial: time.Hour, Max: time.Hour, Multiplier: 2}, 3, func(ctx context.Context) error {
+			calls++
+			cancel()
+			return errors.New("transient")
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, calls)
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -26,9 +27,23 @@ type FetchBitcoinPriceResponse struct {
 	} `json:"bpi"`
 }
 
+// FetchHistoricalCloseResponse has the close price of Bitcoin of every day, keyed by date (e.g. 2026-03-01).
+type FetchHistoricalCloseResponse struct {
+	BPI        map[string]float64 `json:"bpi"`
+	Disclaimer string             `json:"disclaimer"`
+	Time       struct {
+		Updated    string    `json:"updated"`
+		UpdatedISO time.Time `json:"updatedISO"`
+	} `json:"time"`
+}
+
+// HistoricalDateLayout is the layout of the dates of the historical close endpoint.
+const HistoricalDateLayout = "2006-01-02"
+
 // Client is a structure in charge of executing API calls against CoinDesk.
-// Currently it only supports one endpoint:
+// Currently it supports the following endpoints:
 //   - /v1/bpi/currentprice.json
+//   - /v1/bpi/historical/close.json
 //
 // TODO: it would be great to add retrials with exponential backoff.
 type Client struct {
@@ -47,32 +62,53 @@ func NewClient(baseURL string, timeout time.Duration) *Client {
 }
 
 func (c *Client) FetchBitcoinPrice(ctx context.Context) (*FetchBitcoinPriceResponse, error) {
-	url := fmt.Sprintf("%s/v1/bpi/currentprice.json", c.baseURL)
+	var data FetchBitcoinPriceResponse
+	if err := c.get(ctx, fmt.Sprintf("%s/v1/bpi/currentprice.json", c.baseURL), &data); err != nil {
+		return nil, err
+	}
 
+	return &data, nil
+}
+
+// FetchHistoricalClose fetches the daily close price of Bitcoin in the currency between both dates, both included.
+func (c *Client) FetchHistoricalClose(ctx context.Context, currency string, start, end time.Time) (*FetchHistoricalCloseResponse, error) {
+	query := url.Values{}
+	query.Set("currency", currency)
+	query.Set("start", start.UTC().Format(HistoricalDateLayout))
+	query.Set("end", end.UTC().Format(HistoricalDateLayout))
+
+	var data FetchHistoricalCloseResponse
+	if err := c.get(ctx, fmt.Sprintf("%s/v1/bpi/historical/close.json?%s", c.baseURL, query.Encode()), &data); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (c *Client) get(ctx context.Context, url string, data any) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return fmt.Errorf("failed to create request: %v", err)
 	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch data at %s: %v", url, err)
+		return fmt.Errorf("failed to fetch data at %s: %v", url, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return fmt.Errorf("failed to read response: %v", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d, payload: %v", resp.StatusCode, body)
+		return fmt.Errorf("unexpected status code: %d, payload: %v", resp.StatusCode, body)
 	}
 
-	var data FetchBitcoinPriceResponse
-	if err := json.Unmarshal(body, &data); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %v, payload: %v", err, body)
+	if err := json.Unmarshal(body, data); err != nil {
+		return fmt.Errorf("failed to parse JSON: %v, payload: %v", err, body)
 	}
 
-	return &data, nil
+	return nil
 }
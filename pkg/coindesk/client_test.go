@@ -94,3 +94,46 @@ func TestFetchBitcoinPrice_ErrorHandling(t *testing.T) {
 		t.Error("Expected an error for 500 status code, got nil")
 	}
 }
+
+func TestFetchHistoricalClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/bpi/historical/close.json" {
+			t.Errorf("Expected to request '/v1/bpi/historical/close.json', got: %s", r.URL.Path)
+		}
+		query := r.URL.Query()
+		if query.Get("currency") != "EUR" || query.Get("start") != "2026-03-01" || query.Get("end") != "2026-03-02" {
+			t.Errorf("Unexpected query: %s", r.URL.RawQuery)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"bpi":{"2026-03-01":61234.5678,"2026-03-02":62000.1},"disclaimer":"This data was produced from the CoinDesk Bitcoin Price Index.","time":{"updated":"Mar 3, 2026 00:03:00 UTC","updatedISO":"2026-03-03T00:03:00+00:00"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, time.Second)
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	response, err := client.FetchHistoricalClose(context.Background(), "EUR", start, start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(response.BPI) != 2 {
+		t.Fatalf("Expected 2 closes, got %d", len(response.BPI))
+	}
+	if response.BPI["2026-03-01"] != 61234.5678 {
+		t.Errorf("Expected the close of 2026-03-01 to be 61234.5678, got %f", response.BPI["2026-03-01"])
+	}
+}
+
+func TestFetchHistoricalClose_ErrorHandling(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, time.Second)
+	_, err := client.FetchHistoricalClose(context.Background(), "EUR", time.Now(), time.Now())
+	if err == nil {
+		t.Error("Expected an error for 404 status code, got nil")
+	}
+}
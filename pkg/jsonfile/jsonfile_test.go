@@ -0,0 +1,45 @@
+package jsonfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+
+	written := map[string]int{"one": 1, "two": 2}
+	require.NoError(t, Write(path, written))
+
+	// Overwriting must replace the whole content.
+	written = map[string]int{"three": 3}
+	require.NoError(t, Write(path, written))
+
+	var read map[string]int
+	require.NoError(t, Read(path, &read))
+	assert.Equal(t, written, read)
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestRead_NotExist(t *testing.T) {
+	var read map[string]int
+	err := Read(filepath.Join(t.TempDir(), "missing.json"), &read)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestRead_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+
+	var read map[string]int
+	assert.Error(t, Read(path, &read))
+}
@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alex-rufo/exchange/pkg/backoff"
+)
+
+const (
+	// HeaderTimestamp contains the unix time at which the request was signed.
+	HeaderTimestamp = "X-Exchange-Timestamp"
+	// HeaderSignature contains the HMAC-SHA256 signature of the request, as "sha256=<hex>".
+	HeaderSignature = "X-Exchange-Signature"
+)
+
+// Sign returns the signature of the payload. The timestamp is part of the signed
+// content so receivers can reject replayed requests.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a received webhook request against its payload.
+func Verify(secret string, header http.Header, payload []byte) bool {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	expected := Sign(secret, timestamp, payload)
+	return hmac.Equal([]byte(expected), []byte(header.Get(HeaderSignature)))
+}
+
+// Client is in charge of delivering signed webhooks. Network errors, 429 and 5xx
+// responses are retried with exponential backoff, any other status is considered final.
+type Client struct {
+	client      *http.Client
+	backoff     backoff.Backoff
+	maxAttempts int
+}
+
+func NewClient(timeout time.Duration, b backoff.Backoff, maxAttempts int) *Client {
+	return &Client{
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		backoff:     b,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Send posts the JSON payload to the given URL, signing it with the secret if there is one.
+func (c *Client) Send(ctx context.Context, url string, secret string, payload []byte) error {
+	return backoff.Retry(ctx, c.backoff, c.maxAttempts, func(ctx context.Context) error {
+		return c.send(ctx, url, secret, payload)
+	})
+}
+
+func (c *Client) send(ctx context.Context, url string, secret string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return backoff.Permanent(fmt.Errorf("failed to create request: %v", err))
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(HeaderSignature, Sign(secret, timestamp, payload))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver webhook to %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("unexpected status code delivering webhook to %s: %d", url, resp.StatusCode)
+	default:
+		return backoff.Permanent(fmt.Errorf("unexpected status code delivering webhook to %s: %d", url, resp.StatusCode))
+	}
+}
@@ -0,0 +1,107 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/pkg/backoff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testBackoff = backoff.Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+
+func TestSignAndVerify(t *testing.T) {
+	payload := []byte(`{"rate":"50000.00"}`)
+	signature := Sign("secret", 1000, payload)
+	assert.Equal(t, "sha256=", signature[:7])
+
+	header := http.Header{}
+	header.Set(HeaderTimestamp, "1000")
+	header.Set(HeaderSignature, signature)
+	assert.True(t, Verify("secret", header, payload))
+	assert.False(t, Verify("other-secret", header, payload))
+	assert.False(t, Verify("secret", header, []byte(`{"rate":"1.00"}`)))
+
+	// The timestamp is part of the signature.
+	header.Set(HeaderTimestamp, "1001")
+	assert.False(t, Verify("secret", header, payload))
+}
+
+func TestClient_Send(t *testing.T) {
+	payload := []byte(`{"rate":"50000.00"}`)
+
+	var received atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, payload, body)
+		assert.True(t, Verify("secret", r.Header, body))
+
+		timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), time.Unix(timestamp, 0), time.Minute)
+
+		received.Store(true)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewClient(time.Second, testBackoff, 3)
+	require.NoError(t, client.Send(context.Background(), server.URL, "secret", payload))
+	assert.True(t, received.Load())
+}
+
+func TestClient_Send_Retries(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		expectedCalls int32
+		expectErr     bool
+	}{
+		{name: "recovers from server errors", statuses: []int{500, 503, 200}, expectedCalls: 3},
+		{name: "retries rate limited requests", statuses: []int{429, 200}, expectedCalls: 2},
+		{name: "gives up after max attempts", statuses: []int{500, 500, 500, 500}, expectedCalls: 3, expectErr: true},
+		{name: "does not retry client errors", statuses: []int{400, 200}, expectedCalls: 1, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := calls.Add(1)
+				w.WriteHeader(tt.statuses[call-1])
+			}))
+			defer server.Close()
+
+			client := NewClient(time.Second, testBackoff, 3)
+			err := client.Send(context.Background(), server.URL, "", []byte(`{}`))
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedCalls, calls.Load())
+		})
+	}
+}
+
+func TestClient_Send_Unsigned(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get(HeaderSignature))
+		assert.Empty(t, r.Header.Get(HeaderTimestamp))
+	}))
+	defer server.Close()
+
+	client := NewClient(time.Second, testBackoff, 1)
+	assert.NoError(t, client.Send(context.Background(), server.URL, "", []byte(`{}`)))
+}
@@ -0,0 +1,93 @@
+// Package webhooktest provides a webhook endpoint recording the signed webhooks it receives,
+// to test the senders of the webhooks.
+package webhooktest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alex-rufo/exchange/pkg/backoff"
+	"github.com/alex-rufo/exchange/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Receiver is a webhook endpoint recording the payloads it receives, once their signature is verified
+// with any of its secrets. It is closed when the test finishes.
+type Receiver struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	secrets  []string
+	payloads [][]byte
+	failures atomic.Int32 // number of requests to fail before accepting them, negative to fail all of them
+}
+
+func NewReceiver(t *testing.T) *Receiver {
+	r := &Receiver{t: t}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.handle))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// AddSecret adds a secret the signatures of the payloads are verified with.
+func (r *Receiver) AddSecret(secret string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets = append(r.secrets, secret)
+}
+
+// FailNext fails the next n requests with a 503, or all of them until it is called again when n is negative.
+func (r *Receiver) FailNext(n int) {
+	r.failures.Store(int32(n))
+}
+
+func (r *Receiver) handle(w http.ResponseWriter, req *http.Request) {
+	if failures := r.failures.Load(); failures < 0 || (failures > 0 && r.failures.CompareAndSwap(failures, failures-1)) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	require.NoError(r.t, err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	assert.True(r.t, r.verify(req.Header, body), "invalid signature")
+	r.payloads = append(r.payloads, body)
+}
+
+func (r *Receiver) verify(header http.Header, body []byte) bool {
+	for _, secret := range r.secrets {
+		if webhook.Verify(secret, header, body) {
+			return true
+		}
+	}
+	return false
+}
+
+// Received decodes the payloads received by the receiver, in the order they were received.
+func Received[T any](t *testing.T, r *Receiver) []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	received := make([]T, 0, len(r.payloads))
+	for _, payload := range r.payloads {
+		var value T
+		require.NoError(t, json.Unmarshal(payload, &value))
+		received = append(received, value)
+	}
+	return received
+}
+
+// NewClient returns a webhook client retrying the failed deliveries right away, up to maxAttempts times.
+func NewClient(maxAttempts int) *webhook.Client {
+	return webhook.NewClient(time.Second, backoff.Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}, maxAttempts)
+}